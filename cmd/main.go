@@ -2,11 +2,21 @@ package main
 
 import (
 	h "assignment2/handlers"
+	"assignment2/utils/auth"
 	"assignment2/utils/constants"
 	"assignment2/utils/db"
+	"assignment2/utils/dispatcher"
+	"assignment2/utils/div"
+	"assignment2/utils/gateway"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -32,15 +42,165 @@ func main() {
 		port = "8080"
 	}
 
+	// Handle max attempts of webhook deliveries
+	if maxAttempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && maxAttempts > 0 {
+		db.MaxDeliveryAttempts = maxAttempts
+	} else {
+		log.Println("$WEBHOOK_MAX_ATTEMPTS has not been set. Default: " + strconv.Itoa(constants.DEFAULT_WEBHOOK_MAX_ATTEMPTS))
+	}
+
+	// Handle retention of webhook delivery history
+	if retentionDays, err := strconv.Atoi(os.Getenv("DELIVERY_HISTORY_RETENTION_DAYS")); err == nil && retentionDays > 0 {
+		db.DeliveryHistoryRetention = time.Duration(retentionDays) * 24 * time.Hour
+	} else {
+		log.Println("$DELIVERY_HISTORY_RETENTION_DAYS has not been set. Default: " + strconv.Itoa(constants.DEFAULT_DELIVERY_HISTORY_RETENTION_DAYS))
+	}
+
+	// Handle hosts webhooks may be delivered to even if they are private or reserved
+	for _, host := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			gateway.AllowedWebhookHosts = append(gateway.AllowedWebhookHosts, host)
+		}
+	}
+
+	// Handle SMTP server of webhooks emailed through the smtp channel, which is not enabled without it
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		gateway.RegisterNotifier(constants.WEBHOOK_CHANNEL_SMTP, gateway.SmtpNotifier{
+			Addr:     smtpAddr,
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+	} else {
+		log.Println("$SMTP_ADDR has not been set. Webhooks can not be emailed.")
+	}
+
+	// Handle file sink of webhooks of the file channel, which is not enabled without it
+	if fileSink := os.Getenv("WEBHOOK_FILE_SINK"); fileSink != "" {
+		gateway.RegisterNotifier(constants.WEBHOOK_CHANNEL_FILE, gateway.FileNotifier{Path: fileSink})
+	} else {
+		log.Println("$WEBHOOK_FILE_SINK has not been set. Webhooks can not be written to a file.")
+	}
+
+	// Handle key the outbound headers and credentials of webhooks are encrypted with, which can not be stored without it
+	if encryptionKey := os.Getenv("WEBHOOK_ENCRYPTION_KEY"); encryptionKey != "" {
+		if err := div.SetEncryptionKey(encryptionKey); err != nil {
+			log.Fatal("Invalid $WEBHOOK_ENCRYPTION_KEY: ", err)
+		}
+	} else {
+		log.Println("$WEBHOOK_ENCRYPTION_KEY has not been set. Webhooks can not have headers or auth.")
+	}
+
+	// Include renewables in deliveries of webhooks with includeData, read the same way as by the renewables endpoints
+	gateway.SetRenewablesProvider(h.GetRenewablesForWebhook)
+
+	// Handle API key of the admin, which may see and change the webhooks of all clients
+	if adminKey := os.Getenv("ADMIN_API_KEY"); adminKey != "" {
+		auth.SetAdminKey(adminKey)
+	} else {
+		log.Println("$ADMIN_API_KEY has not been set. Webhooks are only available to the clients which registered them.")
+	}
+
+	// Handle failed deliveries in a row before a webhook is disabled
+	db.DisableAfterFailures = getPositiveIntFromEnv("WEBHOOK_DISABLE_AFTER_FAILURES", constants.DEFAULT_WEBHOOK_DISABLE_AFTER_FAILURES)
+
+	// Run invocations and webhook deliveries on a bounded pool of workers
+	jobDispatcher := dispatcher.New(dispatcher.Config{
+		Workers:    getPositiveIntFromEnv("DISPATCHER_WORKERS", constants.DEFAULT_DISPATCHER_WORKERS),
+		QueueSize:  getPositiveIntFromEnv("DISPATCHER_QUEUE_SIZE", constants.DEFAULT_DISPATCHER_QUEUE_SIZE),
+		MaxPerHost: getPositiveIntFromEnv("DISPATCHER_MAX_PER_HOST", constants.DEFAULT_DISPATCHER_MAX_PER_HOST),
+	})
+	db.SetDispatcher(jobDispatcher)
+
+	// Retry failed webhook deliveries, remove old delivery history, expire webhooks, keep the index of webhooks in sync, and send digests, in the background
+	go db.StartDeliveryWorker()
+	go db.StartDeliveryHistoryCleanup()
+	go db.StartWebhookExpiry()
+	go db.StartWebhookIndex()
+	go db.StartDigestScheduler()
+
+	// Handle port assignment for the gRPC server
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		log.Println("$GRPC_PORT has not been set. Default: " + constants.DEFAULT_GRPC_PORT)
+		grpcPort = constants.DEFAULT_GRPC_PORT
+	}
+
+	// Start gRPC server alongside the http server
+	listener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatal("Could not listen on gRPC port: ", err)
+	}
+	grpcServer := h.NewGrpcServer()
+	go func() {
+		log.Println("Starting gRPC server on port " + grpcPort + " ...")
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
 	// Set up handler endpoints through root error handler
 	http.Handle(constants.DEFAULT_PATH, h.RootHandler(h.Default))
 	http.Handle(constants.RENEWABLES_CURRENT_PATH, h.RootHandler(h.RenewablesCurrent))
 	http.Handle(constants.RENEWABLES_HISTORY_PATH, h.RootHandler(h.RenewablesHistory))
 	http.Handle(constants.NOTIFICATION_PATH, h.RootHandler(h.Notification))
 	http.Handle(constants.STATUS_PATH, h.RootHandler(h.Status))
+	http.Handle(constants.DEADLETTERS_PATH, h.RootHandler(h.DeadLetters))
+
+	// Set up the v2 API with the same handlers
+	http.Handle(constants.RENEWABLES_CURRENT_PATH_V2, h.RootHandler(h.RenewablesCurrent))
+	http.Handle(constants.RENEWABLES_HISTORY_PATH_V2, h.RootHandler(h.RenewablesHistory))
+	http.Handle(constants.NOTIFICATION_PATH_V2, h.RootHandler(h.Notification))
+	http.Handle(constants.STATUS_PATH_V2, h.RootHandler(h.Status))
+	http.Handle(constants.DEADLETTERS_PATH_V2, h.RootHandler(h.DeadLetters))
+	http.Handle(constants.GRAPHQL_PATH, h.RootHandler(h.GraphQL))
+
+	// Stop when interrupted, such as when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start server
-	log.Println("Starting server on port " + port + " ...")
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		log.Println("Starting server on port " + port + " ...")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down server ...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), constants.DISPATCHER_SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	// Stop taking requests first, so no more invocations are dispatched
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Could not shut down server: " + err.Error())
+	}
+	grpcServer.GracefulStop()
+
+	// Finish the jobs queued, and store the invocations which could not be finished in time
+	if err := jobDispatcher.Shutdown(shutdownCtx); err != nil {
+		log.Println("Could not store all jobs of the dispatcher: " + err.Error())
+	}
+	log.Printf("Dispatcher shut down, %d jobs stored for later", jobDispatcher.Stats().Persisted)
+}
+
+/*
+Get a positive integer from an environment variable, or the default if it is not set
+
+	name			- Name of the environment variable
+	defaultValue	- Value used if the variable is not set to a positive integer
+
+	return	- The value
+*/
+func getPositiveIntFromEnv(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		log.Println("$" + name + " has not been set. Default: " + strconv.Itoa(defaultValue))
+		return defaultValue
+	}
 
+	return value
 }
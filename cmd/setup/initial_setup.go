@@ -31,9 +31,23 @@ func main() {
 	// Get data from csv file
 	data := createRenewablesDataStructForAppending()
 
+	// Get data as it was before the import, so threshold and dataset webhooks can be fired for the changes
+	oldData, err := db.GetAllDocumentInCollectionFromFirestore(constants.RENEWABLES_COLLECTION)
+	if err != nil {
+		log.Fatal("Couldn't get existing renewables data: " + err.Error())
+	}
+
 	// Add data to firestore
 	_ = db.AppendDataToFirestore(data, constants.RENEWABLES_COLLECTION)
 
+	// Store deliveries to threshold webhooks, which are sent by the running service
+	invoked := db.InvokeThresholds(oldData, data)
+	log.Printf("Stored %d deliveries to threshold webhooks", invoked)
+
+	// And to dataset webhooks, with the countries and years the import added or changed, which are also added to digests
+	updated := db.InvokeDatasetUpdates(oldData, data)
+	log.Printf("Stored %d deliveries to dataset webhooks and digests", updated)
+
 }
 
 /*
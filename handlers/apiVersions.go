@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/gateway"
+	"assignment2/utils/params"
+	"assignment2/utils/structs"
+	"net/http"
+	"strings"
+)
+
+/*
+Returns if the request was sent to the v2 API
+
+	r	- Request
+
+	return	- True if the request path is in the v2 tree
+*/
+func isV2Request(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, constants.SERVICE_PATH_V2+"/")
+}
+
+/*
+Returns if the request was sent to the v1 API
+
+	r	- Request
+
+	return	- True if the request path is in the v1 tree
+*/
+func isV1Request(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, constants.SERVICE_PATH+"/")
+}
+
+/*
+Sets headers marking the v1 API as deprecated, and links to the equivalent v2 path
+
+	w	- Responsewriter
+	r	- Request to the v1 API
+*/
+func setDeprecationHeaders(w http.ResponseWriter, r *http.Request) {
+	successorPath := constants.SERVICE_PATH_V2 + strings.TrimPrefix(r.URL.Path, constants.SERVICE_PATH)
+
+	w.Header().Set("Deprecation", constants.V1_DEPRECATION)
+	w.Header().Set("Sunset", constants.V1_SUNSET)
+	w.Header().Set("Link", "<"+successorPath+">; rel=\"successor-version\"")
+}
+
+/*
+Responds with the body wrapped in the v2 response envelope
+
+	w		- Responsewriter
+	r		- Request, used for the self link
+	data	- Any struct or slice which will be sent as the data field
+	count	- Amount of items in data
+	query	- The interpreted query parameters, echoed in the meta field
+	status	- Status code of the response
+*/
+func respondWithEnvelope(w http.ResponseWriter, r *http.Request, data interface{}, count int, query map[string]interface{}, status int) error {
+	return gateway.RespondToGetRequestWithJSON(w, createEnvelope(r, data, count, query), status)
+}
+
+/*
+Creates the v2 response envelope, with a self link to the request
+*/
+func createEnvelope(r *http.Request, data interface{}, count int, query map[string]interface{}) structs.Envelope {
+	return structs.Envelope{
+		Data: data,
+		Meta: structs.Meta{
+			Version: constants.VERSION_V2,
+			Count:   count,
+			Query:   query,
+		},
+		Links: map[string]string{
+			"self": r.URL.RequestURI(),
+		},
+	}
+}
+
+/*
+Responds with one page of a paginated list, linking to the next page if there is one.
+v1 responds with the list as it is and the link in a Link header, v2 has the link in the links of the envelope.
+
+	w			- Responsewriter
+	r			- Request, used for the self and next links
+	data		- Slice of items in the page
+	count		- Amount of items in the page
+	query		- The interpreted query parameters, echoed in the meta field
+	nextCursor	- Cursor of the next page, or empty if this is the last page
+	status		- Status code of the response
+*/
+func respondWithPage(w http.ResponseWriter, r *http.Request, data interface{}, count int, query map[string]interface{}, nextCursor string, status int) error {
+	var nextLink string
+	if nextCursor != "" {
+		nextURL := *r.URL
+		nextQuery := nextURL.Query()
+		nextQuery.Set("cursor", nextCursor)
+		nextURL.RawQuery = nextQuery.Encode()
+		nextLink = nextURL.RequestURI()
+	}
+
+	if isV2Request(r) {
+		envelope := createEnvelope(r, data, count, query)
+		if nextLink != "" {
+			envelope.Links["next"] = nextLink
+		}
+		return gateway.RespondToGetRequestWithJSON(w, envelope, status)
+	}
+
+	if nextLink != "" {
+		w.Header().Add("Link", "<"+nextLink+">; rel=\"next\"")
+	}
+	return gateway.RespondToGetRequestWithJSON(w, data, status)
+}
+
+/*
+Responds with renewables data in the format of the API version requested.
+v1 responds with the countryOutputs as they are, v2 wraps datapoints with integer years in the response envelope.
+
+	w			- Responsewriter
+	r			- Request
+	output		- List of countryOutput structs to respond with
+	begin		- The first year the output was created from
+	end			- The last year the output was created from
+	boolParams	- Names of the bool parameters the endpoint supports, echoed in the meta field
+*/
+func respondWithRenewables(w http.ResponseWriter, r *http.Request, output []structs.CountryOutput, begin int, end int, boolParams ...string) error {
+	if !isV2Request(r) {
+		return gateway.RespondToGetRequestWithJSON(w, output, http.StatusOK)
+	}
+
+	// Create v2 datapoints from the output
+	datapoints, err := structs.CreateDatapointsFromCountryOutput(output, begin, end)
+	if err != nil {
+		return err
+	}
+
+	return respondWithEnvelope(w, r, datapoints, len(datapoints), createRenewablesQueryEcho(r, begin, end, boolParams...), http.StatusOK)
+}
+
+/*
+Creates the query echo for renewables requests, with typed values
+
+	r			- Request
+	begin		- The first year used for the response
+	end			- The last year used for the response
+	boolParams	- Names of the bool parameters to echo
+
+	return		- Map of parameter names and their interpreted values
+*/
+func createRenewablesQueryEcho(r *http.Request, begin int, end int, boolParams ...string) map[string]interface{} {
+	query := map[string]interface{}{
+		"begin": begin,
+		"end":   end,
+	}
+
+	// Include country code or name if given in path
+	args := strings.Split(r.URL.Path, "/")
+	if len(args) > 5 && args[5] != "" {
+		query["country"] = args[5]
+	}
+
+	// Include each bool parameter, which defaults to false if not set
+	for _, paramName := range boolParams {
+		value, err := params.GetBoolParameterFromRequest(nil, r, paramName)
+		if err == nil {
+			query[paramName] = value
+		}
+	}
+
+	return query
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests responding with renewables data to the v1 and v2 API
+*/
+func TestRespondWithRenewables(t *testing.T) {
+	output := []structs.CountryOutput{
+		{Name: "Norway", IsoCode: "NOR", Year: "2021", Percentage: 71.558365},
+	}
+
+	// v1 should respond with the bare list
+	r := httptest.NewRequest(http.MethodGet, constants.RENEWABLES_CURRENT_PATH+"nor", nil)
+	w := httptest.NewRecorder()
+	err := respondWithRenewables(w, r, output, 2021, 2021, "neighbours", "sortByValue")
+	assert.Nil(t, err, "Responding to v1 request returned error")
+
+	var v1Body []structs.CountryOutput
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&v1Body), "v1 response should be a list")
+	assert.Equal(t, output, v1Body, "v1 response should be unchanged")
+
+	// v2 should respond with the envelope
+	r = httptest.NewRequest(http.MethodGet, constants.RENEWABLES_CURRENT_PATH_V2+"nor?sortByValue=true", nil)
+	w = httptest.NewRecorder()
+	err = respondWithRenewables(w, r, output, 2021, 2021, "neighbours", "sortByValue")
+	assert.Nil(t, err, "Responding to v2 request returned error")
+
+	var v2Body struct {
+		Data  []structs.RenewableDatapoint `json:"data"`
+		Meta  structs.Meta                 `json:"meta"`
+		Links map[string]string            `json:"links"`
+	}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&v2Body), "v2 response should be an envelope")
+	assert.Equal(t, 1, len(v2Body.Data), "Wrong amount of datapoints returned")
+	assert.Equal(t, 2021, *v2Body.Data[0].Year, "Year should be an integer")
+	assert.Equal(t, constants.VERSION_V2, v2Body.Meta.Version, "Wrong version in meta")
+	assert.Equal(t, 1, v2Body.Meta.Count, "Wrong count in meta")
+	assert.Equal(t, "nor", v2Body.Meta.Query["country"], "Country should be echoed in meta")
+	assert.Equal(t, true, v2Body.Meta.Query["sortByValue"], "sortByValue should be echoed as bool")
+	assert.Equal(t, false, v2Body.Meta.Query["neighbours"], "neighbours should default to false")
+	assert.Equal(t, constants.RENEWABLES_CURRENT_PATH_V2+"nor?sortByValue=true", v2Body.Links["self"], "Wrong self link")
+}
+
+/*
+Tests that v1 responses are marked as deprecated, and v2 responses are not
+*/
+func TestDeprecationHeaders(t *testing.T) {
+	handler := RootHandler(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	// v1 request
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, constants.STATUS_PATH, nil))
+	assert.Equal(t, constants.V1_DEPRECATION, w.Header().Get("Deprecation"), "v1 response should have Deprecation header")
+	assert.Equal(t, constants.V1_SUNSET, w.Header().Get("Sunset"), "v1 response should have Sunset header")
+	assert.Contains(t, w.Header().Get("Link"), constants.STATUS_PATH_V2, "v1 response should link to v2")
+
+	// v2 request
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, constants.STATUS_PATH_V2, nil))
+	assert.Empty(t, w.Header().Get("Deprecation"), "v2 response should not have Deprecation header")
+	assert.Empty(t, w.Header().Get("Sunset"), "v2 response should not have Sunset header")
+}
+
+/*
+Tests that paginated lists link to the next page in the format of the API version requested
+*/
+func TestRespondWithPage(t *testing.T) {
+	data := []string{"a", "b"}
+
+	// v1 has the link in a Link header, and the list as the body
+	r := httptest.NewRequest(http.MethodGet, constants.NOTIFICATION_PATH+"abc/deliveries?limit=2", nil)
+	w := httptest.NewRecorder()
+	assert.Nil(t, respondWithPage(w, r, data, len(data), map[string]interface{}{}, "next123", http.StatusOK))
+	assert.Equal(t, `<`+constants.NOTIFICATION_PATH+`abc/deliveries?cursor=next123&limit=2>; rel="next"`, w.Header().Get("Link"), "Wrong next link")
+
+	var list []string
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&list), "Body should be a list")
+	assert.Equal(t, data, list, "Wrong list")
+
+	// v2 has the link in the envelope
+	r = httptest.NewRequest(http.MethodGet, constants.NOTIFICATION_PATH_V2+"abc/deliveries?cursor=prev&limit=2", nil)
+	w = httptest.NewRecorder()
+	assert.Nil(t, respondWithPage(w, r, data, len(data), map[string]interface{}{}, "next123", http.StatusOK))
+
+	var envelope structs.Envelope
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&envelope), "Body should be an envelope")
+	assert.Equal(t, constants.NOTIFICATION_PATH_V2+"abc/deliveries?cursor=next123&limit=2", envelope.Links["next"], "Wrong next link")
+	assert.Equal(t, 2, envelope.Meta.Count, "Wrong count")
+
+	// The last page has no next link
+	w = httptest.NewRecorder()
+	assert.Nil(t, respondWithPage(w, r, data, len(data), map[string]interface{}{}, "", http.StatusOK))
+	envelope = structs.Envelope{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&envelope), "Body should be an envelope")
+	_, hasNext := envelope.Links["next"]
+	assert.False(t, hasNext, "Last page should not link to a next page")
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"assignment2/utils/auth"
+	"assignment2/utils/constants"
+	"assignment2/utils/db"
+	"assignment2/utils/gateway"
+	"assignment2/utils/params"
+	"assignment2/utils/structs"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+/*
+Handler for dead-letter endpoint, with webhook deliveries which failed too many times
+*/
+func DeadLetters(w http.ResponseWriter, r *http.Request) error {
+	// Dead-letters contain the webhooks of all clients, so only the admin may use them
+	client, err := auth.ClientFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if !client.Admin {
+		return auth.AdminRequired()
+	}
+
+	// Check if database is online. If not, give standard error response.
+	if !db.DbState {
+		usrMsg := fmt.Sprintf("The database is currently unavailable. Please try again later. Reattempting database connection in %v seconds.", time.Until(db.DbRestartTimerStartTime.Add(1*time.Minute)).Round(time.Second)) //Create message with time since timer was activated
+		return structs.NewCodedError(nil, http.StatusServiceUnavailable, constants.ERR_DATABASE_UNAVAILABLE, "", usrMsg, "")
+	}
+
+	deadLetterID, action, err := params.GetDeadLetterIDAndActionFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	// Replay has its own path below the dead-letter
+	if action != "" {
+		if action != constants.DEADLETTER_REPLAY_PATH || deadLetterID == "" {
+			return structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PATH, "id", "Malformed URL, Expecting format "+constants.DEADLETTERS_PATH+"{deadLetterID}/"+constants.DEADLETTER_REPLAY_PATH, "")
+		}
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(w, http.MethodPost)
+		}
+		return replayOfDeadLetter(w, r, deadLetterID)
+	}
+
+	// Send request to different functions based on method
+	switch r.Method {
+	case http.MethodGet:
+		return viewDeadLetters(w, r, deadLetterID)
+	case http.MethodDelete:
+		return deletionOfDeadLetter(w, deadLetterID)
+	default:
+		return methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+/*
+Get dead-letter, or all if none is specified, and then respond to user
+*/
+func viewDeadLetters(w http.ResponseWriter, r *http.Request, deadLetterID string) error {
+	// Respond with only the dead-letter requested
+	if deadLetterID != "" {
+		if !db.DocumentInCollection(deadLetterID, constants.DEADLETTERS_COLLECTION) {
+			return deadLetterNotFound()
+		}
+
+		data, err := db.GetDocumentFromFirestore(deadLetterID, constants.DEADLETTERS_COLLECTION)
+		if err != nil {
+			return err
+		}
+		deadLetter := structs.CreateDeadLetterFromData(data, deadLetterID)
+
+		if isV2Request(r) {
+			return respondWithEnvelope(w, r, deadLetter, 1, map[string]interface{}{"deadLetterId": deadLetterID}, http.StatusOK)
+		}
+		return gateway.RespondToGetRequestWithJSON(w, deadLetter, http.StatusOK)
+	}
+
+	data, err := db.GetAllDocumentInCollectionFromFirestore(constants.DEADLETTERS_COLLECTION)
+	if err != nil {
+		return err
+	}
+
+	// Always respond with a list, newest failure first
+	deadLetters := []structs.DeadLetter{}
+	for id, deadLetterData := range data {
+		deadLetters = append(deadLetters, structs.CreateDeadLetterFromData(deadLetterData, id))
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].FailedAt.After(deadLetters[j].FailedAt)
+	})
+
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, deadLetters, len(deadLetters), map[string]interface{}{}, http.StatusOK)
+	}
+	return gateway.RespondToGetRequestWithJSON(w, deadLetters, http.StatusOK)
+}
+
+/*
+Move dead-letter back to the deliveries, and respond with its ID to the user
+*/
+func replayOfDeadLetter(w http.ResponseWriter, r *http.Request, deadLetterID string) error {
+	err := db.ReplayDeadLetter(deadLetterID)
+	if err != nil {
+		return err
+	}
+
+	// The delivery is attempted in the background, so the request is only accepted
+	response := map[string]string{"deadletter_id": deadLetterID}
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, response, 1, map[string]interface{}{"deadLetterId": deadLetterID}, http.StatusAccepted)
+	}
+	return gateway.RespondToGetRequestWithJSON(w, response, http.StatusAccepted)
+}
+
+/*
+Delete dead-letter without delivering it
+*/
+func deletionOfDeadLetter(w http.ResponseWriter, deadLetterID string) error {
+	if deadLetterID == "" || !db.DocumentInCollection(deadLetterID, constants.DEADLETTERS_COLLECTION) {
+		return deadLetterNotFound()
+	}
+
+	err := db.DeleteDocument(deadLetterID, constants.DEADLETTERS_COLLECTION)
+	if err != nil {
+		return err
+	}
+
+	// Respond with status no content
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+/*
+Returns the error for dead-letter IDs which do not exist
+*/
+func deadLetterNotFound() error {
+	return structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_DEADLETTER_NOT_FOUND, "id", "Invalid dead-letter ID given", "Dead-letter ID given was not found in database")
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"assignment2/utils/auth"
+	"assignment2/utils/constants"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that malformed dead-letter urls and methods are rejected before the database is used
+*/
+func TestDeadLettersMalformedRequests(t *testing.T) {
+	handler := RootHandler(DeadLetters)
+	auth.SetAdminKey("admin-key")
+	defer auth.SetAdminKey("")
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, constants.DEADLETTERS_PATH + "abc/replay/extra", http.StatusBadRequest},
+		{http.MethodPost, constants.DEADLETTERS_PATH + "abc/unknown", http.StatusBadRequest},
+		{http.MethodGet, constants.DEADLETTERS_PATH + "abc/" + constants.DEADLETTER_REPLAY_PATH, http.StatusMethodNotAllowed},
+		{http.MethodPut, constants.DEADLETTERS_PATH_V2, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.Header.Set(constants.API_KEY_HEADER, "admin-key")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, test.status, w.Code, "Wrong status code for "+test.method+" "+test.path)
+	}
+}
+
+/*
+Tests that dead-letters are only available to the admin
+*/
+func TestDeadLettersRequireAdmin(t *testing.T) {
+	handler := RootHandler(DeadLetters)
+	auth.SetAdminKey("admin-key")
+	defer auth.SetAdminKey("")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, constants.DEADLETTERS_PATH, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "Requests without an API key should be rejected")
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, constants.DEADLETTERS_PATH, nil)
+	r.Header.Set(constants.API_KEY_HEADER, "client-key")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code, "Clients other than the admin should be rejected")
+}
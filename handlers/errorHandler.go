@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"assignment2/utils/gateway"
 	"assignment2/utils/structs"
+	"errors"
 	"log"
 	"net/http"
+	"regexp"
+	"strings"
 )
 
+// Characters allowed in request IDs given by clients
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 /*
 RootHandler is a wrapper for all handlers in the service.
 It handles all errors in the same place, and logs them.
@@ -14,21 +23,96 @@ type RootHandler func(http.ResponseWriter, *http.Request) error
 
 // Handles all errors in the same place.
 func (fn RootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Use request ID given by client, or create a new one, and return it in the response
+	requestID := getRequestId(r)
+	w.Header().Set(constants.REQUEST_ID_HEADER, requestID)
+
+	// Mark all responses from the v1 API as deprecated
+	if isV1Request(r) {
+		setDeprecationHeaders(w, r)
+	}
+
 	err := fn(w, r) // Calls original function, then awaits errors to "bubble" back up
 	if err == nil { // If there are no errors
 		return
 	}
 
 	// If error is of type wrappederror, special logging actions will be taken.
-	switch e := err.(type) {
-	case structs.WrappedError:
-		if e.DevMessage != "" {
-			log.Println(e.DevMessage) //Logs dev message
+	var wrappedErr structs.WrappedError
+	if errors.As(err, &wrappedErr) {
+		if wrappedErr.DevMessage != "" {
+			log.Println("[" + requestID + "] " + wrappedErr.DevMessage) //Logs dev message
+		}
+	} else {
+		log.Println("[" + requestID + "] Non-wrapped error:")
+		wrappedErr = structs.WrappedError{
+			OrigErr:    err,
+			StatusCode: http.StatusInternalServerError,
+			UsrMessage: constants.DEFAULT500,
 		}
-		http.Error(w, e.UsrMessage, e.StatusCode) //Returns user message and error code
-	default:
-		log.Println("Non-wrapped error:")
-		http.Error(w, "", http.StatusInternalServerError)
 	}
+
+	// Returns problem details with user message and error code
+	gateway.RespondWithProblem(w, createProblem(wrappedErr, r, requestID))
+
 	log.Println("\t" + err.Error()) //Logs original error
 }
+
+/*
+Creates RFC 7807 problem details from a wrapped error
+
+	err			- Error to create problem details from
+	r			- Request which caused the error
+	requestID	- ID of the request
+
+	return		- Problem details to send to the user
+*/
+func createProblem(err structs.WrappedError, r *http.Request, requestID string) structs.Problem {
+	// Use default error code if none is set
+	code := err.Code
+	if code == "" {
+		code = structs.DefaultErrorCode(err.StatusCode)
+	}
+
+	return structs.Problem{
+		Type:      constants.PROBLEM_TYPE_BASE + code,
+		Title:     http.StatusText(err.StatusCode),
+		Status:    err.StatusCode,
+		Detail:    err.UsrMessage,
+		Instance:  r.URL.Path,
+		Code:      code,
+		Param:     err.Param,
+		RequestId: requestID,
+	}
+}
+
+/*
+Get request ID from request header if valid, or create a new one
+
+	r	- Request
+
+	return	- ID of the request
+*/
+func getRequestId(r *http.Request) string {
+	requestID := r.Header.Get(constants.REQUEST_ID_HEADER)
+
+	// Only use IDs given by clients if they are short and contain no special characters
+	if len(requestID) > 0 && len(requestID) <= constants.MAX_REQUEST_ID_LENGTH && validRequestId.MatchString(requestID) {
+		return requestID
+	}
+
+	return div.CreateRequestId()
+}
+
+/*
+Creates an error for requests using an unsupported method, and sets the Allow header
+
+	w		- Responsewriter
+	allowed	- Methods supported by the endpoint
+
+	return	- Error with status method not allowed
+*/
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) error {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	return structs.NewCodedError(nil, http.StatusMethodNotAllowed, constants.ERR_METHOD_NOT_ALLOWED, "", "Invalid method, currently only "+strings.Join(allowed, ", ")+" supported", "User used invalid http method")
+}
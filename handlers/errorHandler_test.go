@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that wrapped errors are sent as problem details, with the request ID given by the client
+*/
+func TestRootHandlerWrappedError(t *testing.T) {
+	handler := RootHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "begin", "Malformed URL, invalid begin parameter set", "")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, constants.RENEWABLES_HISTORY_PATH_V2+"nor?begin=abc", nil)
+	r.Header.Set(constants.REQUEST_ID_HEADER, "test-request-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code, "Wrong status code")
+	assert.Equal(t, constants.CONT_TYPE_PROBLEM_JSON, w.Header().Get("content-type"), "Wrong content type")
+	assert.Equal(t, "test-request-1", w.Header().Get(constants.REQUEST_ID_HEADER), "Request ID should be echoed")
+
+	var problem structs.Problem
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&problem), "Body should be problem details")
+	assert.Equal(t, constants.PROBLEM_TYPE_BASE+constants.ERR_MALFORMED_PARAMETER, problem.Type, "Wrong problem type")
+	assert.Equal(t, "Bad Request", problem.Title, "Wrong problem title")
+	assert.Equal(t, http.StatusBadRequest, problem.Status, "Wrong problem status")
+	assert.Equal(t, "Malformed URL, invalid begin parameter set", problem.Detail, "Wrong problem detail")
+	assert.Equal(t, constants.ERR_MALFORMED_PARAMETER, problem.Code, "Wrong problem code")
+	assert.Equal(t, "begin", problem.Param, "Wrong problem param")
+	assert.Equal(t, "test-request-1", problem.RequestId, "Wrong problem request ID")
+}
+
+/*
+Tests that non-wrapped errors are sent as internal server errors, with a generated request ID
+*/
+func TestRootHandlerNonWrappedError(t *testing.T) {
+	handler := RootHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("unexpected error")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, constants.STATUS_PATH, nil)
+	r.Header.Set(constants.REQUEST_ID_HEADER, "invalid id with spaces")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var problem structs.Problem
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&problem), "Body should be problem details")
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "Wrong status code")
+	assert.Equal(t, "internal_server_error", problem.Code, "Wrong problem code")
+	assert.Equal(t, constants.DEFAULT500, problem.Detail, "Wrong problem detail")
+	assert.NotEqual(t, "invalid id with spaces", problem.RequestId, "Invalid request ID should be replaced")
+	assert.Equal(t, w.Header().Get(constants.REQUEST_ID_HEADER), problem.RequestId, "Request ID in header and body should match")
+}
+
+/*
+Tests that unsupported methods give method not allowed, with the Allow header set
+*/
+func TestMethodNotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	handler := RootHandler(Default)
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, constants.DEFAULT_PATH, nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "Wrong status code")
+	assert.Equal(t, http.MethodGet, w.Header().Get("Allow"), "Wrong Allow header")
+}
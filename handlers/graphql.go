@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"assignment2/utils/auth"
+	"assignment2/utils/constants"
+	"assignment2/utils/db"
+	"assignment2/utils/gateway"
+	"assignment2/utils/params"
+	"assignment2/utils/structs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// Schema of the GraphQL endpoint. Arguments mirror the parameters of the REST endpoints.
+const graphqlSchemaString = `
+	schema {
+		query: Query
+	}
+
+	type Query {
+		# A country by ISO code or name
+		country(code: String, name: String): Country
+		# Countries by ISO code, or all countries with renewables data if no codes are given
+		countries(codes: [String!]): [Country!]!
+		# Same as /renewables/current/{country?}
+		current(country: String, neighbours: Boolean, sortByValue: Boolean): [RenewableDatapoint!]!
+		# Same as /renewables/history/{country?}
+		history(country: String, begin: Int, end: Int, neighbours: Boolean, sortByValue: Boolean, mean: Boolean): [RenewableDatapoint!]!
+		# All registered webhooks
+		webhooks: [Webhook!]!
+		# A webhook by ID
+		webhook(id: ID!): Webhook
+	}
+
+	type Country {
+		isoCode: String!
+		name: String
+		borders: [Country!]!
+		current: RenewableDatapoint
+		history(begin: Int, end: Int, mean: Boolean, sortByValue: Boolean): [RenewableDatapoint!]!
+	}
+
+	type RenewableDatapoint {
+		name: String!
+		isoCode: String!
+		year: Int
+		percentage: Float!
+		aggregation: String!
+		yearFrom: Int!
+		yearTo: Int!
+		country: Country!
+	}
+
+	type Webhook {
+		id: ID!
+		url: String!
+		country: Country
+		calls: Int!
+		year: Int
+	}
+`
+
+// Parsed GraphQL schema, resolved by the query resolver
+var graphqlSchema = graphql.MustParseSchema(graphqlSchemaString, &queryResolver{}, graphql.MaxParallelism(constants.GRAPHQL_MAX_PARALLELISM))
+
+/*
+Struct for decoding GraphQL requests
+*/
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+/*
+Handler for GraphQL endpoint
+*/
+func GraphQL(w http.ResponseWriter, r *http.Request) error {
+	// Check if database is online. If not, give standard error response.
+	if !db.DbState {
+		usrMsg := fmt.Sprintf("The database is currently unavailable. Please try again later. Reattempting database connection in %v seconds.", time.Until(db.DbRestartTimerStartTime.Add(1*time.Minute)).Round(time.Second)) //Create message with time since timer was activated
+		return structs.NewCodedError(nil, http.StatusServiceUnavailable, constants.ERR_DATABASE_UNAVAILABLE, "", usrMsg, "")
+	}
+
+	var request graphqlRequest
+
+	// Get query from url parameters on GET, and from json body on POST
+	switch r.Method {
+	case http.MethodGet:
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "variables", "Malformed URL, invalid variables parameter set", "")
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_INVALID_BODY, "", "Invalid request body for GraphQL query", "There was an error when decoding GraphQL request from json.")
+		}
+	default:
+		return methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+
+	if request.Query == "" {
+		return structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MISSING_FIELD, "query", "A GraphQL query must be given", "")
+	}
+
+	// Execute query with new loaders, so batches and results are not shared between requests
+	ctx := context.WithValue(r.Context(), loadersContextKey{}, newGraphqlLoaders())
+
+	// Webhooks are only resolved for requests with an API key, while the other fields need none
+	if client, err := auth.ClientFromRequest(r); err == nil {
+		ctx = auth.NewContext(ctx, client)
+	}
+	response := graphqlSchema.Exec(ctx, request.Query, request.OperationName, request.Variables)
+
+	return gateway.RespondToGetRequestWithJSON(w, response, http.StatusOK)
+}
+
+/*
+GraphQL error with the user message and error code of a wrapped error
+*/
+type graphqlError struct {
+	structs.WrappedError
+}
+
+// Returns the user message, as this is what GraphQL clients see
+func (e graphqlError) Error() string {
+	return e.UsrMessage
+}
+
+// Returns the error code and parameter as GraphQL error extensions
+func (e graphqlError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":   e.Code,
+		"status": e.StatusCode,
+	}
+	if e.Param != "" {
+		extensions["param"] = e.Param
+	}
+	return extensions
+}
+
+/*
+Converts errors from the service into errors suitable for GraphQL clients
+
+	err	- Error to convert
+
+	return	- Error with user message, or a default internal error if the error is not wrapped
+*/
+func toGraphqlError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var wrappedErr structs.WrappedError
+	if !errors.As(err, &wrappedErr) {
+		wrappedErr = structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "").(structs.WrappedError)
+	}
+
+	return graphqlError{wrappedErr}
+}
+
+/*
+Resolver for the root query type
+*/
+type queryResolver struct{}
+
+/*
+Resolves a country by ISO code or name
+*/
+func (q *queryResolver) Country(ctx context.Context, args struct {
+	Code *string
+	Name *string
+}) (*countryResolver, error) {
+	var codeOrName string
+	if args.Code != nil {
+		codeOrName = *args.Code
+	} else if args.Name != nil {
+		codeOrName = *args.Name
+	} else {
+		return nil, toGraphqlError(structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MISSING_FIELD, "code", "Either code or name must be given", ""))
+	}
+
+	countries, err := params.ResolveCountriesToQuery(codeOrName, false)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	// An empty code or name resolves to no country
+	if len(countries) == 0 {
+		return nil, nil
+	}
+
+	return &countryResolver{isoCode: countries[0]}, nil
+}
+
+/*
+Resolves countries by ISO code, or all countries with renewables data
+*/
+func (q *queryResolver) Countries(ctx context.Context, args struct{ Codes *[]string }) ([]*countryResolver, error) {
+	loaders := loadersFromContext(ctx)
+	var isoCodes []string
+
+	if args.Codes != nil {
+		// Only include countries which have renewables data
+		data, err := db.GetDocumentsFromFirestore(*args.Codes, constants.RENEWABLES_COLLECTION)
+		if err != nil {
+			return nil, toGraphqlError(err)
+		}
+		loaders.renewables.prime(data)
+
+		for _, isoCode := range *args.Codes {
+			if _, ok := data[isoCode]; ok {
+				isoCodes = append(isoCodes, isoCode)
+			}
+		}
+	} else {
+		// Get all countries, and keep the data for resolving their renewables fields
+		data, err := db.GetAllDocumentInCollectionFromFirestore(constants.RENEWABLES_COLLECTION)
+		if err != nil {
+			return nil, toGraphqlError(err)
+		}
+		loaders.renewables.prime(data)
+
+		for isoCode := range data {
+			isoCodes = append(isoCodes, isoCode)
+		}
+		sort.Strings(isoCodes)
+	}
+
+	return createCountryResolvers(isoCodes), nil
+}
+
+/*
+Resolves the current renewables for countries, the same way as the current endpoint
+*/
+func (q *queryResolver) Current(ctx context.Context, args struct {
+	Country     *string
+	Neighbours  *bool
+	SortByValue *bool
+}) ([]*datapointResolver, error) {
+	countries, err := params.ResolveCountriesToQuery(valueOrDefault(args.Country, ""), valueOrDefault(args.Neighbours, false))
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	// Invoke webhooks
+	db.DispatchInvocation(countries, constants.LATEST_YEAR_DB, constants.LATEST_YEAR_DB)
+
+	output, err := getCurrentRenewablesForCountries(nil, countries, valueOrDefault(args.SortByValue, false))
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	return createDatapointResolvers(output, constants.LATEST_YEAR_DB, constants.LATEST_YEAR_DB)
+}
+
+/*
+Resolves the historical renewables for countries, the same way as the history endpoint
+*/
+func (q *queryResolver) History(ctx context.Context, args struct {
+	Country     *string
+	Begin       *int32
+	End         *int32
+	Neighbours  *bool
+	SortByValue *bool
+	Mean        *bool
+}) ([]*datapointResolver, error) {
+	beginYear, endYear, err := getYearRangeArguments(args.Begin, args.End)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	countries, err := params.ResolveCountriesToQuery(valueOrDefault(args.Country, ""), valueOrDefault(args.Neighbours, false))
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	// Invoke webhooks
+	db.DispatchInvocation(countries, beginYear, endYear)
+
+	output, err := getHistoryRenewablesForCountries(nil, countries, beginYear, endYear, valueOrDefault(args.SortByValue, false), valueOrDefault(args.Mean, false))
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	return createDatapointResolvers(output, beginYear, endYear)
+}
+
+/*
+Resolves the webhooks registered by the client, or all webhooks for the admin
+*/
+func (q *queryResolver) Webhooks(ctx context.Context) ([]*webhookResolver, error) {
+	client, err := auth.ClientFromContext(ctx)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	webhooks, err := getWebhooks(client, "")
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	return createWebhookResolvers(webhooks), nil
+}
+
+/*
+Resolves a webhook by ID
+*/
+func (q *queryResolver) Webhook(ctx context.Context, args struct{ Id graphql.ID }) (*webhookResolver, error) {
+	client, err := auth.ClientFromContext(ctx)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+	webhookID := string(args.Id)
+
+	// Check if the webhookID is valid
+	if webhookID == "" || !checkIfValidWebhookId(webhookID) {
+		return nil, nil
+	}
+
+	// Webhooks owned by another client resolve to null, like webhooks which do not exist
+	webhooks, err := getWebhooks(client, webhookID)
+	var wrappedErr structs.WrappedError
+	if errors.As(err, &wrappedErr) && wrappedErr.Code == constants.ERR_WEBHOOK_NOT_FOUND {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	return &webhookResolver{webhook: webhooks[0]}, nil
+}
+
+/*
+Get the year range from GraphQL arguments, using the same defaults and checks as the history endpoint
+
+	begin	- The begin argument, or nil if not given
+	end		- The end argument, or nil if not given
+
+	return	- The begin and end year, or error if they are outside of the database scope
+*/
+func getYearRangeArguments(begin *int32, end *int32) (int, int, error) {
+	beginYear := constants.OLDEST_YEAR_DB
+	endYear := constants.LATEST_YEAR_DB
+
+	if begin != nil {
+		beginYear = int(*begin)
+	}
+	if end != nil {
+		endYear = int(*end)
+	}
+
+	return beginYear, endYear, params.CheckYearRange(beginYear, endYear)
+}
+
+/*
+Returns the value pointed to, or the default value if the pointer is nil
+*/
+func valueOrDefault[T any](value *T, defaultValue T) T {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
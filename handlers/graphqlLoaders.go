@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/db"
+	"assignment2/utils/gateway"
+	"assignment2/utils/structs"
+	"context"
+	"sync"
+	"time"
+)
+
+// Key for storing the loaders of a GraphQL request in its context
+type loadersContextKey struct{}
+
+/*
+Loaders used by the resolvers of one GraphQL request.
+Keys requested by concurrent resolvers are collected into one batch, so that nested fields such as borders
+are fetched with one lookup per level instead of one lookup per country.
+*/
+type graphqlLoaders struct {
+	countries  *batchLoader[*structs.Country]
+	renewables *batchLoader[map[string]interface{}]
+}
+
+/*
+Creates new loaders for a GraphQL request
+
+	return	- Loaders backed by the restcountries API and firestore
+*/
+func newGraphqlLoaders() *graphqlLoaders {
+	return &graphqlLoaders{
+		countries: newBatchLoader(func(keys []string) (map[string]*structs.Country, error) {
+			return gateway.GetCountriesByIso(keys, constants.COUNTRIES_API_URL)
+		}),
+		renewables: newBatchLoader(func(keys []string) (map[string]map[string]interface{}, error) {
+			return db.GetDocumentsFromFirestore(keys, constants.RENEWABLES_COLLECTION)
+		}),
+	}
+}
+
+/*
+Get the loaders of the GraphQL request from its context
+
+	ctx	- Context of the GraphQL request
+
+	return	- Loaders of the request
+*/
+func loadersFromContext(ctx context.Context) *graphqlLoaders {
+	return ctx.Value(loadersContextKey{}).(*graphqlLoaders)
+}
+
+/*
+Result of loading one key
+*/
+type loaderResult[V any] struct {
+	value V
+	found bool
+	err   error
+	done  chan struct{}
+}
+
+/*
+Loader which collects keys requested within a short window, and fetches them in one batch.
+Results are kept for the lifetime of the loader, which is one request.
+*/
+type batchLoader[V any] struct {
+	fetch   func(keys []string) (map[string]V, error)
+	mutex   sync.Mutex
+	results map[string]*loaderResult[V]
+	batch   []string
+}
+
+/*
+Creates a new batch loader
+
+	fetch	- Function fetching values for a batch of keys. Keys not found should be left out of the result.
+
+	return	- The loader
+*/
+func newBatchLoader[V any](fetch func(keys []string) (map[string]V, error)) *batchLoader[V] {
+	return &batchLoader[V]{
+		fetch:   fetch,
+		results: make(map[string]*loaderResult[V]),
+	}
+}
+
+/*
+Primes the loader with values already fetched, so they will not be fetched again
+
+	values	- Map of keys and values
+*/
+func (l *batchLoader[V]) prime(values map[string]V) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, value := range values {
+		if _, ok := l.results[key]; ok {
+			continue
+		}
+		result := &loaderResult[V]{value: value, found: true, done: make(chan struct{})}
+		close(result.done)
+		l.results[key] = result
+	}
+}
+
+/*
+Loads the value of one key, waiting for the batch it is part of
+
+	key	- Key to load
+
+	return	- The value, if it was found, and error from fetching the batch
+*/
+func (l *batchLoader[V]) load(key string) (V, bool, error) {
+	l.mutex.Lock()
+	result, ok := l.results[key]
+	if !ok {
+		// Add key to the current batch, and schedule the batch if it is new
+		result = &loaderResult[V]{done: make(chan struct{})}
+		l.results[key] = result
+		l.batch = append(l.batch, key)
+		if len(l.batch) == 1 {
+			time.AfterFunc(constants.GRAPHQL_BATCH_WINDOW, l.dispatch)
+		}
+	}
+	l.mutex.Unlock()
+
+	<-result.done
+	return result.value, result.found, result.err
+}
+
+/*
+Loads the values of multiple keys in the same batch
+
+	keys	- Keys to load
+
+	return	- The values found, in the same order as the keys, and error from fetching the batch
+*/
+func (l *batchLoader[V]) loadMany(keys []string) ([]V, error) {
+	var values []V
+	results := make([]struct {
+		value V
+		found bool
+		err   error
+	}, len(keys))
+
+	// Load all keys concurrently so they end up in the same batch
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			results[i].value, results[i].found, results[i].err = l.load(key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+		if result.found {
+			values = append(values, result.value)
+		}
+	}
+
+	return values, nil
+}
+
+/*
+Fetches all keys in the current batch, and gives the results to everyone waiting
+*/
+func (l *batchLoader[V]) dispatch() {
+	// Take the current batch, so new keys are put in a new batch
+	l.mutex.Lock()
+	keys := l.batch
+	l.batch = nil
+	l.mutex.Unlock()
+
+	values, err := l.fetch(keys)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, key := range keys {
+		result := l.results[key]
+		result.value, result.found = values[key]
+		result.err = err
+		close(result.done)
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"context"
+	"sort"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+/*
+Resolver for the Country type. Only the ISO code is known up front, other fields are fetched through the request loaders.
+*/
+type countryResolver struct {
+	isoCode string
+}
+
+/*
+Creates country resolvers from a list of ISO codes
+*/
+func createCountryResolvers(isoCodes []string) []*countryResolver {
+	resolvers := make([]*countryResolver, 0, len(isoCodes))
+	for _, isoCode := range isoCodes {
+		resolvers = append(resolvers, &countryResolver{isoCode: isoCode})
+	}
+	return resolvers
+}
+
+// Resolves the ISO code of the country
+func (c *countryResolver) IsoCode() string {
+	return c.isoCode
+}
+
+// Resolves the name of the country from the restcountries API, or null if the dataset has no name for it
+func (c *countryResolver) Name(ctx context.Context) (*string, error) {
+	country, found, err := loadersFromContext(ctx).countries.load(c.isoCode)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	// Fall back to the name in the renewables dataset
+	if !found {
+		data, found, err := loadersFromContext(ctx).renewables.load(c.isoCode)
+		if err != nil || !found {
+			return &c.isoCode, toGraphqlError(err)
+		}
+		name, ok := data["name"].(string)
+		if !ok {
+			return nil, nil
+		}
+		return &name, nil
+	}
+
+	return &country.Name, nil
+}
+
+// Resolves the neighbours of the country, loading all of them in one batch
+func (c *countryResolver) Borders(ctx context.Context) ([]*countryResolver, error) {
+	loaders := loadersFromContext(ctx)
+
+	country, found, err := loaders.countries.load(c.isoCode)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+	if !found {
+		return []*countryResolver{}, nil
+	}
+
+	// Load all neighbours in the same batch, so they are fetched together
+	borders, err := loaders.countries.loadMany(country.Borders)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	resolvers := make([]*countryResolver, 0, len(borders))
+	for _, border := range borders {
+		resolvers = append(resolvers, &countryResolver{isoCode: border.IsoCode})
+	}
+
+	return resolvers, nil
+}
+
+// Resolves the renewables percentage of the country for the latest year in the database
+func (c *countryResolver) Current(ctx context.Context) (*datapointResolver, error) {
+	datapoints, err := c.getDatapoints(ctx, constants.LATEST_YEAR_DB, constants.LATEST_YEAR_DB, structs.CreateCountryOutputFromData, false)
+	if err != nil || len(datapoints) == 0 {
+		return nil, err
+	}
+
+	return datapoints[0], nil
+}
+
+// Resolves the historical renewables percentages of the country
+func (c *countryResolver) History(ctx context.Context, args struct {
+	Begin       *int32
+	End         *int32
+	Mean        *bool
+	SortByValue *bool
+}) ([]*datapointResolver, error) {
+	beginYear, endYear, err := getYearRangeArguments(args.Begin, args.End)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	// Get mean value if specified, else value for each year
+	createCountryOutput := structs.CreateCountryOutputFromData
+	if valueOrDefault(args.Mean, false) {
+		createCountryOutput = structs.CreateMeanCountryOutputFromData
+	}
+
+	return c.getDatapoints(ctx, beginYear, endYear, createCountryOutput, valueOrDefault(args.SortByValue, false))
+}
+
+/*
+Creates datapoint resolvers for the country from its renewables data, using the same functions as the REST endpoints
+
+	ctx					- Context of the GraphQL request
+	beginYear			- The first year to get data from
+	endYear				- The last year to get data from
+	createCountryOutput	- Function for creating the countryOutputs, based on years or mean
+	sortByPercentage	- If the output should be sorted by percentage
+
+	return	- Datapoint resolvers, empty if the country has no renewables data
+*/
+func (c *countryResolver) getDatapoints(ctx context.Context, beginYear int, endYear int, createCountryOutput func(map[string]interface{}, string, int, int) ([]structs.CountryOutput, error), sortByPercentage bool) ([]*datapointResolver, error) {
+	data, found, err := loadersFromContext(ctx).renewables.load(c.isoCode)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+	if !found {
+		return []*datapointResolver{}, nil
+	}
+
+	output, err := createCountryOutput(data, c.isoCode, beginYear, endYear)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	if sortByPercentage {
+		output = sortOutputByPercentage(output)
+	}
+
+	return createDatapointResolvers(output, beginYear, endYear)
+}
+
+/*
+Resolver for the RenewableDatapoint type
+*/
+type datapointResolver struct {
+	datapoint structs.RenewableDatapoint
+}
+
+/*
+Creates datapoint resolvers from countryOutputs, with the same fields as in the v2 API
+
+	output		- List of countryOutput structs
+	beginYear	- The first year the output was created from
+	endYear		- The last year the output was created from
+
+	return	- Datapoint resolvers
+*/
+func createDatapointResolvers(output []structs.CountryOutput, beginYear int, endYear int) ([]*datapointResolver, error) {
+	datapoints, err := structs.CreateDatapointsFromCountryOutput(output, beginYear, endYear)
+	if err != nil {
+		return nil, toGraphqlError(err)
+	}
+
+	resolvers := make([]*datapointResolver, 0, len(datapoints))
+	for _, datapoint := range datapoints {
+		resolvers = append(resolvers, &datapointResolver{datapoint: datapoint})
+	}
+
+	return resolvers, nil
+}
+
+// Resolves the name of the country of the datapoint
+func (d *datapointResolver) Name() string {
+	return d.datapoint.Name
+}
+
+// Resolves the ISO code of the country of the datapoint
+func (d *datapointResolver) IsoCode() string {
+	return d.datapoint.IsoCode
+}
+
+// Resolves the year of the datapoint, or null if it is aggregated
+func (d *datapointResolver) Year() *int32 {
+	if d.datapoint.Year == nil {
+		return nil
+	}
+	year := int32(*d.datapoint.Year)
+	return &year
+}
+
+// Resolves the renewables percentage of the datapoint
+func (d *datapointResolver) Percentage() float64 {
+	return d.datapoint.Percentage
+}
+
+// Resolves the aggregation of the datapoint
+func (d *datapointResolver) Aggregation() string {
+	return d.datapoint.Aggregation
+}
+
+// Resolves the first year the datapoint was created from
+func (d *datapointResolver) YearFrom() int32 {
+	return int32(d.datapoint.YearFrom)
+}
+
+// Resolves the last year the datapoint was created from
+func (d *datapointResolver) YearTo() int32 {
+	return int32(d.datapoint.YearTo)
+}
+
+// Resolves the country of the datapoint
+func (d *datapointResolver) Country() *countryResolver {
+	return &countryResolver{isoCode: d.datapoint.IsoCode}
+}
+
+/*
+Resolver for the Webhook type
+*/
+type webhookResolver struct {
+	webhook structs.Webhook
+}
+
+/*
+Creates webhook resolvers from webhook structs, sorted by ID
+*/
+func createWebhookResolvers(webhooks []structs.Webhook) []*webhookResolver {
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].WebhookId < webhooks[j].WebhookId
+	})
+
+	resolvers := make([]*webhookResolver, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		resolvers = append(resolvers, &webhookResolver{webhook: webhook})
+	}
+	return resolvers
+}
+
+// Resolves the ID of the webhook
+func (wh *webhookResolver) Id() graphql.ID {
+	return graphql.ID(wh.webhook.WebhookId)
+}
+
+// Resolves the URL of the webhook
+func (wh *webhookResolver) Url() string {
+	return wh.webhook.Url
+}
+
+// Resolves the country of the webhook, or null if it applies to any country
+func (wh *webhookResolver) Country() *countryResolver {
+	if wh.webhook.Country == "" || wh.webhook.Country == "ANY" {
+		return nil
+	}
+	return &countryResolver{isoCode: wh.webhook.Country}
+}
+
+// Resolves the amount of calls before the webhook is triggered
+func (wh *webhookResolver) Calls() int32 {
+	return int32(wh.webhook.Calls)
+}
+
+// Resolves the year of the webhook, or null if it applies to any year
+func (wh *webhookResolver) Year() *int32 {
+	if wh.webhook.Year <= 0 {
+		return nil
+	}
+	year := int32(wh.webhook.Year)
+	return &year
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"assignment2/utils/structs"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Creates loaders backed by a fixed set of countries, counting the amount of batches fetched
+*/
+func createStubLoaders(batches *int, mutex *sync.Mutex) *graphqlLoaders {
+	countries := map[string]*structs.Country{
+		"NOR": {Name: "Norway", IsoCode: "NOR", Borders: []string{"FIN", "SWE", "RUS"}},
+		"SWE": {Name: "Sweden", IsoCode: "SWE", Borders: []string{"FIN", "NOR"}},
+		"FIN": {Name: "Finland", IsoCode: "FIN", Borders: []string{"NOR", "SWE", "RUS"}},
+		"RUS": {Name: "Russia", IsoCode: "RUS", Borders: []string{"FIN", "NOR"}},
+	}
+
+	return &graphqlLoaders{
+		countries: newBatchLoader(func(keys []string) (map[string]*structs.Country, error) {
+			mutex.Lock()
+			*batches++
+			mutex.Unlock()
+
+			result := make(map[string]*structs.Country)
+			for _, key := range keys {
+				if country, ok := countries[key]; ok {
+					result[key] = country
+				}
+			}
+			return result, nil
+		}),
+		renewables: newBatchLoader(func(keys []string) (map[string]map[string]interface{}, error) {
+			// Countries which are only in the renewables dataset, one of them without a name
+			renewables := map[string]map[string]interface{}{
+				"DNK": {"name": "Denmark"},
+				"XKX": {"isoCode": "XKX"},
+			}
+
+			result := make(map[string]map[string]interface{})
+			for _, key := range keys {
+				if data, ok := renewables[key]; ok {
+					result[key] = data
+				}
+			}
+			return result, nil
+		}),
+	}
+}
+
+/*
+Tests that keys loaded concurrently are fetched in one batch, and only once
+*/
+func TestBatchLoader(t *testing.T) {
+	var batches int
+	var mutex sync.Mutex
+	loaders := createStubLoaders(&batches, &mutex)
+
+	countries, err := loaders.countries.loadMany([]string{"NOR", "SWE", "XXX"})
+	assert.Nil(t, err, "Loading countries returned error")
+	assert.Equal(t, 2, len(countries), "Unknown keys should be left out")
+	assert.Equal(t, 1, batches, "Keys loaded together should be fetched in one batch")
+
+	// Loading the same keys again should use the results of the first batch
+	_, found, err := loaders.countries.load("NOR")
+	assert.Nil(t, err, "Loading country returned error")
+	assert.True(t, found, "Country should be found")
+	assert.Equal(t, 1, batches, "Keys already loaded should not be fetched again")
+}
+
+/*
+Tests that resolving the borders of multiple countries does not fetch each neighbour separately
+*/
+func TestCountryBordersBatching(t *testing.T) {
+	var batches int
+	var mutex sync.Mutex
+	ctx := context.WithValue(context.Background(), loadersContextKey{}, createStubLoaders(&batches, &mutex))
+
+	// Resolve the borders of all countries concurrently, as the GraphQL executor does
+	var wg sync.WaitGroup
+	results := make([][]*countryResolver, 4)
+	for i, isoCode := range []string{"NOR", "SWE", "FIN", "RUS"} {
+		wg.Add(1)
+		go func(i int, isoCode string) {
+			defer wg.Done()
+			results[i], _ = (&countryResolver{isoCode: isoCode}).Borders(ctx)
+		}(i, isoCode)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, len(results[0]), "Norway should have three neighbours")
+	assert.LessOrEqual(t, batches, 2, "Countries and their neighbours should be fetched in at most one batch each")
+}
+
+/*
+Tests that an empty code or name resolves to no country, rather than failing
+*/
+func TestCountryResolverEmptyCode(t *testing.T) {
+	empty := ""
+	country, err := (&queryResolver{}).Country(context.Background(), struct {
+		Code *string
+		Name *string
+	}{Code: &empty})
+	assert.Nil(t, err, "Empty code should not be an error")
+	assert.Nil(t, country, "Empty code should resolve to no country")
+}
+
+/*
+Tests that countries not in the restcountries API are named by the renewables dataset, and resolve to no name if it has none
+*/
+func TestCountryResolverName(t *testing.T) {
+	var batches int
+	var mutex sync.Mutex
+	ctx := context.WithValue(context.Background(), loadersContextKey{}, createStubLoaders(&batches, &mutex))
+
+	name, err := (&countryResolver{isoCode: "NOR"}).Name(ctx)
+	assert.Nil(t, err, "Resolving name returned error")
+	assert.Equal(t, "Norway", *name, "Name should be taken from the restcountries API")
+
+	name, err = (&countryResolver{isoCode: "DNK"}).Name(ctx)
+	assert.Nil(t, err, "Resolving name returned error")
+	assert.Equal(t, "Denmark", *name, "Name should fall back to the renewables dataset")
+
+	name, err = (&countryResolver{isoCode: "XKX"}).Name(ctx)
+	assert.Nil(t, err, "Missing name should not be an error")
+	assert.Nil(t, name, "Missing name should resolve to null")
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"assignment2/proto/energypb"
+	"assignment2/utils/auth"
+	"assignment2/utils/constants"
+	"assignment2/utils/db"
+	"assignment2/utils/div"
+	"assignment2/utils/params"
+	"assignment2/utils/structs"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+EnergyServer implements the gRPC API, using the same functions as the REST handlers.
+*/
+type EnergyServer struct {
+	energypb.UnimplementedEnergyServer
+}
+
+/*
+Creates a gRPC server with the energy service registered, and errors handled in the same place
+
+	return	- The gRPC server, ready to serve on a listener
+*/
+func NewGrpcServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryInterceptor),
+		grpc.StreamInterceptor(grpcStreamInterceptor),
+	)
+	energypb.RegisterEnergyServer(server, &EnergyServer{})
+	return server
+}
+
+/*
+Get the current renewables for countries, the same way as the current endpoint
+*/
+func (s *EnergyServer) GetCurrent(ctx context.Context, req *energypb.GetCurrentRequest) (*energypb.RenewablesResponse, error) {
+	countries, err := params.ResolveCountriesToQuery(req.Country, req.Neighbours)
+	if err != nil {
+		return nil, err
+	}
+
+	// Invoke webhooks
+	db.DispatchInvocation(countries, constants.LATEST_YEAR_DB, constants.LATEST_YEAR_DB)
+
+	output, err := getCurrentRenewablesForCountries(nil, countries, req.SortByValue)
+	if err != nil {
+		return nil, err
+	}
+
+	datapoints, err := structs.CreateDatapointsFromCountryOutput(output, constants.LATEST_YEAR_DB, constants.LATEST_YEAR_DB)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &energypb.RenewablesResponse{}
+	for _, datapoint := range datapoints {
+		response.Datapoints = append(response.Datapoints, createDatapointMessage(datapoint))
+	}
+
+	return response, nil
+}
+
+/*
+Get the historical renewables for countries, the same way as the history endpoint, and stream each datapoint
+*/
+func (s *EnergyServer) GetHistory(req *energypb.GetHistoryRequest, stream energypb.Energy_GetHistoryServer) error {
+	beginYear, endYear, err := getYearRangeArguments(req.Begin, req.End)
+	if err != nil {
+		return err
+	}
+
+	countries, err := params.ResolveCountriesToQuery(req.Country, req.Neighbours)
+	if err != nil {
+		return err
+	}
+
+	// Invoke webhooks
+	db.DispatchInvocation(countries, beginYear, endYear)
+
+	output, err := getHistoryRenewablesForCountries(nil, countries, beginYear, endYear, req.SortByValue, req.Mean)
+	if err != nil {
+		return err
+	}
+
+	datapoints, err := structs.CreateDatapointsFromCountryOutput(output, beginYear, endYear)
+	if err != nil {
+		return err
+	}
+
+	// Send each datapoint, stopping if the client goes away
+	for _, datapoint := range datapoints {
+		if err := stream.Send(createDatapointMessage(datapoint)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Register a webhook, the same way as the notification endpoint
+*/
+func (s *EnergyServer) CreateWebhook(ctx context.Context, req *energypb.CreateWebhookRequest) (*energypb.Webhook, error) {
+	client, err := auth.ClientFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := createWebhookFromMessage(req)
+	webhook.Owner = client.Owner
+
+	err = params.CheckWebhook(webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err = createWebhook(webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return &energypb.Webhook{Id: webhook.WebhookId, Secret: webhook.Secret, Status: webhook.Status}, nil
+}
+
+/*
+Get a webhook by ID
+*/
+func (s *EnergyServer) GetWebhook(ctx context.Context, req *energypb.GetWebhookRequest) (*energypb.Webhook, error) {
+	client, err := auth.ClientFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the webhookID is valid
+	if req.Id == "" {
+		return nil, webhookNotFound()
+	}
+
+	webhooks, err := getWebhooks(client, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return createWebhookMessage(webhooks[0]), nil
+}
+
+/*
+List the webhooks registered by the client, or all webhooks for the admin
+*/
+func (s *EnergyServer) ListWebhooks(ctx context.Context, req *energypb.ListWebhooksRequest) (*energypb.ListWebhooksResponse, error) {
+	client, err := auth.ClientFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := getWebhooks(client, "")
+	if err != nil {
+		return nil, err
+	}
+
+	response := &energypb.ListWebhooksResponse{}
+	for _, webhook := range webhooks {
+		response.Webhooks = append(response.Webhooks, createWebhookMessage(webhook))
+	}
+
+	return response, nil
+}
+
+/*
+Delete a webhook by ID
+*/
+func (s *EnergyServer) DeleteWebhook(ctx context.Context, req *energypb.DeleteWebhookRequest) (*energypb.DeleteWebhookResponse, error) {
+	client, err := auth.ClientFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = removeWebhook(client, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &energypb.DeleteWebhookResponse{}, nil
+}
+
+/*
+Rotate the signing secret of a webhook
+*/
+func (s *EnergyServer) RotateWebhookSecret(ctx context.Context, req *energypb.RotateWebhookSecretRequest) (*energypb.WebhookSecret, error) {
+	client, err := auth.ClientFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := rotateWebhookSecret(client, req.Id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &energypb.WebhookSecret{Id: secret.WebhookId, Secret: secret.Secret}
+	if secret.PreviousSecretExpires != nil {
+		expires := secret.PreviousSecretExpires.Unix()
+		response.PreviousSecretExpires = &expires
+	}
+
+	return response, nil
+}
+
+/*
+Get the status of the service, the same way as the status endpoint
+*/
+func (s *EnergyServer) GetStatus(ctx context.Context, req *energypb.GetStatusRequest) (*energypb.Status, error) {
+	statusRes, err := createStatusResponse(constants.COUNTRIES_API_URL, Start)
+	if err != nil {
+		return nil, err
+	}
+
+	return &energypb.Status{
+		CountriesApi:   statusRes.CountriesApi,
+		NotificationDb: statusRes.NotificationDb,
+		Webhooks:       int32(statusRes.Webhooks),
+		Version:        statusRes.Version,
+		Uptime:         statusRes.Uptime,
+	}, nil
+}
+
+/*
+Creates a protobuf datapoint from a v2 datapoint
+*/
+func createDatapointMessage(datapoint structs.RenewableDatapoint) *energypb.RenewableDatapoint {
+	message := &energypb.RenewableDatapoint{
+		Name:        datapoint.Name,
+		IsoCode:     datapoint.IsoCode,
+		Percentage:  datapoint.Percentage,
+		Aggregation: datapoint.Aggregation,
+		YearFrom:    int32(datapoint.YearFrom),
+		YearTo:      int32(datapoint.YearTo),
+	}
+	if datapoint.Year != nil {
+		year := int32(*datapoint.Year)
+		message.Year = &year
+	}
+	return message
+}
+
+/*
+Creates a protobuf webhook from a webhook struct
+*/
+func createWebhookMessage(webhook structs.Webhook) *energypb.Webhook {
+	message := &energypb.Webhook{
+		Id:          webhook.WebhookId,
+		Url:         webhook.Url,
+		Country:     webhook.Country,
+		Calls:       int32(webhook.Calls),
+		Status:      webhook.Status,
+		Countries:   webhook.Countries,
+		Region:      webhook.Region,
+		Trigger:     webhook.Trigger,
+		Threshold:   webhook.Threshold,
+		Change:      webhook.Change,
+		Window:      webhook.Window,
+		Cooldown:    webhook.Cooldown,
+		Schedule:    webhook.Schedule,
+		Channel:     webhook.Channel,
+		Headers:     webhook.Headers,
+		Format:      webhook.Format,
+		Template:    webhook.Template,
+		IncludeData: webhook.IncludeData,
+	}
+	if webhook.Year > 0 {
+		year := int32(webhook.Year)
+		message.Year = &year
+	}
+	if webhook.YearFrom > 0 {
+		yearFrom := int32(webhook.YearFrom)
+		message.YearFrom = &yearFrom
+	}
+	if webhook.YearTo > 0 {
+		yearTo := int32(webhook.YearTo)
+		message.YearTo = &yearTo
+	}
+	if webhook.Auth != nil {
+		message.AuthType = webhook.Auth.Type
+	}
+	if webhook.ExpiresAt != nil {
+		expiresAt := webhook.ExpiresAt.Unix()
+		message.ExpiresAt = &expiresAt
+	}
+	return message
+}
+
+/*
+Creates a webhook from the gRPC request to create one, with the same fields as the body of the REST request
+
+	req	- Request to create the webhook
+
+	return	- Webhook which has not been checked yet
+*/
+func createWebhookFromMessage(req *energypb.CreateWebhookRequest) structs.Webhook {
+	webhook := structs.Webhook{
+		Url:         req.Url,
+		Country:     req.Country,
+		Calls:       int(req.Calls),
+		Countries:   req.Countries,
+		Region:      req.Region,
+		Trigger:     req.Trigger,
+		Threshold:   req.Threshold,
+		Change:      req.Change,
+		Window:      req.Window,
+		Cooldown:    req.Cooldown,
+		Schedule:    req.Schedule,
+		Channel:     req.Channel,
+		Headers:     req.Headers,
+		Format:      req.Format,
+		Template:    req.Template,
+		IncludeData: req.IncludeData,
+	}
+	if req.Year != nil {
+		webhook.Year = int(*req.Year)
+	}
+	if req.YearFrom != nil {
+		webhook.YearFrom = int(*req.YearFrom)
+	}
+	if req.YearTo != nil {
+		webhook.YearTo = int(*req.YearTo)
+	}
+	if req.Auth != nil {
+		webhook.Auth = &structs.WebhookAuth{
+			Type:     req.Auth.Type,
+			Username: req.Auth.Username,
+			Password: req.Auth.Password,
+			Token:    req.Auth.Token,
+		}
+	}
+	if req.ExpiresAt != nil {
+		expiresAt := time.Unix(*req.ExpiresAt, 0).UTC()
+		webhook.ExpiresAt = &expiresAt
+	}
+	return webhook
+}
+
+/*
+Checks the database state before unary calls, and converts errors into gRPC status errors
+*/
+func grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !db.DbState {
+		return nil, status.Error(codes.Unavailable, constants.DEFAULT503)
+	}
+
+	res, err := handler(ctx, req)
+	return res, toGrpcError(err, info.FullMethod)
+}
+
+/*
+Checks the database state before streaming calls, and converts errors into gRPC status errors
+*/
+func grpcStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !db.DbState {
+		return status.Error(codes.Unavailable, constants.DEFAULT503)
+	}
+
+	return toGrpcError(handler(srv, ss), info.FullMethod)
+}
+
+/*
+Converts errors from the service into gRPC status errors, and logs them the same way as the root handler
+
+	err		- Error to convert
+	method	- Full name of the gRPC method, used for logging
+
+	return	- Status error with user message and a code matching the http status code
+*/
+func toGrpcError(err error, method string) error {
+	if err == nil {
+		return nil
+	}
+
+	// Errors which are already status errors are sent as they are
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	requestID := div.CreateRequestId()
+
+	var wrappedErr structs.WrappedError
+	if errors.As(err, &wrappedErr) {
+		if wrappedErr.DevMessage != "" {
+			log.Println("[" + requestID + "] " + method + ": " + wrappedErr.DevMessage)
+		}
+	} else {
+		log.Println("[" + requestID + "] " + method + ": Non-wrapped error:")
+		wrappedErr = structs.WrappedError{StatusCode: http.StatusInternalServerError, UsrMessage: constants.DEFAULT500}
+	}
+	log.Println("\t" + err.Error())
+
+	return status.Error(grpcCodeFromHttpStatus(wrappedErr.StatusCode), wrappedErr.UsrMessage)
+}
+
+/*
+Returns the gRPC code equivalent to a http status code
+*/
+func grpcCodeFromHttpStatus(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
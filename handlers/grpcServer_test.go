@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"assignment2/proto/energypb"
+	"assignment2/utils/constants"
+	"assignment2/utils/db"
+	"assignment2/utils/structs"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+/*
+Tests that errors from the shared handler functions are converted into gRPC status errors
+*/
+func TestToGrpcError(t *testing.T) {
+	assert.Nil(t, toGrpcError(nil, "/test"), "Nil error should stay nil")
+
+	err := toGrpcError(structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_WEBHOOK_NOT_FOUND, "id", "Invalid webhookID given", ""), "/test")
+	assert.Equal(t, codes.NotFound, status.Code(err), "Wrong code for not found")
+	assert.Equal(t, "Invalid webhookID given", status.Convert(err).Message(), "User message should be sent")
+
+	err = toGrpcError(structs.NewError(nil, http.StatusBadRequest, "Malformed URL", ""), "/test")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "Wrong code for bad request")
+
+	err = toGrpcError(errors.New("unexpected error"), "/test")
+	assert.Equal(t, codes.Internal, status.Code(err), "Non-wrapped errors should be internal")
+	assert.Equal(t, constants.DEFAULT500, status.Convert(err).Message(), "Non-wrapped errors should not be exposed")
+
+	statusErr := status.Error(codes.Canceled, "canceled")
+	assert.Equal(t, statusErr, toGrpcError(statusErr, "/test"), "Status errors should be sent as they are")
+}
+
+/*
+Tests that calls are rejected with unavailable while the database is offline
+*/
+func TestGrpcDatabaseUnavailable(t *testing.T) {
+	// Run server in memory
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewGrpcServer()
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal("Could not connect to gRPC server: ", err)
+	}
+	defer conn.Close()
+	client := energypb.NewEnergyClient(conn)
+
+	// Set database as offline, and restore it when done
+	dbState := db.DbState
+	db.DbState = false
+	defer func() { db.DbState = dbState }()
+
+	_, err = client.GetCurrent(context.Background(), &energypb.GetCurrentRequest{Country: "nor"})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "Unary calls should be unavailable")
+
+	stream, err := client.GetHistory(context.Background(), &energypb.GetHistoryRequest{Country: "nor"})
+	assert.Nil(t, err, "Stream should be opened")
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err), "Streaming calls should be unavailable")
+}
+
+/*
+Tests that webhooks created by gRPC have the same fields as webhooks registered by the REST endpoint
+*/
+func TestCreateWebhookFromMessage(t *testing.T) {
+	year, yearFrom, yearTo := int32(2020), int32(2000), int32(2010)
+	threshold := 50.0
+	expiresAt := int64(1900000000)
+	webhook := createWebhookFromMessage(&energypb.CreateWebhookRequest{
+		Url:         "https://example.com/hook",
+		Country:     "NOR",
+		Calls:       5,
+		Year:        &year,
+		Countries:   []string{"SWE", "FIN"},
+		Region:      "Europe",
+		YearFrom:    &yearFrom,
+		YearTo:      &yearTo,
+		Trigger:     constants.WEBHOOK_TRIGGER_THRESHOLD,
+		Threshold:   &threshold,
+		Window:      "1h",
+		Cooldown:    "2h",
+		Schedule:    constants.WEBHOOK_SCHEDULE_DAILY,
+		Channel:     constants.WEBHOOK_CHANNEL_HTTP,
+		Headers:     map[string]string{"X-Team": "energy"},
+		Auth:        &energypb.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER, Token: "token"},
+		ExpiresAt:   &expiresAt,
+		Format:      constants.WEBHOOK_FORMAT_TEMPLATE,
+		Template:    `{"text":"{{.WebhookId}}"}`,
+		IncludeData: true,
+	})
+
+	expiry := time.Unix(expiresAt, 0).UTC()
+	assert.Equal(t, structs.Webhook{
+		Url:         "https://example.com/hook",
+		Country:     "NOR",
+		Calls:       5,
+		Year:        2020,
+		Countries:   []string{"SWE", "FIN"},
+		Region:      "Europe",
+		YearFrom:    2000,
+		YearTo:      2010,
+		Trigger:     constants.WEBHOOK_TRIGGER_THRESHOLD,
+		Threshold:   &threshold,
+		Window:      "1h",
+		Cooldown:    "2h",
+		Schedule:    constants.WEBHOOK_SCHEDULE_DAILY,
+		Channel:     constants.WEBHOOK_CHANNEL_HTTP,
+		Headers:     map[string]string{"X-Team": "energy"},
+		Auth:        &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER, Token: "token"},
+		ExpiresAt:   &expiry,
+		Format:      constants.WEBHOOK_FORMAT_TEMPLATE,
+		Template:    `{"text":"{{.WebhookId}}"}`,
+		IncludeData: true,
+	}, webhook, "Wrong webhook created from message")
+
+	// Fields which are not set are left out, as in the REST body
+	webhook = createWebhookFromMessage(&energypb.CreateWebhookRequest{Url: "https://example.com/hook", Calls: 5})
+	assert.Equal(t, structs.Webhook{Url: "https://example.com/hook", Calls: 5}, webhook, "Unset fields should be empty")
+
+	// Credentials are never sent back, only the type of auth
+	message := createWebhookMessage(structs.Webhook{WebhookId: "abc", Auth: &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER}, ExpiresAt: &expiry})
+	assert.Equal(t, constants.WEBHOOK_AUTH_BEARER, message.AuthType, "Wrong auth type of message")
+	assert.Equal(t, expiresAt, message.GetExpiresAt(), "Wrong expiry of message")
+}
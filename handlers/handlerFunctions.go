@@ -3,7 +3,6 @@ package handlers
 import (
 	"assignment2/utils/constants"
 	"assignment2/utils/db"
-	"assignment2/utils/gateway"
 	"assignment2/utils/structs"
 	"encoding/json"
 	"log"
@@ -103,15 +102,74 @@ func getRenewablesForAllCountriesByYears(startYear int, endYear int, createCount
 	return renewablesOutput, nil
 }
 
+/*
+Get renewables data for the countries and years of a webhook, included in its deliveries. Set as the renewables provider of the gateway in main.
+
+	isoCodes	- A list of countries we want to get data from, or an empty list if we want all
+	beginYear	- The first year we will get data from
+	endYear		- The last year we will get data from
+
+	return		- list of CountryOutPut structs sorted by IsoCode and year, as well as error
+*/
+func GetRenewablesForWebhook(isoCodes []string, beginYear int, endYear int) ([]structs.CountryOutput, error) {
+	if len(isoCodes) == 0 {
+		return getRenewablesForAllCountriesByYears(beginYear, endYear, structs.CreateCountryOutputFromData, false)
+	}
+
+	// Get all countries in one request, where countries with no renewables data are left out
+	countriesData, err := db.GetDocumentsFromFirestore(isoCodes, constants.RENEWABLES_COLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	return createRenewablesForCountries(countriesData, isoCodes, beginYear, endYear, structs.CreateCountryOutputFromData)
+}
+
+/*
+Creates renewables data for the countries which have data. Countries of a region are given by restcountries,
+which has countries such as Andorra and Vatican City that are not in the renewables dataset, so those are skipped.
+
+	countriesData		- Map of renewables data with the ISO code as key, for the countries which have data
+	isoCodes			- A list of countries we want to get data from
+	startYear			- The first year we will get data from
+	endYear				- The last year we will get data from
+	createCountryOutput	- Function for creating the countryOutputs. Alternatives are creating based on years or mean.
+
+	return				- list of CountryOutPut structs sorted by IsoCode and year, as well as error
+*/
+func createRenewablesForCountries(countriesData map[string]map[string]interface{}, isoCodes []string, startYear int, endYear int, createCountryOutput func(map[string]interface{}, string, int, int) ([]structs.CountryOutput, error)) ([]structs.CountryOutput, error) {
+	var outputNotSorted [][]structs.CountryOutput
+
+	for _, isoCode := range isoCodes {
+		countryData, ok := countriesData[isoCode]
+		if !ok {
+			continue
+		}
+
+		outputCountry, err := createCountryOutput(countryData, isoCode, startYear, endYear)
+		if err != nil {
+			return nil, err
+		}
+
+		// If there was valid data for the year range, save to slice
+		if len(outputCountry) != 0 {
+			outputNotSorted = append(outputNotSorted, outputCountry)
+		}
+	}
+
+	return sortByIsoCode(outputNotSorted), nil
+}
+
 /*
 Should check if request is in the cache, then respond with cached response
 
-	w	- Http responsewriter
-	r 	- Http request
+	w			- Http responsewriter
+	r 			- Http request
+	boolParams	- Names of the bool parameters the endpoint supports, echoed in v2 responses
 
 	return	- bool, true if there was a cache hit
 */
-func checkCache(w http.ResponseWriter, r *http.Request) (bool, error) {
+func checkCache(w http.ResponseWriter, r *http.Request, boolParams ...string) (bool, error) {
 	var responseBody []structs.CountryOutput
 	var isoCodes []string
 	var years []int
@@ -155,10 +213,10 @@ func checkCache(w http.ResponseWriter, r *http.Request) (bool, error) {
 	}
 
 	// Invoke webhooks
-	go db.InvokeCountry(isoCodes, years[0], years[1])
+	db.DispatchInvocation(isoCodes, years[0], years[1])
 
 	// Answer request with cached response
-	err = gateway.RespondToGetRequestWithJSON(w, responseBody, http.StatusOK)
+	err = respondWithRenewables(w, r, responseBody, years[0], years[1], boolParams...)
 	if err != nil {
 		return false, err
 	}
@@ -48,3 +48,34 @@ func TestSortByIsoCode(t *testing.T) {
 	assert.Equal(t, output, expected, "Output is wrong")
 
 }
+
+/*
+Tests that countries of a region which are not in the renewables dataset are skipped, rather than failing the delivery
+*/
+func TestCreateRenewablesForCountries(t *testing.T) {
+
+	// Renewables data has Norway and Sweden, but not Andorra, Malta or Vatican City, which are in the region Europe
+	countriesData := map[string]map[string]interface{}{
+		"NOR": {"name": "Norway", "2020": 70.96306, "2021": 71.558365},
+		"SWE": {"name": "Sweden", "2020": 50.92446, "2021": 51.5},
+	}
+	isoCodes := []string{"AND", "MLT", "SWE", "NOR", "VAT"}
+
+	// Create expected output
+	expected := []structs.CountryOutput{
+		{Name: "Norway", IsoCode: "NOR", Year: "2021", Percentage: 71.558365},
+		{Name: "Sweden", IsoCode: "SWE", Year: "2021", Percentage: 51.5},
+	}
+
+	// Try to run the fuction
+	output, err := createRenewablesForCountries(countriesData, isoCodes, 2021, 2021, structs.CreateCountryOutputFromData)
+
+	// Check that missing countries did not give an error, and were left out
+	assert.Nil(t, err, "Countries with no renewables data gave an error")
+	assert.Equal(t, expected, output, "Output is wrong")
+
+	// Check that no countries with data give no output
+	output, err = createRenewablesForCountries(countriesData, []string{"AND", "VAT"}, 2021, 2021, structs.CreateCountryOutputFromData)
+	assert.Nil(t, err, "Countries with no renewables data gave an error")
+	assert.Empty(t, output, "Countries with no renewables data gave output")
+}
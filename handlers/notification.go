@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"assignment2/utils/auth"
 	"assignment2/utils/constants"
 	"assignment2/utils/db"
 	"assignment2/utils/div"
 	"assignment2/utils/gateway"
 	"assignment2/utils/params"
 	"assignment2/utils/structs"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -16,24 +23,70 @@ import (
 Handler for notification endpoint
 */
 func Notification(w http.ResponseWriter, r *http.Request) error {
+	// Webhooks are bound to the API key which registered them
+	client, err := auth.ClientFromRequest(r)
+	if err != nil {
+		return err
+	}
+
 	// Check if database is online. If not, give standard error response.
 	if !db.DbState {
 		usrMsg := fmt.Sprintf("The database is currently unavailable. Please try again later. Reattempting database connection in %v seconds.", time.Until(db.DbRestartTimerStartTime.Add(1*time.Minute)).Round(time.Second)) //Create message with time since timer was activated
-		return structs.NewError(nil, http.StatusServiceUnavailable, usrMsg, "")
+		return structs.NewCodedError(nil, http.StatusServiceUnavailable, constants.ERR_DATABASE_UNAVAILABLE, "", usrMsg, "")
 	}
 
-	var err error
+	// Rotation of signing secret has its own path below the webhook
+	if webhookID, ok := params.GetWebhookIDForActionFromRequest(r, constants.WEBHOOK_SECRET_PATH); ok {
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(w, http.MethodPost)
+		}
+		return rotationOfWebhookSecret(w, r, client, webhookID)
+	}
+
+	// And sending the verification challenge again
+	if webhookID, ok := params.GetWebhookIDForActionFromRequest(r, constants.WEBHOOK_VERIFY_PATH); ok {
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(w, http.MethodPost)
+		}
+		return verificationOfWebhook(w, r, client, webhookID)
+	}
+
+	// And enabling a disabled webhook
+	if webhookID, ok := params.GetWebhookIDForActionFromRequest(r, constants.WEBHOOK_ENABLE_PATH); ok {
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(w, http.MethodPost)
+		}
+		return enablingOfWebhook(w, r, client, webhookID)
+	}
+
+	// And sending a test delivery
+	if webhookID, ok := params.GetWebhookIDForActionFromRequest(r, constants.WEBHOOK_TEST_PATH); ok {
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(w, http.MethodPost)
+		}
+		return testingOfWebhook(w, r, client, webhookID)
+	}
+
+	// So does the history of deliveries
+	if webhookID, ok := params.GetWebhookIDForActionFromRequest(r, constants.WEBHOOK_DELIVERIES_PATH); ok {
+		if r.Method != http.MethodGet {
+			return methodNotAllowed(w, http.MethodGet)
+		}
+		return viewWebhookDeliveries(w, r, client, webhookID)
+	}
 
 	// Send request to different functions based on method
 	switch r.Method {
 	case http.MethodPost:
-		err = registrationOfWebhook(w, r)
+		err = registrationOfWebhook(w, r, client)
 	case http.MethodDelete:
-		err = deletionOfWebhook(w, r)
+		err = deletionOfWebhook(w, r, client)
 	case http.MethodGet:
-		err = viewWebhook(w, r)
+		err = viewWebhook(w, r, client)
+	case http.MethodPut, http.MethodPatch:
+		err = updateOfWebhook(w, r, client)
 	default:
-		return structs.NewError(nil, http.StatusNotImplemented, "Invalid method, currently only Post, Delete, Get supported", "User used invalid http method")
+		return methodNotAllowed(w, http.MethodPost, http.MethodDelete, http.MethodGet, http.MethodPut, http.MethodPatch)
 	}
 
 	return err
@@ -42,25 +95,30 @@ func Notification(w http.ResponseWriter, r *http.Request) error {
 /*
 Gets webhook data from request, created ID, saves the webhook to the database, then repsponds with the id to the user
 */
-func registrationOfWebhook(w http.ResponseWriter, r *http.Request) error {
+func registrationOfWebhook(w http.ResponseWriter, r *http.Request, client auth.Client) error {
 	// Get request json body
 	webhook, err := params.GetWebhookFromRequest(w, r)
 	if err != nil {
 		return err
 	}
 
-	// Create and set webhookID
-	webhook.WebhookId = div.CreateWebhookId()
-
-	// Save webhook to database
-	err = saveWebhook(webhook)
+	// Create ID and save webhook, owned by the client
+	webhook.Owner = client.Owner
+	webhook, err = createWebhook(webhook)
 	if err != nil {
 		return err
 	}
 
-	// Create response
+	// Create response, which is the only time the secret is sent to the user
 	response := structs.Webhook{
 		WebhookId: webhook.WebhookId,
+		Secret:    webhook.Secret,
+		Status:    webhook.Status,
+	}
+
+	// Respond with the webhook wrapped in the response envelope if the request was sent to v2
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, response, 1, map[string]interface{}{}, http.StatusCreated)
 	}
 
 	err = gateway.RespondToGetRequestWithJSON(w, response, http.StatusCreated)
@@ -71,14 +129,79 @@ func registrationOfWebhook(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+/*
+Creates an ID and signing secret for a webhook which has been checked, and saves it to the database
+
+	webhook	- Webhook to create
+
+	return	- The webhook with its ID and secret set
+*/
+func createWebhook(webhook structs.Webhook) (structs.Webhook, error) {
+	// Create and set webhookID
+	webhook.WebhookId = div.CreateWebhookId()
+
+	// Create secret for signing deliveries
+	secret, err := div.CreateWebhookSecret()
+	if err != nil {
+		return webhook, structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not create webhook secret.")
+	}
+	webhook.Secret = secret
+
+	// Save webhook to database, where it is not fired until the url has echoed the challenge
+	webhook.Status = constants.WEBHOOK_STATUS_PENDING
+	webhookData, err := saveWebhook(webhook)
+	if err != nil {
+		return webhook, err
+	}
+
+	// The webhook is still created if the url does not echo the challenge, so it can be verified later
+	status, err := verifyWebhook(webhook.WebhookId, webhookData)
+	if err != nil {
+		log.Println("Could not verify url of webhook " + webhook.WebhookId + ": " + err.Error())
+	} else {
+		webhook.Status = status
+	}
+
+	return webhook, nil
+}
+
 /*
 Saves a webhook to the correct database collection and document
 
 	webhook	- Struct which contain all relevant information about webhook to save
 
-	return	- Type of error or nil if none
+	return	- The webhook data as it was saved, or error
 */
-func saveWebhook(webhook structs.Webhook) error {
+func saveWebhook(webhook structs.Webhook) (map[string]interface{}, error) {
+	// Create map containing data to insert into database
+	webhookData, err := createWebhookFields(webhook)
+	if err != nil {
+		return nil, err
+	}
+	webhookData["invocations"] = 0
+	webhookData["secret"] = webhook.Secret
+	webhookData["status"] = webhook.Status
+	webhookData["owner"] = webhook.Owner
+	webhookData["digest_since"] = time.Now()
+	webhookData["created_at"] = time.Now()
+
+	// Save webhook to the database
+	err = db.AppendDocumentToFirestore(webhook.WebhookId, webhookData, constants.WEBHOOKS_COLLECTION)
+	if err != nil {
+		return nil, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not save webhook in firestore database.")
+	}
+
+	return webhookData, nil
+}
+
+/*
+Creates the fields of a webhook which can be set by the user, as they are stored in the database
+
+	webhook	- Webhook to create fields from
+
+	return	- Map of fields, with ANY country and years -1 for webhooks applying to any country or year, or error if the credentials could not be encrypted
+*/
+func createWebhookFields(webhook structs.Webhook) (map[string]interface{}, error) {
 	var isoCode string
 	var year int = -1
 
@@ -94,73 +217,832 @@ func saveWebhook(webhook structs.Webhook) error {
 		year = webhook.Year
 	}
 
-	// Create map containing data to insert into database
-	webhookData := map[string]interface{}{
-		"url":         webhook.Url,
-		"country":     isoCode,
-		"calls":       webhook.Calls,
-		"invocations": 0,
-		"year":        year,
+	// Set range of years to -1 where not specified, like year
+	yearFrom, yearTo := -1, -1
+	if webhook.YearFrom > 0 {
+		yearFrom = webhook.YearFrom
+	}
+	if webhook.YearTo > 0 {
+		yearTo = webhook.YearTo
 	}
 
-	// Save webhook to the database
-	err := db.AppendDocumentToFirestore(webhook.WebhookId, webhookData, constants.WEBHOOKS_COLLECTION)
+	// Store an empty list rather than null, so stored countries are always a list
+	countries := webhook.Countries
+	if countries == nil {
+		countries = []string{}
+	}
+
+	// Set trigger to calls if not specified
+	trigger := webhook.Trigger
+	if trigger == "" {
+		trigger = constants.WEBHOOK_TRIGGER_CALLS
+	}
+
+	// Set payload format to native if not specified
+	format := webhook.Format
+	if format == "" {
+		format = constants.WEBHOOK_FORMAT_NATIVE
+	}
+
+	// Set channel to http if not specified
+	channel := webhook.Channel
+	if channel == "" {
+		channel = constants.WEBHOOK_CHANNEL_HTTP
+	}
+
+	// Numbers are stored as int64, the type firestore gives back. Threshold, change, window, cooldown, schedule and expiry are null if not specified.
+	fields := map[string]interface{}{
+		"url":              webhook.Url,
+		"country":          isoCode,
+		"calls":            int64(webhook.Calls),
+		"year":             int64(year),
+		"countries":        countries,
+		"region":           webhook.Region,
+		"year_from":        int64(yearFrom),
+		"year_to":          int64(yearTo),
+		"trigger":          trigger,
+		"format":           format,
+		"channel":          channel,
+		"template":         webhook.Template,
+		"include_data":     webhook.IncludeData,
+		"threshold":        nil,
+		"change":           nil,
+		"expires_at":       nil,
+		"window_seconds":   nil,
+		"cooldown_seconds": nil,
+		"schedule":         nil,
+		"next_digest_at":   nil,
+	}
+	if webhook.Threshold != nil {
+		fields["threshold"] = *webhook.Threshold
+	}
+	if webhook.Change != nil {
+		fields["change"] = *webhook.Change
+	}
+	if webhook.ExpiresAt != nil {
+		fields["expires_at"] = webhook.ExpiresAt.UTC()
+	}
+
+	// Window and cooldown of rate webhooks are checked before they are stored, and stored in seconds
+	if window, err := time.ParseDuration(webhook.Window); err == nil {
+		fields["window_seconds"] = int64(window.Seconds())
+	}
+	if cooldown, err := time.ParseDuration(webhook.Cooldown); err == nil {
+		fields["cooldown_seconds"] = int64(cooldown.Seconds())
+	}
+
+	// Digests are due at the start of each hour, day or week, so updating the schedule does not move the next digest unless it changes
+	if webhook.Schedule != "" {
+		fields["schedule"] = webhook.Schedule
+		fields["next_digest_at"] = db.NextDigestTime(webhook.Schedule, time.Now())
+	}
+
+	// Header values and credentials are only stored encrypted
+	credentials, err := gateway.CreateCredentialFields(webhook.Headers, webhook.Auth)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range credentials {
+		fields[key] = value
+	}
+
+	return fields, nil
+}
+
+/*
+Replace (PUT) or partially update (PATCH) a webhook, keeping its ID and invocations, and respond with the updated webhook to the user
+*/
+func updateOfWebhook(w http.ResponseWriter, r *http.Request, client auth.Client) error {
+	// Get webhookID
+	webhookID, err := params.GetWebhookIDFromRequest(w, r)
 	if err != nil {
-		// TODO: Error handling
 		return err
 	}
 
-	return nil
+	// Check if the webhookID is valid, and owned by the client
+	if _, err := getOwnedWebhookData(client, webhookID); err != nil {
+		return err
+	}
+
+	// Only update if the webhook has not changed since the client read it
+	ifVersion, err := params.GetIfMatchVersionFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	// Create function updating the webhook as it is stored, based on method
+	var update func(current structs.Webhook) (structs.Webhook, error)
+	if r.Method == http.MethodPut {
+		replacement, err := params.GetWebhookFromRequest(w, r)
+		if err != nil {
+			return err
+		}
+		update = func(current structs.Webhook) (structs.Webhook, error) {
+			return replacement, nil
+		}
+	} else {
+		patch, err := params.GetWebhookPatchFromRequest(r)
+		if err != nil {
+			return err
+		}
+		update = func(current structs.Webhook) (structs.Webhook, error) {
+			return params.ApplyWebhookPatch(current, patch)
+		}
+	}
+
+	updated, err := updateWebhook(webhookID, ifVersion, update)
+	if err != nil {
+		return err
+	}
+
+	// Send the new version, so the client can update again without reading first
+	w.Header().Set("ETag", createETag(updated.Version))
+
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, updated, 1, map[string]interface{}{"webhookId": webhookID}, http.StatusOK)
+	}
+
+	return gateway.RespondToGetRequestWithJSON(w, updated, http.StatusOK)
+}
+
+/*
+Updates the fields of a webhook which can be set by the user, based on the webhook as it is stored
+
+	webhookID	- ID of webhook to update
+	ifVersion	- Version the webhook must have, or -1 for any version
+	update		- Function creating the updated webhook from the current one
+
+	return	- The updated webhook, with its new version
+*/
+func updateWebhook(webhookID string, ifVersion int64, update func(current structs.Webhook) (structs.Webhook, error)) (structs.Webhook, error) {
+	var urlChanged bool
+
+	data, err := db.UpdateDocumentInTransaction(webhookID, constants.WEBHOOKS_COLLECTION, ifVersion, func(current map[string]interface{}) (map[string]interface{}, error) {
+		webhook := structs.CreateWebhookFromData(current, webhookID)
+		urlChanged = false
+
+		// Headers and credentials are redacted in the struct, so the ones stored are kept unless they are updated.
+		// Ones which can not be decrypted, such as after the encryption key was changed, are removed so they can be given again.
+		headers, auth, err := gateway.GetWebhookCredentials(current)
+		if err != nil {
+			log.Println("Could not decrypt credentials of webhook " + webhookID + ", they are removed: " + err.Error())
+		} else {
+			webhook.Headers, webhook.Auth = headers, auth
+		}
+
+		// Any country is stored as ANY, but given as no country
+		if webhook.Country == "ANY" {
+			webhook.Country = ""
+		}
+
+		updated, err := update(webhook)
+		if err != nil {
+			return nil, err
+		}
+
+		// A new url, or a url of a new channel, must be verified before the webhook is fired again
+		fields, err := createWebhookFields(updated)
+		if err != nil {
+			return nil, err
+		}
+		if updated.Url != webhook.Url || fields["channel"] != current["channel"] {
+			// Disabled webhooks must be enabled first, which resets their failures, rather than be activated by verifying the new url.
+			// Updates of expired webhooks are checked to have a new expiresAt, so they are verified like other webhooks.
+			if webhook.Status == constants.WEBHOOK_STATUS_DISABLED {
+				return nil, webhookStoppedError(constants.WEBHOOK_STATUS_DISABLED, "changing its url or channel")
+			}
+			urlChanged = true
+			fields["status"] = constants.WEBHOOK_STATUS_PENDING
+
+			// A challenge sent to the old url must not verify the new one
+			fields["verification_challenge"] = ""
+		} else if webhook.Status == constants.WEBHOOK_STATUS_EXPIRED {
+			// Updates are checked to not be past their expiry, so expired webhooks are fired again
+			fields["status"] = constants.WEBHOOK_STATUS_ACTIVE
+		}
+
+		return fields, nil
+	})
+	if err != nil {
+		return structs.Webhook{}, err
+	}
+
+	updated := structs.CreateWebhookFromData(data, webhookID)
+
+	if urlChanged {
+		status, err := verifyWebhook(webhookID, data)
+		if err != nil {
+			log.Println("Could not verify url of webhook " + webhookID + ": " + err.Error())
+		} else {
+			updated.Status = status
+		}
+	}
+
+	return updated, nil
+}
+
+/*
+Creates a strong ETag from the version of a resource
+*/
+func createETag(version int64) string {
+	return "\"" + strconv.FormatInt(version, 10) + "\""
 }
 
 /*
 Delete webhook and respond with ID of webhook deleted to user
 */
-func deletionOfWebhook(w http.ResponseWriter, r *http.Request) error {
+func deletionOfWebhook(w http.ResponseWriter, r *http.Request, client auth.Client) error {
 	// Get webhookID
 	webhookID, err := params.GetWebhookIDFromRequest(w, r)
 	if err != nil {
 		return err
 	}
 
-	// Check if the webhookID is valid
-	if !checkIfValidWebhookId(webhookID) {
-		return structs.NewError(nil, http.StatusNotFound, "Invalid webhookID given", "webhookID given was not found in database")
+	// Try to delete webhook
+	err = removeWebhook(client, webhookID)
+	if err != nil {
+		return err
+	}
+
+	// Respond with status no content
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+/*
+Delete webhook from database if the webhookID is valid
+
+	client		- Client of the request, which must own the webhook
+	webhookID	- ID of webhook to delete
+
+	return		- Error if the webhook does not exist or could not be deleted
+*/
+func removeWebhook(client auth.Client, webhookID string) error {
+	// Check if the webhookID is valid, and owned by the client
+	if _, err := getOwnedWebhookData(client, webhookID); err != nil {
+		return err
 	}
 
 	// Try to delete webhook from database
-	err = db.DeleteDocument(webhookID, constants.WEBHOOKS_COLLECTION)
+	err := db.DeleteDocument(webhookID, constants.WEBHOOKS_COLLECTION)
 	if err != nil {
 		return err
 	}
 
-	// Respond with status no content
-	w.WriteHeader(http.StatusNoContent)
+	// Delivery history is kept in the webhook document, and is not deleted along with it
+	return db.DeleteDeliveryAttempts(webhookID)
+}
+
+/*
+Rotate the signing secret of a webhook, and respond with the new secret to the user
+*/
+func rotationOfWebhookSecret(w http.ResponseWriter, r *http.Request, client auth.Client, webhookID string) error {
+	response, err := rotateWebhookSecret(client, webhookID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, response, 1, map[string]interface{}{"webhookId": webhookID}, http.StatusOK)
+	}
+
+	return gateway.RespondToGetRequestWithJSON(w, response, http.StatusOK)
+}
+
+/*
+Replaces the signing secret of a webhook. The previous secret is still used for signing during a grace period,
+so receivers can switch to the new secret without rejecting deliveries.
+
+	client		- Client of the request, which must own the webhook
+	webhookID	- ID of webhook to rotate secret of
+	now			- Time of rotation, which the grace period starts from
+
+	return		- The new secret, and when the previous secret stops being used
+*/
+func rotateWebhookSecret(client auth.Client, webhookID string, now time.Time) (structs.WebhookSecret, error) {
+	response := structs.WebhookSecret{WebhookId: webhookID}
+
+	// Check if the webhookID is valid, and owned by the client
+	webhookData, err := getOwnedWebhookData(client, webhookID)
+	if err != nil {
+		return response, err
+	}
+
+	secret, err := div.CreateWebhookSecret()
+	if err != nil {
+		return response, structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not create webhook secret.")
+	}
+	response.Secret = secret
+
+	fields := map[string]interface{}{"secret": secret}
+
+	// Keep the current secret valid during the grace period. Webhooks registered before signing was added have no secret to keep.
+	if previous, ok := webhookData["secret"].(string); ok && previous != "" {
+		expires := now.Add(constants.WEBHOOK_SECRET_GRACE_PERIOD).UTC()
+		fields["previous_secret"] = previous
+		fields["previous_secret_expires"] = expires
+		response.PreviousSecretExpires = &expires
+	}
+
+	err = db.UpdateDocumentInFirestore(webhookID, fields, constants.WEBHOOKS_COLLECTION)
+	if err != nil {
+		return structs.WebhookSecret{WebhookId: webhookID}, err
+	}
+
+	return response, nil
+}
+
+/*
+Send the verification challenge to the url of a webhook again, or check the challenge posted back for targets which were sent it,
+and respond to user with the status of the webhook
+*/
+func verificationOfWebhook(w http.ResponseWriter, r *http.Request, client auth.Client, webhookID string) error {
+	// Check if the webhookID is valid, and owned by the client
+	webhookData, err := getOwnedWebhookData(client, webhookID)
+	if err != nil {
+		return err
+	}
+
+	// Disabled and expired webhooks are fired again by enabling them or updating expiresAt, not by verifying their url
+	if state := webhookState(structs.CreateWebhookFromData(webhookData, webhookID), time.Now()); state == constants.WEBHOOK_STATUS_DISABLED || state == constants.WEBHOOK_STATUS_EXPIRED {
+		return webhookStoppedError(state, "verifying its url")
+	}
+
+	challenge, err := params.GetVerificationChallengeFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	var status string
+	if challenge != "" {
+		status, err = confirmVerificationChallenge(webhookID, challenge, time.Now())
+	} else {
+		status, err = verifyWebhook(webhookID, webhookData)
+	}
+	if err != nil {
+		return err
+	}
+
+	response := structs.Webhook{WebhookId: webhookID, Status: status}
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, response, 1, map[string]interface{}{"webhookId": webhookID}, http.StatusOK)
+	}
+
+	return gateway.RespondToGetRequestWithJSON(w, response, http.StatusOK)
+}
+
+/*
+Verifies the url of a webhook through its channel, such as by sending a challenge the url must echo, and activates the webhook if it is verified.
+The challenge is signed with the secrets of the webhook, and sent with its outbound headers and credentials.
+Targets which can not echo the challenge, such as email addresses, are sent it instead, and the webhook stays pending until it is posted back.
+
+	webhookID	- ID of webhook to verify
+	webhookData	- Map of webhook data, as stored in firestore
+
+	return		- Status of the webhook, or error if the url could not be verified, in which case the webhook is left as it is
+*/
+func verifyWebhook(webhookID string, webhookData map[string]interface{}) (string, error) {
+	channel, _ := webhookData["channel"].(string)
+	webhookURL, _ := webhookData["url"].(string)
+	headers, err := gateway.GetOutboundHeaders(webhookData)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := div.CreateRequestId()
+	err = gateway.VerifyWebhookTarget(channel, webhookURL, webhookID, challenge, gateway.GetSigningSecrets(webhookData, time.Now()), headers)
+	if errors.Is(err, gateway.ErrChallengeSent) {
+		return storeVerificationChallenge(webhookID, challenge, time.Now())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return activateWebhook(webhookID, time.Now())
+}
+
+/*
+Activates a webhook whose url has been verified. Only pending webhooks are activated, as the webhook may have been disabled
+or expired while it was verified. The challenge of the webhook is removed, so it can not be posted back again.
+
+	webhookID	- ID of webhook to activate
+	now			- Time the url was verified
+
+	return	- Status of the webhook after the update
+*/
+func activateWebhook(webhookID string, now time.Time) (string, error) {
+	data, err := db.UpdateDocumentInTransaction(webhookID, constants.WEBHOOKS_COLLECTION, -1, func(current map[string]interface{}) (map[string]interface{}, error) {
+		if webhookState(structs.CreateWebhookFromData(current, webhookID), now) != constants.WEBHOOK_STATUS_PENDING {
+			return map[string]interface{}{}, nil
+		}
+		return map[string]interface{}{"status": constants.WEBHOOK_STATUS_ACTIVE, "verification_challenge": ""}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return webhookState(structs.CreateWebhookFromData(data, webhookID), now), nil
+}
+
+/*
+Stores the hash of a challenge sent to a target which must post it back, replacing any challenge sent before.
+Only the hash is stored, so the challenge can not be read from the database to verify the webhook.
+
+	webhookID	- ID of the webhook
+	challenge	- Challenge sent to the target
+	now			- Time the challenge was sent
+
+	return	- Status of the webhook, which is pending until the challenge is posted back
+*/
+func storeVerificationChallenge(webhookID string, challenge string, now time.Time) (string, error) {
+	data, err := db.UpdateDocumentInTransaction(webhookID, constants.WEBHOOKS_COLLECTION, -1, func(current map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"verification_challenge": hashVerificationChallenge(challenge),
+			"verification_expires":   now.Add(constants.WEBHOOK_VERIFICATION_CHALLENGE_TTL),
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return webhookState(structs.CreateWebhookFromData(data, webhookID), now), nil
+}
+
+/*
+Activates a webhook if the challenge posted back is the one sent to its target
+
+	webhookID	- ID of the webhook
+	challenge	- Challenge posted back by the client
+	now			- Current time
+
+	return	- Status of the webhook, or error with status 422 if the challenge is not the one sent, or has expired
+*/
+func confirmVerificationChallenge(webhookID string, challenge string, now time.Time) (string, error) {
+	data, err := db.UpdateDocumentInTransaction(webhookID, constants.WEBHOOKS_COLLECTION, -1, func(current map[string]interface{}) (map[string]interface{}, error) {
+		if err := checkVerificationChallenge(current, challenge, now); err != nil {
+			return nil, err
+		}
+		if webhookState(structs.CreateWebhookFromData(current, webhookID), now) != constants.WEBHOOK_STATUS_PENDING {
+			return map[string]interface{}{}, nil
+		}
+		return map[string]interface{}{"status": constants.WEBHOOK_STATUS_ACTIVE, "verification_challenge": ""}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return webhookState(structs.CreateWebhookFromData(data, webhookID), now), nil
+}
+
+/*
+Checks that a challenge posted back is the one stored for a webhook, and has not expired
+
+	webhookData	- Map of webhook data, as stored in firestore
+	challenge	- Challenge posted back by the client
+	now			- Current time
+
+	return	- Error with status 422 if the webhook has no challenge, or it is not the one posted back, or it has expired
+*/
+func checkVerificationChallenge(webhookData map[string]interface{}, challenge string, now time.Time) error {
+	stored, _ := webhookData["verification_challenge"].(string)
+	expires, _ := webhookData["verification_expires"].(time.Time)
+
+	if stored == "" || subtle.ConstantTimeCompare([]byte(stored), []byte(hashVerificationChallenge(challenge))) != 1 {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_VERIFICATION_FAILED, "challenge", "The challenge is not the one sent to the webhook. Post to "+constants.NOTIFICATION_PATH+"{id}/"+constants.WEBHOOK_VERIFY_PATH+" without a body to be sent a new one.", "")
+	}
+	if now.After(expires) {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_VERIFICATION_FAILED, "challenge", "The challenge has expired. Post to "+constants.NOTIFICATION_PATH+"{id}/"+constants.WEBHOOK_VERIFY_PATH+" without a body to be sent a new one.", "")
+	}
 
 	return nil
 }
 
+/*
+Hashes a verification challenge, so the challenge itself is never stored
+
+	challenge	- Challenge sent to the target
+
+	return	- Hex encoded SHA-256 hash of the challenge
+*/
+func hashVerificationChallenge(challenge string) string {
+	hash := sha256.Sum256([]byte(challenge))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+Returns the error for webhooks which are disabled or expired, which must be enabled or given a new expiresAt first
+
+	state	- WEBHOOK_STATUS_DISABLED or WEBHOOK_STATUS_EXPIRED
+	action	- What the client tried to do, such as verifying its url
+*/
+func webhookStoppedError(state string, action string) error {
+	if state == constants.WEBHOOK_STATUS_EXPIRED {
+		return structs.NewCodedError(nil, http.StatusConflict, constants.ERR_WEBHOOK_EXPIRED, "expiresAt", "The webhook has expired. Update its expiresAt before "+action+".", "")
+	}
+	return structs.NewCodedError(nil, http.StatusConflict, constants.ERR_WEBHOOK_DISABLED, "status", "The webhook is disabled. Enable it with POST "+constants.NOTIFICATION_PATH+"{id}/"+constants.WEBHOOK_ENABLE_PATH+" before "+action+".", "")
+}
+
+/*
+Enable a webhook which was disabled after too many failed delivery attempts, and respond to user with the status of the webhook
+*/
+func enablingOfWebhook(w http.ResponseWriter, r *http.Request, client auth.Client, webhookID string) error {
+	// Check if the webhookID is valid, and owned by the client
+	if _, err := getOwnedWebhookData(client, webhookID); err != nil {
+		return err
+	}
+
+	updated, err := enableWebhook(webhookID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	response := structs.Webhook{WebhookId: webhookID, Status: updated.Status}
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, response, 1, map[string]interface{}{"webhookId": webhookID}, http.StatusOK)
+	}
+
+	return gateway.RespondToGetRequestWithJSON(w, response, http.StatusOK)
+}
+
+/*
+Enables a disabled webhook, and resets its failed delivery attempts. Webhooks which are not disabled are left as they are.
+
+	webhookID	- ID of webhook to enable
+	now			- Current time, which the expiry is checked against
+
+	return	- The webhook as it is after enabling, or error with status 409 if it is past its expiry
+*/
+func enableWebhook(webhookID string, now time.Time) (structs.Webhook, error) {
+	data, err := db.UpdateDocumentInTransaction(webhookID, constants.WEBHOOKS_COLLECTION, -1, func(current map[string]interface{}) (map[string]interface{}, error) {
+		webhook := structs.CreateWebhookFromData(current, webhookID)
+
+		// Expired webhooks need a new expiry, as they would expire again right away
+		if webhookState(webhook, now) == constants.WEBHOOK_STATUS_EXPIRED {
+			return nil, structs.NewCodedError(nil, http.StatusConflict, constants.ERR_WEBHOOK_EXPIRED, "expiresAt", "The webhook has expired. Update its expiresAt to enable it.", "")
+		}
+
+		if webhook.Status != constants.WEBHOOK_STATUS_DISABLED {
+			return map[string]interface{}{}, nil
+		}
+
+		return map[string]interface{}{
+			"status":               constants.WEBHOOK_STATUS_ACTIVE,
+			"consecutive_failures": 0,
+			"disabled_at":          nil,
+		}, nil
+	})
+	if err != nil {
+		return structs.Webhook{}, err
+	}
+
+	return structs.CreateWebhookFromData(data, webhookID), nil
+}
+
+/*
+Send a test delivery to the url of a webhook right away, and respond to user with how the url responded
+*/
+func testingOfWebhook(w http.ResponseWriter, r *http.Request, client auth.Client, webhookID string) error {
+	// Check if the webhookID is valid, and owned by the client
+	webhookData, err := getOwnedWebhookData(client, webhookID)
+	if err != nil {
+		return err
+	}
+
+	response := testWebhook(webhookID, webhookData)
+	if isV2Request(r) {
+		return respondWithEnvelope(w, r, response, 1, map[string]interface{}{"webhookId": webhookID}, http.StatusOK)
+	}
+
+	return gateway.RespondToGetRequestWithJSON(w, response, http.StatusOK)
+}
+
+/*
+Sends a test delivery to a webhook, through the same payload format and signing as other deliveries.
+It is sent once, without retries, and neither counts as an invocation nor as a failed delivery attempt.
+
+	webhookID	- ID of the webhook
+	webhookData	- Map of webhook data, as stored in firestore
+
+	return	- How the webhook url responded. A failed delivery is part of the result, and not an error.
+*/
+func testWebhook(webhookID string, webhookData map[string]interface{}) structs.WebhookTest {
+	data := createTestDeliveryData(webhookData)
+	deliveryID := constants.WEBHOOK_TEST_PATH + "-" + div.CreateRequestId()
+
+	attempt, err := gateway.DeliverToWebhook(data, webhookID, deliveryID, constants.COUNTRIES_API_URL)
+
+	return structs.WebhookTest{
+		WebhookId:  webhookID,
+		DeliveryId: deliveryID,
+		Delivered:  err == nil,
+		StatusCode: attempt.StatusCode,
+		LatencyMs:  attempt.LatencyMs,
+		Response:   attempt.Response,
+		Error:      attempt.Error,
+	}
+}
+
+/*
+Creates the data of a test delivery from the data of a webhook. As there is no event which fired them,
+rate webhooks get an event just above their calls, threshold webhooks get an event for the country and year
+they apply to with the value at their threshold, and dataset and digest webhooks get an event changing that year of the country.
+
+	webhookData	- Map of webhook data, as stored in firestore
+
+	return	- Copy of the webhook data, marked as a test
+*/
+func createTestDeliveryData(webhookData map[string]interface{}) map[string]interface{} {
+	data := make(map[string]interface{}, len(webhookData)+2)
+	for key, value := range webhookData {
+		data[key] = value
+	}
+	data["test"] = true
+
+	// Webhooks registered before invocations were counted have none
+	if _, ok := data["invocations"].(int64); !ok {
+		data["invocations"] = int64(0)
+	}
+
+	switch trigger, _ := data["trigger"].(string); trigger {
+	case constants.WEBHOOK_TRIGGER_THRESHOLD:
+		isoCode, name := getTestCountry(data)
+		event := map[string]interface{}{
+			"country":   isoCode,
+			"name":      name,
+			"year":      getTestYear(data),
+			"old_value": 0.0,
+			"new_value": 0.0,
+		}
+		if threshold, ok := data["threshold"].(float64); ok {
+			event["threshold"] = threshold
+			event["old_value"] = threshold
+			event["new_value"] = threshold
+		}
+		if change, ok := data["change"].(float64); ok {
+			event["change"] = change
+		}
+		data["event"] = event
+	case constants.WEBHOOK_TRIGGER_RATE:
+		window, _ := data["window_seconds"].(int64)
+		calls, _ := data["calls"].(int64)
+		data["event"] = map[string]interface{}{
+			"type":           constants.WEBHOOK_TRIGGER_RATE,
+			"calls":          calls + 1,
+			"window_seconds": window,
+		}
+	case constants.WEBHOOK_TRIGGER_DIGEST:
+		isoCode, name := getTestCountry(data)
+		year := getTestYear(data)
+		now := time.Now()
+		data["event"] = map[string]interface{}{
+			"type":     constants.WEBHOOK_TRIGGER_DIGEST,
+			"schedule": data["schedule"],
+			"from":     now.Add(-time.Hour),
+			"to":       now,
+			"invocations": []interface{}{map[string]interface{}{
+				"country":   isoCode,
+				"year_from": year,
+				"year_to":   year,
+				"calls":     int64(1),
+			}},
+			"top_countries": []interface{}{map[string]interface{}{"country": isoCode, "calls": int64(1)}},
+			"changes": []interface{}{map[string]interface{}{
+				"country":       isoCode,
+				"name":          name,
+				"years_added":   []interface{}{},
+				"years_changed": []interface{}{year},
+			}},
+		}
+	case constants.WEBHOOK_TRIGGER_DATASET:
+		isoCode, name := getTestCountry(data)
+		data["event"] = map[string]interface{}{
+			"type": constants.WEBHOOK_TRIGGER_DATASET,
+			"changes": []interface{}{map[string]interface{}{
+				"country":       isoCode,
+				"name":          name,
+				"years_added":   []interface{}{},
+				"years_changed": []interface{}{getTestYear(data)},
+			}},
+		}
+	}
+
+	return data
+}
+
+/*
+Get the country of a webhook for test events, which is its country or the first of its countries, or any country
+
+	return	- ISO code and name of the country
+*/
+func getTestCountry(data map[string]interface{}) (string, string) {
+	isoCode, _ := data["country"].(string)
+	if countries, ok := data["countries"].([]interface{}); ok && (isoCode == "" || isoCode == "ANY") && len(countries) > 0 {
+		isoCode, _ = countries[0].(string)
+	}
+	if isoCode == "" || isoCode == "ANY" {
+		return "ANY", "Any country"
+	}
+
+	// The ISO code is better than no name if the restcountries API is unavailable
+	if country, err := gateway.GetCountryByIso(isoCode, constants.COUNTRIES_API_URL); err == nil {
+		return isoCode, country.Name
+	}
+	return isoCode, isoCode
+}
+
+/*
+Get the year of a webhook for test events, which is its year or the last of its range, or the latest year in the database
+*/
+func getTestYear(data map[string]interface{}) int64 {
+	if year, _ := data["year"].(int64); year > 0 {
+		return year
+	}
+	if yearTo, _ := data["year_to"].(int64); yearTo > 0 {
+		return yearTo
+	}
+	return int64(constants.LATEST_YEAR_DB)
+}
+
+/*
+Get the state of a webhook as it is shown to the user, which is expired once it is past its expiry even if the status has not been set yet
+
+	webhook	- The webhook
+	now		- Current time
+
+	return	- Status of the webhook
+*/
+func webhookState(webhook structs.Webhook, now time.Time) string {
+	if webhook.Status != constants.WEBHOOK_STATUS_DISABLED && webhook.ExpiresAt != nil && !webhook.ExpiresAt.After(now) {
+		return constants.WEBHOOK_STATUS_EXPIRED
+	}
+
+	return webhook.Status
+}
+
+/*
+Get a page of the delivery attempts of a webhook, newest first, and respond to user
+*/
+func viewWebhookDeliveries(w http.ResponseWriter, r *http.Request, client auth.Client, webhookID string) error {
+	limit, cursor, err := params.GetPaginationParameters(r)
+	if err != nil {
+		return err
+	}
+
+	// Check if the webhookID is valid, and owned by the client
+	if _, err := getOwnedWebhookData(client, webhookID); err != nil {
+		return err
+	}
+
+	attempts, nextCursor, err := db.GetDeliveryAttempts(webhookID, limit, cursor)
+	if err != nil {
+		return err
+	}
+
+	query := map[string]interface{}{"webhookId": webhookID, "limit": limit}
+	if cursor != "" {
+		query["cursor"] = cursor
+	}
+
+	return respondWithPage(w, r, attempts, len(attempts), query, nextCursor, http.StatusOK)
+}
+
 /*
 Get webhook or multiple if none are specified, and then respond to user
 */
-func viewWebhook(w http.ResponseWriter, r *http.Request) error {
+func viewWebhook(w http.ResponseWriter, r *http.Request, client auth.Client) error {
 	// Get webhookID
 	webhookID, err := params.GetWebhookIDOrNothingFromRequest(w, r)
 	if err != nil {
 		return err
 	}
 
+	// Listings are filtered, sorted and paged
+	if webhookID == "" {
+		return viewWebhookList(w, r, client)
+	}
+
 	// Check if the webhookID is valid
 	if !checkIfValidWebhookId(webhookID) {
-		return structs.NewError(nil, http.StatusNotFound, "Invalid webhookID given", "webhookID given was not found in database")
+		return webhookNotFound()
 	}
 
-	// Get webhooks from database
-	response, err := getWebhooks(webhookID)
+	// Get webhooks of the client from database
+	response, err := getWebhooks(client, webhookID)
 	if err != nil {
 		return err
 	}
 
+	// Send the version of a single webhook, for use in If-Match when updating it
+	if webhookID != "" && len(response) == 1 {
+		w.Header().Set("ETag", createETag(response[0].Version))
+	}
+
+	// For v2, respond with an object if a webhookID was given, and a list otherwise
+	if isV2Request(r) {
+		return respondWithWebhooksEnvelope(w, r, webhookID, response)
+	}
+
 	// If one webhook returned, respond with only that one struct
 	if len(response) == 1 {
 		err = gateway.RespondToGetRequestWithJSON(w, response[0], http.StatusOK)
@@ -184,16 +1066,78 @@ func checkIfValidWebhookId(id string) bool {
 }
 
 /*
-Get webhook from database, and create webhook structs from this data
+Get the data of a webhook, if it exists and the client may access it
+
+	client		- Client of the request
+	webhookID	- ID of the webhook
+
+	return	- Data of the webhook, or error with status 404 if it does not exist or is owned by another client
+*/
+func getOwnedWebhookData(client auth.Client, webhookID string) (map[string]interface{}, error) {
+	if webhookID == "" || !checkIfValidWebhookId(webhookID) {
+		return nil, webhookNotFound()
+	}
+
+	webhookData, err := db.GetDocumentFromFirestore(webhookID, constants.WEBHOOKS_COLLECTION)
+	if err != nil {
+		return nil, err
+	}
+
+	// Webhooks of other clients are not found, so their IDs can not be probed
+	owner, _ := webhookData["owner"].(string)
+	if !client.CanAccess(owner) {
+		return nil, webhookNotFound()
+	}
+
+	return webhookData, nil
+}
+
+/*
+Returns the error for webhooks which do not exist, or are owned by another client
+*/
+func webhookNotFound() error {
+	return structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_WEBHOOK_NOT_FOUND, "id", "Invalid webhookID given", "webhookID given was not found in database")
+}
+
+/*
+Get webhook from database, and create webhook structs from this data.
+Clients only get their own webhooks, while the admin gets all. Listed webhooks are in the order they were registered, and their urls are redacted.
+
+	client		- Client of the request
+	webhookID	- ID of the webhook to get, or empty for all webhooks of the client
 */
-func getWebhooks(webhookID string) ([]structs.Webhook, error) {
+func getWebhooks(client auth.Client, webhookID string) ([]structs.Webhook, error) {
+	webhooks, err := loadWebhooks(client, webhookID)
+	if err != nil {
+		return webhooks, err
+	}
+
+	// The database gives webhooks in no particular order
+	sortWebhooks(webhooks, constants.WEBHOOK_SORT_CREATED)
+
+	// Urls often contain tokens of the receiver, so they are only shown in full when a single webhook is requested
+	if webhookID == "" {
+		redactWebhookUrls(webhooks)
+	}
+
+	return webhooks, nil
+}
+
+/*
+Get webhook from database, and create webhook structs from this data, with their urls in full.
+Clients only get their own webhooks, while the admin gets all.
+
+	client		- Client of the request
+	webhookID	- ID of the webhook to get, or empty for all webhooks of the client
+*/
+func loadWebhooks(client auth.Client, webhookID string) ([]structs.Webhook, error) {
 	var webhooks []structs.Webhook
 	data := make(map[string]map[string]interface{})
 	var err error
 
 	if webhookID != "" {
 		// If webhookID is defined, get its data
-		webhookData, err := db.GetDocumentFromFirestore(webhookID, constants.WEBHOOKS_COLLECTION)
+		webhookData, err := getOwnedWebhookData(client, webhookID)
 		if err != nil {
 			// Error handling
 			return webhooks, err
@@ -201,22 +1145,27 @@ func getWebhooks(webhookID string) ([]structs.Webhook, error) {
 		// Save the webhooks data
 		data[webhookID] = webhookData
 
-	} else {
+	} else if client.Admin {
 		// If no webhookID is given, get all webhooks data
 		data, err = db.GetAllDocumentInCollectionFromFirestore(constants.WEBHOOKS_COLLECTION)
 		if err != nil {
 			// Error handling
 			return webhooks, err
 		}
-	}
-
-	for webhookID, webhookData := range data {
-		// For each webhook found in database, create struct from it
-		webhook := structs.CreateWebhookFromData(webhookData, webhookID)
+	} else {
+		// Other clients only get the webhooks they own
+		data, err = db.GetDocumentsWhereFromFirestore(constants.WEBHOOKS_COLLECTION, "owner", client.Owner)
 		if err != nil {
-			// Error handling
 			return webhooks, err
 		}
+	}
+
+	for id, webhookData := range data {
+		// For each webhook found in database, create struct from it
+		webhook := structs.CreateWebhookFromData(webhookData, id)
+
+		// Show webhooks past their expiry as expired, before the status is set by the check for expired webhooks
+		webhook.Status = webhookState(webhook, time.Now())
 
 		// Save the created struct
 		webhooks = append(webhooks, webhook)
@@ -224,3 +1173,28 @@ func getWebhooks(webhookID string) ([]structs.Webhook, error) {
 
 	return webhooks, nil
 }
+
+/*
+Responds to a v2 request for webhooks with the response envelope
+
+	w			- Responsewriter
+	r			- Request
+	webhookID	- ID of the webhook requested, or empty if all webhooks were requested
+	webhooks	- Webhooks to respond with
+*/
+func respondWithWebhooksEnvelope(w http.ResponseWriter, r *http.Request, webhookID string, webhooks []structs.Webhook) error {
+	query := map[string]interface{}{}
+
+	// Respond with only the webhook requested
+	if webhookID != "" && len(webhooks) == 1 {
+		query["webhookId"] = webhookID
+		return respondWithEnvelope(w, r, webhooks[0], 1, query, http.StatusOK)
+	}
+
+	// Always respond with a list, even when it is empty
+	if webhooks == nil {
+		webhooks = []structs.Webhook{}
+	}
+
+	return respondWithEnvelope(w, r, webhooks, len(webhooks), query, http.StatusOK)
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"assignment2/utils/auth"
+	"assignment2/utils/constants"
+	"assignment2/utils/gateway"
+	"assignment2/utils/params"
+	"assignment2/utils/structs"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Position of a webhook in a listing, by the value it is sorted by and its ID, which orders webhooks with the same value
+*/
+type webhookPosition struct {
+	key int64
+	id  string
+}
+
+/*
+Get a page of the webhooks of the client, filtered and sorted as given in the query of the request, and respond to user
+*/
+func viewWebhookList(w http.ResponseWriter, r *http.Request, client auth.Client) error {
+	query, err := params.GetWebhookQueryFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	webhooks, nextCursor, err := listWebhooks(client, query)
+	if err != nil {
+		return err
+	}
+
+	// Echo the query in v2 responses
+	echo := map[string]interface{}{"sort": query.Sort, "limit": query.Limit}
+	if query.Country != "" {
+		echo["country"] = query.Country
+	}
+	if query.Year != 0 {
+		echo["year"] = query.Year
+	}
+	if query.Url != "" {
+		echo["url"] = query.Url
+	}
+	if query.State != "" {
+		echo["state"] = query.State
+	}
+	if query.Cursor != "" {
+		echo["cursor"] = query.Cursor
+	}
+
+	return respondWithPage(w, r, webhooks, len(webhooks), echo, nextCursor, http.StatusOK)
+}
+
+/*
+Get a page of the webhooks of the client, or of all webhooks for the admin, with their urls redacted
+
+	client	- Client of the request
+	query	- Filters, sort order and page of the listing
+
+	return	- The webhooks, and the cursor of the next page, which is empty if this is the last page
+*/
+func listWebhooks(client auth.Client, query structs.WebhookQuery) ([]structs.Webhook, string, error) {
+	webhooks, err := loadWebhooks(client, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Urls are filtered in full, before they are redacted
+	webhooks = filterWebhooks(webhooks, query)
+	sortWebhooks(webhooks, query.Sort)
+
+	page, nextCursor, err := pageWebhooks(webhooks, query.Sort, query.Limit, query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	redactWebhookUrls(page)
+
+	return page, nextCursor, nil
+}
+
+/*
+Get the webhooks matching all filters of a query
+
+	webhooks	- Webhooks to filter, with their urls in full
+	query		- Query with the filters, which are left out if empty
+
+	return	- The webhooks matching the filters, in the same order
+*/
+func filterWebhooks(webhooks []structs.Webhook, query structs.WebhookQuery) []structs.Webhook {
+	filtered := []structs.Webhook{}
+
+	for _, webhook := range webhooks {
+		if query.Country != "" && webhook.Country != query.Country && !containsString(webhook.Countries, query.Country) {
+			continue
+		}
+		if query.Year != 0 && !webhookAppliesToYear(webhook, query.Year) {
+			continue
+		}
+		if query.Url != "" && !strings.Contains(strings.ToLower(webhook.Url), strings.ToLower(query.Url)) {
+			continue
+		}
+
+		// Webhooks registered before urls were verified have no status, and are active
+		state := webhook.Status
+		if state == "" {
+			state = constants.WEBHOOK_STATUS_ACTIVE
+		}
+		if query.State != "" && state != query.State {
+			continue
+		}
+
+		filtered = append(filtered, webhook)
+	}
+
+	return filtered
+}
+
+/*
+Checks if a webhook applies to a year, either as its year or within its range of years.
+Webhooks applying to any year are not matched, as they are not registered for the year.
+*/
+func webhookAppliesToYear(webhook structs.Webhook, year int) bool {
+	if webhook.Year != 0 {
+		return webhook.Year == year
+	}
+	if webhook.YearFrom == 0 && webhook.YearTo == 0 {
+		return false
+	}
+
+	return (webhook.YearFrom == 0 || webhook.YearFrom <= year) && (webhook.YearTo == 0 || year <= webhook.YearTo)
+}
+
+/*
+Checks if a list of strings contains a value
+*/
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Sorts webhooks by creation time or invocations, and by ID where those are equal, so the order is the same for each page
+
+	webhooks	- Webhooks to sort, in place
+	sortBy		- WEBHOOK_SORT_CREATED or WEBHOOK_SORT_INVOCATIONS, prefixed by WEBHOOK_SORT_DESCENDING_PREFIX if descending
+*/
+func sortWebhooks(webhooks []structs.Webhook, sortBy string) {
+	sort.SliceStable(webhooks, func(i, j int) bool {
+		return getWebhookPosition(webhooks[i], sortBy).before(getWebhookPosition(webhooks[j], sortBy), sortBy)
+	})
+}
+
+/*
+Get the position of a webhook in a listing with a sort order.
+Webhooks registered before creation times were stored are sorted as the oldest.
+*/
+func getWebhookPosition(webhook structs.Webhook, sortBy string) webhookPosition {
+	position := webhookPosition{id: webhook.WebhookId}
+
+	switch strings.TrimPrefix(sortBy, constants.WEBHOOK_SORT_DESCENDING_PREFIX) {
+	case constants.WEBHOOK_SORT_INVOCATIONS:
+		position.key = int64(webhook.Invocations)
+	default:
+		if webhook.CreatedAt != nil {
+			position.key = webhook.CreatedAt.UnixNano()
+		}
+	}
+
+	return position
+}
+
+/*
+Checks if a position comes before another in a listing with a sort order
+*/
+func (position webhookPosition) before(other webhookPosition, sortBy string) bool {
+	if position.key != other.key {
+		if strings.HasPrefix(sortBy, constants.WEBHOOK_SORT_DESCENDING_PREFIX) {
+			return position.key > other.key
+		}
+		return position.key < other.key
+	}
+
+	return position.id < other.id
+}
+
+/*
+Get a page of sorted webhooks, starting after the position in the cursor
+
+	webhooks	- Sorted webhooks
+	sortBy		- Sort order of the webhooks
+	limit		- Max amount of webhooks in the page
+	cursor		- Cursor of the previous page, or empty for the first page
+
+	return	- The page, and the cursor of the next page, which is empty if this is the last page, or error with status 400 if the cursor is invalid
+*/
+func pageWebhooks(webhooks []structs.Webhook, sortBy string, limit int, cursor string) ([]structs.Webhook, string, error) {
+	// Continue after the last webhook of the previous page, which is found by its position as it may have been deleted since
+	if cursor != "" {
+		after, err := decodeWebhookCursor(cursor, sortBy)
+		if err != nil {
+			return nil, "", err
+		}
+
+		start := sort.Search(len(webhooks), func(i int) bool {
+			return after.before(getWebhookPosition(webhooks[i], sortBy), sortBy)
+		})
+		webhooks = webhooks[start:]
+	}
+
+	if len(webhooks) <= limit {
+		return webhooks, "", nil
+	}
+
+	page := webhooks[:limit]
+	return page, encodeWebhookCursor(getWebhookPosition(page[limit-1], sortBy), sortBy), nil
+}
+
+/*
+Creates the cursor of the next page of a listing, from the position of the last webhook of the page.
+The sort order is included, as the position is only valid in the same order.
+*/
+func encodeWebhookCursor(position webhookPosition, sortBy string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortBy + ":" + strconv.FormatInt(position.key, 10) + ":" + position.id))
+}
+
+/*
+Get the position in a cursor created by encodeWebhookCursor
+
+	cursor	- Cursor given in the request
+	sortBy	- Sort order of the request, which must be the one of the cursor
+
+	return	- The position, or error with status 400 if the cursor is malformed or of another sort order
+*/
+func decodeWebhookCursor(cursor string, sortBy string) (webhookPosition, error) {
+	invalid := structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "cursor", "Malformed URL, invalid cursor parameter set", "")
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return webhookPosition{}, invalid
+	}
+	parts := strings.SplitN(string(decoded), ":", 3)
+	if len(parts) != 3 || parts[0] != sortBy || parts[2] == "" {
+		return webhookPosition{}, invalid
+	}
+	key, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return webhookPosition{}, invalid
+	}
+
+	return webhookPosition{key: key, id: parts[2]}, nil
+}
+
+/*
+Redacts the urls of listed webhooks, as they often contain tokens of the receiver. Webhooks of the file channel have no url to redact.
+*/
+func redactWebhookUrls(webhooks []structs.Webhook) {
+	for i := range webhooks {
+		if webhooks[i].Url != "" {
+			webhooks[i].Url = gateway.RedactWebhookUrl(webhooks[i].Url)
+		}
+	}
+}
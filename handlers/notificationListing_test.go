@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Creates webhooks to list, registered one hour apart in the order given
+*/
+func createListedWebhooks() []structs.Webhook {
+	created := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := created.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+
+	return []structs.Webhook{
+		{WebhookId: "C", Url: "https://hooks.example.com/a", Country: "NOR", Invocations: 5, CreatedAt: at(2), Status: constants.WEBHOOK_STATUS_ACTIVE},
+		{WebhookId: "A", Url: "https://example.org/b", Country: "ANY", Countries: []string{"SWE", "NOR"}, Year: 2020, Invocations: 50, CreatedAt: at(1), Status: constants.WEBHOOK_STATUS_PENDING},
+		{WebhookId: "D", Url: "https://HOOKS.example.com/c", Country: "DEU", YearFrom: 2010, Invocations: 5, CreatedAt: at(3), Status: constants.WEBHOOK_STATUS_DISABLED},
+		{WebhookId: "B", Url: "https://example.net/d", Country: "ANY", Invocations: 0},
+	}
+}
+
+/*
+Get the IDs of webhooks, in order
+*/
+func getWebhookIds(webhooks []structs.Webhook) []string {
+	ids := []string{}
+	for _, webhook := range webhooks {
+		ids = append(ids, webhook.WebhookId)
+	}
+	return ids
+}
+
+/*
+Tests that webhooks are filtered by country, year, url and state
+*/
+func TestFilterWebhooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		query structs.WebhookQuery
+		ids   []string
+	}{
+		{"no filters", structs.WebhookQuery{}, []string{"C", "A", "D", "B"}},
+		{"country or countries", structs.WebhookQuery{Country: "NOR"}, []string{"C", "A"}},
+		{"year or range of years", structs.WebhookQuery{Year: 2020}, []string{"A", "D"}},
+		{"year before range", structs.WebhookQuery{Year: 2000}, []string{}},
+		{"url contains, ignoring case", structs.WebhookQuery{Url: "hooks.example"}, []string{"C", "D"}},
+		{"state", structs.WebhookQuery{State: constants.WEBHOOK_STATUS_ACTIVE}, []string{"C", "B"}},
+		{"several filters", structs.WebhookQuery{Country: "NOR", Year: 2020}, []string{"A"}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.ids, getWebhookIds(filterWebhooks(createListedWebhooks(), test.query)), "Wrong webhooks for "+test.name)
+	}
+}
+
+/*
+Tests that webhooks are sorted by creation time or invocations, and by ID where those are equal
+*/
+func TestSortWebhooks(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		ids    []string
+	}{
+		{constants.WEBHOOK_SORT_CREATED, []string{"B", "A", "C", "D"}},
+		{"-" + constants.WEBHOOK_SORT_CREATED, []string{"D", "C", "A", "B"}},
+		{constants.WEBHOOK_SORT_INVOCATIONS, []string{"B", "C", "D", "A"}},
+		{"-" + constants.WEBHOOK_SORT_INVOCATIONS, []string{"A", "C", "D", "B"}},
+	}
+
+	for _, test := range tests {
+		webhooks := createListedWebhooks()
+		sortWebhooks(webhooks, test.sortBy)
+		assert.Equal(t, test.ids, getWebhookIds(webhooks), "Wrong order for "+test.sortBy)
+	}
+}
+
+/*
+Tests that pages continue after the last webhook of the previous page, even if it was deleted since
+*/
+func TestPageWebhooks(t *testing.T) {
+	sortBy := "-" + constants.WEBHOOK_SORT_INVOCATIONS
+	webhooks := createListedWebhooks()
+	sortWebhooks(webhooks, sortBy)
+
+	page, cursor, err := pageWebhooks(webhooks, sortBy, 2, "")
+	assert.Nil(t, err, "First page should be found")
+	assert.Equal(t, []string{"A", "C"}, getWebhookIds(page), "Wrong first page")
+	assert.NotEmpty(t, cursor, "First page should have a cursor")
+
+	page, next, err := pageWebhooks(webhooks, sortBy, 2, cursor)
+	assert.Nil(t, err, "Second page should be found")
+	assert.Equal(t, []string{"D", "B"}, getWebhookIds(page), "Wrong second page")
+	assert.Empty(t, next, "Last page should have no cursor")
+
+	// The last webhook of the previous page is deleted
+	page, _, err = pageWebhooks(append(webhooks[:1:1], webhooks[2:]...), sortBy, 2, cursor)
+	assert.Nil(t, err, "Second page should be found")
+	assert.Equal(t, []string{"D", "B"}, getWebhookIds(page), "Second page should not change")
+
+	// Cursors are only valid for the sort order they were created for
+	_, _, err = pageWebhooks(webhooks, constants.WEBHOOK_SORT_CREATED, 2, cursor)
+	assert.Equal(t, http.StatusBadRequest, err.(structs.WrappedError).StatusCode, "Cursor of another sort order should be rejected")
+	_, _, err = pageWebhooks(webhooks, sortBy, 2, "not a cursor")
+	assert.Equal(t, http.StatusBadRequest, err.(structs.WrappedError).StatusCode, "Malformed cursor should be rejected")
+}
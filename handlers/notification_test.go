@@ -3,6 +3,7 @@ package handlers
 import (
 	"assignment2/utils/constants"
 	"assignment2/utils/db"
+	"assignment2/utils/gateway"
 	"assignment2/utils/structs"
 	"encoding/json"
 	"io"
@@ -12,6 +13,9 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 // Slice of all webhooks registered during testing
@@ -49,8 +53,8 @@ func handleNotificationLogistics(t *testing.T, f func(*testing.T, string, http.C
 	server := httptest.NewServer(http.HandlerFunc(handler.ServeHTTP))
 	defer server.Close()
 
-	//Creates client to speak with server
-	client := http.Client{}
+	//Creates client to speak with server, which sends an API key as owner of the webhooks
+	client := http.Client{Transport: apiKeyTransport{key: "test-key"}}
 	defer client.CloseIdleConnections()
 
 	log.Println("URL: ", server.URL)
@@ -60,6 +64,32 @@ func handleNotificationLogistics(t *testing.T, f func(*testing.T, string, http.C
 	f(t, url, client)
 }
 
+/*
+Transport adding an API key to each request
+*/
+type apiKeyTransport struct {
+	key string
+}
+
+func (t apiKeyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(constants.API_KEY_HEADER, t.key)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+/*
+Tests that webhooks can not be used without an API key
+*/
+func TestNotificationRequiresApiKey(t *testing.T) {
+	handler := RootHandler(Notification)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, constants.NOTIFICATION_PATH, nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "Wrong status code for "+method)
+	}
+}
+
 func TestHttpNotification(t *testing.T) {
 	// Set up Firestore
 	db.InitializeFirestore(constants.CREDENTIALS_FILE_TESTING)
@@ -239,3 +269,167 @@ func deleteWebhook(t *testing.T, url string, client http.Client) {
 		t.Fatal("Error during deletion of webhook:" + resObject)
 	}
 }
+
+/*
+Tests that webhooks past their expiry are shown as expired, unless they are disabled
+*/
+func TestWebhookState(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	assert.Equal(t, constants.WEBHOOK_STATUS_ACTIVE, webhookState(structs.Webhook{Status: constants.WEBHOOK_STATUS_ACTIVE}, now), "Webhook without expiry should keep its status")
+	assert.Equal(t, constants.WEBHOOK_STATUS_ACTIVE, webhookState(structs.Webhook{Status: constants.WEBHOOK_STATUS_ACTIVE, ExpiresAt: &future}, now), "Webhook before expiry should keep its status")
+	assert.Equal(t, constants.WEBHOOK_STATUS_EXPIRED, webhookState(structs.Webhook{Status: constants.WEBHOOK_STATUS_ACTIVE, ExpiresAt: &past}, now), "Webhook past expiry should be expired")
+	assert.Equal(t, constants.WEBHOOK_STATUS_EXPIRED, webhookState(structs.Webhook{Status: constants.WEBHOOK_STATUS_PENDING, ExpiresAt: &past}, now), "Pending webhook past expiry should be expired")
+	assert.Equal(t, constants.WEBHOOK_STATUS_DISABLED, webhookState(structs.Webhook{Status: constants.WEBHOOK_STATUS_DISABLED, ExpiresAt: &past}, now), "Disabled webhook should stay disabled")
+}
+
+/*
+Tests that disabled and expired webhooks are refused with 409, pointing to what fires them again
+*/
+func TestWebhookStoppedError(t *testing.T) {
+	err := webhookStoppedError(constants.WEBHOOK_STATUS_DISABLED, "verifying its url").(structs.WrappedError)
+	assert.Equal(t, http.StatusConflict, err.StatusCode, "Disabled webhook should be a conflict")
+	assert.Equal(t, constants.ERR_WEBHOOK_DISABLED, err.Code, "Wrong code of disabled webhook")
+	assert.Contains(t, err.UsrMessage, constants.WEBHOOK_ENABLE_PATH, "Disabled webhook should point to enabling it")
+
+	err = webhookStoppedError(constants.WEBHOOK_STATUS_EXPIRED, "verifying its url").(structs.WrappedError)
+	assert.Equal(t, http.StatusConflict, err.StatusCode, "Expired webhook should be a conflict")
+	assert.Equal(t, constants.ERR_WEBHOOK_EXPIRED, err.Code, "Wrong code of expired webhook")
+	assert.Equal(t, "expiresAt", err.Param, "Expired webhook should point to expiresAt")
+}
+
+/*
+Tests that a challenge posted back must be the one stored for the webhook, and must not have expired
+*/
+func TestCheckVerificationChallenge(t *testing.T) {
+	now := time.Now()
+	webhookData := map[string]interface{}{
+		"verification_challenge": hashVerificationChallenge("CHALLENGE"),
+		"verification_expires":   now.Add(time.Hour),
+	}
+	assert.Nil(t, checkVerificationChallenge(webhookData, "CHALLENGE", now), "Challenge sent should verify the webhook")
+
+	tests := []struct {
+		name        string
+		webhookData map[string]interface{}
+		challenge   string
+	}{
+		{"wrong challenge", webhookData, "OTHER"},
+		{"hash of challenge", webhookData, hashVerificationChallenge("CHALLENGE")},
+		{"expired challenge", map[string]interface{}{"verification_challenge": hashVerificationChallenge("CHALLENGE"), "verification_expires": now.Add(-time.Second)}, "CHALLENGE"},
+		{"no challenge sent", map[string]interface{}{"url": "https://example.com"}, "CHALLENGE"},
+		{"challenge removed", map[string]interface{}{"verification_challenge": "", "verification_expires": now.Add(time.Hour)}, ""},
+	}
+	for _, test := range tests {
+		err := checkVerificationChallenge(test.webhookData, test.challenge, now)
+		assert.NotNil(t, err, "Challenge should be refused for "+test.name)
+		if err != nil {
+			assert.Equal(t, http.StatusUnprocessableEntity, err.(structs.WrappedError).StatusCode, "Wrong status for "+test.name)
+			assert.Equal(t, "challenge", err.(structs.WrappedError).Param, "Wrong param for "+test.name)
+		}
+	}
+}
+
+/*
+Tests that test deliveries report how the webhook url responded, without changing the webhook data
+*/
+func TestTestWebhook(t *testing.T) {
+	var payload map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get(constants.WEBHOOK_TEST_HEADER), "Test deliveries should have the test header")
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("received"))
+	}))
+	defer ts.Close()
+
+	allowed := gateway.AllowedWebhookHosts
+	gateway.AllowedWebhookHosts = []string{"127.0.0.1"}
+	defer func() { gateway.AllowedWebhookHosts = allowed }()
+
+	webhookData := map[string]interface{}{
+		"url":         ts.URL,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(3),
+	}
+
+	result := testWebhook("TEST", webhookData)
+	assert.True(t, result.Delivered, "Test delivery should be delivered")
+	assert.Equal(t, http.StatusAccepted, result.StatusCode, "Status code of the url should be returned")
+	assert.Equal(t, "received", result.Response, "Response of the url should be returned")
+	assert.True(t, strings.HasPrefix(result.DeliveryId, constants.WEBHOOK_TEST_PATH+"-"), "Delivery ID should be marked as a test")
+	assert.Equal(t, true, payload["test"], "Payload should be marked as a test")
+	assert.Equal(t, float64(3), payload["calls"], "Payload should have the current invocations")
+	assert.Equal(t, int64(3), webhookData["invocations"], "Invocations should not change")
+	assert.NotContains(t, webhookData, "test", "Webhook data should not be changed")
+}
+
+/*
+Tests that test deliveries to rate, digest, threshold and dataset webhooks get an event
+*/
+func TestCreateTestDeliveryData(t *testing.T) {
+	data := createTestDeliveryData(map[string]interface{}{
+		"country":   "ANY",
+		"year":      int64(-1),
+		"year_to":   int64(2015),
+		"trigger":   constants.WEBHOOK_TRIGGER_THRESHOLD,
+		"threshold": 50.0,
+	})
+
+	assert.Equal(t, true, data["test"], "Data should be marked as a test")
+	assert.Equal(t, int64(0), data["invocations"], "Webhooks without invocations should have none")
+	assert.Equal(t, map[string]interface{}{
+		"country":   "ANY",
+		"name":      "Any country",
+		"year":      int64(2015),
+		"old_value": 50.0,
+		"new_value": 50.0,
+		"threshold": 50.0,
+	}, data["event"], "Event should be at the threshold, for the last year of the range")
+
+	data = createTestDeliveryData(map[string]interface{}{
+		"country": "ANY",
+		"year":    int64(2010),
+		"trigger": constants.WEBHOOK_TRIGGER_DATASET,
+	})
+	assert.Equal(t, map[string]interface{}{
+		"type": constants.WEBHOOK_TRIGGER_DATASET,
+		"changes": []interface{}{map[string]interface{}{
+			"country":       "ANY",
+			"name":          "Any country",
+			"years_added":   []interface{}{},
+			"years_changed": []interface{}{int64(2010)},
+		}},
+	}, data["event"], "Event should change the year of the webhook")
+
+	data = createTestDeliveryData(map[string]interface{}{
+		"country":        "ANY",
+		"year":           int64(-1),
+		"calls":          int64(100),
+		"trigger":        constants.WEBHOOK_TRIGGER_RATE,
+		"window_seconds": int64(3600),
+	})
+	assert.Equal(t, map[string]interface{}{
+		"type":           constants.WEBHOOK_TRIGGER_RATE,
+		"calls":          int64(101),
+		"window_seconds": int64(3600),
+	}, data["event"], "Event should be just above the calls of the webhook")
+
+	data = createTestDeliveryData(map[string]interface{}{
+		"country":  "ANY",
+		"year":     int64(2010),
+		"trigger":  constants.WEBHOOK_TRIGGER_DIGEST,
+		"schedule": constants.WEBHOOK_SCHEDULE_DAILY,
+	})
+	event := data["event"].(map[string]interface{})
+	assert.Equal(t, constants.WEBHOOK_TRIGGER_DIGEST, event["type"], "Event should be a digest")
+	assert.Equal(t, constants.WEBHOOK_SCHEDULE_DAILY, event["schedule"], "Event should have the schedule of the webhook")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"country":   "ANY",
+		"year_from": int64(2010),
+		"year_to":   int64(2010),
+		"calls":     int64(1),
+	}}, event["invocations"], "Event should have a request of the country and year of the webhook")
+}
@@ -14,7 +14,7 @@ func Default(w http.ResponseWriter, r *http.Request) error {
 
 	// Send error message if request method is not get
 	if r.Method != http.MethodGet {
-		return structs.NewError(nil, http.StatusNotImplemented, "Invalid method, currently only GET is supported", "User used invalid http method")
+		return methodNotAllowed(w, http.MethodGet)
 	}
 
 	// Set content type
@@ -3,7 +3,6 @@ package handlers
 import (
 	"assignment2/utils/constants"
 	"assignment2/utils/db"
-	"assignment2/utils/gateway"
 	"assignment2/utils/params"
 	"assignment2/utils/structs"
 	"fmt"
@@ -18,18 +17,18 @@ func RenewablesHistory(w http.ResponseWriter, r *http.Request) error {
 	// Check if database is online. If not, give standard error response.
 	if !db.DbState {
 		usrMsg := fmt.Sprintf("The database is currently unavailable. Please try again later. Reattempting database connection in %v seconds.", time.Until(db.DbRestartTimerStartTime.Add(1*time.Minute)).Round(time.Second)) //Create message with time since timer was activated
-		return structs.NewError(nil, http.StatusServiceUnavailable, usrMsg, "")
+		return structs.NewCodedError(nil, http.StatusServiceUnavailable, constants.ERR_DATABASE_UNAVAILABLE, "", usrMsg, "")
 	}
 
 	var response []structs.CountryOutput
 
 	// Send error message if request method is not get
 	if r.Method != http.MethodGet {
-		return structs.NewError(nil, http.StatusNotImplemented, "Invalid method, currently only GET is supported", "User used invalid http method")
+		return methodNotAllowed(w, http.MethodGet)
 	}
 
 	// If cache hit, send cached response
-	hit, err := checkCache(w, r)
+	hit, err := checkCache(w, r, "neighbours", "sortByValue", "mean")
 	if hit || err != nil {
 		return err
 	}
@@ -47,7 +46,7 @@ func RenewablesHistory(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// Invoke webhooks
-	go db.InvokeCountry(countries, beginYear, endYear)
+	db.DispatchInvocation(countries, beginYear, endYear)
 
 	// Get the historical percentage of renewables for countires specified as a list of countryoutput structs
 	response, err = getHistoryRenewablesForCountries(w, countries, beginYear, endYear, sortByValue, getMean)
@@ -57,11 +56,11 @@ func RenewablesHistory(w http.ResponseWriter, r *http.Request) error {
 
 	// Check if there was any data for the given request
 	if len(response) == 0 {
-		return structs.NewError(nil, http.StatusNotFound, "No data available for given request", "No data in database which satisfied the request")
+		return structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_NO_DATA, "", "No data available for given request", "No data in database which satisfied the request")
 	}
 
 	// Respond with list of countryoutput struct encoded as json to user
-	err = gateway.RespondToGetRequestWithJSON(w, response, http.StatusOK)
+	err = respondWithRenewables(w, r, response, beginYear, endYear, "neighbours", "sortByValue", "mean")
 	if err != nil {
 		return err
 	}
@@ -21,7 +21,7 @@ func Status(w http.ResponseWriter, r *http.Request) error {
 
 	// Send error if request is not GET:
 	if r.Method != http.MethodGet {
-		return structs.NewError(nil, http.StatusNotImplemented, "Invalid method, currently only GET is supported", "User used invalid http method")
+		return methodNotAllowed(w, http.MethodGet)
 	}
 
 	// Generate status response
@@ -30,6 +30,12 @@ func Status(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// Respond with the status wrapped in the response envelope if the request was sent to v2
+	if isV2Request(r) {
+		statusRes.Version = constants.VERSION_V2
+		return respondWithEnvelope(w, r, statusRes, 1, map[string]interface{}{}, http.StatusOK)
+	}
+
 	// Handle get request
 	err = gateway.RespondToGetRequestWithJSON(w, statusRes, http.StatusOK)
 	if err != nil {
@@ -74,6 +80,7 @@ func createStatusResponse(countriesApiURL string, start time.Time) (structs.Stat
 		Webhooks:       amountOfWebhooks,
 		Version:        constants.VERSION,
 		Uptime:         calculateUptimeInSeconds(),
+		Dispatcher:     db.DispatcherStats(),
 	}
 
 	return statusResponse, nil
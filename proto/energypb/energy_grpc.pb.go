@@ -0,0 +1,415 @@
+// Protobuf definitions for the gRPC API of the service.
+// The gRPC API is served alongside the REST API, and shares its handler logic.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: proto/energypb/energy.proto
+
+package energypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Energy_GetCurrent_FullMethodName          = "/energy.v1.Energy/GetCurrent"
+	Energy_GetHistory_FullMethodName          = "/energy.v1.Energy/GetHistory"
+	Energy_CreateWebhook_FullMethodName       = "/energy.v1.Energy/CreateWebhook"
+	Energy_GetWebhook_FullMethodName          = "/energy.v1.Energy/GetWebhook"
+	Energy_ListWebhooks_FullMethodName        = "/energy.v1.Energy/ListWebhooks"
+	Energy_DeleteWebhook_FullMethodName       = "/energy.v1.Energy/DeleteWebhook"
+	Energy_RotateWebhookSecret_FullMethodName = "/energy.v1.Energy/RotateWebhookSecret"
+	Energy_GetStatus_FullMethodName           = "/energy.v1.Energy/GetStatus"
+)
+
+// EnergyClient is the client API for Energy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EnergyClient interface {
+	// Same as GET /energy/v1/renewables/current/{country?}
+	GetCurrent(ctx context.Context, in *GetCurrentRequest, opts ...grpc.CallOption) (*RenewablesResponse, error)
+	// Same as GET /energy/v1/renewables/history/{country?}, streaming one datapoint at a time
+	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (Energy_GetHistoryClient, error)
+	// Same as POST /energy/v1/notifications/
+	CreateWebhook(ctx context.Context, in *CreateWebhookRequest, opts ...grpc.CallOption) (*Webhook, error)
+	// Same as GET /energy/v1/notifications/{id}
+	GetWebhook(ctx context.Context, in *GetWebhookRequest, opts ...grpc.CallOption) (*Webhook, error)
+	// Same as GET /energy/v1/notifications/
+	ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...grpc.CallOption) (*ListWebhooksResponse, error)
+	// Same as DELETE /energy/v1/notifications/{id}
+	DeleteWebhook(ctx context.Context, in *DeleteWebhookRequest, opts ...grpc.CallOption) (*DeleteWebhookResponse, error)
+	// Same as POST /energy/v1/notifications/{id}/secret
+	RotateWebhookSecret(ctx context.Context, in *RotateWebhookSecretRequest, opts ...grpc.CallOption) (*WebhookSecret, error)
+	// Same as GET /energy/v1/status
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+}
+
+type energyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEnergyClient(cc grpc.ClientConnInterface) EnergyClient {
+	return &energyClient{cc}
+}
+
+func (c *energyClient) GetCurrent(ctx context.Context, in *GetCurrentRequest, opts ...grpc.CallOption) (*RenewablesResponse, error) {
+	out := new(RenewablesResponse)
+	err := c.cc.Invoke(ctx, Energy_GetCurrent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *energyClient) GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (Energy_GetHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Energy_ServiceDesc.Streams[0], Energy_GetHistory_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &energyGetHistoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Energy_GetHistoryClient interface {
+	Recv() (*RenewableDatapoint, error)
+	grpc.ClientStream
+}
+
+type energyGetHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *energyGetHistoryClient) Recv() (*RenewableDatapoint, error) {
+	m := new(RenewableDatapoint)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *energyClient) CreateWebhook(ctx context.Context, in *CreateWebhookRequest, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, Energy_CreateWebhook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *energyClient) GetWebhook(ctx context.Context, in *GetWebhookRequest, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, Energy_GetWebhook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *energyClient) ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...grpc.CallOption) (*ListWebhooksResponse, error) {
+	out := new(ListWebhooksResponse)
+	err := c.cc.Invoke(ctx, Energy_ListWebhooks_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *energyClient) DeleteWebhook(ctx context.Context, in *DeleteWebhookRequest, opts ...grpc.CallOption) (*DeleteWebhookResponse, error) {
+	out := new(DeleteWebhookResponse)
+	err := c.cc.Invoke(ctx, Energy_DeleteWebhook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *energyClient) RotateWebhookSecret(ctx context.Context, in *RotateWebhookSecretRequest, opts ...grpc.CallOption) (*WebhookSecret, error) {
+	out := new(WebhookSecret)
+	err := c.cc.Invoke(ctx, Energy_RotateWebhookSecret_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *energyClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Energy_GetStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EnergyServer is the server API for Energy service.
+// All implementations must embed UnimplementedEnergyServer
+// for forward compatibility
+type EnergyServer interface {
+	// Same as GET /energy/v1/renewables/current/{country?}
+	GetCurrent(context.Context, *GetCurrentRequest) (*RenewablesResponse, error)
+	// Same as GET /energy/v1/renewables/history/{country?}, streaming one datapoint at a time
+	GetHistory(*GetHistoryRequest, Energy_GetHistoryServer) error
+	// Same as POST /energy/v1/notifications/
+	CreateWebhook(context.Context, *CreateWebhookRequest) (*Webhook, error)
+	// Same as GET /energy/v1/notifications/{id}
+	GetWebhook(context.Context, *GetWebhookRequest) (*Webhook, error)
+	// Same as GET /energy/v1/notifications/
+	ListWebhooks(context.Context, *ListWebhooksRequest) (*ListWebhooksResponse, error)
+	// Same as DELETE /energy/v1/notifications/{id}
+	DeleteWebhook(context.Context, *DeleteWebhookRequest) (*DeleteWebhookResponse, error)
+	// Same as POST /energy/v1/notifications/{id}/secret
+	RotateWebhookSecret(context.Context, *RotateWebhookSecretRequest) (*WebhookSecret, error)
+	// Same as GET /energy/v1/status
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	mustEmbedUnimplementedEnergyServer()
+}
+
+// UnimplementedEnergyServer must be embedded to have forward compatible implementations.
+type UnimplementedEnergyServer struct {
+}
+
+func (UnimplementedEnergyServer) GetCurrent(context.Context, *GetCurrentRequest) (*RenewablesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCurrent not implemented")
+}
+func (UnimplementedEnergyServer) GetHistory(*GetHistoryRequest, Energy_GetHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
+}
+func (UnimplementedEnergyServer) CreateWebhook(context.Context, *CreateWebhookRequest) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateWebhook not implemented")
+}
+func (UnimplementedEnergyServer) GetWebhook(context.Context, *GetWebhookRequest) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWebhook not implemented")
+}
+func (UnimplementedEnergyServer) ListWebhooks(context.Context, *ListWebhooksRequest) (*ListWebhooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhooks not implemented")
+}
+func (UnimplementedEnergyServer) DeleteWebhook(context.Context, *DeleteWebhookRequest) (*DeleteWebhookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhook not implemented")
+}
+func (UnimplementedEnergyServer) RotateWebhookSecret(context.Context, *RotateWebhookSecretRequest) (*WebhookSecret, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateWebhookSecret not implemented")
+}
+func (UnimplementedEnergyServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedEnergyServer) mustEmbedUnimplementedEnergyServer() {}
+
+// UnsafeEnergyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EnergyServer will
+// result in compilation errors.
+type UnsafeEnergyServer interface {
+	mustEmbedUnimplementedEnergyServer()
+}
+
+func RegisterEnergyServer(s grpc.ServiceRegistrar, srv EnergyServer) {
+	s.RegisterService(&Energy_ServiceDesc, srv)
+}
+
+func _Energy_GetCurrent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCurrentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).GetCurrent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_GetCurrent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).GetCurrent(ctx, req.(*GetCurrentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Energy_GetHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EnergyServer).GetHistory(m, &energyGetHistoryServer{stream})
+}
+
+type Energy_GetHistoryServer interface {
+	Send(*RenewableDatapoint) error
+	grpc.ServerStream
+}
+
+type energyGetHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *energyGetHistoryServer) Send(m *RenewableDatapoint) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Energy_CreateWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateWebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).CreateWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_CreateWebhook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).CreateWebhook(ctx, req.(*CreateWebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Energy_GetWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).GetWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_GetWebhook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).GetWebhook(ctx, req.(*GetWebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Energy_ListWebhooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).ListWebhooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_ListWebhooks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).ListWebhooks(ctx, req.(*ListWebhooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Energy_DeleteWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteWebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).DeleteWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_DeleteWebhook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).DeleteWebhook(ctx, req.(*DeleteWebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Energy_RotateWebhookSecret_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateWebhookSecretRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).RotateWebhookSecret(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_RotateWebhookSecret_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).RotateWebhookSecret(ctx, req.(*RotateWebhookSecretRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Energy_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnergyServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Energy_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnergyServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Energy_ServiceDesc is the grpc.ServiceDesc for Energy service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Energy_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "energy.v1.Energy",
+	HandlerType: (*EnergyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCurrent",
+			Handler:    _Energy_GetCurrent_Handler,
+		},
+		{
+			MethodName: "CreateWebhook",
+			Handler:    _Energy_CreateWebhook_Handler,
+		},
+		{
+			MethodName: "GetWebhook",
+			Handler:    _Energy_GetWebhook_Handler,
+		},
+		{
+			MethodName: "ListWebhooks",
+			Handler:    _Energy_ListWebhooks_Handler,
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler:    _Energy_DeleteWebhook_Handler,
+		},
+		{
+			MethodName: "RotateWebhookSecret",
+			Handler:    _Energy_RotateWebhookSecret_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Energy_GetStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetHistory",
+			Handler:       _Energy_GetHistory_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/energypb/energy.proto",
+}
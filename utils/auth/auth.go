@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Hash of the API key of the admin, which may see and change all webhooks. Set from $ADMIN_API_KEY in main, and empty if there is no admin.
+var adminKeyHash string
+
+/*
+Client sending a request, identified by its API key
+*/
+type Client struct {
+	Owner string // Hash of the API key, which is stored as the owner of the webhooks the client registers
+	Admin bool   // If the client used the API key of the admin
+}
+
+// Key of the client in the context of a request
+type clientContextKey struct{}
+
+/*
+Sets the API key of the admin
+
+	key	- API key of the admin, or empty if there is no admin
+*/
+func SetAdminKey(key string) {
+	if key == "" {
+		adminKeyHash = ""
+		return
+	}
+	adminKeyHash = HashApiKey(key)
+}
+
+/*
+Hashes an API key, so the key itself is never stored
+
+	key	- API key
+
+	return	- Hex encoded SHA-256 hash of the key
+*/
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+Get the client of a request, from the API key in the X-API-Key header or as a bearer token
+
+	r	- Request
+
+	return	- The client, or error with status 401 if no API key was given
+*/
+func ClientFromRequest(r *http.Request) (Client, error) {
+	key := r.Header.Get(constants.API_KEY_HEADER)
+	if key == "" {
+		key = getBearerToken(r.Header.Get("Authorization"))
+	}
+
+	return createClient(key)
+}
+
+/*
+Get the client of a gRPC call, from the API key in the x-api-key metadata or as a bearer token
+
+	ctx	- Context of the call
+
+	return	- The client, or error with status 401 if no API key was given
+*/
+func ClientFromMetadata(ctx context.Context) (Client, error) {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(constants.API_KEY_HEADER); len(keys) > 0 {
+			key = keys[0]
+		} else if values := md.Get("authorization"); len(values) > 0 {
+			key = getBearerToken(values[0])
+		}
+	}
+
+	return createClient(key)
+}
+
+/*
+Adds a client to the context of a request, for use where only the context is given, such as GraphQL resolvers
+*/
+func NewContext(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+/*
+Get the client added to the context of a request
+
+	return	- The client, or error with status 401 if the request had no API key
+*/
+func ClientFromContext(ctx context.Context) (Client, error) {
+	if client, ok := ctx.Value(clientContextKey{}).(Client); ok {
+		return client, nil
+	}
+
+	return Client{}, missingApiKey()
+}
+
+/*
+Checks if the client may see and change a resource
+
+	owner	- Hash of the API key which created the resource, or empty if it was created before resources had owners
+
+	return	- If the client is the owner or the admin. Resources without an owner are only available to the admin.
+*/
+func (c Client) CanAccess(owner string) bool {
+	return c.Admin || (owner != "" && owner == c.Owner)
+}
+
+/*
+Creates a client from an API key
+*/
+func createClient(key string) (Client, error) {
+	if key == "" {
+		return Client{}, missingApiKey()
+	}
+
+	owner := HashApiKey(key)
+	admin := adminKeyHash != "" && subtle.ConstantTimeCompare([]byte(owner), []byte(adminKeyHash)) == 1
+
+	return Client{Owner: owner, Admin: admin}, nil
+}
+
+/*
+Get the token of a bearer Authorization header, or empty if it is not a bearer token
+*/
+func getBearerToken(header string) string {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+/*
+Returns the error for requests without an API key
+*/
+func missingApiKey() error {
+	return structs.NewCodedError(nil, http.StatusUnauthorized, constants.ERR_UNAUTHORIZED, constants.API_KEY_HEADER, "An API key must be given in the "+constants.API_KEY_HEADER+" header, or as a bearer token", "")
+}
+
+/*
+Returns the error for clients which must be the admin
+*/
+func AdminRequired() error {
+	return structs.NewCodedError(nil, http.StatusForbidden, constants.ERR_FORBIDDEN, "", "Only the admin API key may use this endpoint", "")
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Tests that clients are identified by the hash of their API key, from the header or a bearer token
+*/
+func TestClientFromRequest(t *testing.T) {
+	SetAdminKey("admin-key")
+	defer SetAdminKey("")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(constants.API_KEY_HEADER, "client-key")
+	client, err := ClientFromRequest(r)
+	assert.Nil(t, err, "API key in header should be accepted")
+	assert.Equal(t, HashApiKey("client-key"), client.Owner, "Owner should be the hash of the key")
+	assert.NotContains(t, client.Owner, "client-key", "Key should not be stored")
+	assert.False(t, client.Admin, "Client should not be admin")
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer admin-key")
+	client, err = ClientFromRequest(r)
+	assert.Nil(t, err, "API key as bearer token should be accepted")
+	assert.True(t, client.Admin, "Client with the admin key should be admin")
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Basic YWRtaW4tazpleQ==")
+	_, err = ClientFromRequest(r)
+	assert.Equal(t, http.StatusUnauthorized, err.(structs.WrappedError).StatusCode, "Other schemes should not be accepted")
+	assert.Equal(t, constants.ERR_UNAUTHORIZED, err.(structs.WrappedError).Code, "Wrong error code")
+}
+
+/*
+Tests that there is no admin when no admin key is set
+*/
+func TestNoAdminWithoutKey(t *testing.T) {
+	SetAdminKey("")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(constants.API_KEY_HEADER, "any-key")
+	client, err := ClientFromRequest(r)
+	assert.Nil(t, err, "API key should be accepted")
+	assert.False(t, client.Admin, "No client should be admin")
+}
+
+/*
+Tests that gRPC calls and GraphQL resolvers get the client from their context
+*/
+func TestClientFromContext(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "client-key"))
+	client, err := ClientFromMetadata(ctx)
+	assert.Nil(t, err, "API key in metadata should be accepted")
+	assert.Equal(t, HashApiKey("client-key"), client.Owner, "Wrong owner")
+
+	_, err = ClientFromMetadata(context.Background())
+	assert.NotNil(t, err, "Calls without metadata should be rejected")
+
+	_, err = ClientFromContext(context.Background())
+	assert.NotNil(t, err, "Requests without a client should be rejected")
+
+	got, err := ClientFromContext(NewContext(context.Background(), client))
+	assert.Nil(t, err, "Client should be in context")
+	assert.Equal(t, client, got, "Wrong client in context")
+}
+
+/*
+Tests that clients may only access what they own, while the admin may access everything
+*/
+func TestCanAccess(t *testing.T) {
+	owner := Client{Owner: HashApiKey("owner")}
+	other := Client{Owner: HashApiKey("other")}
+	admin := Client{Owner: HashApiKey("admin"), Admin: true}
+
+	assert.True(t, owner.CanAccess(owner.Owner), "Owner should have access")
+	assert.False(t, other.CanAccess(owner.Owner), "Other clients should not have access")
+	assert.True(t, admin.CanAccess(owner.Owner), "Admin should have access")
+	assert.False(t, owner.CanAccess(""), "Resources without owner should not be available to clients")
+	assert.True(t, admin.CanAccess(""), "Resources without owner should be available to the admin")
+}
@@ -1,6 +1,9 @@
 package constants
 
-const VERSION = "v1" // Service version
+import "time"
+
+const VERSION = "v1"    // Service version
+const VERSION_V2 = "v2" // Service version of the v2 API
 
 // Endpoint paths
 
@@ -11,17 +14,44 @@ const RENEWABLES_CURRENT_PATH = RENEWABLES_PATH + "/current/" // Renewables curr
 const RENEWABLES_HISTORY_PATH = RENEWABLES_PATH + "/history/" // Renewables history path
 const NOTIFICATION_PATH = SERVICE_PATH + "/notifications/"    // Notification path
 const STATUS_PATH = SERVICE_PATH + "/status"                  // Status path
+const DEADLETTERS_PATH = SERVICE_PATH + "/deadletters/"       // Dead-letter path
+
+// Endpoint paths for the v2 API, served by the same handlers as v1
+
+const SERVICE_PATH_V2 = "/energy/" + VERSION_V2                     // Service path v2
+const RENEWABLES_PATH_V2 = SERVICE_PATH_V2 + "/renewables"          // Renewables path v2
+const RENEWABLES_CURRENT_PATH_V2 = RENEWABLES_PATH_V2 + "/current/" // Renewables current path v2
+const RENEWABLES_HISTORY_PATH_V2 = RENEWABLES_PATH_V2 + "/history/" // Renewables history path v2
+const NOTIFICATION_PATH_V2 = SERVICE_PATH_V2 + "/notifications/"    // Notification path v2
+const STATUS_PATH_V2 = SERVICE_PATH_V2 + "/status"                  // Status path v2
+const GRAPHQL_PATH = SERVICE_PATH_V2 + "/graphql"                   // GraphQL path
+const DEADLETTERS_PATH_V2 = SERVICE_PATH_V2 + "/deadletters/"       // Dead-letter path v2
+
+// Deprecation of the v1 API
+
+const V1_DEPRECATION = "@1793491200"              // Deprecation header value for v1 responses (2026-11-01)
+const V1_SUNSET = "Wed, 30 Jun 2027 00:00:00 GMT" // Sunset header value for v1 responses
+
+// Aggregation types for v2 renewables responses
+
+const AGGREGATION_NONE = "none" // Value for a single year
+const AGGREGATION_MEAN = "mean" // Mean value over a year range
 
 // Content type
 
-const CONT_TYPE_JSON = "application/json" // Content type JSON
+const CONT_TYPE_JSON = "application/json"                         // Content type JSON
+const CONT_TYPE_PROBLEM_JSON = "application/problem+json"         // Content type for RFC 7807 problem details
+const CONT_TYPE_CLOUDEVENTS_JSON = "application/cloudevents+json" // Content type for CloudEvents in structured mode
 
 // Country API
 
-const COUNTRIES_API_URL = "http://129.241.150.113:8080" // URL to countries API
-const COUNTRY_NAME_SEARCH_PATH = "/v3.1/name/"          // Path to search for country name
-const COUNTRY_CODE_SEARCH_PATH = "/v3.1/alpha/"         // Path to search for country code
-const USED_COUNTRY_CODE = "cca3"                        // Country code used in response from countries API
+const COUNTRIES_API_URL = "http://129.241.150.113:8080"  // URL to countries API
+const COUNTRY_NAME_SEARCH_PATH = "/v3.1/name/"           // Path to search for country name
+const COUNTRY_CODE_SEARCH_PATH = "/v3.1/alpha/"          // Path to search for country code
+const COUNTRY_CODES_SEARCH_PATH = "/v3.1/alpha?codes="   // Path to search for multiple country codes, separated by comma
+const COUNTRY_REGION_SEARCH_PATH = "/v3.1/region/"       // Path to search for countries in a region
+const COUNTRY_SUBREGION_SEARCH_PATH = "/v3.1/subregion/" // Path to search for countries in a subregion
+const USED_COUNTRY_CODE = "cca3"                         // Country code used in response from countries API
 
 // Years for renewables database
 
@@ -30,9 +60,13 @@ const LATEST_YEAR_DB = 2021 // Latest year in database
 
 // Firestore constants
 
-const RENEWABLES_COLLECTION = "renewables" // Name of renewables collection
-const WEBHOOKS_COLLECTION = "webhooks"     // Name of webhooks collection
-const CACHE_COLLECTION = "cache"           // Name of cache collection
+const RENEWABLES_COLLECTION = "renewables"                   // Name of renewables collection
+const WEBHOOKS_COLLECTION = "webhooks"                       // Name of webhooks collection
+const CACHE_COLLECTION = "cache"                             // Name of cache collection
+const DELIVERIES_COLLECTION = "deliveries"                   // Name of collection with webhook deliveries waiting to be sent
+const DEADLETTERS_COLLECTION = "deadletters"                 // Name of collection with webhook deliveries which failed too many times
+const PENDING_INVOCATIONS_COLLECTION = "pending_invocations" // Name of collection with invocations stored when they could not be dispatched
+const ATTEMPTS_COLLECTION = "attempts"                       // Name of subcollection of each webhook with its delivery attempts
 
 // Name of files
 const RENEWABLES_CSV_FILE = "/go/src/app/res/renewable-share-energy.csv"   // Path to CSV file
@@ -42,12 +76,167 @@ const RESTCOUNTRIES_MOCK = "./res/restcountries-mock.json"                 // Pa
 
 // Webhooks
 
-const WEBHOOK_ID_LENGTH = 16 // Length of webhook ID
+const WEBHOOK_ID_LENGTH = 16                          // Length of webhook ID
+const WEBHOOK_SECRET_PREFIX = "whsec_"                // Prefix of webhook signing secrets
+const WEBHOOK_SECRET_LENGTH = 32                      // Amount of random bytes in webhook signing secrets
+const WEBHOOK_SECRET_PATH = "secret"                  // Path after webhookID for rotating the signing secret
+const WEBHOOK_SECRET_GRACE_PERIOD = 24 * time.Hour    // Time the previous secret is still used for signing after rotation
+const WEBHOOK_SIGNATURE_HEADER = "X-Energy-Signature" // Header with timestamp and signatures of webhook deliveries
+const WEBHOOK_DELIVERY_HEADER = "X-Energy-Delivery"   // Header with unique ID of each webhook delivery
+const WEBHOOK_TEST_HEADER = "X-Energy-Test"           // Header set to true on test deliveries
+const WEBHOOK_SIGNATURE_TOLERANCE = 5 * time.Minute   // Max age of signature timestamps accepted by receivers
+const WEBHOOK_SIGNATURE_SCHEME = "v1"                 // Name of the signature scheme, HMAC-SHA256 of "{timestamp}.{body}"
+const WEBHOOK_TRIGGER_CALLS = "calls"                 // Trigger of webhooks fired when the invocations reach a multiple of calls
+const WEBHOOK_TRIGGER_THRESHOLD = "threshold"         // Trigger of webhooks fired when a dataset import crosses a percentage or changes a value by more than a number of points
+const WEBHOOK_TRIGGER_DATASET = "dataset.updated"     // Trigger of webhooks fired when a dataset import adds or changes renewables data
+const WEBHOOK_TRIGGER_RATE = "rate"                   // Trigger of webhooks fired when the invocations within a window of time exceed calls
+const WEBHOOK_TRIGGER_DIGEST = "digest"               // Trigger of webhooks sent a digest of the requests and dataset changes on a schedule
+
+// Webhook rate triggers
+
+const WEBHOOK_RATE_BUCKETS = 12                    // Buckets the invocations of a window are counted in, so the window slides by a twelfth of its length
+const MIN_WEBHOOK_RATE_WINDOW = time.Minute        // Shortest window of rate webhooks
+const MAX_WEBHOOK_RATE_WINDOW = 7 * 24 * time.Hour // Longest window of rate webhooks
+const MIN_WEBHOOK_RATE_COOLDOWN = time.Second      // Shortest cooldown of rate webhooks, which is stored in whole seconds
+
+// Webhook digests
+
+const WEBHOOK_SCHEDULE_HOURLY = "hourly"  // Schedule of digests sent at the start of every hour
+const WEBHOOK_SCHEDULE_DAILY = "daily"    // Schedule of digests sent at midnight UTC
+const WEBHOOK_SCHEDULE_WEEKLY = "weekly"  // Schedule of digests sent at midnight UTC between Sunday and Monday
+const DIGEST_CHECK_INTERVAL = time.Minute // Time between each check for digests which are due
+const DIGEST_TOP_COUNTRIES = 5            // Amount of countries in the top requested countries of a digest
+const DIGEST_ALL_COUNTRIES = "ALL"        // Country of invocations in a digest which requested all countries
+
+// Webhook payload formats
+
+const WEBHOOK_FORMAT_NATIVE = "native"               // Payload format of the webhook or threshold notification as json, used if no format is given
+const WEBHOOK_FORMAT_CLOUDEVENTS = "cloudevents"     // Payload format of CloudEvents 1.0 in structured json, with the native payload as data
+const WEBHOOK_FORMAT_SLACK = "slack"                 // Payload format of a Slack or Mattermost message
+const WEBHOOK_FORMAT_TEMPLATE = "template"           // Payload format of a Go text/template given at registration, which must render json
+const CLOUDEVENTS_TYPE_PREFIX = "no.energy.webhook." // Prefix of the type of CloudEvents, followed by the trigger
+const MAX_WEBHOOK_TEMPLATE_SIZE = 4096               // Max length of templates given at registration
+const MAX_WEBHOOK_PAYLOAD_SIZE = 64 * 1024           // Max size of payloads rendered from templates
+
+// Webhook notification channels
+
+const WEBHOOK_CHANNEL_HTTP = "http"   // Channel posting deliveries to the url of the webhook, used if no channel is given
+const WEBHOOK_CHANNEL_SMTP = "smtp"   // Channel emailing deliveries to the mailto: url of the webhook, if $SMTP_ADDR is set
+const WEBHOOK_CHANNEL_FILE = "file"   // Channel appending deliveries to the file sink of the service, if $WEBHOOK_FILE_SINK is set
+const WEBHOOK_FILE_SINK_STDOUT = "-"  // File sink writing deliveries to stdout
+const SMTP_TIMEOUT = 10 * time.Second // Max time to wait for the SMTP server to accept a delivery
+
+// Outbound headers and credentials of webhooks
+
+const WEBHOOK_AUTH_BASIC = "basic"       // Auth sending a username and password as HTTP basic auth
+const WEBHOOK_AUTH_BEARER = "bearer"     // Auth sending a token as bearer token
+const MAX_WEBHOOK_HEADERS = 20           // Max amount of outbound headers of a webhook
+const MAX_WEBHOOK_HEADER_SIZE = 4096     // Max length of the name and value of an outbound header
+const REDACTED_VALUE = "***"             // Value shown instead of header values and credentials, which are never sent in responses
+const ENCRYPTION_KEY_SIZE = 32           // Size in bytes of the AES-256 key in $WEBHOOK_ENCRYPTION_KEY
+const ENCRYPTED_VALUE_PREFIX = "enc:v1:" // Prefix of values encrypted at rest, followed by the nonce and ciphertext in base64
+
+// Webhook delivery
+
+const WEBHOOK_DELIVERY_TIMEOUT = 10 * time.Second       // Max time to wait for a webhook url to respond
+const WEBHOOK_IDLE_CONN_TIMEOUT = 90 * time.Second      // Time a connection to a webhook url is kept alive between deliveries
+const WEBHOOK_MAX_IDLE_CONNS_PER_HOST = 4               // Connections kept alive to each webhook host between deliveries
+const DEFAULT_WEBHOOK_MAX_ATTEMPTS = 8                  // Attempts before a delivery is moved to dead-letters if $WEBHOOK_MAX_ATTEMPTS is not set
+const WEBHOOK_RETRY_BASE_DELAY = 10 * time.Second       // Delay before the first retry, doubled for each attempt
+const WEBHOOK_RETRY_MAX_DELAY = time.Hour               // Max delay between retries
+const WEBHOOK_DELIVERY_LEASE = time.Minute              // Time a server has to attempt a delivery before other servers may attempt it
+const WEBHOOK_DELIVERY_POLL_INTERVAL = 10 * time.Second // Time between each check for deliveries to retry
+const WEBHOOK_DELIVERY_BATCH_SIZE = 50                  // Max amount of deliveries attempted in each check
+const WEBHOOK_PENDING_DELIVERY_DELAY = time.Minute      // Time between each check of deliveries to a webhook waiting to be verified
+const DEADLETTER_REPLAY_PATH = "replay"                 // Path after the dead-letter ID for replaying it
+const WEBHOOK_INVOCATION_MAX_ATTEMPTS = 20              // Attempts of the transaction counting an invocation, which is retried when replicas count invocations of the same webhook at once
+const WEBHOOK_TEST_PATH = "test"                        // Path after webhookID for sending a test delivery
+const MAX_RESPONSE_EXCERPT_SIZE = 1024                  // Max amount of bytes of the response from a webhook url kept with the attempt
+
+// Dispatcher of invocations and webhook deliveries
+
+const DEFAULT_DISPATCHER_WORKERS = 16               // Jobs run at once if $DISPATCHER_WORKERS is not set
+const DEFAULT_DISPATCHER_QUEUE_SIZE = 1000          // Jobs waiting before new jobs are rejected if $DISPATCHER_QUEUE_SIZE is not set
+const DEFAULT_DISPATCHER_MAX_PER_HOST = 4           // Deliveries run at once against the same webhook host if $DISPATCHER_MAX_PER_HOST is not set
+const DISPATCHER_SHUTDOWN_TIMEOUT = 8 * time.Second // Time to finish requests and jobs queued at shutdown, within the 10 seconds docker waits before killing the container
+
+// Webhook delivery history
+
+const WEBHOOK_DELIVERIES_PATH = "deliveries"        // Path after webhookID for viewing delivery attempts
+const DEFAULT_DELIVERY_HISTORY_RETENTION_DAYS = 30  // Days delivery attempts are kept if $DELIVERY_HISTORY_RETENTION_DAYS is not set
+const DELIVERY_HISTORY_CLEANUP_INTERVAL = time.Hour // Time between each removal of delivery attempts older than the retention
+
+// Pagination
+
+const DEFAULT_PAGE_LIMIT = 20 // Amount of items in a page if no limit is given
+const MAX_PAGE_LIMIT = 100    // Max amount of items in a page
+
+// Listing of webhooks
+
+const WEBHOOK_SORT_CREATED = "createdAt"       // Sorts webhooks by the time they were registered, the default
+const WEBHOOK_SORT_INVOCATIONS = "invocations" // Sorts webhooks by their count of invocations
+const WEBHOOK_SORT_DESCENDING_PREFIX = "-"     // Prefix of sort orders which are descending, such as -createdAt for newest first
+
+// Webhook verification
+
+const WEBHOOK_VERIFY_PATH = "verify"                      // Path after webhookID for sending the verification challenge again, or posting back a challenge sent by email
+const WEBHOOK_VERIFICATION_TYPE = "url_verification"      // Type of the body sent to verify the webhook url
+const WEBHOOK_VERIFICATION_TIMEOUT = 5 * time.Second      // Max time to wait for a webhook url to echo the challenge
+const WEBHOOK_VERIFICATION_CHALLENGE_TTL = 24 * time.Hour // Time to post back a challenge sent to a target which can not echo it, such as an email address
+const WEBHOOK_STATUS_PENDING = "pending"                  // Status of webhooks which have not echoed or posted back the challenge, and are not fired
+const WEBHOOK_STATUS_ACTIVE = "active"                    // Status of webhooks which have echoed the challenge
+
+// Webhook expiry and disabling
+
+const WEBHOOK_STATUS_DISABLED = "disabled"             // Status of webhooks disabled after too many failed deliveries in a row
+const WEBHOOK_STATUS_EXPIRED = "expired"               // Status of webhooks past their expiresAt
+const WEBHOOK_ENABLE_PATH = "enable"                   // Path after webhookID for enabling a disabled webhook
+const DEFAULT_WEBHOOK_DISABLE_AFTER_FAILURES = 20      // Failed deliveries in a row before a webhook is disabled if $WEBHOOK_DISABLE_AFTER_FAILURES is not set
+const WEBHOOK_EXPIRY_CHECK_INTERVAL = 10 * time.Minute // Time between each check for webhooks past their expiresAt
+const WEBHOOK_INDEX_RETRY_INTERVAL = 30 * time.Second  // Time before listening to changes of webhooks again, after listening failed
+
+// GraphQL
+
+const GRAPHQL_BATCH_WINDOW = 2 * time.Millisecond // Time to collect keys requested by resolvers before fetching them in one batch
+const GRAPHQL_MAX_PARALLELISM = 20                // Max amount of resolvers running concurrently for one request
+
+// gRPC
+
+const DEFAULT_GRPC_PORT = "9090" // Port of the gRPC server if $GRPC_PORT is not set
 
 // Cache
 
 const MAX_CACHE_AGE_IN_HOURS = 4 // Max age of cache in hours
 
+// Problem details
+
+const PROBLEM_TYPE_BASE = "/energy/problems/" // Base of the type URI in problem details, followed by the error code
+const REQUEST_ID_HEADER = "X-Request-ID"      // Header used for the request ID, both in requests and responses
+const API_KEY_HEADER = "X-API-Key"            // Header with the API key of the client, which may also be sent as a bearer token
+const MAX_REQUEST_ID_LENGTH = 64              // Max length of request IDs given by clients
+
+// Error codes
+
+const ERR_MALFORMED_PARAMETER = "malformed_parameter"   // A query parameter could not be parsed
+const ERR_YEAR_OUT_OF_RANGE = "year_out_of_range"       // A year parameter is outside of the dataset
+const ERR_MALFORMED_PATH = "malformed_path"             // The request path does not have the expected format
+const ERR_INVALID_BODY = "invalid_body"                 // The request body could not be decoded
+const ERR_MISSING_FIELD = "missing_field"               // A required field in the request body is missing
+const ERR_COUNTRY_NOT_FOUND = "country_not_found"       // The country given does not exist in the service
+const ERR_REGION_NOT_FOUND = "region_not_found"         // The region given does not exist in the restcountries API
+const ERR_WEBHOOK_NOT_FOUND = "webhook_not_found"       // The webhook ID given does not exist
+const ERR_NO_DATA = "no_data"                           // There is no data for the request
+const ERR_METHOD_NOT_ALLOWED = "method_not_allowed"     // The method is not supported by the endpoint
+const ERR_DATABASE_UNAVAILABLE = "database_unavailable" // The database is currently unavailable
+const ERR_DEADLETTER_NOT_FOUND = "deadletter_not_found" // The dead-letter ID given does not exist
+const ERR_PRECONDITION_FAILED = "precondition_failed"   // The resource was changed since the version given in If-Match
+const ERR_INVALID_FIELD = "invalid_field"               // A field in the request body has a value which is not allowed
+const ERR_VERIFICATION_FAILED = "verification_failed"   // The webhook url did not echo the verification challenge
+const ERR_UNAUTHORIZED = "unauthorized"                 // No API key was given
+const ERR_FORBIDDEN = "forbidden"                       // The API key given is not allowed to use the endpoint
+const ERR_WEBHOOK_EXPIRED = "webhook_expired"           // The webhook is past its expiresAt, which must be changed before it is enabled
+const ERR_WEBHOOK_DISABLED = "webhook_disabled"         // The webhook is disabled, and must be enabled before its url is verified or changed
+
 // Default error responses
 
 const DEFAULT500 = "There has been an internal server error. Please try again later."                                // Default 500 error message
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+/*
+Compares the renewables data before and after a dataset import, and stores a delivery to each dataset webhook
+which applies to any of the countries and years added or changed. The changes are also added to the next digest of digest webhooks.
+
+	oldData	- Renewables data before the import, with isoCode as key
+	newData	- Renewables data after the import, with isoCode as key
+
+	return	- Amount of deliveries stored and digests changed
+*/
+func InvokeDatasetUpdates(oldData, newData map[string]map[string]interface{}) int {
+	changes := diffDatasets(oldData, newData)
+	if len(changes) == 0 {
+		return 0
+	}
+
+	invoked := 0
+
+	iter := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).
+		Where("trigger", "in", []string{constants.WEBHOOK_TRIGGER_DATASET, constants.WEBHOOK_TRIGGER_DIGEST}).
+		Documents(firestoreContext)
+	defer iter.Stop()
+
+	// Go through all dataset and digest webhooks
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Println("Could not get dataset webhooks: " + err.Error())
+			return invoked
+		}
+
+		webhook := doc.Data()
+		if !webhookIsActive(webhook, time.Now()) {
+			continue
+		}
+
+		// Each webhook only gets the countries and years it applies to
+		webhookChanges := filterDatasetChanges(webhook, changes, getRegionIsoCodes)
+		if len(webhookChanges) == 0 {
+			continue
+		}
+
+		event := createDatasetEvent(webhookChanges)
+		if trigger, _ := webhook["trigger"].(string); trigger == constants.WEBHOOK_TRIGGER_DIGEST {
+			if err := collectDigestChanges(doc.Ref, event["changes"].([]interface{})); err != nil {
+				log.Println("Could not add changes to digest of webhook " + doc.Ref.ID + ": " + err.Error())
+				continue
+			}
+			invoked++
+			continue
+		}
+
+		_, err = EnqueueDelivery(doc.Ref.ID, webhook, event)
+		if err != nil {
+			log.Println("Could not enqueue delivery to webhook " + doc.Ref.ID + ": " + err.Error())
+			continue
+		}
+		invoked++
+	}
+
+	return invoked
+}
+
+/*
+Finds the years of each country which a dataset import added or changed
+
+	oldData	- Renewables data before the import, with isoCode as key
+	newData	- Renewables data after the import, with isoCode as key
+
+	return	- Changes of each country with years added or changed, sorted by ISO code
+*/
+func diffDatasets(oldData, newData map[string]map[string]interface{}) []structs.DatasetChange {
+	var changes []structs.DatasetChange
+
+	for isoCode, newCountry := range newData {
+		change := structs.DatasetChange{IsoCode: isoCode}
+		change.Country, _ = newCountry["name"].(string)
+
+		for key, data := range newCountry {
+			// Skip fields such as name
+			year, err := strconv.Atoi(key)
+			newValue, ok := data.(float64)
+			if err != nil || !ok {
+				continue
+			}
+
+			oldValue, existed := oldData[isoCode][key].(float64)
+			if !existed {
+				change.YearsAdded = append(change.YearsAdded, year)
+			} else if oldValue != newValue {
+				change.YearsChanged = append(change.YearsChanged, year)
+			}
+		}
+
+		if len(change.YearsAdded) == 0 && len(change.YearsChanged) == 0 {
+			continue
+		}
+		sort.Ints(change.YearsAdded)
+		sort.Ints(change.YearsChanged)
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].IsoCode < changes[j].IsoCode })
+	return changes
+}
+
+/*
+Get the changes of a dataset import which a webhook applies to
+
+	webhook		- Map of webhook data, as stored in firestore
+	changes		- Changes of each country in the import
+	regionCodes	- Function getting the ISO codes of the countries in a region
+
+	return	- Changes of the countries the webhook applies to, with only the years it applies to
+*/
+func filterDatasetChanges(webhook map[string]interface{}, changes []structs.DatasetChange, regionCodes func(region string) ([]string, error)) []structs.DatasetChange {
+	var filtered []structs.DatasetChange
+
+	for _, change := range changes {
+		if !webhookAppliesToCountries(webhook, []string{change.IsoCode}, regionCodes) {
+			continue
+		}
+
+		webhookChange := structs.DatasetChange{
+			IsoCode:      change.IsoCode,
+			Country:      change.Country,
+			YearsAdded:   filterYears(webhook, change.YearsAdded),
+			YearsChanged: filterYears(webhook, change.YearsChanged),
+		}
+		if len(webhookChange.YearsAdded) > 0 || len(webhookChange.YearsChanged) > 0 {
+			filtered = append(filtered, webhookChange)
+		}
+	}
+
+	return filtered
+}
+
+/*
+Get the years a webhook applies to
+*/
+func filterYears(webhook map[string]interface{}, years []int) []int {
+	var filtered []int
+	for _, year := range years {
+		if webhookAppliesToYears(webhook, year, year) {
+			filtered = append(filtered, year)
+		}
+	}
+	return filtered
+}
+
+/*
+Creates the event of a dataset import, as stored with the delivery
+
+	changes	- Changes of the countries the webhook applies to
+
+	return	- Event with the type and changes, in the types firestore returns them as
+*/
+func createDatasetEvent(changes []structs.DatasetChange) map[string]interface{} {
+	eventChanges := make([]interface{}, 0, len(changes))
+	for _, change := range changes {
+		eventChanges = append(eventChanges, map[string]interface{}{
+			"country":       change.IsoCode,
+			"name":          change.Country,
+			"years_added":   yearsToList(change.YearsAdded),
+			"years_changed": yearsToList(change.YearsChanged),
+		})
+	}
+
+	return map[string]interface{}{
+		"type":    constants.WEBHOOK_TRIGGER_DATASET,
+		"changes": eventChanges,
+	}
+}
+
+/*
+Converts years to a list as firestore returns it
+*/
+func yearsToList(years []int) []interface{} {
+	list := make([]interface{}, 0, len(years))
+	for _, year := range years {
+		list = append(list, int64(year))
+	}
+	return list
+}
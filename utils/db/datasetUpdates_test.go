@@ -0,0 +1,74 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that the years added or changed by an import are found for each country, and unchanged countries are left out
+*/
+func TestDiffDatasets(t *testing.T) {
+	oldData := map[string]map[string]interface{}{
+		"NOR": {"name": "Norway", "2020": 70.0, "2021": 71.0},
+		"SWE": {"name": "Sweden", "2020": 50.0},
+	}
+	newData := map[string]map[string]interface{}{
+		"NOR": {"name": "Norway", "2020": 72.0, "2021": 71.0, "2022": 75.0},
+		"SWE": {"name": "Sweden", "2020": 50.0},
+		"DNK": {"name": "Denmark", "2021": 30.0, "2020": 29.0},
+	}
+
+	assert.Equal(t, []structs.DatasetChange{
+		{IsoCode: "DNK", Country: "Denmark", YearsAdded: []int{2020, 2021}},
+		{IsoCode: "NOR", Country: "Norway", YearsAdded: []int{2022}, YearsChanged: []int{2020}},
+	}, diffDatasets(oldData, newData), "Wrong changes of import")
+
+	assert.Empty(t, diffDatasets(newData, newData), "Import without changes should have no changes")
+}
+
+/*
+Tests that webhooks only get the countries and years they apply to
+*/
+func TestFilterDatasetChanges(t *testing.T) {
+	changes := []structs.DatasetChange{
+		{IsoCode: "DNK", Country: "Denmark", YearsAdded: []int{2020, 2021}},
+		{IsoCode: "NOR", Country: "Norway", YearsAdded: []int{2022}, YearsChanged: []int{2020}},
+	}
+
+	all := map[string]interface{}{"country": "ANY", "year": int64(-1)}
+	assert.Equal(t, changes, filterDatasetChanges(all, changes, testRegionCodes), "Webhook of any country and year should get all changes")
+
+	norway := map[string]interface{}{"country": "NOR", "year": int64(-1), "year_from": int64(2021)}
+	assert.Equal(t, []structs.DatasetChange{
+		{IsoCode: "NOR", Country: "Norway", YearsAdded: []int{2022}},
+	}, filterDatasetChanges(norway, changes, testRegionCodes), "Webhook should only get its country and years")
+
+	sweden := map[string]interface{}{"country": "SWE", "year": int64(-1)}
+	assert.Empty(t, filterDatasetChanges(sweden, changes, testRegionCodes), "Webhook of unchanged country should get no changes")
+
+	region := map[string]interface{}{"country": "ANY", "region": "Northern Europe", "year": int64(2021)}
+	assert.Equal(t, []structs.DatasetChange{
+		{IsoCode: "DNK", Country: "Denmark", YearsAdded: []int{2021}},
+	}, filterDatasetChanges(region, changes, testRegionCodes), "Webhook should get changed years of its region")
+}
+
+/*
+Tests that events are stored in the types firestore returns them as
+*/
+func TestCreateDatasetEvent(t *testing.T) {
+	event := createDatasetEvent([]structs.DatasetChange{{IsoCode: "NOR", Country: "Norway", YearsAdded: []int{2022}}})
+
+	assert.Equal(t, map[string]interface{}{
+		"type": constants.WEBHOOK_TRIGGER_DATASET,
+		"changes": []interface{}{map[string]interface{}{
+			"country":       "NOR",
+			"name":          "Norway",
+			"years_added":   []interface{}{int64(2022)},
+			"years_changed": []interface{}{},
+		}},
+	}, event, "Wrong event")
+}
@@ -2,10 +2,9 @@ package db
 
 import (
 	"assignment2/utils/constants"
-	"assignment2/utils/div"
-	"assignment2/utils/gateway"
 	"assignment2/utils/structs"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -128,6 +127,82 @@ func AppendDocumentToFirestore(id string, doc map[string]interface{}, collection
 	return nil
 }
 
+/*
+Updates fields of a single document in firestore, leaving other fields untouched
+
+	id				- Id of document to update
+	fields			- Map of fields to set
+	collectionName	- Name of collection the document is in
+*/
+func UpdateDocumentInFirestore(id string, fields map[string]interface{}, collectionName string) error {
+	_, err := firebaseClient.Collection(collectionName).Doc(id).Set(firestoreContext, fields, firestore.MergeAll)
+	if err != nil {
+		return structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not update document in firestore database.")
+	}
+	return nil
+}
+
+/*
+Updates a document in a transaction, so the update is based on the data stored when it is written.
+The document has a version which is incremented on each update, for use as an ETag.
+
+	id				- Id of document to update
+	collectionName	- Name of collection the document is in
+	ifVersion		- Version the document must have, or -1 for any version
+	update			- Function creating the fields to set from the current data of the document
+
+	return	- The document data after the update, with the new version
+*/
+func UpdateDocumentInTransaction(id string, collectionName string, ifVersion int64, update func(current map[string]interface{}) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	ref := firebaseClient.Collection(collectionName).Doc(id)
+	var updated map[string]interface{}
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		current := snapshot.Data()
+
+		// Documents which have never been updated have version 0
+		version, _ := current["version"].(int64)
+		if ifVersion >= 0 && version != ifVersion {
+			return structs.NewCodedError(nil, http.StatusPreconditionFailed, constants.ERR_PRECONDITION_FAILED, "If-Match", "The resource has been changed since it was read. Get it again and retry the update.", "")
+		}
+
+		fields, err := update(current)
+		if err != nil {
+			return err
+		}
+		fields["version"] = version + 1
+
+		// Keep the data after the update, as the transaction has no result of its own
+		updated = make(map[string]interface{})
+		for key, value := range current {
+			updated[key] = value
+		}
+		for key, value := range fields {
+			updated[key] = value
+		}
+
+		return tx.Set(ref, fields, firestore.MergeAll)
+	})
+
+	// Errors from the update function are passed on as they are
+	var wrappedErr structs.WrappedError
+	if errors.As(err, &wrappedErr) {
+		return nil, err
+	}
+	if status.Code(err) == codes.NotFound {
+		return nil, structs.NewError(err, http.StatusNotFound, "Could not find document "+id, "")
+	}
+	if err != nil {
+		return nil, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not update document "+id+" in firestore database.")
+	}
+
+	return updated, nil
+}
+
 /*
 Get a document from firestore
 
@@ -152,6 +227,44 @@ func GetDocumentFromFirestore(id string, collectionName string) (map[string]inte
 	return docSnapshot.Data(), nil
 }
 
+/*
+Get multiple documents from firestore in one request
+
+	ids				- document IDs to get
+	collectionName	- Name of collection to get documents from
+
+	return	- Map containing key (document id) and elements containing maps with data from each document. Documents which do not exist are left out.
+*/
+func GetDocumentsFromFirestore(ids []string, collectionName string) (map[string]map[string]interface{}, error) {
+	data := make(map[string]map[string]interface{})
+
+	// Create a reference to each document
+	var refs []*firestore.DocumentRef
+	for _, id := range ids {
+		refs = append(refs, firebaseClient.Collection(collectionName).Doc(id))
+	}
+
+	// Get all documents in one request
+	docSnapshots, err := firebaseClient.GetAll(firestoreContext, refs)
+	if err != nil {
+
+		if !checkDbState() {
+			ReportDbState(false)
+		}
+
+		return nil, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not reach firestone database. Error getting multiple documents from collection "+collectionName)
+	}
+
+	// Save each document which exists with documentID as the key
+	for _, docSnapshot := range docSnapshots {
+		if docSnapshot.Exists() {
+			data[docSnapshot.Ref.ID] = docSnapshot.Data()
+		}
+	}
+
+	return data, nil
+}
+
 /*
 Gets all documents from a collection in firestore
 
@@ -188,6 +301,36 @@ func GetAllDocumentInCollectionFromFirestore(collectionName string) (map[string]
 	return data, nil
 }
 
+/*
+Gets the documents in a collection in firestore where a field has a value
+
+	collectionName	- Name of collection to get documents from
+	field			- Name of the field
+	value			- Value the field must have
+
+	return 			- Map containing key (document id) and elements containing maps with data from each document
+*/
+func GetDocumentsWhereFromFirestore(collectionName string, field string, value interface{}) (map[string]map[string]interface{}, error) {
+	data := make(map[string]map[string]interface{})
+
+	docs, err := firebaseClient.Collection(collectionName).Where(field, "==", value).Documents(firestoreContext).GetAll()
+	if err != nil {
+
+		if !checkDbState() {
+			ReportDbState(false)
+		}
+
+		return nil, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Failed to query documents in collection "+collectionName+" on firebase")
+	}
+
+	// Save each document with documentID as the key
+	for _, doc := range docs {
+		data[doc.Ref.ID] = doc.Data()
+	}
+
+	return data, nil
+}
+
 /*
 Delete a document given ID if it exists
 
@@ -229,51 +372,44 @@ func DeleteDocument(documentID string, collectionName string) error {
 }
 
 /*
-Go through all webhooks and check if they are to be invoked
+Go through the webhooks of the countries invoked and check if they are to be invoked
 
 	isoCode	- Isocode of countries to be invoked, empty if all countries
 */
 func InvokeCountry(isoCode []string, begin int, end int) {
-	// Get reference to documents in collection
-	webhooksCollection := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION)
-	iter := webhooksCollection.Documents(firestoreContext)
+	// Only the webhooks fired by requests of one of the countries, or of any country, are matched
+	webhooks, err := matchCallWebhooks(isoCode)
+	if err != nil {
+		log.Println("Could not get webhooks to invoke: " + err.Error())
+		return
+	}
 
-	// Go through all webhooks
-	for {
-		// Try to get next document in collection
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return
+	now := time.Now()
+	for _, webhook := range webhooks {
+		// Webhooks whose url has not been verified, or which are disabled or expired, are not fired
+		if !webhookIsActive(webhook.data, now) {
+			continue
 		}
 
-		// Get the webhook data
-		webhook := doc.Data()
-
-		// only want webhook if webhook country is one of the invoked countries, or we invoked all countries, or the webhook is invoked for all countries
-		if len(isoCode) != 0 && webhook["country"].(string) != "ANY" && !div.Contains(isoCode, webhook["country"].(string)) {
+		// if years are specified, we only want to invoke if they overlap the years between begin and end year
+		if !webhookAppliesToYears(webhook.data, begin, end) {
 			continue
 		}
 
-		// if year is specified, we only want to invoke if the year is between begin and end year
-		if webhook["year"].(int64) != -1 && (int(webhook["year"].(int64)) < begin || int(webhook["year"].(int64)) > end) {
+		// Count the invocation, and store the delivery if the webhook fires, in one transaction
+		deliveryID, err := invokeWebhook(firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Doc(webhook.id), isoCode, begin, end)
+		if status.Code(err) == codes.NotFound {
 			continue
 		}
-
-		// Increase invocation count by one
-		webhook["invocations"] = webhook["invocations"].(int64) + 1
-
-		// Check if we have met the required invokation amount
-		if webhook["invocations"].(int64)%webhook["calls"].(int64) == 0 {
-			// Send post to webhook
-			go gateway.PostToWebhook(webhook, doc.Ref.ID, constants.COUNTRIES_API_URL)
+		if err != nil {
+			log.Println("Could not invoke webhook " + webhook.id + ": " + err.Error())
+			continue
 		}
 
-		// Update webhook with new invocations
-		webhooksCollection.Doc(doc.Ref.ID).Set(firestoreContext, webhook)
-
+		// Only the replica whose transaction fired the webhook attempts the delivery right away
+		if deliveryID != "" {
+			dispatchDelivery(deliveryID, webhook.data["url"].(string))
+		}
 	}
 }
 
@@ -0,0 +1,468 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"assignment2/utils/gateway"
+	"assignment2/utils/structs"
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Amount of attempts before a delivery is moved to dead-letters. Set from $WEBHOOK_MAX_ATTEMPTS in main.
+var MaxDeliveryAttempts = constants.DEFAULT_WEBHOOK_MAX_ATTEMPTS
+
+/*
+Stores a delivery to a webhook as a job, and makes the first attempt right away.
+The job stays in the database until it is delivered or moved to dead-letters, so it is not lost if the attempt fails or the server stops.
+
+	webhookID	- ID of webhook to deliver to
+	webhook		- Map of webhook data, with the invocations the delivery is for
+	event		- Event which fired the webhook, or nil for webhooks fired by calls
+
+	return	- ID of the delivery, or error if it could not be stored
+*/
+func EnqueueDelivery(webhookID string, webhook map[string]interface{}, event map[string]interface{}) (string, error) {
+	deliveryID := div.CreateRequestId()
+
+	_, err := firebaseClient.Collection(constants.DELIVERIES_COLLECTION).Doc(deliveryID).Set(firestoreContext, createDeliveryJob(webhookID, webhook, event, time.Now()))
+	if err != nil {
+		return "", structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not store webhook delivery in firestore database.")
+	}
+
+	dispatchDelivery(deliveryID, webhook["url"].(string))
+
+	return deliveryID, nil
+}
+
+/*
+Counts an invocation of a webhook, and stores a delivery if the invocations reach a multiple of its calls,
+or for rate webhooks if the invocations within its window exceed its calls. Digest webhooks count the countries and years
+invoked for their next digest.
+Both are done in one transaction, which firestore retries if another replica counts an invocation at the same time,
+so no invocation is lost and each multiple fires exactly once.
+
+	ref			- Reference to the webhook invoked
+	isoCodes	- ISO codes of the countries invoked, or empty if all countries were invoked
+	begin		- First year invoked
+	end			- Last year invoked
+
+	return	- ID of the delivery, or empty if the webhook did not fire
+*/
+func invokeWebhook(ref *firestore.DocumentRef, isoCodes []string, begin int, end int) (string, error) {
+	var deliveryID string
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		deliveryID = ""
+		now := time.Now()
+
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		webhook := snapshot.Data()
+
+		invocations := webhook["invocations"].(int64) + 1
+		webhook["invocations"] = invocations
+
+		// Only update the counts, leaving other fields such as a rotated secret untouched
+		updates := []firestore.Update{{Path: "invocations", Value: invocations}}
+		var event map[string]interface{}
+
+		trigger, _ := webhook["trigger"].(string)
+		if trigger == constants.WEBHOOK_TRIGGER_DIGEST {
+			counts := countDigestInvocation(webhook, isoCodes, begin, end, getRegionIsoCodes)
+			updates = append(updates, firestore.Update{Path: "digest_counts", Value: counts})
+		} else if trigger == constants.WEBHOOK_TRIGGER_RATE {
+			count := countRate(webhook, now)
+			updates = append(updates, firestore.Update{Path: "rate_buckets", Value: count.buckets})
+			if count.fires {
+				deliveryID = rateDeliveryId(ref.ID, invocations)
+				event = createRateEvent(webhook, count.calls)
+				updates = append(updates, firestore.Update{Path: "rate_fired_at", Value: now})
+			}
+		} else if calls, _ := webhook["calls"].(int64); webhookFires(invocations, calls) {
+			// The ID is given by the invocation, so a delivery can never be stored twice for the same multiple
+			deliveryID = invocationDeliveryId(ref.ID, invocations)
+		}
+
+		if deliveryID != "" {
+			job := createDeliveryJob(ref.ID, webhook, event, now)
+			if err := tx.Create(firebaseClient.Collection(constants.DELIVERIES_COLLECTION).Doc(deliveryID), job); err != nil {
+				return err
+			}
+		}
+
+		return tx.Update(ref, updates)
+	}, firestore.MaxAttempts(constants.WEBHOOK_INVOCATION_MAX_ATTEMPTS))
+	if err != nil {
+		return "", err
+	}
+
+	return deliveryID, nil
+}
+
+/*
+Checks if a webhook fires at an amount of invocations
+
+	invocations	- Amount of invocations, including the one being counted
+	calls		- Amount of invocations between each time the webhook fires
+*/
+func webhookFires(invocations int64, calls int64) bool {
+	return calls > 0 && invocations%calls == 0
+}
+
+/*
+Creates the ID of the delivery fired by an amount of invocations of a webhook
+*/
+func invocationDeliveryId(webhookID string, invocations int64) string {
+	return webhookID + "-" + strconv.FormatInt(invocations, 10)
+}
+
+/*
+Creates a delivery job, with only the fields needed for the payload and the channel and url it is sent to.
+Secrets and credentials are read from the webhook when the delivery is attempted, so they are not copied to jobs and dead-letters.
+The channel and url are also read from the webhook then, and are only kept in the job to spread out deliveries by host,
+and to show where a dead-letter was sent.
+
+	webhookID	- ID of webhook to deliver to
+	webhook		- Map of webhook data, with the invocations the delivery is for
+	event		- Event which fired the webhook, or nil for webhooks fired by calls
+	now			- Time the delivery is created, and first due
+
+	return	- Map of the job, as stored in firestore
+*/
+func createDeliveryJob(webhookID string, webhook map[string]interface{}, event map[string]interface{}, now time.Time) map[string]interface{} {
+	job := map[string]interface{}{
+		"webhook_id":      webhookID,
+		"url":             webhook["url"],
+		"channel":         webhook["channel"],
+		"country":         webhook["country"],
+		"year":            webhook["year"],
+		"countries":       webhook["countries"],
+		"region":          webhook["region"],
+		"year_from":       webhook["year_from"],
+		"year_to":         webhook["year_to"],
+		"invocations":     webhook["invocations"],
+		"attempts":        0,
+		"last_status":     0,
+		"last_error":      "",
+		"created_at":      now,
+		"next_attempt_at": now,
+		"lease_until":     time.Time{},
+	}
+	if event != nil {
+		job["event"] = event
+	}
+
+	return job
+}
+
+/*
+Attempts a delivery if no other server is attempting it, and records the result.
+Delivered jobs are deleted, failed jobs are scheduled for a retry or moved to dead-letters.
+
+	deliveryID	- ID of the delivery to attempt
+*/
+func AttemptDelivery(deliveryID string) {
+	ref := firebaseClient.Collection(constants.DELIVERIES_COLLECTION).Doc(deliveryID)
+
+	job, claimed, err := claimDelivery(ref, time.Now())
+	if err != nil {
+		log.Println("Could not claim webhook delivery " + deliveryID + ": " + err.Error())
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	webhookID := job["webhook_id"].(string)
+
+	// Drop the delivery if the webhook has been deleted, as there is no longer anyone to deliver to
+	webhook, err := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Doc(webhookID).Get(firestoreContext)
+	if status.Code(err) == codes.NotFound {
+		ref.Delete(firestoreContext)
+		return
+	}
+	if err != nil {
+		releaseDelivery(ref)
+		return
+	}
+
+	// Deliveries to webhooks which were disabled or expired since they were stored are not attempted,
+	// and are kept as dead-letters so they can be replayed once the webhook is enabled
+	if state, stopped := webhookStopped(webhook.Data(), time.Now()); stopped {
+		job["last_error"] = "webhook is " + state
+		moveToDeadLetters(ref, job, time.Now())
+		return
+	}
+
+	// Deliveries to webhooks whose url has not been verified since it was changed wait until it is, without counting an attempt
+	if status, _ := webhook.Data()["status"].(string); status == constants.WEBHOOK_STATUS_PENDING {
+		postponeDelivery(ref, time.Now())
+		return
+	}
+
+	// Send to the url and channel the webhook has now, so changes apply to retries, sign with the secrets and send the credentials
+	// it has now, so rotations apply to retries, and render in the format and with the data it has now
+	data := make(map[string]interface{})
+	for key, value := range job {
+		data[key] = value
+	}
+	for _, key := range []string{"url", "channel", "secret", "previous_secret", "previous_secret_expires", "format", "template", "headers", "auth_type", "auth_credentials", "include_data"} {
+		if value, ok := webhook.Data()[key]; ok {
+			data[key] = value
+		}
+	}
+
+	// Dead-letters show where the delivery was last sent
+	job["url"], job["channel"] = data["url"], data["channel"]
+
+	attempt, err := gateway.DeliverToWebhook(data, webhookID, deliveryID, constants.COUNTRIES_API_URL)
+
+	// Keep a record of every attempt, so failed deliveries can be looked into
+	attempt.Attempt = int(job["attempts"].(int64)) + 1
+	recordDeliveryAttempt(webhookID, attempt)
+
+	if err != nil {
+		// Only deliveries which failed every attempt count towards disabling the webhook, not each retry
+		if recordFailedAttempt(ref, job, attempt.StatusCode, err, time.Now()) {
+			recordWebhookFailure(webhookID, time.Now())
+		}
+		return
+	}
+	recordWebhookSuccess(webhookID, webhook.Data())
+
+	// Delivered, so the job is no longer needed
+	_, err = ref.Delete(firestoreContext)
+	if err != nil {
+		log.Println("Could not delete delivered webhook delivery " + deliveryID + ": " + err.Error())
+	}
+}
+
+/*
+Claims a delivery which is due, so that only one server attempts it at a time
+
+	ref	- Reference to the delivery
+	now	- Current time
+
+	return	- Data of the delivery, and if it was claimed
+*/
+func claimDelivery(ref *firestore.DocumentRef, now time.Time) (map[string]interface{}, bool, error) {
+	var job map[string]interface{}
+	claimed := false
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+
+		snapshot, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		job = snapshot.Data()
+
+		// Skip deliveries which are not due, or which another server is attempting
+		if job["next_attempt_at"].(time.Time).After(now) || job["lease_until"].(time.Time).After(now) {
+			return nil
+		}
+
+		claimed = true
+		return tx.Set(ref, map[string]interface{}{"lease_until": now.Add(constants.WEBHOOK_DELIVERY_LEASE)}, firestore.MergeAll)
+	})
+
+	return job, claimed, err
+}
+
+/*
+Releases the claim on a delivery without counting an attempt, so it is retried at the next check
+
+	ref	- Reference to the delivery
+*/
+func releaseDelivery(ref *firestore.DocumentRef) {
+	ref.Set(firestoreContext, map[string]interface{}{"lease_until": time.Time{}}, firestore.MergeAll)
+}
+
+/*
+Releases the claim on a delivery without counting an attempt, and checks it again after a delay,
+so deliveries waiting for a webhook to be verified do not take up every check
+
+	ref	- Reference to the delivery
+	now	- Current time
+*/
+func postponeDelivery(ref *firestore.DocumentRef, now time.Time) {
+	_, err := ref.Set(firestoreContext, map[string]interface{}{
+		"next_attempt_at": now.Add(constants.WEBHOOK_PENDING_DELIVERY_DELAY),
+		"lease_until":     time.Time{},
+	}, firestore.MergeAll)
+	if err != nil {
+		log.Println("Could not postpone webhook delivery " + ref.ID + ": " + err.Error())
+	}
+}
+
+/*
+Records a failed attempt, and schedules a retry or moves the delivery to dead-letters
+
+	ref			- Reference to the delivery
+	job			- Data of the delivery
+	statusCode	- Status code from the webhook url, or 0 if it did not respond
+	attemptErr	- Error from the attempt
+	now			- Time of the attempt
+
+	return	- If the delivery was moved to dead-letters, as it had no attempts left
+*/
+func recordFailedAttempt(ref *firestore.DocumentRef, job map[string]interface{}, statusCode int, attemptErr error, now time.Time) bool {
+	attempts := int(job["attempts"].(int64)) + 1
+
+	job["attempts"] = attempts
+	job["last_status"] = statusCode
+	job["last_error"] = attemptErr.Error()
+
+	if attempts >= MaxDeliveryAttempts {
+		moveToDeadLetters(ref, job, now)
+		return true
+	}
+
+	_, err := ref.Set(firestoreContext, map[string]interface{}{
+		"attempts":        attempts,
+		"last_status":     statusCode,
+		"last_error":      attemptErr.Error(),
+		"next_attempt_at": now.Add(retryDelay(attempts, rand.Float64())),
+		"lease_until":     time.Time{},
+	}, firestore.MergeAll)
+	if err != nil {
+		log.Println("Could not schedule retry of webhook delivery " + ref.ID + ": " + err.Error())
+	}
+
+	return false
+}
+
+/*
+Moves a delivery to dead-letters in one batch, so the delivery is never in both or neither collection
+
+	ref	- Reference to the delivery
+	job	- Data of the delivery, with the result of the last attempt
+	now	- Time the delivery failed
+*/
+func moveToDeadLetters(ref *firestore.DocumentRef, job map[string]interface{}, now time.Time) {
+	job["failed_at"] = now
+	delete(job, "next_attempt_at")
+	delete(job, "lease_until")
+
+	batch := firebaseClient.Batch()
+	batch.Set(firebaseClient.Collection(constants.DEADLETTERS_COLLECTION).Doc(ref.ID), job)
+	batch.Delete(ref)
+	_, err := batch.Commit(firestoreContext)
+	if err != nil {
+		log.Println("Could not move webhook delivery " + ref.ID + " to dead-letters: " + err.Error())
+	}
+}
+
+/*
+Calculates the delay before the next attempt, using exponential backoff with jitter.
+The jitter spreads out retries to a webhook url which failed for many deliveries at once.
+
+	attempts	- Amount of attempts made so far
+	random		- Random number in [0, 1)
+
+	return	- Delay between half and all of the exponential delay, capped at the max delay
+*/
+func retryDelay(attempts int, random float64) time.Duration {
+	delay := float64(constants.WEBHOOK_RETRY_BASE_DELAY) * math.Pow(2, float64(attempts-1))
+	delay = math.Min(delay, float64(constants.WEBHOOK_RETRY_MAX_DELAY))
+
+	return time.Duration(delay/2 + random*delay/2)
+}
+
+/*
+Attempts all deliveries which are due for a retry
+*/
+func ProcessDueDeliveries() {
+	iter := firebaseClient.Collection(constants.DELIVERIES_COLLECTION).
+		Where("next_attempt_at", "<=", time.Now()).
+		Limit(constants.WEBHOOK_DELIVERY_BATCH_SIZE).
+		Documents(firestoreContext)
+
+	docs, err := iter.GetAll()
+	if err != nil {
+		log.Println("Could not get webhook deliveries to retry: " + err.Error())
+		return
+	}
+
+	// Deliveries still waiting from the last check are not attempted twice, as only one attempt can claim a delivery
+	for _, doc := range docs {
+		webhookURL, _ := doc.Data()["url"].(string)
+		dispatchDelivery(doc.Ref.ID, webhookURL)
+	}
+}
+
+/*
+Checks for deliveries to retry, and invocations which could not be dispatched, at a fixed interval, for as long as the service runs
+*/
+func StartDeliveryWorker() {
+	ticker := time.NewTicker(constants.WEBHOOK_DELIVERY_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Wait for the database to come back before retrying
+		if !DbState {
+			continue
+		}
+		ProcessDueDeliveries()
+		ProcessPendingInvocations()
+	}
+}
+
+/*
+Moves a dead-letter back to the deliveries, and attempts it again with a new count of attempts
+
+	deadLetterID	- ID of the dead-letter, which is also the ID of the delivery
+
+	return	- Error if the dead-letter does not exist or could not be moved
+*/
+func ReplayDeadLetter(deadLetterID string) error {
+	deadLetterRef := firebaseClient.Collection(constants.DEADLETTERS_COLLECTION).Doc(deadLetterID)
+	deliveryRef := firebaseClient.Collection(constants.DELIVERIES_COLLECTION).Doc(deadLetterID)
+	var webhookURL string
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(deadLetterRef)
+		if err != nil {
+			return err
+		}
+
+		// Keep the delivery ID, so receivers can ignore it if an earlier attempt did arrive
+		job := snapshot.Data()
+		webhookURL, _ = job["url"].(string)
+		job["attempts"] = 0
+		job["next_attempt_at"] = time.Now()
+		job["lease_until"] = time.Time{}
+		delete(job, "failed_at")
+
+		if err := tx.Set(deliveryRef, job); err != nil {
+			return err
+		}
+		return tx.Delete(deadLetterRef)
+	})
+	if status.Code(err) == codes.NotFound {
+		return structs.NewCodedError(err, http.StatusNotFound, constants.ERR_DEADLETTER_NOT_FOUND, "id", "Invalid dead-letter ID given", "Dead-letter ID given was not found in database")
+	}
+	if err != nil {
+		return structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not replay dead-letter "+deadLetterID+".")
+	}
+
+	dispatchDelivery(deadLetterID, webhookURL)
+
+	return nil
+}
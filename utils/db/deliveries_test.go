@@ -0,0 +1,38 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that the delay between attempts grows exponentially, with jitter, up to the max delay
+*/
+func TestRetryDelay(t *testing.T) {
+	// Delay is between half and all of the exponential delay
+	assert.Equal(t, constants.WEBHOOK_RETRY_BASE_DELAY/2, retryDelay(1, 0), "Wrong min delay for first retry")
+	assert.Equal(t, constants.WEBHOOK_RETRY_BASE_DELAY, retryDelay(1, 1), "Wrong max delay for first retry")
+	assert.Equal(t, 4*constants.WEBHOOK_RETRY_BASE_DELAY, retryDelay(3, 1), "Delay should double for each attempt")
+
+	// Delay never exceeds the max delay
+	assert.Equal(t, constants.WEBHOOK_RETRY_MAX_DELAY, retryDelay(30, 1), "Delay should be capped")
+
+	// Jitter spreads out the delays
+	delay := retryDelay(2, 0.5)
+	assert.True(t, delay > constants.WEBHOOK_RETRY_BASE_DELAY && delay < 2*constants.WEBHOOK_RETRY_BASE_DELAY, "Delay should be within the jitter range")
+}
+
+/*
+Tests that webhooks fire at each multiple of their calls, and that the delivery ID is given by the invocation
+*/
+func TestWebhookFires(t *testing.T) {
+	assert.False(t, webhookFires(4, 5), "Webhook should not fire before its calls")
+	assert.True(t, webhookFires(5, 5), "Webhook should fire at its calls")
+	assert.True(t, webhookFires(10, 5), "Webhook should fire at each multiple of its calls")
+	assert.False(t, webhookFires(5, 0), "Webhook without calls should never fire")
+
+	assert.Equal(t, "TEST-10", invocationDeliveryId("TEST", 10), "Wrong delivery ID for invocation")
+	assert.NotEqual(t, invocationDeliveryId("TEST", 5), invocationDeliveryId("TEST", 10), "Each multiple should have its own delivery")
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Time delivery attempts are kept. Set from $DELIVERY_HISTORY_RETENTION_DAYS in main.
+var DeliveryHistoryRetention = constants.DEFAULT_DELIVERY_HISTORY_RETENTION_DAYS * 24 * time.Hour
+
+// Max amount of writes in one firestore batch
+const maxBatchSize = 500
+
+/*
+Saves an attempt of a delivery in the history of the webhook
+
+	webhookID	- ID of the webhook delivered to
+	attempt		- The attempt to save
+*/
+func recordDeliveryAttempt(webhookID string, attempt structs.DeliveryAttempt) {
+	attemptData := map[string]interface{}{
+		"delivery_id": attempt.DeliveryId,
+		"attempt":     attempt.Attempt,
+		"timestamp":   attempt.Timestamp,
+		"payload":     string(attempt.Payload),
+		"status_code": attempt.StatusCode,
+		"latency_ms":  attempt.LatencyMs,
+		"error":       attempt.Error,
+	}
+
+	_, err := attemptsCollection(webhookID).NewDoc().Set(firestoreContext, attemptData)
+	if err != nil {
+		log.Println("Could not record attempt of webhook delivery " + attempt.DeliveryId + ": " + err.Error())
+	}
+}
+
+/*
+Get a page of the delivery attempts of a webhook, newest first
+
+	webhookID	- ID of the webhook
+	limit		- Max amount of attempts to get
+	cursor		- ID of the last attempt of the previous page, or empty for the first page
+
+	return	- The attempts, and the cursor of the next page, which is empty if this is the last page
+*/
+func GetDeliveryAttempts(webhookID string, limit int, cursor string) ([]structs.DeliveryAttempt, string, error) {
+	attempts := []structs.DeliveryAttempt{}
+	collection := attemptsCollection(webhookID)
+
+	// Get one more than the limit, to know if there is a next page
+	query := collection.OrderBy("timestamp", firestore.Desc).Limit(limit + 1)
+
+	// Continue after the last attempt of the previous page
+	if cursor != "" {
+		cursorSnapshot, err := collection.Doc(cursor).Get(firestoreContext)
+		if status.Code(err) == codes.NotFound {
+			return attempts, "", structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "cursor", "Malformed URL, invalid cursor parameter set", "")
+		}
+		if err != nil {
+			return attempts, "", structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not get cursor of delivery attempts from firestore database.")
+		}
+		query = query.StartAfter(cursorSnapshot)
+	}
+
+	docs, err := query.Documents(firestoreContext).GetAll()
+	if err != nil {
+		if !checkDbState() {
+			ReportDbState(false)
+		}
+		return attempts, "", structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not get delivery attempts of webhook "+webhookID+" from firestore database.")
+	}
+
+	var nextCursor string
+	if len(docs) > limit {
+		docs = docs[:limit]
+		nextCursor = docs[limit-1].Ref.ID
+	}
+
+	for _, doc := range docs {
+		attempts = append(attempts, structs.CreateDeliveryAttemptFromData(doc.Data(), doc.Ref.ID))
+	}
+
+	return attempts, nextCursor, nil
+}
+
+/*
+Deletes all delivery attempts of a webhook, such as when the webhook is deleted
+
+	webhookID	- ID of the webhook
+*/
+func DeleteDeliveryAttempts(webhookID string) error {
+	_, err := deleteQueryResults(attemptsCollection(webhookID).Query)
+	return err
+}
+
+/*
+Deletes delivery attempts of all webhooks which are older than the retention
+
+	now	- Current time
+
+	return	- Amount of attempts deleted
+*/
+func DeleteExpiredDeliveryAttempts(now time.Time) (int, error) {
+	cutoff := now.Add(-DeliveryHistoryRetention)
+
+	// Attempts are kept in a subcollection of their webhook, so query the subcollections of all webhooks at once
+	return deleteQueryResults(firebaseClient.CollectionGroup(constants.ATTEMPTS_COLLECTION).Where("timestamp", "<", cutoff))
+}
+
+/*
+Removes expired delivery attempts at a fixed interval, for as long as the service runs
+*/
+func StartDeliveryHistoryCleanup() {
+	ticker := time.NewTicker(constants.DELIVERY_HISTORY_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Wait for the database to come back before cleaning up
+		if !DbState {
+			continue
+		}
+
+		deleted, err := DeleteExpiredDeliveryAttempts(time.Now())
+		if err != nil {
+			log.Println("Could not delete expired delivery attempts: " + err.Error())
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d expired delivery attempts", deleted)
+		}
+	}
+}
+
+/*
+Get the collection of delivery attempts of a webhook
+*/
+func attemptsCollection(webhookID string) *firestore.CollectionRef {
+	return firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Doc(webhookID).Collection(constants.ATTEMPTS_COLLECTION)
+}
+
+/*
+Deletes all documents matched by a query, in batches
+
+	query	- Query to delete results of
+
+	return	- Amount of documents deleted
+*/
+func deleteQueryResults(query firestore.Query) (int, error) {
+	deleted := 0
+	iter := query.Documents(firestoreContext)
+	defer iter.Stop()
+
+	batch := firebaseClient.Batch()
+	batchSize := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return deleted, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not iterate through documents to delete.")
+		}
+
+		batch.Delete(doc.Ref)
+		batchSize++
+
+		// Commit full batches, and start a new one
+		if batchSize == maxBatchSize {
+			if _, err := batch.Commit(firestoreContext); err != nil {
+				return deleted, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not delete documents from firestore database.")
+			}
+			deleted += batchSize
+			batch = firebaseClient.Batch()
+			batchSize = 0
+		}
+	}
+
+	if batchSize > 0 {
+		if _, err := batch.Commit(firestoreContext); err != nil {
+			return deleted, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Could not delete documents from firestore database.")
+		}
+		deleted += batchSize
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,335 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+Get the time the next digest of a schedule is due. Digests are due at the start of each hour, day or week in UTC,
+so every replica finds the same time.
+
+	schedule	- Hourly, daily or weekly
+	now			- Current time
+
+	return	- Start of the next hour, day or week after now
+*/
+func NextDigestTime(schedule string, now time.Time) time.Time {
+	now = now.UTC()
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch schedule {
+	case constants.WEBHOOK_SCHEDULE_DAILY:
+		return day.AddDate(0, 0, 1)
+	case constants.WEBHOOK_SCHEDULE_WEEKLY:
+		// Weeks start on Monday
+		daysUntilMonday := (8 - int(day.Weekday())) % 7
+		if daysUntilMonday == 0 {
+			daysUntilMonday = 7
+		}
+		return day.AddDate(0, 0, daysUntilMonday)
+	default:
+		return hour.Add(time.Hour)
+	}
+}
+
+/*
+Counts an invocation of a digest webhook, by each country invoked which the webhook applies to and the range of years
+
+	webhook		- Map of webhook data, as stored in firestore
+	isoCodes	- ISO codes of the countries invoked, or empty if all countries were invoked
+	begin		- First year invoked
+	end			- Last year invoked
+	regionCodes	- Function getting the ISO codes of the countries in a region
+
+	return	- The counts of the webhook with the invocation, as stored in firestore
+*/
+func countDigestInvocation(webhook map[string]interface{}, isoCodes []string, begin int, end int, regionCodes func(region string) ([]string, error)) map[string]interface{} {
+	counts := map[string]interface{}{}
+	if stored, ok := webhook["digest_counts"].(map[string]interface{}); ok {
+		for key, value := range stored {
+			counts[key] = value
+		}
+	}
+
+	countries := []string{constants.DIGEST_ALL_COUNTRIES}
+	if len(isoCodes) > 0 {
+		countries = nil
+		for _, isoCode := range isoCodes {
+			if webhookAppliesToCountries(webhook, []string{isoCode}, regionCodes) {
+				countries = append(countries, isoCode)
+			}
+		}
+	}
+
+	for _, isoCode := range countries {
+		key := digestKey(isoCode, begin, end)
+		calls, _ := counts[key].(int64)
+		counts[key] = calls + 1
+	}
+
+	return counts
+}
+
+/*
+Creates the key of the invocations of a country and range of years in the counts of a digest webhook
+*/
+func digestKey(isoCode string, begin int, end int) string {
+	return isoCode + ":" + strconv.Itoa(begin) + "-" + strconv.Itoa(end)
+}
+
+/*
+Get the country and range of years of a key in the counts of a digest webhook
+
+	return	- ISO code, first and last year, and false if the key could not be read
+*/
+func parseDigestKey(key string) (string, int, int, bool) {
+	isoCode, years, found := strings.Cut(key, ":")
+	if !found {
+		return "", 0, 0, false
+	}
+	beginText, endText, found := strings.Cut(years, "-")
+	begin, beginErr := strconv.Atoi(beginText)
+	end, endErr := strconv.Atoi(endText)
+	if !found || beginErr != nil || endErr != nil {
+		return "", 0, 0, false
+	}
+
+	return isoCode, begin, end, true
+}
+
+/*
+Checks if a digest webhook has nothing to report since its last digest
+*/
+func digestEmpty(webhook map[string]interface{}) bool {
+	counts, _ := webhook["digest_counts"].(map[string]interface{})
+	changes, _ := webhook["digest_changes"].([]interface{})
+	return len(counts) == 0 && len(changes) == 0
+}
+
+/*
+Creates the event of a digest, as stored with the delivery
+
+	webhook	- Map of webhook data, as stored in firestore, with the counts and changes since the last digest
+	now		- Time the digest is sent at
+
+	return	- Event with the invocations by country and range of years and by most requested country, and the dataset changes
+*/
+func createDigestEvent(webhook map[string]interface{}, now time.Time) map[string]interface{} {
+	type invocation struct {
+		isoCode    string
+		begin, end int
+		calls      int64
+	}
+
+	var invocations []invocation
+	countryCalls := map[string]int64{}
+	counts, _ := webhook["digest_counts"].(map[string]interface{})
+	for key, value := range counts {
+		isoCode, begin, end, ok := parseDigestKey(key)
+		calls, isCount := value.(int64)
+		if !ok || !isCount {
+			continue
+		}
+		invocations = append(invocations, invocation{isoCode, begin, end, calls})
+		countryCalls[isoCode] += calls
+	}
+
+	// Most requested first, and by country and years when requested as often
+	sort.Slice(invocations, func(i, j int) bool {
+		a, b := invocations[i], invocations[j]
+		if a.calls != b.calls {
+			return a.calls > b.calls
+		}
+		if a.isoCode != b.isoCode {
+			return a.isoCode < b.isoCode
+		}
+		return a.begin < b.begin || (a.begin == b.begin && a.end < b.end)
+	})
+	eventInvocations := make([]interface{}, 0, len(invocations))
+	for _, inv := range invocations {
+		eventInvocations = append(eventInvocations, map[string]interface{}{
+			"country":   inv.isoCode,
+			"year_from": int64(inv.begin),
+			"year_to":   int64(inv.end),
+			"calls":     inv.calls,
+		})
+	}
+
+	countries := make([]string, 0, len(countryCalls))
+	for isoCode := range countryCalls {
+		countries = append(countries, isoCode)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		if countryCalls[countries[i]] != countryCalls[countries[j]] {
+			return countryCalls[countries[i]] > countryCalls[countries[j]]
+		}
+		return countries[i] < countries[j]
+	})
+	if len(countries) > constants.DIGEST_TOP_COUNTRIES {
+		countries = countries[:constants.DIGEST_TOP_COUNTRIES]
+	}
+	topCountries := make([]interface{}, 0, len(countries))
+	for _, isoCode := range countries {
+		topCountries = append(topCountries, map[string]interface{}{"country": isoCode, "calls": countryCalls[isoCode]})
+	}
+
+	changes, _ := webhook["digest_changes"].([]interface{})
+	if changes == nil {
+		changes = []interface{}{}
+	}
+
+	event := map[string]interface{}{
+		"type":          constants.WEBHOOK_TRIGGER_DIGEST,
+		"schedule":      webhook["schedule"],
+		"to":            now,
+		"invocations":   eventInvocations,
+		"top_countries": topCountries,
+		"changes":       changes,
+	}
+	if since, ok := webhook["digest_since"].(time.Time); ok {
+		event["from"] = since
+	}
+
+	return event
+}
+
+/*
+Creates the ID of the delivery of a digest. The ID is given by the time the digest was due, so each digest is stored once.
+*/
+func digestDeliveryId(webhookID string, due time.Time) string {
+	return webhookID + "-" + constants.WEBHOOK_TRIGGER_DIGEST + "-" + strconv.FormatInt(due.Unix(), 10)
+}
+
+/*
+Sends the digest of a webhook if it is due, and schedules the next one. Done in a transaction,
+so when replicas check for digests at the same time only one of them stores the delivery.
+Digests with nothing to report are not sent, and webhooks which are not active keep their counts until they are.
+
+	ref	- Reference to the digest webhook
+	now	- Current time
+
+	return	- ID of the delivery, or empty if no digest was sent
+*/
+func sendDigest(ref *firestore.DocumentRef, now time.Time) (string, error) {
+	var deliveryID string
+	var webhookURL string
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		deliveryID = ""
+
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		webhook := snapshot.Data()
+
+		// Another replica may have sent the digest since it was found to be due
+		due, ok := webhook["next_digest_at"].(time.Time)
+		if !ok || due.After(now) {
+			return nil
+		}
+
+		schedule, _ := webhook["schedule"].(string)
+		updates := []firestore.Update{{Path: "next_digest_at", Value: NextDigestTime(schedule, now)}}
+
+		if webhookIsActive(webhook, now) && !digestEmpty(webhook) {
+			deliveryID = digestDeliveryId(ref.ID, due)
+			webhookURL, _ = webhook["url"].(string)
+			job := createDeliveryJob(ref.ID, webhook, createDigestEvent(webhook, now), now)
+			if err := tx.Create(firebaseClient.Collection(constants.DELIVERIES_COLLECTION).Doc(deliveryID), job); err != nil {
+				return err
+			}
+
+			updates = append(updates,
+				firestore.Update{Path: "digest_counts", Value: map[string]interface{}{}},
+				firestore.Update{Path: "digest_changes", Value: []interface{}{}},
+				firestore.Update{Path: "digest_since", Value: now},
+			)
+		}
+
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if deliveryID != "" {
+		dispatchDelivery(deliveryID, webhookURL)
+	}
+
+	return deliveryID, nil
+}
+
+/*
+Sends the digests which are due
+
+	now	- Current time
+
+	return	- Amount of digests sent
+*/
+func SendDueDigests(now time.Time) (int, error) {
+	docs, err := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).
+		Where("next_digest_at", "<=", now).
+		Documents(firestoreContext).
+		GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, doc := range docs {
+		deliveryID, err := sendDigest(doc.Ref, now)
+		if err != nil {
+			log.Println("Could not send digest of webhook " + doc.Ref.ID + ": " + err.Error())
+			continue
+		}
+		if deliveryID != "" {
+			sent++
+		}
+	}
+
+	return sent, nil
+}
+
+/*
+Adds the changes of a dataset import to the next digest of a webhook
+
+	ref		- Reference to the digest webhook
+	changes	- Changes of the countries and years the webhook applies to, as stored in events
+*/
+func collectDigestChanges(ref *firestore.DocumentRef, changes []interface{}) error {
+	_, err := ref.Update(firestoreContext, []firestore.Update{{Path: "digest_changes", Value: firestore.ArrayUnion(changes...)}})
+	return err
+}
+
+/*
+Checks for digests which are due at a fixed interval, for as long as the service runs
+*/
+func StartDigestScheduler() {
+	ticker := time.NewTicker(constants.DIGEST_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Wait for the database to come back before checking
+		if !DbState {
+			continue
+		}
+
+		sent, err := SendDueDigests(time.Now())
+		if err != nil {
+			log.Println("Could not send digests: " + err.Error())
+		}
+		if sent > 0 {
+			log.Printf("Sent %d digests", sent)
+		}
+	}
+}
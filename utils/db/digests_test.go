@@ -0,0 +1,108 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that digests are due at the start of the next hour, day or week in UTC
+*/
+func TestNextDigestTime(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 5, 15, 13, 45, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2024, 5, 15, 14, 0, 0, 0, time.UTC), NextDigestTime(constants.WEBHOOK_SCHEDULE_HOURLY, now), "Hourly digest should be due next hour")
+	assert.Equal(t, time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC), NextDigestTime(constants.WEBHOOK_SCHEDULE_DAILY, now), "Daily digest should be due next day")
+	assert.Equal(t, time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC), NextDigestTime(constants.WEBHOOK_SCHEDULE_WEEKLY, now), "Weekly digest should be due next Monday")
+
+	monday := time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 27, 0, 0, 0, 0, time.UTC), NextDigestTime(constants.WEBHOOK_SCHEDULE_WEEKLY, monday), "Weekly digest due now should be due the Monday after")
+
+	oslo := time.FixedZone("CEST", 2*60*60)
+	assert.Equal(t, time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC), NextDigestTime(constants.WEBHOOK_SCHEDULE_DAILY, time.Date(2024, 5, 16, 1, 0, 0, 0, oslo)), "Days should start in UTC")
+}
+
+/*
+Tests that invocations are counted by the countries the webhook applies to and the range of years
+*/
+func TestCountDigestInvocation(t *testing.T) {
+	webhook := map[string]interface{}{
+		"country":   "ANY",
+		"countries": []interface{}{},
+		"region":    "Northern Europe",
+		"digest_counts": map[string]interface{}{
+			"NOR:2020-2020": int64(2),
+		},
+	}
+
+	counts := countDigestInvocation(webhook, []string{"NOR", "DEU", "SWE"}, 2020, 2020, testRegionCodes)
+	assert.Equal(t, map[string]interface{}{
+		"NOR:2020-2020": int64(3),
+		"SWE:2020-2020": int64(1),
+	}, counts, "Only countries of the region should be counted")
+	assert.Equal(t, int64(2), webhook["digest_counts"].(map[string]interface{})["NOR:2020-2020"], "Stored counts should not change")
+
+	counts = countDigestInvocation(webhook, []string{}, 1965, 2021, testRegionCodes)
+	assert.Equal(t, int64(1), counts[constants.DIGEST_ALL_COUNTRIES+":1965-2021"], "Invocations of all countries should be counted once")
+}
+
+/*
+Tests that keys of counts are read back, and that other keys are not
+*/
+func TestParseDigestKey(t *testing.T) {
+	isoCode, begin, end, ok := parseDigestKey(digestKey("NOR", 2010, 2020))
+	assert.True(t, ok, "Key should be read")
+	assert.Equal(t, "NOR", isoCode, "Wrong ISO code")
+	assert.Equal(t, 2010, begin, "Wrong first year")
+	assert.Equal(t, 2020, end, "Wrong last year")
+
+	for _, key := range []string{"NOR", "NOR:2010", "NOR:a-2020", "NOR:2010-b"} {
+		_, _, _, ok = parseDigestKey(key)
+		assert.False(t, ok, "Key should not be read: "+key)
+	}
+}
+
+/*
+Tests that digests have the invocations by most requested, the most requested countries and the dataset changes
+*/
+func TestCreateDigestEvent(t *testing.T) {
+	since := time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)
+	now := since.AddDate(0, 0, 1)
+	changes := []interface{}{map[string]interface{}{"country": "NOR", "name": "Norway", "years_added": []interface{}{int64(2022)}, "years_changed": []interface{}{}}}
+
+	webhook := map[string]interface{}{
+		"schedule":     constants.WEBHOOK_SCHEDULE_DAILY,
+		"digest_since": since,
+		"digest_counts": map[string]interface{}{
+			"NOR:2020-2020": int64(2),
+			"NOR:2021-2021": int64(2),
+			"SWE:2020-2020": int64(3),
+			"not a key":     int64(100),
+		},
+		"digest_changes": changes,
+	}
+
+	assert.False(t, digestEmpty(webhook), "Digest with counts should not be empty")
+	assert.True(t, digestEmpty(map[string]interface{}{"digest_counts": map[string]interface{}{}}), "Digest without counts or changes should be empty")
+
+	assert.Equal(t, map[string]interface{}{
+		"type":     constants.WEBHOOK_TRIGGER_DIGEST,
+		"schedule": constants.WEBHOOK_SCHEDULE_DAILY,
+		"from":     since,
+		"to":       now,
+		"invocations": []interface{}{
+			map[string]interface{}{"country": "SWE", "year_from": int64(2020), "year_to": int64(2020), "calls": int64(3)},
+			map[string]interface{}{"country": "NOR", "year_from": int64(2020), "year_to": int64(2020), "calls": int64(2)},
+			map[string]interface{}{"country": "NOR", "year_from": int64(2021), "year_to": int64(2021), "calls": int64(2)},
+		},
+		"top_countries": []interface{}{
+			map[string]interface{}{"country": "NOR", "calls": int64(4)},
+			map[string]interface{}{"country": "SWE", "calls": int64(3)},
+		},
+		"changes": changes,
+	}, createDigestEvent(webhook, now), "Wrong digest event")
+}
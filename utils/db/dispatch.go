@@ -0,0 +1,170 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/dispatcher"
+	"context"
+	"log"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Dispatcher running invocations and delivery attempts. Set in main, and if not set each runs in its own goroutine.
+var jobDispatcher *dispatcher.Dispatcher
+
+/*
+Sets the dispatcher running invocations and delivery attempts
+
+	d	- The dispatcher
+*/
+func SetDispatcher(d *dispatcher.Dispatcher) {
+	jobDispatcher = d
+}
+
+/*
+Get the metrics of the dispatcher, or nil if there is none
+*/
+func DispatcherStats() *dispatcher.Stats {
+	if jobDispatcher == nil {
+		return nil
+	}
+
+	stats := jobDispatcher.Stats()
+	return &stats
+}
+
+/*
+Counts an invocation of countries for all webhooks, without waiting for it.
+If the dispatcher is full or shut down, the invocation is stored and counted by the delivery worker instead.
+
+	isoCodes	- ISO codes of countries invoked, empty if all countries
+	begin		- First year invoked
+	end			- Last year invoked
+*/
+func DispatchInvocation(isoCodes []string, begin int, end int) {
+	if jobDispatcher == nil {
+		go InvokeCountry(isoCodes, begin, end)
+		return
+	}
+
+	err := jobDispatcher.Submit(dispatcher.Job{
+		Run: func() { InvokeCountry(isoCodes, begin, end) },
+		Persist: func() error {
+			return persistInvocation(isoCodes, begin, end)
+		},
+	})
+	if err != nil {
+		// Invocations are not stored anywhere else, so store them rather than lose them
+		if err := persistInvocation(isoCodes, begin, end); err != nil {
+			log.Println("Could not store invocation: " + err.Error())
+		}
+	}
+}
+
+/*
+Attempts a delivery without waiting for it, limited by the amount of attempts against the same host.
+Deliveries are already stored, so if the dispatcher is full or shut down the delivery worker attempts it later.
+
+	deliveryID	- ID of the delivery
+	webhookURL	- URL of the webhook delivered to
+*/
+func dispatchDelivery(deliveryID string, webhookURL string) {
+	if jobDispatcher == nil {
+		go AttemptDelivery(deliveryID)
+		return
+	}
+
+	var host string
+	if parsed, err := url.Parse(webhookURL); err == nil {
+		host = parsed.Host
+	}
+
+	err := jobDispatcher.Submit(dispatcher.Job{
+		Host: host,
+		Run:  func() { AttemptDelivery(deliveryID) },
+	})
+	if err != nil {
+		log.Println("Webhook delivery " + deliveryID + " is left to the delivery worker: " + err.Error())
+	}
+}
+
+/*
+Stores an invocation which could not be counted, so it is counted by the delivery worker later
+*/
+func persistInvocation(isoCodes []string, begin int, end int) error {
+	// Store an empty list rather than null, for invocations of all countries
+	if isoCodes == nil {
+		isoCodes = []string{}
+	}
+
+	_, _, err := firebaseClient.Collection(constants.PENDING_INVOCATIONS_COLLECTION).Add(firestoreContext, map[string]interface{}{
+		"countries":  isoCodes,
+		"begin":      int64(begin),
+		"end":        int64(end),
+		"created_at": time.Now(),
+	})
+	return err
+}
+
+/*
+Counts the invocations stored when they could not be dispatched
+*/
+func ProcessPendingInvocations() {
+	docs, err := firebaseClient.Collection(constants.PENDING_INVOCATIONS_COLLECTION).
+		Limit(constants.WEBHOOK_DELIVERY_BATCH_SIZE).
+		Documents(firestoreContext).
+		GetAll()
+	if err != nil {
+		log.Println("Could not get stored invocations: " + err.Error())
+		return
+	}
+
+	for _, doc := range docs {
+		invocation, claimed := claimInvocation(doc.Ref)
+		if !claimed {
+			continue
+		}
+
+		var isoCodes []string
+		if countries, ok := invocation["countries"].([]interface{}); ok {
+			for _, country := range countries {
+				isoCodes = append(isoCodes, country.(string))
+			}
+		}
+		DispatchInvocation(isoCodes, int(invocation["begin"].(int64)), int(invocation["end"].(int64)))
+	}
+}
+
+/*
+Deletes a stored invocation in a transaction, so that only one replica counts it
+
+	ref	- Reference to the invocation
+
+	return	- Data of the invocation, and if this replica claimed it
+*/
+func claimInvocation(ref *firestore.DocumentRef) (map[string]interface{}, bool) {
+	var invocation map[string]interface{}
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		invocation = snapshot.Data()
+
+		return tx.Delete(ref)
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Could not claim stored invocation " + ref.ID + ": " + err.Error())
+		return nil, false
+	}
+
+	return invocation, true
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Failed deliveries in a row before a webhook is disabled. Set from $WEBHOOK_DISABLE_AFTER_FAILURES in main.
+var DisableAfterFailures = constants.DEFAULT_WEBHOOK_DISABLE_AFTER_FAILURES
+
+/*
+Checks if a webhook is past its expiry. Webhooks without an expiry never expire.
+
+	webhook	- Map of webhook data, as stored in firestore
+	now		- Current time
+*/
+func webhookExpired(webhook map[string]interface{}, now time.Time) bool {
+	expiresAt, ok := webhook["expires_at"].(time.Time)
+	return ok && !expiresAt.After(now)
+}
+
+/*
+Checks if a webhook is no longer fired because it is disabled or past its expiry
+
+	webhook	- Map of webhook data, as stored in firestore
+	now		- Current time
+
+	return	- WEBHOOK_STATUS_DISABLED or WEBHOOK_STATUS_EXPIRED, and if the webhook is either
+*/
+func webhookStopped(webhook map[string]interface{}, now time.Time) (string, bool) {
+	if status, _ := webhook["status"].(string); status == constants.WEBHOOK_STATUS_DISABLED {
+		return status, true
+	}
+	if webhookExpired(webhook, now) {
+		return constants.WEBHOOK_STATUS_EXPIRED, true
+	}
+
+	return "", false
+}
+
+/*
+Counts a failed delivery of a webhook, which is a delivery moved to dead-letters after every attempt failed,
+and disables the webhook when the deliveries failed in a row reach the limit.
+Counted in a transaction, so failures of deliveries attempted at once by several replicas are all counted.
+
+	webhookID	- ID of the webhook
+	now			- Time of the last attempt
+*/
+func recordWebhookFailure(webhookID string, now time.Time) {
+	ref := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Doc(webhookID)
+
+	err := firebaseClient.RunTransaction(firestoreContext, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		webhook := snapshot.Data()
+
+		// Webhooks which have never failed have no count
+		failures, _ := webhook["consecutive_failures"].(int64)
+		fields := map[string]interface{}{"consecutive_failures": failures + 1}
+
+		if failures+1 >= int64(DisableAfterFailures) && webhookIsActive(webhook, now) {
+			fields["status"] = constants.WEBHOOK_STATUS_DISABLED
+			fields["disabled_at"] = now
+			log.Printf("Disabled webhook %s after %d failed deliveries in a row", webhookID, failures+1)
+		}
+
+		return tx.Set(ref, fields, firestore.MergeAll)
+	})
+	if err != nil {
+		log.Println("Could not count failed delivery of webhook " + webhookID + ": " + err.Error())
+	}
+}
+
+/*
+Resets the count of failed deliveries in a row of a webhook after a delivery succeeded
+
+	webhookID	- ID of the webhook
+	webhook		- Map of webhook data, as stored in firestore
+*/
+func recordWebhookSuccess(webhookID string, webhook map[string]interface{}) {
+	// Skip the write for the usual case of a webhook which has not failed
+	if failures, _ := webhook["consecutive_failures"].(int64); failures == 0 {
+		return
+	}
+
+	_, err := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Doc(webhookID).Set(firestoreContext, map[string]interface{}{"consecutive_failures": 0}, firestore.MergeAll)
+	if err != nil {
+		log.Println("Could not reset failed deliveries of webhook " + webhookID + ": " + err.Error())
+	}
+}
+
+/*
+Sets the status of webhooks past their expiry to expired, so it is shown without checking the expiry
+
+	now	- Current time
+
+	return	- Amount of webhooks which expired
+*/
+func ExpireWebhooks(now time.Time) (int, error) {
+	docs, err := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).
+		Where("expires_at", "<=", now).
+		Documents(firestoreContext).
+		GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, doc := range docs {
+		if status, _ := doc.Data()["status"].(string); status == constants.WEBHOOK_STATUS_EXPIRED {
+			continue
+		}
+		if _, err := doc.Ref.Set(firestoreContext, map[string]interface{}{"status": constants.WEBHOOK_STATUS_EXPIRED}, firestore.MergeAll); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+/*
+Checks for webhooks past their expiry at a fixed interval, for as long as the service runs
+*/
+func StartWebhookExpiry() {
+	ticker := time.NewTicker(constants.WEBHOOK_EXPIRY_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Wait for the database to come back before checking
+		if !DbState {
+			continue
+		}
+
+		expired, err := ExpireWebhooks(time.Now())
+		if err != nil {
+			log.Println("Could not expire webhooks: " + err.Error())
+		}
+		if expired > 0 {
+			log.Printf("Expired %d webhooks", expired)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"assignment2/utils/gateway"
+	"log"
+	"time"
+)
+
+// Webhooks are matched as stored, so webhooks registered before a field was added do not have it. Those webhooks have no list
+// of countries, region, range of years, status or trigger, and are matched as active webhooks fired by calls, applying to
+// their country and year.
+
+/*
+Checks if a webhook applies to any of the countries invoked.
+Webhooks apply to their country, their list of countries and the countries in their region, or to any country if none of these are set.
+
+	webhook		- Map of webhook data, as stored in firestore
+	isoCodes	- ISO codes of the countries invoked, or empty if all countries were invoked
+	regionCodes	- Function getting the ISO codes of the countries in a region
+
+	return	- If the webhook applies to the countries
+*/
+func webhookAppliesToCountries(webhook map[string]interface{}, isoCodes []string, regionCodes func(region string) ([]string, error)) bool {
+	countries, region := webhookCountries(webhook)
+
+	// Webhooks without any limit apply to all countries, as do invocations of all countries
+	if len(countries) == 0 && region == "" {
+		return true
+	}
+	if len(isoCodes) == 0 {
+		return true
+	}
+
+	for _, isoCode := range isoCodes {
+		if div.Contains(countries, isoCode) {
+			return true
+		}
+	}
+
+	if region == "" {
+		return false
+	}
+
+	regionIsoCodes, err := regionCodes(region)
+	if err != nil {
+		log.Println("Could not get countries in region " + region + ": " + err.Error())
+		return false
+	}
+	for _, isoCode := range isoCodes {
+		if div.Contains(regionIsoCodes, isoCode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Get the countries and region a webhook applies to
+
+	webhook	- Map of webhook data, as stored in firestore
+
+	return	- ISO codes of its country and list of countries, and its region. Both are empty for webhooks applying to any country.
+*/
+func webhookCountries(webhook map[string]interface{}) ([]string, string) {
+	var countries []string
+	if country, _ := webhook["country"].(string); country != "" && country != "ANY" {
+		countries = append(countries, country)
+	}
+
+	if list, ok := webhook["countries"].([]interface{}); ok {
+		for _, country := range list {
+			countries = append(countries, country.(string))
+		}
+	}
+	region, _ := webhook["region"].(string)
+
+	return countries, region
+}
+
+/*
+Checks if the years a webhook applies to overlap the years invoked.
+Webhooks apply to their year, or their range of years where either end may be open, or to any year if neither is set.
+
+	webhook	- Map of webhook data, as stored in firestore
+	begin	- First year invoked
+	end		- Last year invoked
+
+	return	- If the webhook applies to any of the years
+*/
+func webhookAppliesToYears(webhook map[string]interface{}, begin int, end int) bool {
+	from, to := -1, -1
+
+	if yearFrom, ok := webhook["year_from"].(int64); ok {
+		from = int(yearFrom)
+	}
+	if yearTo, ok := webhook["year_to"].(int64); ok {
+		to = int(yearTo)
+	}
+
+	// A year overrides the range of years
+	if year := int(webhook["year"].(int64)); year != -1 {
+		from, to = year, year
+	}
+
+	return (from == -1 || from <= end) && (to == -1 || to >= begin)
+}
+
+/*
+Checks if a webhook is fired. Webhooks are not fired until their url has echoed the verification challenge,
+nor when they are disabled or past their expiry. Webhooks with no status are fired.
+
+	webhook	- Map of webhook data, as stored in firestore
+	now		- Current time
+*/
+func webhookIsActive(webhook map[string]interface{}, now time.Time) bool {
+	status, ok := webhook["status"].(string)
+	return (!ok || status == constants.WEBHOOK_STATUS_ACTIVE) && !webhookExpired(webhook, now)
+}
+
+/*
+Checks if a webhook is fired by requests for renewables, which are the webhooks fired by calls or by rate, and digest webhooks.
+Webhooks with no trigger are fired by calls.
+
+	webhook	- Map of webhook data, as stored in firestore
+*/
+func webhookFiredByRequests(webhook map[string]interface{}) bool {
+	trigger, ok := webhook["trigger"].(string)
+	return !ok || trigger == constants.WEBHOOK_TRIGGER_CALLS || trigger == constants.WEBHOOK_TRIGGER_RATE || trigger == constants.WEBHOOK_TRIGGER_DIGEST
+}
+
+/*
+Get the ISO codes of the countries in a region from the restcountries API
+*/
+func getRegionIsoCodes(region string) ([]string, error) {
+	return gateway.GetIsoCodesByRegion(region, constants.COUNTRIES_API_URL)
+}
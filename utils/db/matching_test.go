@@ -0,0 +1,100 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests matching of webhooks with one country, lists of countries and regions against the countries invoked
+*/
+func TestWebhookAppliesToCountries(t *testing.T) {
+	regionCodes := func(region string) ([]string, error) {
+		if region == "Northern Europe" {
+			return []string{"NOR", "SWE", "DNK"}, nil
+		}
+		return nil, errors.New("no such region")
+	}
+
+	tests := []struct {
+		name     string
+		webhook  map[string]interface{}
+		isoCodes []string
+		applies  bool
+	}{
+		{"any country", map[string]interface{}{"country": "ANY"}, []string{"NOR"}, true},
+		{"stored before lists", map[string]interface{}{"country": "NOR"}, []string{"SWE", "NOR"}, true},
+		{"other country", map[string]interface{}{"country": "NOR"}, []string{"SWE"}, false},
+		{"all countries invoked", map[string]interface{}{"country": "NOR"}, []string{}, true},
+		{"list of countries", map[string]interface{}{"country": "ANY", "countries": []interface{}{"FIN", "SWE"}, "region": ""}, []string{"SWE"}, true},
+		{"not in list", map[string]interface{}{"country": "ANY", "countries": []interface{}{"FIN", "SWE"}, "region": ""}, []string{"NOR"}, false},
+		{"region", map[string]interface{}{"country": "ANY", "countries": []interface{}{}, "region": "Northern Europe"}, []string{"DNK"}, true},
+		{"not in region", map[string]interface{}{"country": "ANY", "countries": []interface{}{}, "region": "Northern Europe"}, []string{"DEU"}, false},
+		{"country or region", map[string]interface{}{"country": "DEU", "countries": []interface{}{}, "region": "Northern Europe"}, []string{"DEU"}, true},
+		{"unknown region", map[string]interface{}{"country": "ANY", "countries": []interface{}{}, "region": "Atlantis"}, []string{"NOR"}, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.applies, webhookAppliesToCountries(test.webhook, test.isoCodes, regionCodes), "Wrong match for "+test.name)
+	}
+}
+
+/*
+Tests that the years of webhooks overlap the years invoked, with open ends of ranges
+*/
+func TestWebhookAppliesToYears(t *testing.T) {
+	tests := []struct {
+		name       string
+		webhook    map[string]interface{}
+		begin, end int
+		applies    bool
+	}{
+		{"any year", map[string]interface{}{"year": int64(-1)}, 2000, 2010, true},
+		{"stored before ranges", map[string]interface{}{"year": int64(2005)}, 2000, 2010, true},
+		{"year outside", map[string]interface{}{"year": int64(2015)}, 2000, 2010, false},
+		{"range overlapping start", map[string]interface{}{"year": int64(-1), "year_from": int64(1990), "year_to": int64(2000)}, 2000, 2010, true},
+		{"range overlapping end", map[string]interface{}{"year": int64(-1), "year_from": int64(2010), "year_to": int64(2020)}, 2000, 2010, true},
+		{"range inside", map[string]interface{}{"year": int64(-1), "year_from": int64(2003), "year_to": int64(2004)}, 2000, 2010, true},
+		{"range before", map[string]interface{}{"year": int64(-1), "year_from": int64(1980), "year_to": int64(1999)}, 2000, 2010, false},
+		{"range after", map[string]interface{}{"year": int64(-1), "year_from": int64(2011), "year_to": int64(-1)}, 2000, 2010, false},
+		{"open start", map[string]interface{}{"year": int64(-1), "year_from": int64(-1), "year_to": int64(2001)}, 2000, 2010, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.applies, webhookAppliesToYears(test.webhook, test.begin, test.end), "Wrong match for "+test.name)
+	}
+}
+
+/*
+Tests that only active webhooks before their expiry are fired, and that disabled and expired webhooks are stopped
+*/
+func TestWebhookIsActive(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		webhook map[string]interface{}
+		active  bool
+		stopped string
+	}{
+		{"stored before status", map[string]interface{}{}, true, ""},
+		{"active", map[string]interface{}{"status": constants.WEBHOOK_STATUS_ACTIVE}, true, ""},
+		{"pending", map[string]interface{}{"status": constants.WEBHOOK_STATUS_PENDING}, false, ""},
+		{"disabled", map[string]interface{}{"status": constants.WEBHOOK_STATUS_DISABLED}, false, constants.WEBHOOK_STATUS_DISABLED},
+		{"before expiry", map[string]interface{}{"status": constants.WEBHOOK_STATUS_ACTIVE, "expires_at": now.Add(time.Minute)}, true, ""},
+		{"past expiry", map[string]interface{}{"status": constants.WEBHOOK_STATUS_ACTIVE, "expires_at": now}, false, constants.WEBHOOK_STATUS_EXPIRED},
+		{"expired", map[string]interface{}{"status": constants.WEBHOOK_STATUS_EXPIRED, "expires_at": now.Add(-time.Hour)}, false, constants.WEBHOOK_STATUS_EXPIRED},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.active, webhookIsActive(test.webhook, now), "Wrong activity for "+test.name)
+
+		state, stopped := webhookStopped(test.webhook, now)
+		assert.Equal(t, test.stopped != "", stopped, "Wrong stop for "+test.name)
+		assert.Equal(t, test.stopped, state, "Wrong state for "+test.name)
+	}
+}
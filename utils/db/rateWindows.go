@@ -0,0 +1,103 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"strconv"
+	"time"
+)
+
+/*
+Invocations of a rate webhook within its window, after counting an invocation
+*/
+type rateCount struct {
+	buckets map[string]interface{} // Invocations of each bucket in the window, by the unix time the bucket starts, as stored in firestore
+	calls   int64                  // Invocations within the window
+	fires   bool                   // If the invocations exceed the calls of the webhook, and it is not cooling down
+}
+
+/*
+Counts an invocation of a rate webhook in a sliding window. The window is counted in buckets stored with the webhook,
+so replicas counting in a transaction share the count, and buckets older than the window are dropped.
+
+	webhook	- Map of webhook data, as stored in firestore
+	now		- Time of the invocation
+
+	return	- The count of the window with the invocation
+*/
+func countRate(webhook map[string]interface{}, now time.Time) rateCount {
+	window, _ := webhook["window_seconds"].(int64)
+	bucketSize := window / constants.WEBHOOK_RATE_BUCKETS
+	if bucketSize < 1 {
+		bucketSize = 1
+	}
+
+	current := now.Unix() - now.Unix()%bucketSize
+	oldest := current - bucketSize*(constants.WEBHOOK_RATE_BUCKETS-1)
+
+	count := rateCount{buckets: map[string]interface{}{}}
+	stored, _ := webhook["rate_buckets"].(map[string]interface{})
+	for key, value := range stored {
+		start, err := strconv.ParseInt(key, 10, 64)
+		calls, ok := value.(int64)
+		if err != nil || !ok || start < oldest || start > current {
+			continue
+		}
+		count.buckets[key] = calls
+		count.calls += calls
+	}
+
+	key := strconv.FormatInt(current, 10)
+	currentCalls, _ := count.buckets[key].(int64)
+	count.buckets[key] = currentCalls + 1
+	count.calls++
+
+	limit, _ := webhook["calls"].(int64)
+	count.fires = limit > 0 && count.calls > limit && !rateCoolingDown(webhook, window, now)
+
+	return count
+}
+
+/*
+Checks if a rate webhook fired within its cooldown, which is its window if it has none
+
+	webhook	- Map of webhook data, as stored in firestore
+	window	- Window of the webhook in seconds
+	now		- Time of the invocation
+*/
+func rateCoolingDown(webhook map[string]interface{}, window int64, now time.Time) bool {
+	firedAt, ok := webhook["rate_fired_at"].(time.Time)
+	if !ok {
+		return false
+	}
+
+	cooldown, ok := webhook["cooldown_seconds"].(int64)
+	if !ok {
+		cooldown = window
+	}
+
+	return now.Before(firedAt.Add(time.Duration(cooldown) * time.Second))
+}
+
+/*
+Creates the event of a rate webhook firing, as stored with the delivery
+
+	webhook	- Map of webhook data, as stored in firestore
+	calls	- Invocations within the window
+
+	return	- Event with the type, invocations and window
+*/
+func createRateEvent(webhook map[string]interface{}, calls int64) map[string]interface{} {
+	return map[string]interface{}{
+		"type":           constants.WEBHOOK_TRIGGER_RATE,
+		"calls":          calls,
+		"window_seconds": webhook["window_seconds"],
+	}
+}
+
+/*
+Creates the ID of the delivery fired by an invocation of a rate webhook. Each invocation is counted once, so the ID is unique
+even when the webhook fires more than once in a second.
+*/
+func rateDeliveryId(webhookID string, invocations int64) string {
+	return webhookID + "-" + constants.WEBHOOK_TRIGGER_RATE + "-" + strconv.FormatInt(invocations, 10)
+}
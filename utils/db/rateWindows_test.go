@@ -0,0 +1,66 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that invocations are counted in buckets of the window, and that buckets older than the window are dropped
+*/
+func TestCountRate(t *testing.T) {
+	now := time.Unix(36000, 0)
+	webhook := map[string]interface{}{"calls": int64(10), "window_seconds": int64(3600)}
+
+	// Buckets of the window are five minutes, and the oldest bucket still in the window started 55 minutes ago
+	webhook["rate_buckets"] = map[string]interface{}{
+		strconv.FormatInt(now.Unix(), 10):      int64(4),
+		strconv.FormatInt(now.Unix()-3300, 10): int64(5),
+		strconv.FormatInt(now.Unix()-3600, 10): int64(100),
+		strconv.FormatInt(now.Unix()+300, 10):  int64(100),
+		"not a bucket":                         int64(100),
+		strconv.FormatInt(now.Unix()-600, 10):  "not a count",
+	}
+
+	count := countRate(webhook, now.Add(time.Minute))
+	assert.Equal(t, int64(10), count.calls, "Only buckets within the window should be counted")
+	assert.False(t, count.fires, "Webhook should not fire at its calls")
+	assert.Equal(t, map[string]interface{}{
+		strconv.FormatInt(now.Unix(), 10):      int64(5),
+		strconv.FormatInt(now.Unix()-3300, 10): int64(5),
+	}, count.buckets, "Invocation should be counted in the current bucket, and old buckets dropped")
+
+	webhook["rate_buckets"] = count.buckets
+	count = countRate(webhook, now.Add(2*time.Minute))
+	assert.Equal(t, int64(11), count.calls, "Invocations should be added")
+	assert.True(t, count.fires, "Webhook should fire above its calls")
+
+	// The window slides, so the oldest bucket is dropped a bucket later
+	count = countRate(webhook, now.Add(5*time.Minute))
+	assert.Equal(t, int64(6), count.calls, "Bucket leaving the window should be dropped")
+}
+
+/*
+Tests that rate webhooks do not fire again within their cooldown, which is the window if not set
+*/
+func TestRateCooldown(t *testing.T) {
+	now := time.Unix(36000, 0)
+	webhook := map[string]interface{}{
+		"calls":          int64(1),
+		"window_seconds": int64(3600),
+		"rate_buckets":   map[string]interface{}{strconv.FormatInt(now.Unix(), 10): int64(5)},
+		"rate_fired_at":  now.Add(-30 * time.Minute),
+	}
+
+	assert.False(t, countRate(webhook, now).fires, "Webhook should not fire within the window since it fired")
+
+	webhook["cooldown_seconds"] = int64(600)
+	assert.True(t, countRate(webhook, now).fires, "Webhook should fire after its cooldown")
+
+	assert.Equal(t, map[string]interface{}{"type": constants.WEBHOOK_TRIGGER_RATE, "calls": int64(6), "window_seconds": int64(3600)}, createRateEvent(webhook, 6), "Wrong event")
+	assert.Equal(t, "abc-rate-42", rateDeliveryId("abc", 42), "Wrong delivery ID")
+}
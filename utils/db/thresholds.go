@@ -0,0 +1,156 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+/*
+Compares the renewables data before and after a dataset import, and stores a delivery to each threshold webhook
+whose country's share crossed its threshold or changed by more than its change
+
+	oldData	- Renewables data before the import, with isoCode as key
+	newData	- Renewables data after the import, with isoCode as key
+
+	return	- Amount of deliveries stored
+*/
+func InvokeThresholds(oldData, newData map[string]map[string]interface{}) int {
+	invoked := 0
+
+	iter := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).
+		Where("trigger", "==", constants.WEBHOOK_TRIGGER_THRESHOLD).
+		Documents(firestoreContext)
+	defer iter.Stop()
+
+	// Go through all threshold webhooks
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Println("Could not get threshold webhooks: " + err.Error())
+			return invoked
+		}
+
+		webhook := doc.Data()
+		if !webhookIsActive(webhook, time.Now()) {
+			continue
+		}
+
+		threshold, hasThreshold := webhook["threshold"].(float64)
+		change, hasChange := webhook["change"].(float64)
+
+		// Check every country imported which the webhook applies to
+		for isoCode := range newData {
+			if !webhookAppliesToCountries(webhook, []string{isoCode}, getRegionIsoCodes) {
+				continue
+			}
+
+			event, ok := createThresholdEvent(oldData[isoCode], newData[isoCode], int(webhook["year"].(int64)))
+			if !ok {
+				continue
+			}
+
+			oldValue := event["old_value"].(float64)
+			newValue := event["new_value"].(float64)
+			if !(hasThreshold && thresholdCrossed(oldValue, newValue, threshold)) && !(hasChange && changeExceeded(oldValue, newValue, change)) {
+				continue
+			}
+
+			// Keep the limits the event was fired for, as the webhook may be updated before the delivery
+			event["country"] = isoCode
+			event["threshold"] = webhook["threshold"]
+			event["change"] = webhook["change"]
+
+			_, err = EnqueueDelivery(doc.Ref.ID, webhook, event)
+			if err != nil {
+				log.Println("Could not enqueue delivery to webhook " + doc.Ref.ID + ": " + err.Error())
+				continue
+			}
+			invoked++
+		}
+	}
+
+	return invoked
+}
+
+/*
+Creates the event of a country's renewables share changing in an import
+
+	oldCountry	- Data of the country before the import
+	newCountry	- Data of the country after the import
+	year		- Year to compare, or -1 to compare the latest year of each
+
+	return	- Event with name, year, old_value and new_value, and false if either value is missing
+*/
+func createThresholdEvent(oldCountry, newCountry map[string]interface{}, year int) (map[string]interface{}, bool) {
+	oldYear, oldValue, oldOk := getRenewablesShare(oldCountry, year)
+	newYear, newValue, newOk := getRenewablesShare(newCountry, year)
+	if !oldOk || !newOk {
+		return nil, false
+	}
+
+	// Nothing to report if the import did not change anything
+	if oldYear == newYear && oldValue == newValue {
+		return nil, false
+	}
+
+	name, _ := newCountry["name"].(string)
+
+	return map[string]interface{}{
+		"name":      name,
+		"year":      int64(newYear),
+		"old_value": oldValue,
+		"new_value": newValue,
+	}, true
+}
+
+/*
+Get the renewables share of a country in a year
+
+	country	- Data of the country, with years as keys
+	year	- Year to get, or -1 for the latest year
+
+	return	- Year and share, and false if there is no share for the year
+*/
+func getRenewablesShare(country map[string]interface{}, year int) (int, float64, bool) {
+	if year != -1 {
+		value, ok := country[strconv.Itoa(year)].(float64)
+		return year, value, ok
+	}
+
+	// Find the latest year, skipping fields such as name
+	latest := -1
+	var value float64
+	for key, data := range country {
+		keyYear, err := strconv.Atoi(key)
+		share, ok := data.(float64)
+		if err != nil || !ok || keyYear <= latest {
+			continue
+		}
+		latest = keyYear
+		value = share
+	}
+
+	return latest, value, latest != -1
+}
+
+/*
+Checks if a share went from below the threshold to at or above it, or the other way around
+*/
+func thresholdCrossed(oldValue, newValue, threshold float64) bool {
+	return (oldValue < threshold) != (newValue < threshold)
+}
+
+/*
+Checks if a share changed by more than the amount of points given
+*/
+func changeExceeded(oldValue, newValue, change float64) bool {
+	return math.Abs(newValue-oldValue) > change
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that events are created from the share of the webhook's year, or the latest year if none is set
+*/
+func TestCreateThresholdEvent(t *testing.T) {
+	oldCountry := map[string]interface{}{"name": "Norway", "2020": 70.0, "2021": 71.0}
+	newCountry := map[string]interface{}{"name": "Norway", "2020": 72.0, "2021": 71.0, "2022": 75.0}
+
+	// Latest year of each is compared when no year is set
+	event, ok := createThresholdEvent(oldCountry, newCountry, -1)
+	assert.True(t, ok, "Event should be created")
+	assert.Equal(t, map[string]interface{}{"name": "Norway", "year": int64(2022), "old_value": 71.0, "new_value": 75.0}, event, "Wrong event for latest year")
+
+	// Year of the webhook is compared when set
+	event, ok = createThresholdEvent(oldCountry, newCountry, 2020)
+	assert.True(t, ok, "Event should be created")
+	assert.Equal(t, 72.0, event["new_value"], "Wrong new value for year")
+
+	// No event if the share did not change, or is missing before or after the import
+	_, ok = createThresholdEvent(oldCountry, newCountry, 2021)
+	assert.False(t, ok, "Unchanged share should not create event")
+	_, ok = createThresholdEvent(oldCountry, newCountry, 2022)
+	assert.False(t, ok, "Share missing before import should not create event")
+	_, ok = createThresholdEvent(nil, newCountry, -1)
+	assert.False(t, ok, "New country should not create event")
+}
+
+/*
+Tests crossing of thresholds and changes in both directions
+*/
+func TestThresholdChecks(t *testing.T) {
+	assert.True(t, thresholdCrossed(49, 51, 50), "Rising above threshold should cross")
+	assert.True(t, thresholdCrossed(51, 49, 50), "Falling below threshold should cross")
+	assert.True(t, thresholdCrossed(49, 50, 50), "Reaching threshold should cross")
+	assert.False(t, thresholdCrossed(51, 55, 50), "Staying above threshold should not cross")
+
+	assert.True(t, changeExceeded(10, 16, 5), "Rise above change should exceed")
+	assert.True(t, changeExceeded(16, 10, 5), "Fall above change should exceed")
+	assert.False(t, changeExceeded(10, 15, 5), "Change equal to limit should not exceed")
+}
@@ -0,0 +1,352 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Index of the webhooks fired by requests, kept in sync with the webhooks collection by StartWebhookIndex
+var callWebhooks = newWebhookIndex()
+
+/*
+In-memory index of webhooks by the countries they apply to, so invocations of a country only go through
+the webhooks of that country, instead of every webhook registered.
+*/
+type webhookIndex struct {
+	mutex      sync.RWMutex
+	synced     bool                              // If the index has been loaded, and is kept in sync with the collection
+	webhooks   map[string]map[string]interface{} // Data of each webhook, by ID
+	anyCountry map[string]struct{}               // IDs of webhooks applying to any country
+	byCountry  map[string]map[string]struct{}    // IDs of webhooks by the ISO codes of their country and list of countries
+	byRegion   map[string]map[string]struct{}    // IDs of webhooks by their region
+}
+
+/*
+Webhook found in the index
+*/
+type indexedWebhook struct {
+	id   string
+	data map[string]interface{}
+}
+
+/*
+Creates an empty index, which is not synced until it is loaded
+*/
+func newWebhookIndex() *webhookIndex {
+	return &webhookIndex{
+		webhooks:   map[string]map[string]interface{}{},
+		anyCountry: map[string]struct{}{},
+		byCountry:  map[string]map[string]struct{}{},
+		byRegion:   map[string]map[string]struct{}{},
+	}
+}
+
+/*
+Replaces the webhooks in the index, and marks it as synced
+
+	webhooks	- Map of webhook data, as stored in firestore, by ID
+*/
+func (index *webhookIndex) load(webhooks map[string]map[string]interface{}) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.webhooks = map[string]map[string]interface{}{}
+	index.anyCountry = map[string]struct{}{}
+	index.byCountry = map[string]map[string]struct{}{}
+	index.byRegion = map[string]map[string]struct{}{}
+	for id, webhook := range webhooks {
+		index.add(id, webhook)
+	}
+	index.synced = true
+}
+
+/*
+Marks the index as no longer in sync with the collection, so webhooks are matched without it until it is loaded again
+*/
+func (index *webhookIndex) unsync() {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.synced = false
+}
+
+/*
+Checks if the index is in sync with the collection
+*/
+func (index *webhookIndex) isSynced() bool {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	return index.synced
+}
+
+/*
+Adds or updates a webhook in the index
+
+	id		- ID of the webhook
+	webhook	- Map of webhook data, as stored in firestore
+*/
+func (index *webhookIndex) set(id string, webhook map[string]interface{}) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.delete(id)
+	index.add(id, webhook)
+}
+
+/*
+Removes a webhook from the index
+
+	id	- ID of the webhook
+*/
+func (index *webhookIndex) remove(id string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.delete(id)
+}
+
+/*
+Get the webhooks which apply to any of the countries invoked.
+The webhooks are not checked for years or status, which may change after they were indexed.
+
+	isoCodes	- ISO codes of the countries invoked, or empty if all countries were invoked
+	regionCodes	- Function getting the ISO codes of the countries in a region
+
+	return	- The webhooks, sorted by ID
+*/
+func (index *webhookIndex) match(isoCodes []string, regionCodes func(region string) ([]string, error)) []indexedWebhook {
+	index.mutex.RLock()
+	regions := make([]string, 0, len(index.byRegion))
+	for region := range index.byRegion {
+		regions = append(regions, region)
+	}
+	index.mutex.RUnlock()
+
+	// Countries of regions are looked up without the lock, as they may be requested from the restcountries API
+	var matchedRegions []string
+	if len(isoCodes) > 0 {
+		for _, region := range regions {
+			regionIsoCodes, err := regionCodes(region)
+			if err != nil {
+				log.Println("Could not get countries in region " + region + ": " + err.Error())
+				continue
+			}
+			if containsAny(regionIsoCodes, isoCodes) {
+				matchedRegions = append(matchedRegions, region)
+			}
+		}
+	}
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	ids := map[string]struct{}{}
+	if len(isoCodes) == 0 {
+		// Invocations of all countries apply to all webhooks
+		for id := range index.webhooks {
+			ids[id] = struct{}{}
+		}
+	} else {
+		addIds(ids, index.anyCountry)
+		for _, isoCode := range isoCodes {
+			addIds(ids, index.byCountry[isoCode])
+		}
+		for _, region := range matchedRegions {
+			addIds(ids, index.byRegion[region])
+		}
+	}
+
+	webhooks := make([]indexedWebhook, 0, len(ids))
+	for id := range ids {
+		webhooks = append(webhooks, indexedWebhook{id: id, data: index.webhooks[id]})
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].id < webhooks[j].id })
+
+	return webhooks
+}
+
+/*
+Adds a webhook to the index, if it is fired by requests. Must be called with the lock held.
+*/
+func (index *webhookIndex) add(id string, webhook map[string]interface{}) {
+	// Threshold and dataset webhooks are fired by dataset imports, not requests
+	if !webhookFiredByRequests(webhook) {
+		return
+	}
+
+	index.webhooks[id] = webhook
+
+	countries, region := webhookCountries(webhook)
+	if len(countries) == 0 && region == "" {
+		index.anyCountry[id] = struct{}{}
+		return
+	}
+	for _, country := range countries {
+		addToSet(index.byCountry, country, id)
+	}
+	if region != "" {
+		addToSet(index.byRegion, region, id)
+	}
+}
+
+/*
+Deletes a webhook from the index, if it is there. Must be called with the lock held.
+*/
+func (index *webhookIndex) delete(id string) {
+	webhook, ok := index.webhooks[id]
+	if !ok {
+		return
+	}
+	delete(index.webhooks, id)
+	delete(index.anyCountry, id)
+
+	countries, region := webhookCountries(webhook)
+	for _, country := range countries {
+		deleteFromSet(index.byCountry, country, id)
+	}
+	if region != "" {
+		deleteFromSet(index.byRegion, region, id)
+	}
+}
+
+/*
+Adds an ID to the set of a key, creating the set if needed
+*/
+func addToSet(sets map[string]map[string]struct{}, key, id string) {
+	if sets[key] == nil {
+		sets[key] = map[string]struct{}{}
+	}
+	sets[key][id] = struct{}{}
+}
+
+/*
+Deletes an ID from the set of a key, and the set once it is empty
+*/
+func deleteFromSet(sets map[string]map[string]struct{}, key, id string) {
+	delete(sets[key], id)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+/*
+Adds all IDs of a set to another
+*/
+func addIds(ids map[string]struct{}, set map[string]struct{}) {
+	for id := range set {
+		ids[id] = struct{}{}
+	}
+}
+
+/*
+Checks if any of the values are in a slice
+*/
+func containsAny(slice []string, values []string) bool {
+	for _, value := range values {
+		if div.Contains(slice, value) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Get the webhooks fired by requests which apply to any of the countries invoked. Uses the index while it is in sync,
+and otherwise goes through all webhooks in the collection.
+
+	isoCodes	- ISO codes of the countries invoked, or empty if all countries were invoked
+
+	return	- The webhooks, or error if the collection could not be read
+*/
+func matchCallWebhooks(isoCodes []string) ([]indexedWebhook, error) {
+	if callWebhooks.isSynced() {
+		return callWebhooks.match(isoCodes, getRegionIsoCodes), nil
+	}
+
+	var webhooks []indexedWebhook
+	iter := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Documents(firestoreContext)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		webhook := doc.Data()
+		if !webhookFiredByRequests(webhook) {
+			continue
+		}
+		if webhookAppliesToCountries(webhook, isoCodes, getRegionIsoCodes) {
+			webhooks = append(webhooks, indexedWebhook{id: doc.Ref.ID, data: webhook})
+		}
+	}
+
+	return webhooks, nil
+}
+
+/*
+Keeps the index of webhooks in sync with the webhooks collection, by listening to changes of the collection,
+for as long as the service runs. If listening fails, webhooks are matched without the index until it is loaded again.
+*/
+func StartWebhookIndex() {
+	for {
+		err := syncWebhookIndex()
+		callWebhooks.unsync()
+		log.Println("Stopped syncing index of webhooks: " + err.Error())
+
+		time.Sleep(constants.WEBHOOK_INDEX_RETRY_INTERVAL)
+	}
+}
+
+/*
+Loads the index of webhooks from the first snapshot of the collection, and applies the changes of the ones after it
+
+	return	- Error when listening to the collection fails
+*/
+func syncWebhookIndex() error {
+	snapshots := firebaseClient.Collection(constants.WEBHOOKS_COLLECTION).Snapshots(firestoreContext)
+	defer snapshots.Stop()
+
+	loaded := false
+	for {
+		snapshot, err := snapshots.Next()
+		if err != nil {
+			return err
+		}
+
+		// Webhooks may have changed while not listening, so the first snapshot replaces the whole index
+		if !loaded {
+			docs, err := snapshot.Documents.GetAll()
+			if err != nil {
+				return err
+			}
+			webhooks := make(map[string]map[string]interface{}, len(docs))
+			for _, doc := range docs {
+				webhooks[doc.Ref.ID] = doc.Data()
+			}
+			callWebhooks.load(webhooks)
+			loaded = true
+			continue
+		}
+
+		for _, change := range snapshot.Changes {
+			if change.Kind == firestore.DocumentRemoved {
+				callWebhooks.remove(change.Doc.Ref.ID)
+			} else {
+				callWebhooks.set(change.Doc.Ref.ID, change.Doc.Data())
+			}
+		}
+	}
+}
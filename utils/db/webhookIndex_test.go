@@ -0,0 +1,143 @@
+package db
+
+import (
+	"assignment2/utils/constants"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Get the ISO codes of the countries in a region, for tests without the restcountries API
+*/
+func testRegionCodes(region string) ([]string, error) {
+	if region == "Northern Europe" {
+		return []string{"NOR", "SWE", "DNK"}, nil
+	}
+	return nil, errors.New("no such region")
+}
+
+/*
+Get the IDs of webhooks found in the index
+*/
+func indexedIds(webhooks []indexedWebhook) []string {
+	ids := []string{}
+	for _, webhook := range webhooks {
+		ids = append(ids, webhook.id)
+	}
+	return ids
+}
+
+/*
+Tests that the index finds the same webhooks as matching each webhook, and follows updates and removals
+*/
+func TestWebhookIndex(t *testing.T) {
+	index := newWebhookIndex()
+	assert.False(t, index.isSynced(), "Index should not be synced before it is loaded")
+
+	index.load(map[string]map[string]interface{}{
+		"any":       {"country": "ANY"},
+		"norway":    {"country": "NOR"},
+		"list":      {"country": "ANY", "countries": []interface{}{"FIN", "SWE"}, "region": ""},
+		"region":    {"country": "ANY", "countries": []interface{}{}, "region": "Northern Europe"},
+		"unknown":   {"country": "ANY", "countries": []interface{}{}, "region": "Atlantis"},
+		"threshold": {"country": "NOR", "trigger": constants.WEBHOOK_TRIGGER_THRESHOLD},
+		"rate":      {"country": "FIN", "trigger": constants.WEBHOOK_TRIGGER_RATE},
+		"digest":    {"country": "DEU", "trigger": constants.WEBHOOK_TRIGGER_DIGEST},
+	})
+	assert.True(t, index.isSynced(), "Index should be synced once it is loaded")
+
+	tests := []struct {
+		name     string
+		isoCodes []string
+		ids      []string
+	}{
+		{"country", []string{"NOR"}, []string{"any", "norway", "region"}},
+		{"list of countries", []string{"FIN"}, []string{"any", "list", "rate"}},
+		{"several countries", []string{"SWE", "NOR"}, []string{"any", "list", "norway", "region"}},
+		{"no webhook of country", []string{"DNK"}, []string{"any", "region"}},
+		{"digest", []string{"DEU"}, []string{"any", "digest"}},
+		{"all countries", []string{}, []string{"any", "digest", "list", "norway", "rate", "region", "unknown"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.ids, indexedIds(index.match(test.isoCodes, testRegionCodes)), "Wrong webhooks for "+test.name)
+	}
+
+	// Updated webhooks are moved to their new countries
+	index.set("norway", map[string]interface{}{"country": "DEU"})
+	assert.Equal(t, []string{"any", "region"}, indexedIds(index.match([]string{"NOR"}, testRegionCodes)), "Updated webhook should not match its old country")
+	assert.Equal(t, []string{"any", "digest", "norway"}, indexedIds(index.match([]string{"DEU"}, testRegionCodes)), "Updated webhook should match its new country")
+
+	// Webhooks changed to threshold webhooks are no longer fired by requests
+	index.set("region", map[string]interface{}{"country": "ANY", "region": "Northern Europe", "trigger": constants.WEBHOOK_TRIGGER_THRESHOLD})
+	assert.Equal(t, []string{"any"}, indexedIds(index.match([]string{"DNK"}, testRegionCodes)), "Threshold webhook should not be indexed")
+
+	index.remove("any")
+	index.remove("missing")
+	assert.Equal(t, []string{"digest", "norway"}, indexedIds(index.match([]string{"DEU"}, testRegionCodes)), "Removed webhook should not match")
+	assert.Empty(t, index.byRegion["Northern Europe"], "Empty sets should be removed")
+
+	index.unsync()
+	assert.False(t, index.isSynced(), "Index should not be synced after listening failed")
+}
+
+/*
+Creates an index with webhooks spread over other countries, and ten webhooks of Norway and any country each
+*/
+func createBenchmarkWebhooks(total int) map[string]map[string]interface{} {
+	webhooks := make(map[string]map[string]interface{}, total)
+	for i := 0; i < total; i++ {
+		country := fmt.Sprintf("C%05d", i)
+		switch {
+		case i < 10:
+			country = "NOR"
+		case i < 20:
+			country = "ANY"
+		}
+		webhooks[fmt.Sprintf("webhook%06d", i)] = map[string]interface{}{"country": country, "countries": []interface{}{}, "region": "", "year": int64(-1)}
+	}
+	return webhooks
+}
+
+/*
+Benchmarks matching an invocation of one country against the index, which should not grow with the amount of webhooks
+*/
+func BenchmarkWebhookIndexMatch(b *testing.B) {
+	for _, total := range []int{1000, 10000, 100000} {
+		index := newWebhookIndex()
+		index.load(createBenchmarkWebhooks(total))
+
+		b.Run(fmt.Sprintf("webhooks=%d", total), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if matched := index.match([]string{"NOR"}, testRegionCodes); len(matched) != 20 {
+					b.Fatalf("Expected 20 webhooks, got %d", len(matched))
+				}
+			}
+		})
+	}
+}
+
+/*
+Benchmarks matching an invocation of one country against every webhook, as done without the index
+*/
+func BenchmarkWebhookScanMatch(b *testing.B) {
+	for _, total := range []int{1000, 10000, 100000} {
+		webhooks := createBenchmarkWebhooks(total)
+
+		b.Run(fmt.Sprintf("webhooks=%d", total), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matched := 0
+				for _, webhook := range webhooks {
+					if webhookAppliesToCountries(webhook, []string{"NOR"}, testRegionCodes) {
+						matched++
+					}
+				}
+				if matched != 20 {
+					b.Fatalf("Expected 20 webhooks, got %d", matched)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned when a job is submitted, so the caller can persist it for later instead
+var (
+	ErrQueueFull = errors.New("dispatcher queue is full")
+	ErrClosed    = errors.New("dispatcher is shut down")
+)
+
+/*
+Work run by the dispatcher, such as an attempt of a webhook delivery
+*/
+type Job struct {
+	Host    string       // Destination host, which at most MaxPerHost jobs run against at once. Empty for no limit.
+	Run     func()       // Runs the job
+	Persist func() error // Stores the job for later if it is not run before shutdown. Nil for jobs which are already stored.
+}
+
+/*
+Settings of a dispatcher
+*/
+type Config struct {
+	Workers    int // Amount of jobs run at once
+	QueueSize  int // Amount of jobs waiting to run before submissions are rejected
+	MaxPerHost int // Amount of jobs run at once against the same host
+}
+
+/*
+Metrics of a dispatcher
+*/
+type Stats struct {
+	QueueDepth int64 `json:"queue_depth"` // Jobs waiting to run
+	InFlight   int64 `json:"in_flight"`   // Jobs running
+	Workers    int   `json:"workers"`
+	QueueSize  int   `json:"queue_size"`
+	Processed  int64 `json:"processed"` // Jobs run since start
+	Rejected   int64 `json:"rejected"`  // Jobs rejected because the queue was full
+	Persisted  int64 `json:"persisted"` // Jobs stored for later at shutdown
+}
+
+/*
+Runs jobs on a bounded pool of workers, from a bounded queue, with a limit of jobs against each host
+*/
+type Dispatcher struct {
+	config Config
+	queue  chan Job
+
+	mutex    sync.Mutex
+	closed   bool
+	stopping bool
+	active   map[string]int   // Jobs running against each host
+	waiting  map[string][]Job // Jobs taken from the queue while their host was at its limit
+
+	workers sync.WaitGroup
+
+	depth     atomic.Int64
+	inFlight  atomic.Int64
+	processed atomic.Int64
+	rejected  atomic.Int64
+	persisted atomic.Int64
+}
+
+/*
+Creates a dispatcher and starts its workers
+
+	config	- Settings of the dispatcher, where values below 1 are set to 1
+
+	return	- The dispatcher
+*/
+func New(config Config) *Dispatcher {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.QueueSize < 1 {
+		config.QueueSize = 1
+	}
+	if config.MaxPerHost < 1 {
+		config.MaxPerHost = 1
+	}
+
+	d := &Dispatcher{
+		config:  config,
+		queue:   make(chan Job, config.QueueSize),
+		active:  make(map[string]int),
+		waiting: make(map[string][]Job),
+	}
+
+	for i := 0; i < config.Workers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+
+	return d
+}
+
+/*
+Adds a job to the queue without waiting
+
+	job	- Job to run
+
+	return	- ErrQueueFull if the queue is full, or ErrClosed after shutdown
+*/
+func (d *Dispatcher) Submit(job Job) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	// Jobs waiting for their host count towards the size of the queue
+	if d.depth.Load() >= int64(d.config.QueueSize) {
+		d.rejected.Add(1)
+		return ErrQueueFull
+	}
+
+	d.depth.Add(1)
+	d.queue <- job
+
+	return nil
+}
+
+/*
+Stops accepting jobs, and waits for the jobs queued to run.
+If the context is done first, the jobs not started are persisted instead, and jobs running are waited for.
+
+	ctx	- Context with the deadline for running the jobs queued
+
+	return	- Error from the first job which could not be persisted
+*/
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mutex.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Workers persist the rest of the queue instead of running it
+	d.mutex.Lock()
+	d.stopping = true
+	var waiting []Job
+	for host, jobs := range d.waiting {
+		waiting = append(waiting, jobs...)
+		delete(d.waiting, host)
+	}
+	d.mutex.Unlock()
+
+	var firstErr error
+	for _, job := range waiting {
+		if err := d.persist(job); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	<-drained
+	return firstErr
+}
+
+/*
+Get the metrics of the dispatcher
+*/
+func (d *Dispatcher) Stats() Stats {
+	return Stats{
+		QueueDepth: d.depth.Load(),
+		InFlight:   d.inFlight.Load(),
+		Workers:    d.config.Workers,
+		QueueSize:  d.config.QueueSize,
+		Processed:  d.processed.Load(),
+		Rejected:   d.rejected.Load(),
+		Persisted:  d.persisted.Load(),
+	}
+}
+
+/*
+Runs jobs from the queue until it is closed and empty
+*/
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+
+	for job := range d.queue {
+		run, stopping := d.acquire(job)
+		if stopping {
+			if err := d.persist(job); err != nil {
+				log.Println("Could not persist job at shutdown: " + err.Error())
+			}
+			continue
+		}
+		if !run {
+			continue
+		}
+
+		// Keep running jobs waiting for the same host, as this worker holds its slot
+		for ok := true; ok; job, ok = d.release(job.Host) {
+			d.run(job)
+		}
+	}
+}
+
+/*
+Takes a slot for the host of a job, or puts the job aside until a slot is released
+
+	return	- If the job got a slot and should run now, and if the deadline of the shutdown has passed so it should be persisted
+*/
+func (d *Dispatcher) acquire(job Job) (bool, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.stopping {
+		return false, true
+	}
+	if job.Host == "" {
+		return true, false
+	}
+
+	if d.active[job.Host] < d.config.MaxPerHost {
+		d.active[job.Host]++
+		return true, false
+	}
+
+	d.waiting[job.Host] = append(d.waiting[job.Host], job)
+	return false, false
+}
+
+/*
+Hands the slot of a host over to the next job waiting for it, or releases it
+
+	return	- The next job for the host, and if there was one
+*/
+func (d *Dispatcher) release(host string) (Job, bool) {
+	if host == "" {
+		return Job{}, false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if jobs := d.waiting[host]; len(jobs) > 0 && !d.stopping {
+		d.waiting[host] = jobs[1:]
+		if len(d.waiting[host]) == 0 {
+			delete(d.waiting, host)
+		}
+		return jobs[0], true
+	}
+
+	d.active[host]--
+	if d.active[host] <= 0 {
+		delete(d.active, host)
+	}
+	return Job{}, false
+}
+
+/*
+Runs a job, keeping the metrics up to date
+*/
+func (d *Dispatcher) run(job Job) {
+	d.depth.Add(-1)
+	d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	defer d.processed.Add(1)
+
+	job.Run()
+}
+
+/*
+Stores a job which will not be run for later
+*/
+func (d *Dispatcher) persist(job Job) error {
+	d.depth.Add(-1)
+	if job.Persist == nil {
+		return nil
+	}
+
+	d.persisted.Add(1)
+	return job.Persist()
+}
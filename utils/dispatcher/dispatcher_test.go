@@ -0,0 +1,139 @@
+package dispatcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that all jobs queued are run before shutdown returns
+*/
+func TestDispatcherDrainsOnShutdown(t *testing.T) {
+	d := New(Config{Workers: 4, QueueSize: 100, MaxPerHost: 2})
+
+	var ran atomic.Int64
+	for i := 0; i < 50; i++ {
+		err := d.Submit(Job{Host: []string{"a", "b", ""}[i%3], Run: func() { ran.Add(1) }})
+		assert.Nil(t, err, "Job should be queued")
+	}
+
+	assert.Nil(t, d.Shutdown(context.Background()), "Shutdown should drain the queue")
+	assert.Equal(t, int64(50), ran.Load(), "All jobs should run")
+
+	stats := d.Stats()
+	assert.Equal(t, int64(50), stats.Processed, "All jobs should be counted")
+	assert.Equal(t, int64(0), stats.QueueDepth, "Queue should be empty")
+	assert.Equal(t, ErrClosed, d.Submit(Job{Run: func() {}}), "Jobs should be rejected after shutdown")
+}
+
+/*
+Tests that jobs are rejected when the queue is full, rather than waiting
+*/
+func TestDispatcherBackpressure(t *testing.T) {
+	d := New(Config{Workers: 1, QueueSize: 2, MaxPerHost: 1})
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// Keep the only worker busy
+	assert.Nil(t, d.Submit(Job{Run: func() { close(started); <-release }}), "First job should be queued")
+	<-started
+
+	assert.Nil(t, d.Submit(Job{Run: func() {}}), "Second job should be queued")
+	assert.Nil(t, d.Submit(Job{Run: func() {}}), "Third job should be queued")
+	assert.Equal(t, ErrQueueFull, d.Submit(Job{Run: func() {}}), "Job should be rejected when queue is full")
+
+	stats := d.Stats()
+	assert.Equal(t, int64(2), stats.QueueDepth, "Queue depth should count jobs waiting")
+	assert.Equal(t, int64(1), stats.InFlight, "One job should be running")
+	assert.Equal(t, int64(1), stats.Rejected, "Rejected job should be counted")
+
+	close(release)
+	assert.Nil(t, d.Shutdown(context.Background()), "Shutdown should drain the queue")
+}
+
+/*
+Tests that no more than the limit of jobs run against the same host at once, while other hosts are not held up
+*/
+func TestDispatcherMaxPerHost(t *testing.T) {
+	d := New(Config{Workers: 8, QueueSize: 100, MaxPerHost: 2})
+
+	var mutex sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+	job := func(host string) Job {
+		return Job{Host: host, Run: func() {
+			mutex.Lock()
+			running[host]++
+			if running[host] > maxRunning[host] {
+				maxRunning[host] = running[host]
+			}
+			mutex.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mutex.Lock()
+			running[host]--
+			mutex.Unlock()
+		}}
+	}
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, d.Submit(job("slow.example")), "Job should be queued")
+		assert.Nil(t, d.Submit(job("other.example")), "Job should be queued")
+	}
+	assert.Nil(t, d.Shutdown(context.Background()), "Shutdown should drain the queue")
+
+	assert.Equal(t, 2, maxRunning["slow.example"], "Jobs against a host should be limited")
+	assert.Equal(t, 2, maxRunning["other.example"], "Jobs against a host should be limited")
+	assert.Equal(t, int64(40), d.Stats().Processed, "All jobs should run")
+}
+
+/*
+Tests that jobs not started before the deadline of the shutdown are persisted
+*/
+func TestDispatcherPersistsOnShutdown(t *testing.T) {
+	d := New(Config{Workers: 1, QueueSize: 10, MaxPerHost: 1})
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	assert.Nil(t, d.Submit(Job{Run: func() { close(started); <-release }}), "First job should be queued")
+	<-started
+
+	var ran, persisted atomic.Int64
+	for i := 0; i < 5; i++ {
+		d.Submit(Job{
+			Run:     func() { ran.Add(1) },
+			Persist: func() error { persisted.Add(1); return nil },
+		})
+	}
+	// Jobs which are stored elsewhere have nothing to persist
+	d.Submit(Job{Run: func() { ran.Add(1) }})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Finish the running job once the deadline has passed and the shutdown has stopped running jobs
+	go func() {
+		for {
+			d.mutex.Lock()
+			stopping := d.stopping
+			d.mutex.Unlock()
+			if stopping {
+				close(release)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	assert.Nil(t, d.Shutdown(ctx), "Jobs should be persisted")
+	assert.Equal(t, int64(0), ran.Load(), "Jobs should not run after the deadline")
+	assert.Equal(t, int64(5), persisted.Load(), "Jobs not run should be persisted")
+	assert.Equal(t, int64(5), d.Stats().Persisted, "Persisted jobs should be counted")
+	assert.Equal(t, int64(0), d.Stats().QueueDepth, "Queue should be empty")
+}
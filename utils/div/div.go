@@ -2,6 +2,8 @@
 
 import (
 	"assignment2/utils/constants"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -27,6 +29,38 @@ func CreateWebhookId() string {
 	return string(b)
 }
 
+/*
+Create random request ID, as 32 hexadecimal characters
+
+	return - Random request ID
+*/
+func CreateRequestId() string {
+	b := make([]byte, 16)
+
+	// Fall back to the random webhookID generator if the system source fails
+	if _, err := cryptorand.Read(b); err != nil {
+		return CreateWebhookId()
+	}
+
+	return hex.EncodeToString(b)
+}
+
+/*
+Create random secret for signing webhook deliveries
+
+	return - Random secret, or error if the system source of randomness fails
+*/
+func CreateWebhookSecret() (string, error) {
+	b := make([]byte, constants.WEBHOOK_SECRET_LENGTH)
+
+	// Secrets must never be predictable, so there is no fallback to math/rand
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+
+	return constants.WEBHOOK_SECRET_PREFIX + hex.EncodeToString(b), nil
+}
+
 /*
 Returns if slice contains value
 */
@@ -1,6 +1,8 @@
 package div
 
 import (
+	"assignment2/utils/constants"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -47,3 +49,27 @@ func TestRemoveDuplicates(t *testing.T) {
 	// Check if slice contains values
 	assert.Equal(t, expected, RemoveDuplicates(slice), "Slice does not contain value a")
 }
+
+/*
+Tests the creation of a random request ID
+*/
+func TestCreateRequestId(t *testing.T) {
+	id1 := CreateRequestId()
+	id2 := CreateRequestId()
+
+	assert.NotEqual(t, id1, id2, "Request IDs are the same")
+	assert.Equal(t, 32, len(id1), "Request ID is not 32 characters long")
+}
+
+/*
+Tests the creation of a random webhook secret
+*/
+func TestCreateWebhookSecret(t *testing.T) {
+	secret1, err := CreateWebhookSecret()
+	assert.Nil(t, err, "Secret should be created")
+	secret2, _ := CreateWebhookSecret()
+
+	assert.NotEqual(t, secret1, secret2, "Secrets are the same")
+	assert.True(t, strings.HasPrefix(secret1, constants.WEBHOOK_SECRET_PREFIX), "Secret does not have prefix")
+	assert.Equal(t, len(constants.WEBHOOK_SECRET_PREFIX)+2*constants.WEBHOOK_SECRET_LENGTH, len(secret1), "Secret has wrong length")
+}
@@ -0,0 +1,104 @@
+package div
+
+import (
+	"assignment2/utils/constants"
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Cipher encrypting values at rest, such as the credentials of webhooks. Set from $WEBHOOK_ENCRYPTION_KEY in main, and nil if values can not be encrypted.
+var encryptionCipher cipher.AEAD
+
+/*
+Sets the key values are encrypted at rest with
+
+	key	- Base64 encoded AES-256 key, or empty to disable encryption
+
+	return	- Error if the key is not a base64 encoded key of ENCRYPTION_KEY_SIZE bytes
+*/
+func SetEncryptionKey(key string) error {
+	if key == "" {
+		encryptionCipher = nil
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != constants.ENCRYPTION_KEY_SIZE {
+		return errors.New("encryption key must be " + strconv.Itoa(constants.ENCRYPTION_KEY_SIZE) + " bytes encoded in base64")
+	}
+
+	block, err := aes.NewCipher(decoded)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	encryptionCipher = aead
+	return nil
+}
+
+/*
+Returns if an encryption key has been set, so values can be encrypted
+*/
+func EncryptionEnabled() bool {
+	return encryptionCipher != nil
+}
+
+/*
+Encrypts a value with AES-GCM, with a random nonce so equal values are not stored equally
+
+	plaintext	- Value to encrypt
+
+	return	- ENCRYPTED_VALUE_PREFIX followed by the nonce and ciphertext in base64, or error if no key is set
+*/
+func EncryptValue(plaintext string) (string, error) {
+	if encryptionCipher == nil {
+		return "", errors.New("no encryption key is set")
+	}
+
+	nonce := make([]byte, encryptionCipher.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := encryptionCipher.Seal(nonce, nonce, []byte(plaintext), nil)
+	return constants.ENCRYPTED_VALUE_PREFIX + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+/*
+Decrypts a value encrypted by EncryptValue
+
+	encrypted	- Encrypted value
+
+	return	- The value, or error if no key is set, or the value was not encrypted with the key
+*/
+func DecryptValue(encrypted string) (string, error) {
+	if encryptionCipher == nil {
+		return "", errors.New("no encryption key is set")
+	}
+
+	encoded, found := strings.CutPrefix(encrypted, constants.ENCRYPTED_VALUE_PREFIX)
+	if !found {
+		return "", errors.New("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < encryptionCipher.NonceSize() {
+		return "", errors.New("encrypted value is malformed")
+	}
+
+	nonce, ciphertext := sealed[:encryptionCipher.NonceSize()], sealed[encryptionCipher.NonceSize():]
+	plaintext, err := encryptionCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("value could not be decrypted with the encryption key")
+	}
+
+	return string(plaintext), nil
+}
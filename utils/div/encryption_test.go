@@ -0,0 +1,46 @@
+package div
+
+import (
+	"assignment2/utils/constants"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that values are encrypted with a random nonce, and only decrypted with the key they were encrypted with
+*/
+func TestEncryptValue(t *testing.T) {
+	t.Cleanup(func() { SetEncryptionKey("") })
+
+	_, err := EncryptValue("secret")
+	assert.NotNil(t, err, "Values should not be encrypted without a key")
+	assert.NotNil(t, SetEncryptionKey("c2hvcnQ="), "Keys which are too short should be refused")
+	assert.NotNil(t, SetEncryptionKey("not base64!"), "Keys which are not base64 should be refused")
+
+	assert.Nil(t, SetEncryptionKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="), "Key should be set")
+	assert.True(t, EncryptionEnabled(), "Encryption should be enabled")
+
+	first, err := EncryptValue("secret")
+	assert.Nil(t, err, "Value should be encrypted")
+	second, _ := EncryptValue("secret")
+	assert.True(t, strings.HasPrefix(first, constants.ENCRYPTED_VALUE_PREFIX), "Encrypted value should have the prefix")
+	assert.NotContains(t, first, "secret", "Value should not be stored in plain text")
+	assert.NotEqual(t, first, second, "Equal values should not be encrypted equally")
+
+	value, err := DecryptValue(first)
+	assert.Nil(t, err, "Value should be decrypted")
+	assert.Equal(t, "secret", value, "Wrong decrypted value")
+
+	_, err = DecryptValue("secret")
+	assert.NotNil(t, err, "Values which are not encrypted should not be decrypted")
+
+	// Values encrypted with another key can not be decrypted
+	assert.Nil(t, SetEncryptionKey("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="), "Key should be set")
+	_, err = DecryptValue(first)
+	assert.NotNil(t, err, "Value should not be decrypted with another key")
+
+	assert.Nil(t, SetEncryptionKey(""), "Empty key should disable encryption")
+	assert.False(t, EncryptionEnabled(), "Encryption should be disabled")
+}
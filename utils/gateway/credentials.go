@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"assignment2/utils/structs"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+Creates the fields of the outbound headers and credentials of a webhook, as they are stored in the database.
+Header values and credentials are encrypted. Headers are stored as a list, so updates replace them rather than merge with the ones stored.
+
+	headers	- Outbound headers of the webhook, or nil
+	auth	- Credentials of the webhook, or nil
+
+	return	- Map of fields, with an empty list of headers and null auth if not given, or error if they could not be encrypted
+*/
+func CreateCredentialFields(headers map[string]string, auth *structs.WebhookAuth) (map[string]interface{}, error) {
+	fields := map[string]interface{}{
+		"headers":          []interface{}{},
+		"auth_type":        nil,
+		"auth_credentials": nil,
+	}
+
+	// Sort by canonical name, so the stored list does not change unless the headers do
+	canonical := map[string]string{}
+	names := make([]string, 0, len(headers))
+	for name, value := range headers {
+		name = http.CanonicalHeaderKey(name)
+		canonical[name] = value
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	storedHeaders := make([]interface{}, 0, len(headers))
+	for _, name := range names {
+		value, err := div.EncryptValue(canonical[name])
+		if err != nil {
+			return nil, encryptionFailed(err)
+		}
+		storedHeaders = append(storedHeaders, map[string]interface{}{"name": name, "value": value})
+	}
+	fields["headers"] = storedHeaders
+
+	if auth != nil {
+		// Basic auth is stored as username:password, which is how it is sent
+		credentials := auth.Token
+		if auth.Type == constants.WEBHOOK_AUTH_BASIC {
+			credentials = auth.Username + ":" + auth.Password
+		}
+
+		encrypted, err := div.EncryptValue(credentials)
+		if err != nil {
+			return nil, encryptionFailed(err)
+		}
+		fields["auth_type"] = auth.Type
+		fields["auth_credentials"] = encrypted
+	}
+
+	return fields, nil
+}
+
+/*
+Get the outbound headers and credentials of a webhook, decrypted
+
+	data	- Map of webhook data, as stored in firestore
+
+	return	- Headers and credentials, nil if the webhook has none, or error if they could not be decrypted
+*/
+func GetWebhookCredentials(data map[string]interface{}) (map[string]string, *structs.WebhookAuth, error) {
+	var headers map[string]string
+	storedHeaders, _ := data["headers"].([]interface{})
+	for _, stored := range storedHeaders {
+		header, _ := stored.(map[string]interface{})
+		name, _ := header["name"].(string)
+		encrypted, _ := header["value"].(string)
+
+		value, err := div.DecryptValue(encrypted)
+		if err != nil {
+			return nil, nil, decryptionFailed(err)
+		}
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers[name] = value
+	}
+
+	authType, ok := data["auth_type"].(string)
+	if !ok || authType == "" {
+		return headers, nil, nil
+	}
+
+	encrypted, _ := data["auth_credentials"].(string)
+	credentials, err := div.DecryptValue(encrypted)
+	if err != nil {
+		return nil, nil, decryptionFailed(err)
+	}
+
+	auth := &structs.WebhookAuth{Type: authType}
+	if authType == constants.WEBHOOK_AUTH_BASIC {
+		auth.Username, auth.Password, _ = strings.Cut(credentials, ":")
+	} else {
+		auth.Token = credentials
+	}
+
+	return headers, auth, nil
+}
+
+/*
+Get the headers sent with requests to the url of a webhook, from its outbound headers and credentials
+
+	data	- Map of webhook data, as stored in firestore
+
+	return	- Headers, with Authorization set from the credentials, or error if they could not be decrypted
+*/
+func GetOutboundHeaders(data map[string]interface{}) (http.Header, error) {
+	headers, auth, err := GetWebhookCredentials(data)
+	if err != nil {
+		return nil, err
+	}
+
+	outbound := http.Header{}
+	for name, value := range headers {
+		outbound.Set(name, value)
+	}
+
+	if auth != nil {
+		request := http.Request{Header: outbound}
+		switch auth.Type {
+		case constants.WEBHOOK_AUTH_BASIC:
+			request.SetBasicAuth(auth.Username, auth.Password)
+		case constants.WEBHOOK_AUTH_BEARER:
+			outbound.Set("Authorization", "Bearer "+auth.Token)
+		default:
+			return nil, decryptionFailed(errors.New("unknown auth type " + auth.Type))
+		}
+	}
+
+	return outbound, nil
+}
+
+/*
+Returns the error for credentials which could not be encrypted
+*/
+func encryptionFailed(err error) error {
+	return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not encrypt webhook credentials.")
+}
+
+/*
+Returns the error for credentials which could not be decrypted, such as after the encryption key was changed
+*/
+func decryptionFailed(err error) error {
+	return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not decrypt webhook credentials.")
+}
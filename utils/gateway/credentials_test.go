@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"assignment2/utils/structs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Sets an encryption key for the rest of a test
+*/
+func setTestEncryptionKey(t *testing.T) {
+	if err := div.SetEncryptionKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { div.SetEncryptionKey("") })
+}
+
+/*
+Tests that headers and credentials are stored encrypted, and read back as they were given
+*/
+func TestWebhookCredentials(t *testing.T) {
+	setTestEncryptionKey(t)
+
+	headers := map[string]string{"x-api-key": "KEY", "X-Tenant": "energy"}
+	auth := &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BASIC, Username: "user", Password: "pa:ss"}
+	fields, err := CreateCredentialFields(headers, auth)
+	assert.Nil(t, err, "Credentials should be encrypted")
+
+	stored := fields["headers"].([]interface{})
+	assert.Len(t, stored, 2, "Both headers should be stored")
+	assert.Equal(t, "X-Api-Key", stored[0].(map[string]interface{})["name"], "Header names should be canonical and sorted")
+	assert.NotContains(t, stored[0].(map[string]interface{})["value"], "KEY", "Header value should be encrypted")
+	assert.Equal(t, constants.WEBHOOK_AUTH_BASIC, fields["auth_type"], "Auth type should be stored")
+	assert.NotContains(t, fields["auth_credentials"], "user", "Credentials should be encrypted")
+
+	readHeaders, readAuth, err := GetWebhookCredentials(fields)
+	assert.Nil(t, err, "Credentials should be decrypted")
+	assert.Equal(t, map[string]string{"X-Api-Key": "KEY", "X-Tenant": "energy"}, readHeaders, "Wrong headers")
+	assert.Equal(t, auth, readAuth, "Wrong auth")
+
+	outbound, err := GetOutboundHeaders(fields)
+	assert.Nil(t, err, "Headers should be created")
+	assert.Equal(t, "KEY", outbound.Get("X-Api-Key"), "Custom header should be sent")
+	username, password, ok := (&http.Request{Header: outbound}).BasicAuth()
+	assert.True(t, ok && username == "user" && password == "pa:ss", "Basic auth should be sent")
+
+	// Webhooks without headers and auth clear the ones stored
+	fields, err = CreateCredentialFields(nil, &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER, Token: "TOKEN"})
+	assert.Nil(t, err, "Credentials should be encrypted")
+	assert.Empty(t, fields["headers"], "No headers should be stored")
+	outbound, _ = GetOutboundHeaders(fields)
+	assert.Equal(t, "Bearer TOKEN", outbound.Get("Authorization"), "Bearer auth should be sent")
+
+	// Credentials can not be read after the key is changed
+	assert.Nil(t, div.SetEncryptionKey("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="), "Key should be set")
+	_, err = GetOutboundHeaders(fields)
+	assert.Equal(t, http.StatusInternalServerError, err.(structs.WrappedError).StatusCode, "Credentials should not be decrypted with another key")
+}
+
+/*
+Tests that deliveries are sent with the headers and auth of the webhook, which do not replace the headers of the service
+*/
+func TestPostToWebhookWithCredentials(t *testing.T) {
+	setTestEncryptionKey(t)
+
+	var received http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	data, err := CreateCredentialFields(
+		map[string]string{"X-Api-Key": "KEY", constants.WEBHOOK_DELIVERY_HEADER: "FORGED"},
+		&structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER, Token: "TOKEN"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data["url"] = ts.URL
+	data["country"] = "ANY"
+	data["year"] = int64(-1)
+	data["invocations"] = int64(5)
+
+	_, err = DeliverToWebhook(data, "TEST", "TEST-DELIVERY", ts.URL)
+	assert.Nil(t, err, "Delivery should succeed")
+	assert.Equal(t, "Bearer TOKEN", received.Get("Authorization"), "Delivery should have the auth of the webhook")
+	assert.Equal(t, "KEY", received.Get("X-Api-Key"), "Delivery should have the headers of the webhook")
+	assert.Equal(t, "TEST-DELIVERY", received.Get(constants.WEBHOOK_DELIVERY_HEADER), "Headers of the service should not be replaced")
+	assert.True(t, strings.HasPrefix(received.Get("Content-Type"), "application/json"), "Content type should not be replaced")
+}
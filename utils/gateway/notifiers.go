@@ -0,0 +1,482 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Sends webhook deliveries through a channel, chosen by the channel field of the webhook. The url of the webhook is the target of the channel.
+*/
+type Notifier interface {
+	// Checks the target of a webhook when it is registered, and again before each delivery
+	CheckTarget(target string) error
+	// Checks that the target wants deliveries of the webhook, before the webhook is fired.
+	// Returns ErrChallengeSent if the target can not echo the challenge, and has been sent it to post back instead.
+	Verify(target, webhookID, challenge string, secrets []string, headers http.Header) error
+	// Sends a delivery to the target, and records how the receiver responded in the attempt
+	Notify(target string, delivery structs.Delivery, attempt *structs.DeliveryAttempt) error
+}
+
+// Returned by Verify when the challenge has been sent to a target which can not echo it, such as an email address.
+// The webhook is verified when the challenge is posted back to the verify endpoint.
+var ErrChallengeSent = errors.New("verification challenge sent, waiting for it to be posted back")
+
+// Notifiers of each channel. SMTP and the file sink are not enabled until they are configured with RegisterNotifier in main.
+var notifiers = map[string]Notifier{
+	constants.WEBHOOK_CHANNEL_HTTP: HttpNotifier{},
+	constants.WEBHOOK_CHANNEL_SMTP: SmtpNotifier{},
+	constants.WEBHOOK_CHANNEL_FILE: FileNotifier{},
+}
+
+/*
+Adds a channel, or replaces the notifier of an existing one. Must be called before the server starts, such as in main or init.
+
+	channel		- Name of the channel, as given in the channel field of webhooks
+	notifier	- Notifier of the channel
+*/
+func RegisterNotifier(channel string, notifier Notifier) {
+	notifiers[channel] = notifier
+}
+
+/*
+Checks that the channel of a webhook exists, and that the url of the webhook is a target of the channel
+
+	channel	- Channel of the webhook, or empty for http
+	target	- URL of the webhook
+
+	return	- Error with status 422 if the channel does not exist or the url is not a target of it
+*/
+func CheckWebhookTarget(channel, target string) error {
+	notifier, err := getNotifier(channel)
+	if err != nil {
+		return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "channel", "Invalid request body for registration of webhook, channel must be one of "+strings.Join(notifierChannels(), ", "), "")
+	}
+
+	return notifier.CheckTarget(target)
+}
+
+/*
+Checks that the target of a webhook wants its deliveries, such as by sending a challenge to its url
+
+	channel		- Channel of the webhook, or empty for http
+	target		- URL of the webhook
+	webhookID	- ID of the webhook
+	challenge	- Random challenge the target may have to echo
+	secrets		- Secrets to sign the challenge with
+	headers		- Outbound headers and credentials of the webhook
+
+	return	- ErrChallengeSent if the challenge must be posted back, or error with status 422 if the target could not be verified
+*/
+func VerifyWebhookTarget(channel, target, webhookID, challenge string, secrets []string, headers http.Header) error {
+	notifier, err := getNotifier(channel)
+	if err != nil {
+		return verificationFailed(err)
+	}
+
+	return notifier.Verify(target, webhookID, challenge, secrets, headers)
+}
+
+/*
+Get the notifier of a channel, where empty is http
+*/
+func getNotifier(channel string) (Notifier, error) {
+	if channel == "" {
+		channel = constants.WEBHOOK_CHANNEL_HTTP
+	}
+
+	notifier, ok := notifiers[channel]
+	if !ok {
+		return nil, errors.New("unknown channel " + channel)
+	}
+
+	return notifier, nil
+}
+
+/*
+Get the names of all channels, sorted
+*/
+func notifierChannels() []string {
+	var channels []string
+	for channel := range notifiers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+/*
+Posts deliveries to the http or https url of the webhook, which must echo a challenge before it is fired
+*/
+type HttpNotifier struct{}
+
+func (HttpNotifier) CheckTarget(target string) error {
+	return CheckWebhookUrl(target)
+}
+
+func (HttpNotifier) Verify(target, webhookID, challenge string, secrets []string, headers http.Header) error {
+	return SendVerificationChallenge(target, webhookID, challenge, secrets, headers)
+}
+
+func (HttpNotifier) Notify(target string, delivery structs.Delivery, attempt *structs.DeliveryAttempt) error {
+	// Create post request to url
+	request, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return structs.NewError(err, http.StatusBadRequest, "Invalid webhook URL", "Could not create request to webhook url.")
+	}
+	// Outbound headers are set first, so they can not replace the headers of the delivery
+	for name, values := range delivery.Headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("content-type", delivery.ContentType)
+	request.Header.Set(constants.WEBHOOK_DELIVERY_HEADER, delivery.DeliveryId)
+	if delivery.Test {
+		request.Header.Set(constants.WEBHOOK_TEST_HEADER, "true")
+	}
+
+	// Sign delivery, so the receiver can check that it was sent by us
+	if delivery.Signature != "" {
+		request.Header.Set(constants.WEBHOOK_SIGNATURE_HEADER, delivery.Signature)
+	}
+
+	// Issue post request
+	response, err := getWebhookClient(constants.WEBHOOK_DELIVERY_TIMEOUT).Do(request)
+	if err != nil {
+		return structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "Post request to webhook url failed.")
+	}
+
+	// Close reponse body at end of function
+	defer response.Body.Close()
+	attempt.StatusCode = response.StatusCode
+
+	// Keep the start of the response, which often explains why a delivery was refused
+	excerpt, _ := io.ReadAll(io.LimitReader(response.Body, constants.MAX_RESPONSE_EXCERPT_SIZE))
+	attempt.Response = string(excerpt)
+
+	// Only 2xx responses count as delivered
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return structs.NewError(errors.New("webhook url responded with status "+response.Status), http.StatusBadGateway, constants.DEFAULT504, "")
+	}
+
+	return nil
+}
+
+/*
+Emails deliveries to the mailto: url of the webhook, through an SMTP server. Not enabled if Addr is empty.
+The payload is the body of the email, and the summary of the notification its subject.
+*/
+type SmtpNotifier struct {
+	Addr     string // Host and port of the SMTP server, such as smtp.example.com:587
+	From     string // Address deliveries are sent from
+	Username string // Username of the SMTP server, or empty if it needs no authentication
+	Password string
+}
+
+func (notifier SmtpNotifier) CheckTarget(target string) error {
+	if notifier.Addr == "" {
+		return channelNotEnabled(constants.WEBHOOK_CHANNEL_SMTP)
+	}
+
+	if _, err := parseMailtoUrl(target); err != nil {
+		return invalidWebhookUrl(err)
+	}
+
+	return nil
+}
+
+// Addresses can not echo challenges, so the challenge is emailed to the address, which must post it back to the verify endpoint
+func (notifier SmtpNotifier) Verify(target, webhookID, challenge string, secrets []string, headers http.Header) error {
+	to, err := parseMailtoUrl(target)
+	if err != nil {
+		return invalidWebhookUrl(err)
+	}
+
+	body, err := json.Marshal(structs.WebhookVerification{
+		Type:      constants.WEBHOOK_VERIFICATION_TYPE,
+		WebhookId: webhookID,
+		Challenge: challenge,
+	})
+	if err != nil {
+		return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "There was an error when encoding verification challenge.")
+	}
+
+	// Sign the challenge like deliveries, and send it with the steps to verify the address
+	now := time.Now()
+	delivery := structs.Delivery{
+		WebhookId:   webhookID,
+		DeliveryId:  constants.WEBHOOK_VERIFICATION_TYPE + "-" + webhookID,
+		Timestamp:   now,
+		Summary:     "Verify webhook " + webhookID,
+		ContentType: "text/plain",
+		Payload:     []byte(createVerificationEmail(webhookID, body)),
+	}
+	if len(secrets) > 0 {
+		delivery.Signature = CreateSignatureHeader(secrets, now.Unix(), delivery.Payload)
+	}
+
+	if err := notifier.send(to, delivery); err != nil {
+		return err
+	}
+
+	return ErrChallengeSent
+}
+
+func (notifier SmtpNotifier) Notify(target string, delivery structs.Delivery, attempt *structs.DeliveryAttempt) error {
+	to, err := parseMailtoUrl(target)
+	if err != nil {
+		return invalidWebhookUrl(err)
+	}
+
+	if err := notifier.send(to, delivery); err != nil {
+		return err
+	}
+	attempt.Response = "Accepted by SMTP server"
+
+	return nil
+}
+
+/*
+Sends a delivery as an email through the SMTP server
+
+	to			- Address to send to
+	delivery	- Delivery to send
+
+	return	- Error with status 502 if the SMTP server did not accept the email
+*/
+func (notifier SmtpNotifier) send(to string, delivery structs.Delivery) error {
+	host, _, err := net.SplitHostPort(notifier.Addr)
+	if err != nil {
+		return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Invalid address of SMTP server.")
+	}
+
+	conn, err := net.DialTimeout("tcp", notifier.Addr, constants.SMTP_TIMEOUT)
+	if err != nil {
+		return smtpFailed(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(constants.SMTP_TIMEOUT))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return smtpFailed(err)
+	}
+	defer client.Close()
+
+	// Encrypt the connection if the server supports it, which the server requires before authenticating
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return smtpFailed(err)
+		}
+	}
+	if notifier.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", notifier.Username, notifier.Password, host)); err != nil {
+			return smtpFailed(err)
+		}
+	}
+
+	if err := client.Mail(notifier.From); err != nil {
+		return smtpFailed(err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return smtpFailed(err)
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return smtpFailed(err)
+	}
+	if _, err := writer.Write(createEmail(notifier.From, to, delivery)); err != nil {
+		return smtpFailed(err)
+	}
+	if err := writer.Close(); err != nil {
+		return smtpFailed(err)
+	}
+
+	return client.Quit()
+}
+
+/*
+Creates the body of the email sent to verify the address of a webhook
+
+	webhookID	- ID of the webhook
+	body		- Json body to post to the verify endpoint, with the challenge
+
+	return	- The body of the email
+*/
+func createVerificationEmail(webhookID string, body []byte) string {
+	return "Webhook " + webhookID + " has been registered to email its deliveries to this address.\n\n" +
+		"To confirm that this address wants them, the owner of the webhook must post this body to " + constants.NOTIFICATION_PATH + webhookID + "/" + constants.WEBHOOK_VERIFY_PATH + ":\n\n" +
+		string(body) + "\n\n" +
+		"The challenge expires in " + strconv.Itoa(int(constants.WEBHOOK_VERIFICATION_CHALLENGE_TTL.Hours())) + " hours. " +
+		"No deliveries are sent until the address is verified, so this email can be ignored if the deliveries are not wanted.\n"
+}
+
+/*
+Get the address of a mailto: url
+
+	target	- URL of the webhook, such as mailto:alerts@example.com
+
+	return	- The address, or error if the url is not a mailto: url with a single address
+*/
+func parseMailtoUrl(target string) (string, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme != "mailto" || parsed.Opaque == "" {
+		return "", errors.New("webhook url must be a mailto: url for the smtp channel")
+	}
+
+	address, err := mail.ParseAddress(parsed.Opaque)
+	if err != nil || strings.Contains(parsed.Opaque, ",") {
+		return "", errors.New("webhook url must be a mailto: url with a single address")
+	}
+
+	return address.Address, nil
+}
+
+/*
+Creates an email of a delivery, with the headers of http deliveries
+
+	from		- Address the delivery is sent from
+	to			- Address of the webhook
+	delivery	- Delivery to send
+
+	return	- The email, with CRLF line endings
+*/
+func createEmail(from, to string, delivery structs.Delivery) []byte {
+	var email bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&email, "%s: %s\r\n", name, value)
+	}
+
+	header("From", from)
+	header("To", to)
+	header("Subject", mime.QEncoding.Encode("utf-8", delivery.Summary))
+	header("Date", delivery.Timestamp.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", delivery.ContentType+"; charset=utf-8")
+	header(constants.WEBHOOK_DELIVERY_HEADER, delivery.DeliveryId)
+	if delivery.Test {
+		header(constants.WEBHOOK_TEST_HEADER, "true")
+	}
+	if delivery.Signature != "" {
+		header(constants.WEBHOOK_SIGNATURE_HEADER, delivery.Signature)
+	}
+	email.WriteString("\r\n")
+
+	// Lines of the body must end with CRLF
+	email.WriteString(strings.ReplaceAll(strings.ReplaceAll(string(delivery.Payload), "\r\n", "\n"), "\n", "\r\n"))
+	email.WriteString("\r\n")
+
+	return email.Bytes()
+}
+
+// Deliveries are written to the file sink one at a time, so lines from concurrent deliveries are not mixed
+var fileSinkMutex sync.Mutex
+
+/*
+Appends deliveries to a file, one json object per line, for audit of the notifications sent.
+The file is set for the service, so webhooks of this channel have no url. Not enabled if Path is empty.
+*/
+type FileNotifier struct {
+	Path string // Path of the file, or WEBHOOK_FILE_SINK_STDOUT for stdout
+}
+
+func (notifier FileNotifier) CheckTarget(target string) error {
+	if notifier.Path == "" {
+		return channelNotEnabled(constants.WEBHOOK_CHANNEL_FILE)
+	}
+	if target != "" {
+		return invalidWebhookUrl(errors.New("webhooks of the file channel are written to the file sink of the service, and have no url"))
+	}
+
+	return nil
+}
+
+// The file sink is set for the service, so there is nothing to verify
+func (FileNotifier) Verify(target, webhookID, challenge string, secrets []string, headers http.Header) error {
+	return nil
+}
+
+func (notifier FileNotifier) Notify(target string, delivery structs.Delivery, attempt *structs.DeliveryAttempt) error {
+	record := structs.DeliveryRecord{
+		Timestamp:   delivery.Timestamp,
+		WebhookId:   delivery.WebhookId,
+		DeliveryId:  delivery.DeliveryId,
+		Test:        delivery.Test,
+		ContentType: delivery.ContentType,
+		Signature:   delivery.Signature,
+		Payload:     delivery.Payload,
+	}
+
+	// Keep payloads which are not json as a string, so each line is json
+	if !json.Valid(delivery.Payload) {
+		record.Payload, _ = json.Marshal(string(delivery.Payload))
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "There was an error when encoding delivery record.")
+	}
+
+	fileSinkMutex.Lock()
+	defer fileSinkMutex.Unlock()
+
+	if notifier.Path == constants.WEBHOOK_FILE_SINK_STDOUT {
+		_, err = os.Stdout.Write(append(line, '\n'))
+	} else {
+		err = appendToFile(notifier.Path, append(line, '\n'))
+	}
+	if err != nil {
+		return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not write delivery to file sink.")
+	}
+
+	return nil
+}
+
+/*
+Appends to a file, creating it if it does not exist
+*/
+func appendToFile(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+/*
+Returns the error for channels which are not configured on this service
+*/
+func channelNotEnabled(channel string) error {
+	return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "channel", "Invalid request body for registration of webhook, the "+channel+" channel is not enabled on this service", "")
+}
+
+/*
+Returns the error for deliveries which the SMTP server did not accept
+*/
+func smtpFailed(err error) error {
+	return structs.NewError(err, http.StatusBadGateway, constants.DEFAULT504, "SMTP server did not accept webhook delivery.")
+}
@@ -0,0 +1,269 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Mail received by the SMTP stand-in
+*/
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+/*
+Starts an SMTP server on a local port which accepts all mail, as a stand-in for the SMTP server of the service
+
+	return	- Address of the server, and a channel getting each mail received
+*/
+func startSmtpStandIn(t *testing.T) (string, chan receivedMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan receivedMail, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSmtp(conn, received)
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+/*
+Serves one SMTP session of the stand-in
+*/
+func serveSmtp(conn net.Conn, received chan receivedMail) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP stand-in")
+
+	var mail receivedMail
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			text.PrintfLine("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			mail.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			text.PrintfLine("250 OK")
+		case command == "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			mail.data = strings.Join(lines, "\n")
+			received <- mail
+			mail = receivedMail{}
+			text.PrintfLine("250 OK")
+		case command == "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+/*
+Replaces the notifier of a channel for the rest of a test
+*/
+func registerTestNotifier(t *testing.T, channel string, notifier Notifier) {
+	previous := notifiers[channel]
+	RegisterNotifier(channel, notifier)
+	t.Cleanup(func() { notifiers[channel] = previous })
+}
+
+/*
+Tests that the url of a webhook is checked by the notifier of its channel, and that channels not configured are refused
+*/
+func TestCheckWebhookTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		target  string
+		valid   bool
+	}{
+		{"http", "", "https://example.com/hook", true},
+		{"http to private address", constants.WEBHOOK_CHANNEL_HTTP, "http://10.0.0.1/hook", false},
+		{"unknown channel", "pigeon", "https://example.com/hook", false},
+		{"smtp not enabled", constants.WEBHOOK_CHANNEL_SMTP, "mailto:alerts@example.com", false},
+		{"file not enabled", constants.WEBHOOK_CHANNEL_FILE, "", false},
+	}
+	for _, test := range tests {
+		err := CheckWebhookTarget(test.channel, test.target)
+		assert.Equal(t, test.valid, err == nil, "Wrong validity for "+test.name)
+		if err != nil {
+			assert.Equal(t, http.StatusUnprocessableEntity, err.(structs.WrappedError).StatusCode, "Wrong status for "+test.name)
+		}
+	}
+
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_SMTP, SmtpNotifier{Addr: "localhost:25"})
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_FILE, FileNotifier{Path: constants.WEBHOOK_FILE_SINK_STDOUT})
+
+	tests = []struct {
+		name    string
+		channel string
+		target  string
+		valid   bool
+	}{
+		{"smtp", constants.WEBHOOK_CHANNEL_SMTP, "mailto:alerts@example.com", true},
+		{"smtp to http url", constants.WEBHOOK_CHANNEL_SMTP, "https://example.com/hook", false},
+		{"smtp to several addresses", constants.WEBHOOK_CHANNEL_SMTP, "mailto:a@example.com,b@example.com", false},
+		{"smtp to invalid address", constants.WEBHOOK_CHANNEL_SMTP, "mailto:alerts", false},
+		{"file", constants.WEBHOOK_CHANNEL_FILE, "", true},
+		{"file with url", constants.WEBHOOK_CHANNEL_FILE, "https://example.com/hook", false},
+	}
+	for _, test := range tests {
+		err := CheckWebhookTarget(test.channel, test.target)
+		assert.Equal(t, test.valid, err == nil, "Wrong validity for "+test.name)
+	}
+
+	assert.Nil(t, VerifyWebhookTarget(constants.WEBHOOK_CHANNEL_FILE, "", "TEST", "challenge", nil, nil), "Webhooks of the file sink should not need verification")
+}
+
+/*
+Tests that the challenge of email webhooks is emailed to the address, which must post it back, rather than the webhook being verified
+*/
+func TestSmtpNotifierVerify(t *testing.T) {
+	addr, received := startSmtpStandIn(t)
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_SMTP, SmtpNotifier{Addr: addr, From: "energy@example.com"})
+
+	err := VerifyWebhookTarget(constants.WEBHOOK_CHANNEL_SMTP, "mailto:alerts@example.com", "TEST", "CHALLENGE", []string{"SECRET"}, nil)
+	assert.ErrorIs(t, err, ErrChallengeSent, "Email webhooks should wait for the challenge to be posted back")
+
+	mail := <-received
+	assert.Equal(t, []string{"alerts@example.com"}, mail.to, "Wrong recipient")
+
+	header, body, _ := strings.Cut(mail.data, "\n\n")
+	assert.Contains(t, header, "Subject: Verify webhook TEST", "Subject should name the webhook")
+	assert.Contains(t, header, constants.WEBHOOK_SIGNATURE_HEADER+": t=", "Challenge should be signed")
+	assert.Contains(t, body, `"challenge":"CHALLENGE"`, "Body should have the challenge to post back")
+	assert.Contains(t, body, constants.NOTIFICATION_PATH+"TEST/"+constants.WEBHOOK_VERIFY_PATH, "Body should have the path to post the challenge to")
+
+	// The webhook can not be verified when the SMTP server does not accept the challenge
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_SMTP, SmtpNotifier{Addr: "127.0.0.1:1", From: "energy@example.com"})
+	err = VerifyWebhookTarget(constants.WEBHOOK_CHANNEL_SMTP, "mailto:alerts@example.com", "TEST", "CHALLENGE", nil, nil)
+	assert.NotNil(t, err, "Verification should fail")
+	assert.NotErrorIs(t, err, ErrChallengeSent, "Challenge should not be sent")
+}
+
+/*
+Tests that deliveries of the smtp channel are emailed, with the summary as subject and the headers of http deliveries
+*/
+func TestSmtpNotifier(t *testing.T) {
+	addr, received := startSmtpStandIn(t)
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_SMTP, SmtpNotifier{Addr: addr, From: "energy@example.com"})
+
+	data := map[string]interface{}{
+		"url":         "mailto:alerts@example.com",
+		"channel":     constants.WEBHOOK_CHANNEL_SMTP,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(5),
+		"secret":      "SECRET",
+	}
+	attempt, err := DeliverToWebhook(data, "TEST", "TEST-DELIVERY", "")
+	assert.Nil(t, err, "Delivery should be accepted by the SMTP server")
+	assert.Empty(t, attempt.Error, "Attempt should not have an error")
+
+	mail := <-received
+	assert.Equal(t, "energy@example.com", mail.from, "Wrong sender")
+	assert.Equal(t, []string{"alerts@example.com"}, mail.to, "Wrong recipient")
+
+	header, body, _ := strings.Cut(mail.data, "\n\n")
+	assert.Contains(t, header, "Subject: Webhook TEST: Renewables of any country were requested 5 times", "Subject should be the summary")
+	assert.Contains(t, header, "Content-Type: application/json", "Email should have the content type of the payload")
+	assert.Contains(t, header, constants.WEBHOOK_DELIVERY_HEADER+": TEST-DELIVERY", "Email should have the delivery ID")
+	assert.Contains(t, header, constants.WEBHOOK_SIGNATURE_HEADER+": t=", "Email should be signed")
+	assert.JSONEq(t, string(attempt.Payload), body, "Body should be the payload")
+
+	// Deliveries fail when the SMTP server can not be reached, so they are retried
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_SMTP, SmtpNotifier{Addr: "127.0.0.1:1", From: "energy@example.com"})
+	attempt, err = DeliverToWebhook(data, "TEST", "TEST-DELIVERY", "")
+	assert.NotNil(t, err, "Delivery should fail")
+	assert.Equal(t, http.StatusBadGateway, err.(structs.WrappedError).StatusCode, "Wrong status of failed delivery")
+	assert.NotEmpty(t, attempt.Error, "Attempt should have the error")
+}
+
+/*
+Tests that deliveries of the file channel are appended to the file sink as json lines
+*/
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deliveries.jsonl")
+	registerTestNotifier(t, constants.WEBHOOK_CHANNEL_FILE, FileNotifier{Path: path})
+
+	data := map[string]interface{}{
+		"url":         "",
+		"channel":     constants.WEBHOOK_CHANNEL_FILE,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(5),
+	}
+	_, err := DeliverToWebhook(data, "TEST", "FIRST", "")
+	assert.Nil(t, err, "Delivery should be written")
+
+	data["format"] = constants.WEBHOOK_FORMAT_SLACK
+	data["test"] = true
+	_, err = DeliverToWebhook(data, "TEST", "SECOND", "")
+	assert.Nil(t, err, "Delivery should be appended")
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []structs.DeliveryRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record structs.DeliveryRecord
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record), "Each line should be a delivery")
+		records = append(records, record)
+	}
+
+	assert.Len(t, records, 2, "Both deliveries should be in the file")
+	assert.Equal(t, "FIRST", records[0].DeliveryId, "Deliveries should be in order")
+	assert.JSONEq(t, `{"webhook_id":"TEST","calls":5}`, string(records[0].Payload), "Wrong payload")
+	assert.Equal(t, "SECOND", records[1].DeliveryId, "Deliveries should be in order")
+	assert.True(t, records[1].Test, "Test delivery should be marked")
+
+	// Payloads which are not json are kept as a string
+	delivery := structs.Delivery{WebhookId: "TEST", DeliveryId: "THIRD", ContentType: "text/plain", Payload: []byte("Renewables were requested")}
+	assert.Nil(t, FileNotifier{Path: path}.Notify("", delivery, &structs.DeliveryAttempt{}), "Delivery should be written")
+	content, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	var record structs.DeliveryRecord
+	assert.Nil(t, json.Unmarshal([]byte(lines[2]), &record), "Line should be a delivery")
+	assert.Equal(t, `"Renewables were requested"`, string(record.Payload), "Text payload should be a json string")
+}
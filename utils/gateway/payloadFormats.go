@@ -0,0 +1,557 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Functions templates may call. Others, such as printf, can render far more than the template in little time.
+var templateFuncs = map[string]bool{
+	"json": true, "len": true, "index": true, "slice": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"and": true, "or": true, "not": true,
+}
+
+// Returned by the writer of rendered templates when the payload gets too large, which stops the template
+var errPayloadTooLarge = fmt.Errorf("template must render at most %d bytes", constants.MAX_WEBHOOK_PAYLOAD_SIZE)
+
+/*
+Renders the payload of webhook deliveries in a format, chosen by the format field of the webhook
+*/
+type PayloadRenderer interface {
+	// Content type of the payloads rendered
+	ContentType() string
+	// Checks the template given with the format when a webhook is registered, by rendering a sample notification
+	Validate(tmpl string, sample structs.WebhookNotification) error
+	// Renders the payload of a notification
+	Render(notification structs.WebhookNotification, tmpl string) ([]byte, error)
+}
+
+// Renderers of each payload format. Other formats are added with RegisterPayloadRenderer.
+var payloadRenderers = map[string]PayloadRenderer{
+	constants.WEBHOOK_FORMAT_NATIVE:      nativeRenderer{},
+	constants.WEBHOOK_FORMAT_CLOUDEVENTS: cloudEventsRenderer{},
+	constants.WEBHOOK_FORMAT_SLACK:       slackRenderer{},
+	constants.WEBHOOK_FORMAT_TEMPLATE:    templateRenderer{},
+}
+
+/*
+Adds a payload format, or replaces the renderer of an existing one. Must be called before the server starts, such as in main or init.
+
+	format		- Name of the format, as given in the format field of webhooks
+	renderer	- Renderer of the format
+*/
+func RegisterPayloadRenderer(format string, renderer PayloadRenderer) {
+	payloadRenderers[format] = renderer
+}
+
+/*
+Checks that the payload format of a webhook exists, and that its template renders
+
+	format	- Payload format, or empty for the native format
+	tmpl	- Template given with the format, or empty
+	trigger	- Trigger of the webhook, which decides the notifications the template is rendered with
+
+	return	- Error with status 422 if the format does not exist or the template does not render
+*/
+func CheckPayloadFormat(format, tmpl, trigger string) error {
+	renderer, err := getPayloadRenderer(format)
+	if err != nil {
+		return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "format", "Invalid request body for registration of webhook, format must be one of "+strings.Join(payloadFormats(), ", "), "")
+	}
+
+	if err := renderer.Validate(tmpl, createSampleNotification(trigger)); err != nil {
+		return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "template", "Invalid request body for registration of webhook, "+err.Error(), "")
+	}
+
+	return nil
+}
+
+/*
+Renders a notification in the payload format of a webhook
+
+	notification	- Notification to render
+	format			- Payload format, or empty for the native format
+	tmpl			- Template given with the format, or empty
+
+	return	- The payload and its content type
+*/
+func renderPayload(notification structs.WebhookNotification, format, tmpl string) ([]byte, string, error) {
+	renderer, err := getPayloadRenderer(format)
+	if err != nil {
+		return nil, "", structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not render webhook payload.")
+	}
+
+	payload, err := renderer.Render(notification, tmpl)
+	if err != nil {
+		return nil, "", structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "There was an error when encoding webhook payload.")
+	}
+
+	return payload, renderer.ContentType(), nil
+}
+
+/*
+Get the renderer of a payload format, where empty is the native format
+*/
+func getPayloadRenderer(format string) (PayloadRenderer, error) {
+	if format == "" {
+		format = constants.WEBHOOK_FORMAT_NATIVE
+	}
+
+	renderer, ok := payloadRenderers[format]
+	if !ok {
+		return nil, errors.New("unknown payload format " + format)
+	}
+
+	return renderer, nil
+}
+
+/*
+Get the names of all payload formats, sorted
+*/
+func payloadFormats() []string {
+	var formats []string
+	for format := range payloadRenderers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	return formats
+}
+
+/*
+Creates a notification like the ones a webhook with the trigger gets, for checking templates
+*/
+func createSampleNotification(trigger string) structs.WebhookNotification {
+	threshold := 50.0
+	notification := structs.WebhookNotification{
+		WebhookId:  "BOlOomFOeiKvZhVD",
+		DeliveryId: "3f0c9e4b7a2f1d5e8c6b0a9f7e6d5c4b",
+		Trigger:    constants.WEBHOOK_TRIGGER_CALLS,
+		Timestamp:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Data:       structs.Webhook{WebhookId: "BOlOomFOeiKvZhVD", Country: "Norway", Calls: 5, Year: 2020},
+	}
+
+	if trigger == constants.WEBHOOK_TRIGGER_RATE {
+		notification.Trigger = trigger
+		notification.Data = structs.RateNotification{WebhookId: notification.WebhookId, Trigger: trigger, Country: "Norway", Calls: 101, Window: "1h0m0s"}
+	}
+
+	if trigger == constants.WEBHOOK_TRIGGER_DIGEST {
+		from := notification.Timestamp.Add(-time.Hour)
+		notification.Trigger = trigger
+		notification.Data = structs.DigestNotification{
+			WebhookId:    notification.WebhookId,
+			Trigger:      trigger,
+			Schedule:     constants.WEBHOOK_SCHEDULE_HOURLY,
+			From:         &from,
+			To:           notification.Timestamp,
+			Invocations:  []structs.DigestInvocation{{IsoCode: "NOR", YearFrom: 2020, YearTo: 2020, Calls: 12}},
+			TopCountries: []structs.DigestCountry{{IsoCode: "NOR", Calls: 12}},
+			Changes:      []structs.DatasetChange{{IsoCode: "NOR", Country: "Norway", YearsAdded: []int{2022}}},
+		}
+	}
+
+	if trigger == constants.WEBHOOK_TRIGGER_DATASET {
+		notification.Trigger = trigger
+		notification.Data = structs.DatasetUpdateNotification{
+			WebhookId: notification.WebhookId,
+			Trigger:   trigger,
+			Changes: []structs.DatasetChange{
+				{IsoCode: "NOR", Country: "Norway", YearsAdded: []int{2022}, YearsChanged: []int{2020, 2021}},
+			},
+		}
+	}
+
+	if trigger == constants.WEBHOOK_TRIGGER_THRESHOLD {
+		notification.Trigger = trigger
+		notification.Data = structs.ThresholdNotification{
+			WebhookId: notification.WebhookId,
+			Trigger:   trigger,
+			Country:   "Norway",
+			IsoCode:   "NOR",
+			Year:      2020,
+			OldValue:  49.5,
+			NewValue:  51.2,
+			Threshold: &threshold,
+		}
+	}
+
+	return notification
+}
+
+/*
+Renders the native payload as json, which is the webhook or threshold notification
+*/
+type nativeRenderer struct{}
+
+func (nativeRenderer) ContentType() string {
+	return constants.CONT_TYPE_JSON
+}
+
+func (nativeRenderer) Validate(tmpl string, sample structs.WebhookNotification) error {
+	return noTemplate(tmpl)
+}
+
+func (nativeRenderer) Render(notification structs.WebhookNotification, tmpl string) ([]byte, error) {
+	return json.Marshal(notification.Data)
+}
+
+/*
+Renders CloudEvents 1.0 in structured json, with the native payload as data
+*/
+type cloudEventsRenderer struct{}
+
+func (cloudEventsRenderer) ContentType() string {
+	return constants.CONT_TYPE_CLOUDEVENTS_JSON
+}
+
+func (cloudEventsRenderer) Validate(tmpl string, sample structs.WebhookNotification) error {
+	return noTemplate(tmpl)
+}
+
+func (cloudEventsRenderer) Render(notification structs.WebhookNotification, tmpl string) ([]byte, error) {
+	// The delivery ID is the same for every attempt, so receivers can use the event ID to ignore duplicates
+	return json.Marshal(structs.CloudEvent{
+		SpecVersion:     "1.0",
+		Type:            constants.CLOUDEVENTS_TYPE_PREFIX + notification.Trigger,
+		Source:          constants.NOTIFICATION_PATH + notification.WebhookId,
+		Id:              notification.DeliveryId,
+		Time:            notification.Timestamp.UTC(),
+		DataContentType: constants.CONT_TYPE_JSON,
+		Data:            notification.Data,
+	})
+}
+
+/*
+Renders a Slack or Mattermost message, with a summary of the notification
+*/
+type slackRenderer struct{}
+
+func (slackRenderer) ContentType() string {
+	return constants.CONT_TYPE_JSON
+}
+
+func (slackRenderer) Validate(tmpl string, sample structs.WebhookNotification) error {
+	return noTemplate(tmpl)
+}
+
+func (slackRenderer) Render(notification structs.WebhookNotification, tmpl string) ([]byte, error) {
+	summary := summarizeNotification(notification)
+
+	return json.Marshal(structs.SlackMessage{
+		Text: summary,
+		Blocks: []structs.SlackBlock{
+			{Type: "section", Text: &structs.SlackText{Type: "mrkdwn", Text: summary}},
+		},
+	})
+}
+
+/*
+Renders a Go text/template given at registration, executed with the notification.
+The function json encodes a value, so strings can be put into the json safely.
+Templates are given by clients and run by the delivery workers, so they are limited to actions which end quickly, see checkTemplateNode.
+*/
+type templateRenderer struct{}
+
+func (templateRenderer) ContentType() string {
+	return constants.CONT_TYPE_JSON
+}
+
+func (r templateRenderer) Validate(tmpl string, sample structs.WebhookNotification) error {
+	if tmpl == "" {
+		return errors.New("template must be given for the " + constants.WEBHOOK_FORMAT_TEMPLATE + " format")
+	}
+	if len(tmpl) > constants.MAX_WEBHOOK_TEMPLATE_SIZE {
+		return fmt.Errorf("template must be at most %d characters", constants.MAX_WEBHOOK_TEMPLATE_SIZE)
+	}
+
+	_, err := r.Render(sample, tmpl)
+	return err
+}
+
+func (templateRenderer) Render(notification structs.WebhookNotification, tmpl string) ([]byte, error) {
+	parsed, err := template.New("payload").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"json": templateJson}).
+		Parse(tmpl)
+	if err != nil {
+		return nil, errors.New("template could not be parsed: " + err.Error())
+	}
+
+	// Templates stored before they were limited are checked as well, as they are rendered by the delivery workers
+	if len(parsed.Templates()) > 1 {
+		return nil, errors.New("template must not define other templates")
+	}
+	if err := checkTemplateNode(parsed.Tree.Root, reflect.ValueOf(notification)); err != nil {
+		return nil, err
+	}
+
+	// Payloads are recorded in the delivery history as json, and receivers expect json
+	payload := limitedBuffer{limit: constants.MAX_WEBHOOK_PAYLOAD_SIZE}
+	if err := parsed.Execute(&payload, notification); err != nil {
+		if errors.Is(err, errPayloadTooLarge) {
+			return nil, errPayloadTooLarge
+		}
+		return nil, errors.New("template could not be rendered: " + err.Error())
+	}
+	if !json.Valid(payload.Bytes()) {
+		return nil, errors.New("template must render valid json")
+	}
+
+	return payload.Bytes(), nil
+}
+
+/*
+Checks that a parsed template only has actions which end quickly. Range and with only move dot to a field of dot,
+so dot never moves back up, and range only goes over lists and maps of the notification, not over numbers.
+Templates can not call other templates, and can only call the functions in templateFuncs.
+
+	node	- Node of the parsed template, starting with the root
+	dot		- Value of dot at the node, which is the notification at the root, or a zero value of its type within range
+
+	return	- Error if the template has an action which is not allowed
+*/
+func checkTemplateNode(node parse.Node, dot reflect.Value) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := checkTemplateNode(child, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkTemplatePipe(node.Pipe)
+	case *parse.IfNode:
+		if err := checkTemplatePipe(node.Pipe); err != nil {
+			return err
+		}
+		return checkTemplateLists(node.BranchNode, dot, dot)
+	case *parse.WithNode:
+		field, err := getTemplateField(node.Pipe, dot)
+		if err != nil {
+			return err
+		}
+		return checkTemplateLists(node.BranchNode, field, dot)
+	case *parse.RangeNode:
+		field, err := getTemplateField(node.Pipe, dot)
+		if err != nil {
+			return err
+		}
+		if kind := field.Kind(); kind != reflect.Slice && kind != reflect.Array && kind != reflect.Map {
+			return errors.New("template must only use range on a list, such as {{range .Data.Changes}}")
+		}
+		return checkTemplateLists(node.BranchNode, reflect.Zero(field.Type().Elem()), dot)
+	case *parse.TemplateNode:
+		return errors.New("template must not call other templates")
+	case *parse.TextNode, *parse.CommentNode, *parse.BreakNode, *parse.ContinueNode:
+		return nil
+	default:
+		return errors.New("template must not use " + node.String())
+	}
+
+	return nil
+}
+
+/*
+Checks the list of an if, range or with action, and its else list, where dot is not moved
+*/
+func checkTemplateLists(branch parse.BranchNode, dot reflect.Value, elseDot reflect.Value) error {
+	if err := checkTemplateNode(branch.List, dot); err != nil {
+		return err
+	}
+	return checkTemplateNode(branch.ElseList, elseDot)
+}
+
+/*
+Get the value of the field a range or with action moves dot to
+
+	pipe	- Pipeline of the action, which must be a field of dot such as .Data.Changes
+	dot		- Value of dot at the action
+
+	return	- Value of the field, or error if the pipeline is not a field of dot
+*/
+func getTemplateField(pipe *parse.PipeNode, dot reflect.Value) (reflect.Value, error) {
+	notField := errors.New("template must only use range and with on a field, such as {{range .Data.Changes}}")
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return reflect.Value{}, notField
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok {
+		return reflect.Value{}, notField
+	}
+
+	value := dot
+	for _, name := range field.Ident {
+		// Data is an interface, and fields may be pointers, which are followed to the value they hold or the zero value of their type
+		for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+			if value.IsNil() && value.Kind() == reflect.Pointer {
+				value = reflect.Zero(value.Type().Elem())
+			} else {
+				value = value.Elem()
+			}
+		}
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, errors.New("template must only use range and with on fields of the notification, not ." + name)
+		}
+		value = value.FieldByName(name)
+		if !value.IsValid() {
+			return reflect.Value{}, errors.New("template must only use range and with on fields of the notification, not ." + name)
+		}
+	}
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			value = reflect.Zero(value.Type().Elem())
+		} else {
+			value = value.Elem()
+		}
+	}
+
+	return value, nil
+}
+
+/*
+Checks that a pipeline only calls the functions in templateFuncs
+*/
+func checkTemplatePipe(pipe *parse.PipeNode) error {
+	if pipe == nil {
+		return nil
+	}
+
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			if err := checkTemplateArg(arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+Checks that an argument of a pipeline, and the pipelines within it, only call the functions in templateFuncs
+*/
+func checkTemplateArg(arg parse.Node) error {
+	switch arg := arg.(type) {
+	case *parse.IdentifierNode:
+		if !templateFuncs[arg.Ident] {
+			return errors.New("template must not call " + arg.Ident)
+		}
+	case *parse.PipeNode:
+		return checkTemplatePipe(arg)
+	case *parse.ChainNode:
+		return checkTemplateArg(arg.Node)
+	}
+
+	return nil
+}
+
+/*
+Buffer of a rendered template, which fails writes once the payload is larger than the limit, so the template stops
+*/
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (buffer *limitedBuffer) Write(p []byte) (int, error) {
+	if buffer.Len()+len(p) > buffer.limit {
+		return 0, errPayloadTooLarge
+	}
+	return buffer.Buffer.Write(p)
+}
+
+/*
+Json encodes a value in a template
+*/
+func templateJson(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+/*
+Returns an error if a template is given for a format which takes none
+*/
+func noTemplate(tmpl string) error {
+	if tmpl != "" {
+		return errors.New("template can only be given for the " + constants.WEBHOOK_FORMAT_TEMPLATE + " format")
+	}
+	return nil
+}
+
+/*
+Creates a message summarizing a notification, for chat messages
+*/
+func summarizeNotification(notification structs.WebhookNotification) string {
+	if notification.Test {
+		return "[Test] " + summarizeData(notification)
+	}
+	return summarizeData(notification)
+}
+
+/*
+Creates a message summarizing the data of a notification
+*/
+func summarizeData(notification structs.WebhookNotification) string {
+	switch data := notification.Data.(type) {
+	case structs.ThresholdNotification:
+		return fmt.Sprintf("Webhook %s: The renewables share of %s in %d changed from %.2f%% to %.2f%%",
+			notification.WebhookId, data.Country, data.Year, data.OldValue, data.NewValue)
+	case structs.RateNotification:
+		country := data.Country
+		if country == "" {
+			country = "any country"
+		}
+		return fmt.Sprintf("Webhook %s: Renewables of %s were requested %d times in %s", notification.WebhookId, country, data.Calls, data.Window)
+	case structs.DigestNotification:
+		calls := 0
+		for _, invocation := range data.Invocations {
+			calls += invocation.Calls
+		}
+		summary := fmt.Sprintf("Webhook %s: %s digest with %d requests", notification.WebhookId, data.Schedule, calls)
+		if len(data.TopCountries) > 0 {
+			top := make([]string, 0, len(data.TopCountries))
+			for _, country := range data.TopCountries {
+				top = append(top, fmt.Sprintf("%s (%d)", country.IsoCode, country.Calls))
+			}
+			summary += ", most for " + strings.Join(top, ", ")
+		}
+		if len(data.Changes) > 0 {
+			summary += fmt.Sprintf(", and data of %d countries updated", len(data.Changes))
+		}
+		return summary
+	case structs.DatasetUpdateNotification:
+		countries := make([]string, 0, len(data.Changes))
+		for _, change := range data.Changes {
+			countries = append(countries, change.Country)
+		}
+		return fmt.Sprintf("Webhook %s: The renewables data of %s was updated", notification.WebhookId, strings.Join(countries, ", "))
+	case structs.Webhook:
+		country := data.Country
+		if country == "" {
+			country = "any country"
+		}
+		summary := fmt.Sprintf("Webhook %s: Renewables of %s were requested %d times", notification.WebhookId, country, data.Calls)
+		if data.Year > 0 {
+			summary += fmt.Sprintf(" for %d", data.Year)
+		}
+		return summary
+	default:
+		return "Webhook " + notification.WebhookId + " was fired"
+	}
+}
@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Notification of a webhook fired by calls, rendered in each test
+var testNotification = structs.WebhookNotification{
+	WebhookId:  "TEST",
+	DeliveryId: "TEST-DELIVERY",
+	Trigger:    constants.WEBHOOK_TRIGGER_CALLS,
+	Timestamp:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	Data:       structs.Webhook{WebhookId: "TEST", Country: "Norway", Calls: 5},
+}
+
+/*
+Tests the payloads rendered by each built-in format
+*/
+func TestRenderPayload(t *testing.T) {
+	payload, contentType, err := renderPayload(testNotification, "", "")
+	assert.Nil(t, err, "Native format should render")
+	assert.Equal(t, constants.CONT_TYPE_JSON, contentType, "Wrong content type of native format")
+	assert.JSONEq(t, `{"webhook_id":"TEST","country":"Norway","calls":5}`, string(payload), "Native format should be the webhook")
+
+	payload, contentType, err = renderPayload(testNotification, constants.WEBHOOK_FORMAT_CLOUDEVENTS, "")
+	assert.Nil(t, err, "CloudEvents format should render")
+	assert.Equal(t, constants.CONT_TYPE_CLOUDEVENTS_JSON, contentType, "Wrong content type of CloudEvents format")
+	assert.JSONEq(t, `{
+		"specversion": "1.0",
+		"type": "no.energy.webhook.calls",
+		"source": "/energy/v1/notifications/TEST",
+		"id": "TEST-DELIVERY",
+		"time": "2024-01-01T12:00:00Z",
+		"datacontenttype": "application/json",
+		"data": {"webhook_id":"TEST","country":"Norway","calls":5}
+	}`, string(payload), "Wrong CloudEvent")
+
+	payload, _, err = renderPayload(testNotification, constants.WEBHOOK_FORMAT_SLACK, "")
+	assert.Nil(t, err, "Slack format should render")
+	var message structs.SlackMessage
+	assert.Nil(t, json.Unmarshal(payload, &message), "Slack message should be json")
+	assert.Equal(t, "Webhook TEST: Renewables of Norway were requested 5 times", message.Text, "Wrong text of Slack message")
+	assert.Equal(t, message.Text, message.Blocks[0].Text.Text, "Blocks should have the text")
+
+	payload, _, err = renderPayload(testNotification, constants.WEBHOOK_FORMAT_TEMPLATE, `{"id": {{json .DeliveryId}}, "country": {{json .Data.Country}}, "calls": {{.Data.Calls}}}`)
+	assert.Nil(t, err, "Template format should render")
+	assert.JSONEq(t, `{"id":"TEST-DELIVERY","country":"Norway","calls":5}`, string(payload), "Wrong rendered template")
+
+	_, _, err = renderPayload(testNotification, "unknown", "")
+	assert.NotNil(t, err, "Unknown format should not render")
+}
+
+/*
+Tests that formats and templates are checked when a webhook is registered
+*/
+func TestCheckPayloadFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		tmpl    string
+		trigger string
+		valid   bool
+	}{
+		{"no format", "", "", "", true},
+		{"slack", constants.WEBHOOK_FORMAT_SLACK, "", constants.WEBHOOK_TRIGGER_CALLS, true},
+		{"unknown format", "xml", "", "", false},
+		{"template without format", "", `{"a": 1}`, "", false},
+		{"format without template", constants.WEBHOOK_FORMAT_TEMPLATE, "", "", false},
+		{"template", constants.WEBHOOK_FORMAT_TEMPLATE, `{"calls": {{.Data.Calls}}}`, constants.WEBHOOK_TRIGGER_CALLS, true},
+		{"threshold template", constants.WEBHOOK_FORMAT_TEMPLATE, `{"value": {{.Data.NewValue}}}`, constants.WEBHOOK_TRIGGER_THRESHOLD, true},
+		{"dataset template", constants.WEBHOOK_FORMAT_TEMPLATE, `{"countries": {{len .Data.Changes}}}`, constants.WEBHOOK_TRIGGER_DATASET, true},
+		{"digest template", constants.WEBHOOK_FORMAT_TEMPLATE, `{"top": {{json (index .Data.TopCountries 0).IsoCode}}}`, constants.WEBHOOK_TRIGGER_DIGEST, true},
+		{"field of other trigger", constants.WEBHOOK_FORMAT_TEMPLATE, `{"value": {{.Data.NewValue}}}`, constants.WEBHOOK_TRIGGER_CALLS, false},
+		{"unparsable template", constants.WEBHOOK_FORMAT_TEMPLATE, `{"calls": {{.Data.Calls}`, "", false},
+		{"template rendering other than json", constants.WEBHOOK_FORMAT_TEMPLATE, `calls: {{.Data.Calls}}`, "", false},
+		{"range over list", constants.WEBHOOK_FORMAT_TEMPLATE, `[{{range $i, $change := .Data.Changes}}{{if $i}},{{end}}{"country": {{json .IsoCode}}, "years": [{{range $j, $year := .YearsAdded}}{{if $j}},{{end}}{{$year}}{{end}}]}{{end}}]`, constants.WEBHOOK_TRIGGER_DATASET, true},
+		{"with field", constants.WEBHOOK_FORMAT_TEMPLATE, `{{with .Data}}{"calls": {{.Calls}}}{{end}}`, constants.WEBHOOK_TRIGGER_CALLS, true},
+		{"range over number", constants.WEBHOOK_FORMAT_TEMPLATE, `{{range .Data.Calls}}{{end}}{}`, constants.WEBHOOK_TRIGGER_CALLS, false},
+		{"range over constant", constants.WEBHOOK_FORMAT_TEMPLATE, `{{range 1000000000}}{{range 1000000000}}{{end}}{{end}}{}`, "", false},
+		{"range over variable", constants.WEBHOOK_FORMAT_TEMPLATE, `{{range $.Data.Changes}}{{end}}{}`, constants.WEBHOOK_TRIGGER_DATASET, false},
+		{"with variable", constants.WEBHOOK_FORMAT_TEMPLATE, `{{range .Data.Changes}}{{with $}}{{end}}{{end}}{}`, constants.WEBHOOK_TRIGGER_DATASET, false},
+		{"define", constants.WEBHOOK_FORMAT_TEMPLATE, `{{define "loop"}}{{end}}{}`, "", false},
+		{"block", constants.WEBHOOK_FORMAT_TEMPLATE, `{{block "loop" .}}{{end}}{}`, "", false},
+		{"template call", constants.WEBHOOK_FORMAT_TEMPLATE, `{{template "payload" .}}`, "", false},
+		{"printf", constants.WEBHOOK_FORMAT_TEMPLATE, `{"calls": {{printf "%099999999d" .Data.Calls}}}`, constants.WEBHOOK_TRIGGER_CALLS, false},
+	}
+
+	for _, test := range tests {
+		err := CheckPayloadFormat(test.format, test.tmpl, test.trigger)
+		assert.Equal(t, test.valid, err == nil, "Wrong validity for "+test.name)
+		if err != nil {
+			assert.Equal(t, http.StatusUnprocessableEntity, err.(structs.WrappedError).StatusCode, "Wrong status for "+test.name)
+		}
+	}
+}
+
+/*
+Tests that templates stop once their payload is larger than the max size, rather than after rendering all of it
+*/
+func TestTemplatePayloadSize(t *testing.T) {
+	notification := structs.WebhookNotification{
+		WebhookId: "TEST",
+		Trigger:   constants.WEBHOOK_TRIGGER_DATASET,
+		Data:      structs.DatasetUpdateNotification{Changes: make([]structs.DatasetChange, 100)},
+	}
+	tmpl := `[{{range .Data.Changes}}"` + strings.Repeat("x", 1000) + `",{{end}}""]`
+
+	_, err := templateRenderer{}.Render(notification, tmpl)
+	assert.Equal(t, errPayloadTooLarge, err, "Template rendering more than the max size should fail")
+
+	notification.Data = structs.DatasetUpdateNotification{Changes: make([]structs.DatasetChange, 10)}
+	payload, err := templateRenderer{}.Render(notification, tmpl)
+	assert.Nil(t, err, "Template rendering less than the max size should render")
+	assert.True(t, json.Valid(payload), "Payload should be json")
+}
+
+/*
+Renderer of a format added in the test
+*/
+type textRenderer struct{}
+
+func (textRenderer) ContentType() string {
+	return "text/plain"
+}
+
+func (textRenderer) Validate(tmpl string, sample structs.WebhookNotification) error {
+	return noTemplate(tmpl)
+}
+
+func (textRenderer) Render(notification structs.WebhookNotification, tmpl string) ([]byte, error) {
+	return []byte(`"` + summarizeNotification(notification) + `"`), nil
+}
+
+/*
+Tests that formats can be added, and that deliveries are sent with the content type of their format
+*/
+func TestRegisterPayloadRenderer(t *testing.T) {
+	RegisterPayloadRenderer("text", textRenderer{})
+	defer delete(payloadRenderers, "text")
+
+	assert.Nil(t, CheckPayloadFormat("text", "", ""), "Added format should be valid")
+
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("content-type")
+	}))
+	defer ts.Close()
+
+	data := map[string]interface{}{
+		"url":         ts.URL,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(5),
+		"format":      "text",
+	}
+	attempt, err := DeliverToWebhook(data, "TEST", "TEST-DELIVERY", ts.URL)
+	assert.Nil(t, err, "Delivery should succeed")
+	assert.Equal(t, "text/plain", contentType, "Delivery should have the content type of the format")
+	assert.Equal(t, `"Webhook TEST: Renewables of any country were requested 5 times"`, string(attempt.Payload), "Wrong payload")
+}
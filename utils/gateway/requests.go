@@ -3,9 +3,9 @@ package gateway
 import (
 	"assignment2/utils/constants"
 	"assignment2/utils/structs"
-	"bytes"
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
 /*
@@ -13,11 +13,15 @@ Responds to GET request with JSON content and body specified
 
 	w			- Responsewriter
 	jsonBody	- Any struct which will be encoded into json and sent as response body
+	status		- Status code of the response
 */
 func RespondToGetRequestWithJSON(w http.ResponseWriter, jsonBody interface{}, status int) error {
 	// Write to content type field in response header
 	w.Header().Add("content-type", constants.CONT_TYPE_JSON)
 
+	// Write status code given
+	w.WriteHeader(status)
+
 	// Encode content and write to response
 	err := json.NewEncoder(w).Encode(jsonBody)
 	if err != nil {
@@ -27,6 +31,29 @@ func RespondToGetRequestWithJSON(w http.ResponseWriter, jsonBody interface{}, st
 	return nil
 }
 
+/*
+Responds with RFC 7807 problem details
+
+	w		- Responsewriter
+	problem	- Problem details to encode into json and send as response body
+*/
+func RespondWithProblem(w http.ResponseWriter, problem structs.Problem) error {
+	// Write to content type field in response header, replacing any content type already set
+	w.Header().Set("content-type", constants.CONT_TYPE_PROBLEM_JSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Write status code of problem
+	w.WriteHeader(problem.Status)
+
+	// Encode problem and write to response
+	err := json.NewEncoder(w).Encode(problem)
+	if err != nil {
+		return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "There was an error when encoding problem details.")
+	}
+
+	return nil
+}
+
 /*
 Create a request and returns response from a specified URL using specified method
 
@@ -63,32 +90,195 @@ func HttpRequestFromUrl(url string, method string) (http.Response, error) {
 }
 
 /*
-Post content given to webhookURL
+Send a delivery to a webhook, through the notifier of its channel. Errors are returned, so that the caller can retry failed deliveries.
 
-	data		- Map of webhook data
-	webhookID	- ID of webhook
+	data			- Map of webhook data, with test set to true for test deliveries
+	webhookID		- ID of webhook
+	deliveryID		- ID of delivery, which is the same for every attempt so receivers can ignore duplicates
+	countriesApiUrl	- URL of restcountries API, used for finding the name of the country
+
+	return	- The attempt with timestamp, payload, status code, latency, response excerpt and error, and error if the delivery failed
 */
-func PostToWebhook(data map[string]interface{}, webhookID, countriesApiUrl string) {
+func DeliverToWebhook(data map[string]interface{}, webhookID, deliveryID, countriesApiUrl string) (structs.DeliveryAttempt, error) {
+	attempt := structs.DeliveryAttempt{
+		DeliveryId: deliveryID,
+		Timestamp:  time.Now(),
+	}
+
+	// Record the error of failed attempts
+	fail := func(err error) (structs.DeliveryAttempt, error) {
+		attempt.LatencyMs = time.Since(attempt.Timestamp).Milliseconds()
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+
+	// Create payload in the format of the webhook
+	delivery, err := createDelivery(data, webhookID, deliveryID, attempt.Timestamp, countriesApiUrl)
+	if err != nil {
+		return fail(err)
+	}
+	attempt.Payload = delivery.Payload
+
+	// Webhooks registered before channels were added are posted to their url
+	channel, _ := data["channel"].(string)
+	notifier, err := getNotifier(channel)
+	if err != nil {
+		return fail(structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not send webhook delivery."))
+	}
+
+	// Check the target again, as it may have been registered before targets were checked, or its channel disabled since
+	target, _ := data["url"].(string)
+	if err := notifier.CheckTarget(target); err != nil {
+		return fail(err)
+	}
+
+	// Sign delivery, so the receiver can check that it was sent by us
+	if secrets := GetSigningSecrets(data, attempt.Timestamp); len(secrets) > 0 {
+		delivery.Signature = CreateSignatureHeader(secrets, attempt.Timestamp.Unix(), delivery.Payload)
+	}
+
+	// Send the outbound headers and credentials the webhook has now, so changes apply to retries
+	delivery.Headers, err = GetOutboundHeaders(data)
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := notifier.Notify(target, delivery, &attempt); err != nil {
+		return fail(err)
+	}
+
+	attempt.LatencyMs = time.Since(attempt.Timestamp).Milliseconds()
+	return attempt, nil
+}
+
+/*
+Creates a webhook delivery, with the body in the payload format of the webhook. The signature and outbound headers are set when it is sent.
+
+	data			- Map of webhook data, with the format and template of the webhook, and test set to true for test deliveries
+	webhookID		- ID of webhook
+	deliveryID		- ID of the delivery
+	now				- Time the delivery is sent at
+	countriesApiUrl	- URL of restcountries API, used for finding the name of the country
+
+	return	- The delivery with its summary, encoded body and content type, or error if the country could not be found or the payload not rendered
+*/
+func createDelivery(data map[string]interface{}, webhookID, deliveryID string, now time.Time, countriesApiUrl string) (structs.Delivery, error) {
+	notification, err := createNotification(data, webhookID, deliveryID, now, countriesApiUrl)
+	if err != nil {
+		return structs.Delivery{}, err
+	}
+
+	// Webhooks registered before formats were added have the native format
+	format, _ := data["format"].(string)
+	tmpl, _ := data["template"].(string)
+	payload, contentType, err := renderPayload(notification, format, tmpl)
+	if err != nil {
+		return structs.Delivery{}, err
+	}
+
+	delivery := structs.Delivery{
+		WebhookId:   webhookID,
+		DeliveryId:  deliveryID,
+		Timestamp:   now,
+		Summary:     summarizeNotification(notification),
+		ContentType: contentType,
+		Payload:     payload,
+	}
+	delivery.Test, _ = data["test"].(bool)
+
+	return delivery, nil
+}
+
+/*
+Creates the notification of a webhook delivery, with the native payload as data
+
+	data			- Map of webhook data
+	webhookID		- ID of webhook
+	deliveryID		- ID of the delivery
+	now				- Time the delivery is sent at
+	countriesApiUrl	- URL of restcountries API, used for finding the name of the country
+
+	return	- The notification, or error if the country could not be found
+*/
+func createNotification(data map[string]interface{}, webhookID, deliveryID string, now time.Time, countriesApiUrl string) (structs.WebhookNotification, error) {
+	notification := structs.WebhookNotification{
+		WebhookId:  webhookID,
+		DeliveryId: deliveryID,
+		Trigger:    constants.WEBHOOK_TRIGGER_CALLS,
+		Timestamp:  now,
+	}
+	notification.Test, _ = data["test"].(bool)
+
+	// Deliveries to digest webhooks have the requests and changes since the last digest
+	if event, ok := data["event"].(map[string]interface{}); ok && event["type"] == constants.WEBHOOK_TRIGGER_DIGEST {
+		digestNotification := createDigestNotification(event, webhookID)
+		digestNotification.Test = notification.Test
+		notification.Trigger = constants.WEBHOOK_TRIGGER_DIGEST
+		notification.Data = digestNotification
+		return notification, nil
+	}
+
+	// Deliveries to dataset webhooks have the changes of the import which fired them
+	if event, ok := data["event"].(map[string]interface{}); ok && event["type"] == constants.WEBHOOK_TRIGGER_DATASET {
+		datasetNotification := createDatasetNotification(event, webhookID)
+		datasetNotification.Test = notification.Test
+		notification.Trigger = constants.WEBHOOK_TRIGGER_DATASET
+		notification.Data = datasetNotification
+		return notification, nil
+	}
+
+	// Deliveries to threshold webhooks have the event which fired them, which has no type as it was the first kind of event
+	if event, ok := data["event"].(map[string]interface{}); ok && event["type"] == nil {
+		thresholdNotification := createThresholdNotification(event, webhookID)
+		thresholdNotification.Test = notification.Test
+		notification.Trigger = constants.WEBHOOK_TRIGGER_THRESHOLD
+		notification.Data = thresholdNotification
+		return notification, nil
+	}
+
 	var countryName string
-	var err error
 
 	// Check if country isoCode is Any, if so return no country name
-	if data["country"].(string) == "ANY" {
-		countryName = ""
-	} else {
+	if data["country"].(string) != "ANY" {
 		// Find name from isoCode
 		country, err := GetCountryByIso(data["country"].(string), countriesApiUrl)
 		if err != nil {
-			return
+			return notification, err
 		}
 		countryName = country.Name
 	}
 
+	// Deliveries to rate webhooks have the invocations within the window which fired them
+	if event, ok := data["event"].(map[string]interface{}); ok && event["type"] == constants.WEBHOOK_TRIGGER_RATE {
+		rateNotification := structs.RateNotification{
+			WebhookId: webhookID,
+			Trigger:   constants.WEBHOOK_TRIGGER_RATE,
+			Country:   countryName,
+			Calls:     int(event["calls"].(int64)),
+			Window:    (time.Duration(event["window_seconds"].(int64)) * time.Second).String(),
+			Test:      notification.Test,
+		}
+		if data["year"].(int64) != -1 {
+			rateNotification.Year = int(data["year"].(int64))
+		}
+		if includeData, _ := data["include_data"].(bool); includeData {
+			renewables, err := getWebhookRenewables(data, countriesApiUrl)
+			if err != nil {
+				return notification, err
+			}
+			rateNotification.Data = renewables
+		}
+		notification.Trigger = constants.WEBHOOK_TRIGGER_RATE
+		notification.Data = rateNotification
+		return notification, nil
+	}
+
 	// Create base struct
 	webhookStruct := structs.Webhook{
 		WebhookId: webhookID,
 		Country:   countryName,
 		Calls:     int(data["invocations"].(int64)),
+		Test:      notification.Test,
 	}
 
 	// Include year if specified
@@ -96,18 +286,169 @@ func PostToWebhook(data map[string]interface{}, webhookID, countriesApiUrl strin
 		webhookStruct.Year = int(data["year"].(int64))
 	}
 
-	// Encode struct into json
-	jsonData, err := json.Marshal(webhookStruct)
-	if err != nil {
-		return
+	// Include the renewables as they are now, if the webhook asks for them
+	if includeData, _ := data["include_data"].(bool); includeData {
+		renewables, err := getWebhookRenewables(data, countriesApiUrl)
+		if err != nil {
+			return notification, err
+		}
+		webhookStruct.Data = renewables
 	}
 
-	// Issue post request to url
-	response, err := http.Post(data["url"].(string), "application/json", bytes.NewReader(jsonData))
-	if err != nil {
-		return
+	notification.Data = webhookStruct
+	return notification, nil
+}
+
+/*
+Creates the notification of a delivery to a threshold webhook
+
+	event		- Map of the event, with the country, year, old and new value, and the limits it was fired for
+	webhookID	- ID of webhook
+
+	return	- The threshold notification
+*/
+func createThresholdNotification(event map[string]interface{}, webhookID string) structs.ThresholdNotification {
+	notification := structs.ThresholdNotification{
+		WebhookId: webhookID,
+		Trigger:   constants.WEBHOOK_TRIGGER_THRESHOLD,
+		Country:   event["name"].(string),
+		IsoCode:   event["country"].(string),
+		Year:      int(event["year"].(int64)),
+		OldValue:  event["old_value"].(float64),
+		NewValue:  event["new_value"].(float64),
+	}
+
+	// Include the limits the webhook has
+	if threshold, ok := event["threshold"].(float64); ok {
+		notification.Threshold = &threshold
+	}
+	if change, ok := event["change"].(float64); ok {
+		notification.Change = &change
+	}
+
+	return notification
+}
+
+/*
+Creates the notification of a delivery to a dataset webhook
+
+	event		- Map of the event, with the countries and years the import added or changed
+	webhookID	- ID of webhook
+
+	return	- The dataset notification
+*/
+func createDatasetNotification(event map[string]interface{}, webhookID string) structs.DatasetUpdateNotification {
+	notification := structs.DatasetUpdateNotification{
+		WebhookId: webhookID,
+		Trigger:   constants.WEBHOOK_TRIGGER_DATASET,
+		Changes:   []structs.DatasetChange{},
+	}
+
+	notification.Changes = append(notification.Changes, listToDatasetChanges(event["changes"])...)
+
+	return notification
+}
+
+/*
+Creates the notification of a delivery to a digest webhook
+
+	event		- Map of the event, with the requests by country and range of years, the most requested countries and dataset changes
+	webhookID	- ID of webhook
+
+	return	- The digest notification
+*/
+func createDigestNotification(event map[string]interface{}, webhookID string) structs.DigestNotification {
+	notification := structs.DigestNotification{
+		WebhookId:    webhookID,
+		Trigger:      constants.WEBHOOK_TRIGGER_DIGEST,
+		Invocations:  []structs.DigestInvocation{},
+		TopCountries: []structs.DigestCountry{},
+		Changes:      []structs.DatasetChange{},
+	}
+	notification.Schedule, _ = event["schedule"].(string)
+	notification.To, _ = event["to"].(time.Time)
+	if from, ok := event["from"].(time.Time); ok {
+		notification.From = &from
+	}
+
+	invocations, _ := event["invocations"].([]interface{})
+	for _, invocation := range invocations {
+		counts := invocation.(map[string]interface{})
+		notification.Invocations = append(notification.Invocations, structs.DigestInvocation{
+			IsoCode:  counts["country"].(string),
+			YearFrom: int(counts["year_from"].(int64)),
+			YearTo:   int(counts["year_to"].(int64)),
+			Calls:    int(counts["calls"].(int64)),
+		})
+	}
+
+	countries, _ := event["top_countries"].([]interface{})
+	for _, country := range countries {
+		counts := country.(map[string]interface{})
+		notification.TopCountries = append(notification.TopCountries, structs.DigestCountry{
+			IsoCode: counts["country"].(string),
+			Calls:   int(counts["calls"].(int64)),
+		})
+	}
+
+	notification.Changes = append(notification.Changes, listToDatasetChanges(event["changes"])...)
+
+	return notification
+}
+
+/*
+Converts a list of dataset changes, as stored in firestore, to dataset changes
+*/
+func listToDatasetChanges(list interface{}) []structs.DatasetChange {
+	values, _ := list.([]interface{})
+
+	var changes []structs.DatasetChange
+	for _, value := range values {
+		country := value.(map[string]interface{})
+		changes = append(changes, structs.DatasetChange{
+			IsoCode:      country["country"].(string),
+			Country:      country["name"].(string),
+			YearsAdded:   listToYears(country["years_added"]),
+			YearsChanged: listToYears(country["years_changed"]),
+		})
+	}
+	return changes
+}
+
+/*
+Converts a list of years, as stored in firestore, to years
+*/
+func listToYears(list interface{}) []int {
+	values, _ := list.([]interface{})
+
+	var years []int
+	for _, value := range values {
+		years = append(years, int(value.(int64)))
+	}
+	return years
+}
+
+/*
+Get the secrets a webhook delivery should be signed with
+
+	data	- Map of webhook data
+	now		- Time the delivery is sent at
+
+	return	- The current secret, and the previous secret if it is still in its grace period. Empty for webhooks without a secret.
+*/
+func GetSigningSecrets(data map[string]interface{}, now time.Time) []string {
+	var secrets []string
+
+	if secret, ok := data["secret"].(string); ok && secret != "" {
+		secrets = append(secrets, secret)
+	}
+
+	// Keep signing with the previous secret until its grace period is over
+	previous, ok := data["previous_secret"].(string)
+	expires, hasExpiry := data["previous_secret_expires"].(time.Time)
+	if ok && previous != "" && hasExpiry && now.Before(expires) {
+		secrets = append(secrets, previous)
 	}
 
-	// Close reponse body at end of function
-	defer response.Body.Close()
+	return secrets
 }
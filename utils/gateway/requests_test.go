@@ -2,11 +2,15 @@ package gateway
 
 import (
 	"assignment2/utils/constants"
+	"assignment2/utils/structs"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -115,7 +119,7 @@ func TestHttpRequestFromUrlNonExistingUrl(t *testing.T) {
 }
 
 /*
-Tests the PostToWebhook function
+Tests the DeliverToWebhook function
 */
 func TestPostToWebhook(t *testing.T) {
 	count := 0        // Count number of requests
@@ -186,9 +190,216 @@ func TestPostToWebhook(t *testing.T) {
 	data["invocations"] = int64(5)
 
 	// Post data to webhook
-	PostToWebhook(data, "TEST", ts.URL+"/api")
+	_, err := DeliverToWebhook(data, "TEST", "TEST-DELIVERY", ts.URL+"/api")
+	assert.Nil(t, err, "Delivery should succeed.")
 
 	assert.Equal(t, 1, webhookCount, "Webhook should be called once.")
 	assert.Equal(t, 1, apiCount, "API should be called once.")
 	assert.Equal(t, 2, count, "Webhook and API should be called once each.")
 }
+
+/*
+Tests that deliveries which get a non-2xx response are reported as failed
+*/
+func TestPostToWebhookFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	data := map[string]interface{}{
+		"url":         ts.URL,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(5),
+	}
+
+	attempt, err := DeliverToWebhook(data, "TEST", "TEST-DELIVERY", ts.URL)
+	assert.NotNil(t, err, "Non-2xx response should be an error.")
+	assert.Equal(t, http.StatusInternalServerError, attempt.StatusCode, "Status code of response should be recorded.")
+	assert.Equal(t, "webhook url responded with status 500 Internal Server Error", attempt.Error, "Error should be recorded.")
+	assert.JSONEq(t, `{"webhook_id":"TEST","calls":5}`, string(attempt.Payload), "Payload should be recorded.")
+	assert.Equal(t, "TEST-DELIVERY", attempt.DeliveryId, "Delivery ID should be recorded.")
+	assert.False(t, attempt.Timestamp.IsZero(), "Timestamp should be recorded.")
+
+	// No response at all is also a failure
+	ts.Close()
+	attempt, err = DeliverToWebhook(data, "TEST", "TEST-DELIVERY", ts.URL)
+	assert.NotNil(t, err, "Unreachable url should be an error.")
+	assert.Equal(t, 0, attempt.StatusCode, "Status code should be 0 without a response.")
+	assert.NotEmpty(t, attempt.Error, "Error should be recorded.")
+}
+
+/*
+Tests that test deliveries are marked in the header and payload, and that the start of the response is kept
+*/
+func TestPostToWebhookTest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get(constants.WEBHOOK_TEST_HEADER), "Test deliveries should have the test header.")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("x", constants.MAX_RESPONSE_EXCERPT_SIZE+100)))
+	}))
+	defer ts.Close()
+
+	data := map[string]interface{}{
+		"url":         ts.URL,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(2),
+		"test":        true,
+	}
+
+	attempt, err := DeliverToWebhook(data, "TEST", "test-DELIVERY", ts.URL)
+	assert.NotNil(t, err, "Non-2xx response should be an error.")
+	assert.Equal(t, http.StatusBadRequest, attempt.StatusCode, "Status code of response should be recorded.")
+	assert.Equal(t, strings.Repeat("x", constants.MAX_RESPONSE_EXCERPT_SIZE), attempt.Response, "Response should be cut to the excerpt size.")
+	assert.JSONEq(t, `{"webhook_id":"TEST","calls":2,"test":true}`, string(attempt.Payload), "Payload should be marked as a test.")
+}
+
+/*
+Tests that deliveries to dataset webhooks have the countries and years the import changed
+*/
+func TestCreateDeliveryDataset(t *testing.T) {
+	data := map[string]interface{}{
+		"country":     "NOR",
+		"year":        int64(-1),
+		"invocations": int64(0),
+		"event": map[string]interface{}{
+			"type": constants.WEBHOOK_TRIGGER_DATASET,
+			"changes": []interface{}{map[string]interface{}{
+				"country":       "NOR",
+				"name":          "Norway",
+				"years_added":   []interface{}{int64(2022)},
+				"years_changed": []interface{}{int64(2020), int64(2021)},
+			}},
+		},
+	}
+
+	delivery, err := createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.Nil(t, err, "Payload should be created without the restcountries API.")
+	assert.Equal(t, constants.CONT_TYPE_JSON, delivery.ContentType, "Wrong content type.")
+	assert.JSONEq(t, `{
+		"webhook_id": "TEST",
+		"trigger": "dataset.updated",
+		"changes": [{"isoCode": "NOR", "country": "Norway", "yearsAdded": [2022], "yearsChanged": [2020, 2021]}]
+	}`, string(delivery.Payload), "Wrong payload of dataset webhook.")
+}
+
+/*
+Tests that deliveries to digest webhooks have the requests by country and range of years, and the most requested countries
+*/
+func TestCreateDeliveryDigest(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := map[string]interface{}{
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(0),
+		"event": map[string]interface{}{
+			"type":     constants.WEBHOOK_TRIGGER_DIGEST,
+			"schedule": constants.WEBHOOK_SCHEDULE_DAILY,
+			"from":     from,
+			"to":       from.AddDate(0, 0, 1),
+			"invocations": []interface{}{
+				map[string]interface{}{"country": "NOR", "year_from": int64(2020), "year_to": int64(2020), "calls": int64(3)},
+				map[string]interface{}{"country": "ALL", "year_from": int64(1965), "year_to": int64(2021), "calls": int64(1)},
+			},
+			"top_countries": []interface{}{map[string]interface{}{"country": "NOR", "calls": int64(3)}},
+			"changes":       []interface{}{},
+		},
+	}
+
+	delivery, err := createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.Nil(t, err, "Payload should be created without the restcountries API.")
+	assert.JSONEq(t, `{
+		"webhook_id": "TEST",
+		"trigger": "digest",
+		"schedule": "daily",
+		"from": "2024-01-01T00:00:00Z",
+		"to": "2024-01-02T00:00:00Z",
+		"invocations": [
+			{"isoCode": "NOR", "yearFrom": 2020, "yearTo": 2020, "calls": 3},
+			{"isoCode": "ALL", "yearFrom": 1965, "yearTo": 2021, "calls": 1}
+		],
+		"topCountries": [{"isoCode": "NOR", "calls": 3}],
+		"changes": []
+	}`, string(delivery.Payload), "Wrong payload of digest webhook.")
+}
+
+/*
+Tests that deliveries to rate webhooks have the invocations within the window
+*/
+func TestCreateDeliveryRate(t *testing.T) {
+	data := map[string]interface{}{
+		"country":     "ANY",
+		"year":        int64(2020),
+		"invocations": int64(500),
+		"event": map[string]interface{}{
+			"type":           constants.WEBHOOK_TRIGGER_RATE,
+			"calls":          int64(101),
+			"window_seconds": int64(3600),
+		},
+	}
+
+	delivery, err := createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.Nil(t, err, "Payload should be created without the restcountries API.")
+	assert.JSONEq(t, `{"webhook_id":"TEST","trigger":"rate","calls":101,"window":"1h0m0s","year":2020}`, string(delivery.Payload), "Wrong payload of rate webhook.")
+}
+
+/*
+Tests that deliveries to webhooks with includeData have the renewables of their countries and years, read when the payload is created
+*/
+func TestCreateDeliveryIncludeData(t *testing.T) {
+	var gotCodes []string
+	var gotBegin, gotEnd int
+	SetRenewablesProvider(func(isoCodes []string, beginYear int, endYear int) ([]structs.CountryOutput, error) {
+		gotCodes, gotBegin, gotEnd = isoCodes, beginYear, endYear
+		return []structs.CountryOutput{{Name: "Norway", IsoCode: "NOR", Year: "2021", Percentage: 71.5}}, nil
+	})
+	t.Cleanup(func() { SetRenewablesProvider(nil) })
+
+	data := map[string]interface{}{
+		"country":      "ANY",
+		"countries":    []interface{}{"NOR", "SWE", "NOR"},
+		"year":         int64(-1),
+		"year_from":    int64(2010),
+		"year_to":      int64(-1),
+		"invocations":  int64(5),
+		"include_data": true,
+	}
+	delivery, err := createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.Nil(t, err, "Payload should be created")
+	assert.JSONEq(t, `{"webhook_id":"TEST","calls":5,"data":[{"name":"Norway","isoCode":"NOR","year":"2021","percentage":71.5}]}`, string(delivery.Payload), "Payload should have the renewables")
+	assert.Equal(t, []string{"NOR", "SWE"}, gotCodes, "Renewables should be read for each country of the webhook once")
+	assert.Equal(t, 2010, gotBegin, "Range should start at yearFrom")
+	assert.Equal(t, constants.LATEST_YEAR_DB, gotEnd, "Range without yearTo should end at the latest year")
+
+	// Webhooks applying to any country and year have the current renewables of all countries
+	data = map[string]interface{}{
+		"country":      "ANY",
+		"year":         int64(-1),
+		"invocations":  int64(500),
+		"include_data": true,
+		"event": map[string]interface{}{
+			"type":           constants.WEBHOOK_TRIGGER_RATE,
+			"calls":          int64(101),
+			"window_seconds": int64(3600),
+		},
+	}
+	delivery, err = createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.Nil(t, err, "Payload should be created")
+	assert.Contains(t, string(delivery.Payload), `"data":[`, "Rate payload should have the renewables")
+	assert.Empty(t, gotCodes, "Renewables of all countries should be read")
+	assert.Equal(t, constants.LATEST_YEAR_DB, gotBegin, "Current renewables should be read")
+
+	// Deliveries fail when the renewables can not be read, so they are retried
+	SetRenewablesProvider(func(isoCodes []string, beginYear int, endYear int) ([]structs.CountryOutput, error) {
+		return nil, errors.New("database unavailable")
+	})
+	_, err = createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.NotNil(t, err, "Payload should not be created without the renewables")
+
+	// Webhooks without includeData do not read the renewables
+	data["include_data"] = false
+	_, err = createDelivery(data, "TEST", "TEST-DELIVERY", time.Now(), "")
+	assert.Nil(t, err, "Payload should be created without the renewables")
+}
@@ -4,18 +4,39 @@ import (
 	"assignment2/utils/constants"
 	"assignment2/utils/structs"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 )
 
 // Map of countries that link country ISO codes to their respective structs containing all information.
 var rcCache = make(map[string]*structs.Country)
 
+// Lock for the country cache, as it is used by concurrent requests and resolvers
+var rcCacheMutex sync.RWMutex
+
+// Map of regions and subregions in lower case, and the ISO codes of the countries in them
+var regionCache = make(map[string][]string)
+
 /*
 Clears the country cache.
 */
 func clearRcCache() {
+	rcCacheMutex.Lock()
+	defer rcCacheMutex.Unlock()
 	rcCache = make(map[string]*structs.Country)
+	regionCache = make(map[string][]string)
+}
+
+/*
+Saves a country in the country cache.
+*/
+func cacheCountry(country *structs.Country) {
+	rcCacheMutex.Lock()
+	defer rcCacheMutex.Unlock()
+	rcCache[country.IsoCode] = country
 }
 
 /*
@@ -29,7 +50,9 @@ Returns a country struct based on the ISO code of the country.
 func GetCountryByIso(iso, apiURL string) (*structs.Country, error) {
 
 	// Check if country ISO is in map
+	rcCacheMutex.RLock()
 	country, ok := rcCache[iso]
+	rcCacheMutex.RUnlock()
 	if ok { //Cache hit
 		return country, nil
 	} //Cache miss
@@ -43,12 +66,59 @@ func GetCountryByIso(iso, apiURL string) (*structs.Country, error) {
 		return nil, err
 	}
 
-	rcCache[country.IsoCode] = country
+	cacheCountry(country)
 
 	// Return pointer to country
 	return country, nil
 }
 
+/*
+Returns country structs for multiple ISO codes, using one request to the restcountries API for all countries not cached.
+
+	isos		- The ISO codes of the countries to get
+	apiURL		- The URL to the restcountries API
+
+	returns		- Map of ISO codes and pointers to country structs. ISO codes not found are left out.
+*/
+func GetCountriesByIso(isos []string, apiURL string) (map[string]*structs.Country, error) {
+	countries := make(map[string]*structs.Country)
+	var missing []string
+
+	// Check which countries are in the cache
+	rcCacheMutex.RLock()
+	for _, iso := range isos {
+		if country, ok := rcCache[iso]; ok {
+			countries[iso] = country
+		} else {
+			missing = append(missing, iso)
+		}
+	}
+	rcCacheMutex.RUnlock()
+
+	// All countries were cached
+	if len(missing) == 0 {
+		return countries, nil
+	}
+
+	//Stitch together complete URL based on constants and all codes missing
+	url := apiURL + constants.COUNTRY_CODES_SEARCH_PATH + strings.Join(missing, ",")
+
+	// Get response from API
+	resObject, err := getInterface(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a country struct from each country in the response
+	for i := range resObject {
+		country := createCountryFromObject(resObject[i])
+		cacheCountry(country)
+		countries[country.IsoCode] = country
+	}
+
+	return countries, nil
+}
+
 /*
 Get country from restcountries API, based on the name.
 
@@ -60,11 +130,14 @@ Get country from restcountries API, based on the name.
 func GetCountryByName(name string, apiURL string) (*structs.Country, error) {
 
 	// Check if country name is in map
+	rcCacheMutex.RLock()
 	for _, v := range rcCache {
 		if strings.Contains(strings.ToLower(v.Name), strings.ToLower(name)) {
+			rcCacheMutex.RUnlock()
 			return v, nil
 		}
 	}
+	rcCacheMutex.RUnlock()
 
 	//Stitch together complete URL based on constants and input name
 	urlParts := []string{apiURL, constants.COUNTRY_NAME_SEARCH_PATH, name}
@@ -75,12 +148,67 @@ func GetCountryByName(name string, apiURL string) (*structs.Country, error) {
 		return nil, err
 	}
 
-	rcCache[country.IsoCode] = country
+	cacheCountry(country)
 
 	// Return pointer to country
 	return country, nil
 }
 
+/*
+Returns the ISO codes of the countries in a region, such as "Europe", or a subregion, such as "Northern Europe".
+
+	region		- The name of the region or subregion, in any case
+	apiURL		- The URL to the restcountries API
+
+	returns		- The ISO codes of the countries in the region, or error with status 404 if there is no such region
+*/
+func GetIsoCodesByRegion(region, apiURL string) ([]string, error) {
+	key := strings.ToLower(region)
+
+	// Check if region is in map
+	rcCacheMutex.RLock()
+	isoCodes, ok := regionCache[key]
+	rcCacheMutex.RUnlock()
+	if ok {
+		return isoCodes, nil
+	}
+
+	// Regions and subregions have separate paths, so try regions first
+	for _, path := range []string{constants.COUNTRY_REGION_SEARCH_PATH, constants.COUNTRY_SUBREGION_SEARCH_PATH} {
+		res, err := HttpRequestFromUrl(apiURL+path+url.PathEscape(region), http.MethodGet)
+		if err != nil {
+			return nil, structs.NewError(err, http.StatusBadGateway, constants.DEFAULT500, "Restcountries API did not respond to request.")
+		}
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			continue
+		}
+
+		var resObject []map[string]interface{}
+		err = json.NewDecoder(res.Body).Decode(&resObject)
+		res.Body.Close()
+		if err != nil {
+			return nil, structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Could not decode restcountries json response.")
+		}
+
+		// Cache each country, as well as the region
+		isoCodes = []string{}
+		for i := range resObject {
+			country := createCountryFromObject(resObject[i])
+			cacheCountry(country)
+			isoCodes = append(isoCodes, country.IsoCode)
+		}
+
+		rcCacheMutex.Lock()
+		regionCache[key] = isoCodes
+		rcCacheMutex.Unlock()
+
+		return isoCodes, nil
+	}
+
+	return nil, structs.NewCodedError(errors.New("no region or subregion named "+region), http.StatusNotFound, constants.ERR_REGION_NOT_FOUND, "region", "Invalid region given", "Region given was not found in the restcountries API")
+}
+
 /*
 Get isocode from country name.
 
@@ -151,13 +279,24 @@ func getCountry(url string) (*structs.Country, error) {
 		return nil, err
 	}
 
+	return createCountryFromObject(resObject[0]), nil
+}
+
+/*
+Creates a country struct from one country in a response from the restcountries API.
+
+	countryObject	 - One country object from the restcountries API
+
+	returns	 - A pointer to a country struct containing all information about the country
+*/
+func createCountryFromObject(countryObject map[string]interface{}) *structs.Country {
 	//Define new country struct, and fill it with data from response
 	country := new(structs.Country)
-	country.IsoCode = resObject[0][constants.USED_COUNTRY_CODE].(string)
-	country.Name = resObject[0]["name"].(map[string]interface{})["common"].(string)
-	country.Borders = getCountryBorder(resObject)
+	country.IsoCode = countryObject[constants.USED_COUNTRY_CODE].(string)
+	country.Name = countryObject["name"].(map[string]interface{})["common"].(string)
+	country.Borders = getCountryBorder([]map[string]interface{}{countryObject})
 
-	return country, nil
+	return country
 }
 
 /*
@@ -169,8 +308,15 @@ Get a list of all the country ISO codes that border the country given
 */
 func getCountryBorder(resObject []map[string]interface{}) []string {
 	var borders []string
+
+	// Countries without borders, such as islands, may not have the field set
+	bordersObject, ok := resObject[0]["borders"].([]interface{})
+	if !ok {
+		return borders
+	}
+
 	// For each border, save border as a string to the list
-	for _, border := range resObject[0]["borders"].([]interface{}) {
+	for _, border := range bordersObject {
 		borders = append(borders, border.(string))
 	}
 	return borders
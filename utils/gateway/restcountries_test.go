@@ -542,3 +542,75 @@ func TestGetCountry(t *testing.T) {
 
 	assert.Equal(t, expected, country, "Response body does not match expected")
 }
+
+/*
+Tests that multiple countries are fetched in one request, and that cached countries are not fetched again
+*/
+func TestGetCountriesByIso(t *testing.T) {
+	clearRcCache()
+	requests := 0
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "NOR,ISL", r.URL.Query().Get("codes"), "All codes should be requested together")
+
+		// Send response to be tested, where Iceland has no borders field
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"name": {"common": "Norway"}, "cca3": "NOR", "borders": ["FIN", "SWE", "RUS"]},
+			{"name": {"common": "Iceland"}, "cca3": "ISL"}
+		]`))
+	}))
+	defer ts.Close()
+
+	countries, err := GetCountriesByIso([]string{"NOR", "ISL"}, ts.URL)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, 2, len(countries), "Both countries should be returned")
+	assert.Equal(t, "Iceland", countries["ISL"].Name, "Wrong country name")
+	assert.Empty(t, countries["ISL"].Borders, "Iceland should have no borders")
+
+	// Countries should now be cached
+	countries, err = GetCountriesByIso([]string{"NOR"}, ts.URL)
+	assert.Nil(t, err, "Expected no error")
+	assert.Equal(t, "Norway", countries["NOR"].Name, "Wrong country name")
+	assert.Equal(t, 1, requests, "Cached countries should not be requested again")
+}
+
+/*
+Tests that subregions are found when there is no region with the name, and that regions are cached
+*/
+func TestGetIsoCodesByRegion(t *testing.T) {
+	clearRcCache()
+	requests := 0
+	// Create a test server with one region and one subregion
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case constants.COUNTRY_SUBREGION_SEARCH_PATH + "Northern Europe":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[
+				{"name": {"common": "Norway"}, "cca3": "NOR", "borders": ["FIN", "SWE", "RUS"]},
+				{"name": {"common": "Iceland"}, "cca3": "ISL"}
+			]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status": 404, "message": "Not Found"}`))
+		}
+	}))
+	defer ts.Close()
+
+	isoCodes, err := GetIsoCodesByRegion("Northern Europe", ts.URL)
+	assert.Nil(t, err, "Subregion should be found")
+	assert.Equal(t, []string{"NOR", "ISL"}, isoCodes, "Wrong countries in subregion")
+	assert.Equal(t, 2, requests, "Region should be tried before subregion")
+
+	// Regions are cached regardless of case
+	isoCodes, err = GetIsoCodesByRegion("northern europe", ts.URL)
+	assert.Nil(t, err, "Cached subregion should be found")
+	assert.Equal(t, []string{"NOR", "ISL"}, isoCodes, "Wrong countries in cached subregion")
+	assert.Equal(t, 2, requests, "Cached subregion should not be requested again")
+
+	// Regions which do not exist are not found
+	_, err = GetIsoCodesByRegion("Atlantis", ts.URL)
+	assert.Equal(t, http.StatusNotFound, err.(structs.WrappedError).StatusCode, "Unknown region should not be found")
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Computes the signature of a webhook delivery
+
+	secret		- Signing secret of the webhook
+	timestamp	- Unix time the delivery is sent at
+	body		- Body of the delivery
+
+	return	- Hex encoded HMAC-SHA256 of "{timestamp}.{body}"
+*/
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+Creates the signature header of a webhook delivery, with one signature for each secret.
+Receivers accept the delivery if any of the signatures match a secret they know, so secrets can be rotated without downtime.
+
+	secrets		- Signing secrets which are currently valid
+	timestamp	- Unix time the delivery is sent at
+	body		- Body of the delivery
+
+	return	- Header value on the format "t={timestamp},v1={signature},v1={signature}"
+*/
+func CreateSignatureHeader(secrets []string, timestamp int64, body []byte) string {
+	parts := []string{"t=" + strconv.FormatInt(timestamp, 10)}
+	for _, secret := range secrets {
+		parts = append(parts, constants.WEBHOOK_SIGNATURE_SCHEME+"="+SignWebhookPayload(secret, timestamp, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+/*
+Verifies the signature header of a webhook delivery. Meant for receivers of webhooks written in Go, and for testing.
+
+	header		- Value of the signature header
+	body		- Body of the delivery, exactly as received
+	secret		- Signing secret of the webhook
+	tolerance	- Max difference between the timestamp and now, to reject replayed deliveries
+	now			- Current time
+
+	return	- Error if the header is malformed, too old, or has no matching signature
+*/
+func VerifyWebhookSignature(header string, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	var timestamp int64 = -1
+	var signatures []string
+
+	// Split header into timestamp and signatures, ignoring unknown schemes
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("invalid timestamp in signature header")
+			}
+			timestamp = parsed
+		case constants.WEBHOOK_SIGNATURE_SCHEME:
+			signatures = append(signatures, value)
+		}
+	}
+
+	if timestamp < 0 {
+		return errors.New("no timestamp in signature header")
+	}
+	if len(signatures) == 0 {
+		return errors.New("no " + constants.WEBHOOK_SIGNATURE_SCHEME + " signatures in signature header")
+	}
+
+	// Reject deliveries signed too long ago, or too far in the future
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return errors.New("timestamp in signature header is outside the tolerance")
+	}
+
+	// Compare in constant time, so the signature cannot be guessed byte by byte
+	expected := []byte(SignWebhookPayload(secret, timestamp, body))
+	for _, signature := range signatures {
+		if hmac.Equal(expected, []byte(signature)) {
+			return nil
+		}
+	}
+
+	return errors.New("no signature in signature header matches the secret")
+}
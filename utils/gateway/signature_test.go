@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests that signatures created for a delivery are verified, and that tampered or replayed deliveries are rejected
+*/
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"webhook_id":"TEST","calls":5}`)
+	header := CreateSignatureHeader([]string{"whsec_new", "whsec_old"}, now.Unix(), body)
+
+	assert.True(t, strings.HasPrefix(header, "t=1700000000,v1="), "Header should start with timestamp")
+	assert.Equal(t, 2, strings.Count(header, "v1="), "Header should have one signature per secret")
+
+	// Both secrets are valid during rotation
+	assert.Nil(t, VerifyWebhookSignature(header, body, "whsec_new", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "New secret should be accepted")
+	assert.Nil(t, VerifyWebhookSignature(header, body, "whsec_old", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "Old secret should be accepted")
+
+	// Wrong secret, tampered body, and replays outside the tolerance are rejected
+	assert.NotNil(t, VerifyWebhookSignature(header, body, "whsec_other", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "Wrong secret should be rejected")
+	assert.NotNil(t, VerifyWebhookSignature(header, []byte(`{"webhook_id":"TEST","calls":6}`), "whsec_new", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "Tampered body should be rejected")
+	assert.NotNil(t, VerifyWebhookSignature(header, body, "whsec_new", constants.WEBHOOK_SIGNATURE_TOLERANCE, now.Add(10*time.Minute)), "Replayed delivery should be rejected")
+
+	// Malformed headers are rejected
+	assert.NotNil(t, VerifyWebhookSignature("", body, "whsec_new", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "Empty header should be rejected")
+	assert.NotNil(t, VerifyWebhookSignature("t=abc,v1=00", body, "whsec_new", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "Invalid timestamp should be rejected")
+	assert.NotNil(t, VerifyWebhookSignature("t=1700000000", body, "whsec_new", constants.WEBHOOK_SIGNATURE_TOLERANCE, now), "Header without signatures should be rejected")
+}
+
+/*
+Tests that the previous secret is only used for signing during its grace period
+*/
+func TestGetSigningSecrets(t *testing.T) {
+	now := time.Now()
+
+	assert.Empty(t, GetSigningSecrets(map[string]interface{}{}, now), "Webhooks without secret should not be signed")
+
+	data := map[string]interface{}{
+		"secret":                  "whsec_new",
+		"previous_secret":         "whsec_old",
+		"previous_secret_expires": now.Add(time.Hour),
+	}
+	assert.Equal(t, []string{"whsec_new", "whsec_old"}, GetSigningSecrets(data, now), "Both secrets should be used during grace period")
+	assert.Equal(t, []string{"whsec_new"}, GetSigningSecrets(data, now.Add(2*time.Hour)), "Previous secret should not be used after grace period")
+}
+
+/*
+Tests that deliveries to webhooks with a secret are signed
+*/
+func TestPostToWebhookSigned(t *testing.T) {
+	var signature, deliveryID string
+	var body []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(constants.WEBHOOK_SIGNATURE_HEADER)
+		deliveryID = r.Header.Get(constants.WEBHOOK_DELIVERY_HEADER)
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	data := map[string]interface{}{
+		"url":         ts.URL,
+		"country":     "ANY",
+		"year":        int64(-1),
+		"invocations": int64(5),
+		"secret":      "whsec_test",
+	}
+	_, err := DeliverToWebhook(data, "TEST", "TEST-DELIVERY", ts.URL)
+	assert.Nil(t, err, "Delivery should succeed")
+
+	assert.Equal(t, "TEST-DELIVERY", deliveryID, "Delivery should have its ID in a header")
+	assert.Nil(t, VerifyWebhookSignature(signature, body, "whsec_test", constants.WEBHOOK_SIGNATURE_TOLERANCE, time.Now()), "Delivery should be signed with the secret")
+}
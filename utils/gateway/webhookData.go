@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"errors"
+	"net/http"
+)
+
+/*
+Function getting the renewables of countries between two years, built the same way as by the renewables endpoints
+
+	isoCodes	- ISO codes of the countries, or empty for all countries
+	beginYear	- First year
+	endYear		- Last year
+
+	return	- The renewables sorted by ISO code and year, or error
+*/
+type RenewablesProvider func(isoCodes []string, beginYear int, endYear int) ([]structs.CountryOutput, error)
+
+// Provider of the renewables included in deliveries of webhooks with includeData. Set in main, as the renewables are read by the handlers.
+var renewablesProvider RenewablesProvider
+
+/*
+Sets the provider of the renewables included in deliveries of webhooks with includeData
+
+	provider	- Function getting the renewables of countries between two years
+*/
+func SetRenewablesProvider(provider RenewablesProvider) {
+	renewablesProvider = provider
+}
+
+/*
+Get the renewables of the countries and years a webhook applies to, as they are when the delivery is sent.
+Webhooks with no year have the current renewables, and webhooks with a year or range of years have the history of those years.
+
+	data			- Map of webhook data, with the country, countries, region, year and range of years
+	countriesApiUrl	- URL of restcountries API, used for finding the countries of the region
+
+	return	- The renewables, or error if they could not be read
+*/
+func getWebhookRenewables(data map[string]interface{}, countriesApiUrl string) ([]structs.CountryOutput, error) {
+	if renewablesProvider == nil {
+		return nil, structs.NewError(errors.New("no renewables provider is set"), http.StatusInternalServerError, constants.DEFAULT500, "Could not include data in webhook delivery.")
+	}
+
+	// Countries of the webhook, or none for webhooks applying to any country
+	var isoCodes []string
+	seen := map[string]bool{}
+	add := func(isoCode string) {
+		if isoCode != "" && !seen[isoCode] {
+			seen[isoCode] = true
+			isoCodes = append(isoCodes, isoCode)
+		}
+	}
+	if country, _ := data["country"].(string); country != "ANY" {
+		add(country)
+	}
+	countries, _ := data["countries"].([]interface{})
+	for _, country := range countries {
+		isoCode, _ := country.(string)
+		add(isoCode)
+	}
+	if region, _ := data["region"].(string); region != "" {
+		regionCodes, err := GetIsoCodesByRegion(region, countriesApiUrl)
+		if err != nil {
+			return nil, err
+		}
+		for _, isoCode := range regionCodes {
+			add(isoCode)
+		}
+	}
+
+	beginYear, endYear := getWebhookYears(data)
+	return renewablesProvider(isoCodes, beginYear, endYear)
+}
+
+/*
+Get the years a webhook applies to, where ends of a range which are not given are the oldest and latest year in the database
+
+	data	- Map of webhook data, with years of -1 where not given
+
+	return	- First and last year, which are the latest year in the database for webhooks applying to any year
+*/
+func getWebhookYears(data map[string]interface{}) (int, int) {
+	if year, ok := data["year"].(int64); ok && year != -1 {
+		return int(year), int(year)
+	}
+
+	yearFrom, okFrom := data["year_from"].(int64)
+	yearTo, okTo := data["year_to"].(int64)
+	if (!okFrom || yearFrom == -1) && (!okTo || yearTo == -1) {
+		return constants.LATEST_YEAR_DB, constants.LATEST_YEAR_DB
+	}
+
+	beginYear, endYear := constants.OLDEST_YEAR_DB, constants.LATEST_YEAR_DB
+	if okFrom && yearFrom != -1 {
+		beginYear = int(yearFrom)
+	}
+	if okTo && yearTo != -1 {
+		endYear = int(yearTo)
+	}
+	return beginYear, endYear
+}
@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/structs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hosts and networks webhooks may be delivered to even if they are private or reserved, such as receivers on an internal network.
+// Entries are hostnames, IP addresses or CIDR ranges. Set from $WEBHOOK_ALLOWED_HOSTS in main.
+var AllowedWebhookHosts []string
+
+// Reserved ranges not covered by the methods of net.IP, such as shared address space used by carrier-grade NAT
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+)
+
+// Max size of the response read when verifying a webhook url
+const maxVerificationResponseSize = 4096
+
+/*
+Checks that a webhook url is an absolute http or https url, and that it is not an address in a private or reserved range.
+Hostnames are resolved when the webhook is delivered to, and checked again then.
+
+	rawURL	- URL of the webhook
+
+	return	- Error with status 422 if the url is not allowed
+*/
+func CheckWebhookUrl(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return invalidWebhookUrl(errors.New("webhook url must be an absolute http or https url"))
+	}
+
+	host := parsed.Hostname()
+	ip := net.ParseIP(host)
+	if isAllowedHost(host, ip) {
+		return nil
+	}
+
+	if ip != nil && isBlockedIP(ip) {
+		return invalidWebhookUrl(errors.New("webhook url must not be a private or reserved address"))
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return invalidWebhookUrl(errors.New("webhook url must not be a private or reserved address"))
+	}
+
+	return nil
+}
+
+/*
+Sends a challenge to a webhook url, which must respond with the challenge to show that it is a receiver of deliveries
+
+	webhookURL	- URL of the webhook
+	webhookID	- ID of the webhook
+	challenge	- Random challenge to echo
+	secrets		- Secrets to sign the challenge with
+	headers		- Outbound headers and credentials of the webhook, which the url may require
+
+	return	- Error with status 422 if the challenge was not echoed
+*/
+func SendVerificationChallenge(webhookURL, webhookID, challenge string, secrets []string, headers http.Header) error {
+	if err := CheckWebhookUrl(webhookURL); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(structs.WebhookVerification{
+		Type:      constants.WEBHOOK_VERIFICATION_TYPE,
+		WebhookId: webhookID,
+		Challenge: challenge,
+	})
+	if err != nil {
+		return structs.NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "There was an error when encoding verification challenge.")
+	}
+
+	request, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return invalidWebhookUrl(err)
+	}
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("content-type", constants.CONT_TYPE_JSON)
+
+	// Sign the challenge like deliveries, so the receiver can check it before echoing
+	now := time.Now()
+	if len(secrets) > 0 {
+		request.Header.Set(constants.WEBHOOK_SIGNATURE_HEADER, CreateSignatureHeader(secrets, now.Unix(), body))
+	}
+
+	response, err := getWebhookClient(constants.WEBHOOK_VERIFICATION_TIMEOUT).Do(request)
+	if err != nil {
+		return verificationFailed(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return verificationFailed(errors.New("webhook url responded with status " + response.Status))
+	}
+
+	// The challenge may be echoed as json, or as the whole body
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, maxVerificationResponseSize))
+	if err != nil {
+		return verificationFailed(err)
+	}
+	var echo structs.WebhookVerification
+	if json.Unmarshal(responseBody, &echo) == nil && echo.Challenge == challenge {
+		return nil
+	}
+	if strings.TrimSpace(string(responseBody)) == challenge {
+		return nil
+	}
+
+	return verificationFailed(errors.New("webhook url did not echo the challenge"))
+}
+
+/*
+Redacts a webhook url for listings, as the path and query often contain tokens of the receiver
+
+	rawURL	- URL of the webhook
+
+	return	- The scheme and host of the url, followed by /*** if anything else was left out
+*/
+func RedactWebhookUrl(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "***"
+	}
+
+	redacted := parsed.Scheme + "://" + parsed.Host
+	if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+		redacted += "/***"
+	}
+
+	return redacted
+}
+
+// Clients for requests to webhook urls, by their timeout. Shared, so connections kept alive are reused and closed when idle.
+var webhookClients sync.Map
+
+/*
+Get the client for requests to webhook urls with a timeout, which refuses to connect to private or reserved addresses.
+The address is checked after the hostname is resolved, so hostnames resolving to such addresses are refused as well.
+The client is created once for each timeout, so all deliveries and challenges share its connections.
+
+	timeout	- Max time to wait for the webhook url to respond
+
+	return	- The client
+*/
+func getWebhookClient(timeout time.Duration) *http.Client {
+	if client, ok := webhookClients.Load(timeout); ok {
+		return client.(*http.Client)
+	}
+
+	client, _ := webhookClients.LoadOrStore(timeout, newWebhookClient(timeout))
+	return client.(*http.Client)
+}
+
+/*
+Creates a client for requests to webhook urls, see getWebhookClient
+*/
+func newWebhookClient(timeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		// Connect directly, as a proxy would connect to the address instead of us
+		Proxy: nil,
+		// Close connections receivers keep open, so they do not pile up between deliveries
+		IdleConnTimeout:     constants.WEBHOOK_IDLE_CONN_TIMEOUT,
+		MaxIdleConnsPerHost: constants.WEBHOOK_MAX_IDLE_CONNS_PER_HOST,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+
+			dialer := &net.Dialer{
+				Timeout: timeout,
+				// Check each address the hostname resolved to, right before connecting to it
+				Control: func(network, resolved string, _ syscall.RawConn) error {
+					resolvedHost, _, err := net.SplitHostPort(resolved)
+					if err != nil {
+						return err
+					}
+					ip := net.ParseIP(resolvedHost)
+					if ip == nil || (isBlockedIP(ip) && !isAllowedHost(host, ip)) {
+						return errors.New("webhook url " + host + " resolved to private or reserved address " + resolvedHost)
+					}
+					return nil
+				},
+			}
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+/*
+Checks if an address is loopback, private, link-local, multicast, unspecified or otherwise reserved
+*/
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Checks if a host or address is in the allowlist of webhook hosts
+
+	host	- Hostname of the webhook url
+	ip		- Address of the host, or nil if it is not known
+*/
+func isAllowedHost(host string, ip net.IP) bool {
+	for _, allowed := range AllowedWebhookHosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+		if ip == nil {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(ip) {
+			return true
+		}
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Parses CIDR ranges which are known to be valid
+*/
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+/*
+Returns the error for webhook urls which are not allowed
+*/
+func invalidWebhookUrl(err error) error {
+	return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "url", "Invalid webhook URL, "+err.Error(), "")
+}
+
+/*
+Returns the error for webhook urls which did not echo the verification challenge
+*/
+func verificationFailed(err error) error {
+	return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_VERIFICATION_FAILED, "url", "Webhook URL did not respond to the verification challenge with the challenge", "")
+}
@@ -7,10 +7,13 @@ import (
 	"assignment2/utils/gateway"
 	"assignment2/utils/structs"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -22,9 +25,6 @@ Get country code or name, and neighbours parameter from request, then returns ap
 	return	- Either empty list of no country specified, or one country, or country and its neighbours
 */
 func GetCountriesToQuery(w http.ResponseWriter, r *http.Request, path string) ([]string, error) {
-	var countries []string
-	var countriesInDB []string
-
 	// Get country code or name from request
 	countryCodeOrName, err := getCountryCodeOrNameFromRequest(w, r, path)
 	if err != nil {
@@ -37,6 +37,21 @@ func GetCountriesToQuery(w http.ResponseWriter, r *http.Request, path string) ([
 		return nil, err
 	}
 
+	return ResolveCountriesToQuery(countryCodeOrName, neighbours)
+}
+
+/*
+Returns appropiate list of countries from a country code or name, and if neighbours should be included
+
+	countryCodeOrName	- ISO code or name of country, or empty if no country was specified
+	neighbours			- If the neighbours of the country should be included
+
+	return	- Either empty list of no country specified, or one country, or country and its neighbours
+*/
+func ResolveCountriesToQuery(countryCodeOrName string, neighbours bool) ([]string, error) {
+	var countries []string
+	var countriesInDB []string
+
 	// If user didn't specify any country
 	if countryCodeOrName == "" {
 		return nil, nil
@@ -80,7 +95,7 @@ func GetCountriesToQuery(w http.ResponseWriter, r *http.Request, path string) ([
 
 	// If no countries existed in the database
 	if len(countriesInDB) == 0 {
-		return nil, structs.NewError(nil, http.StatusNotFound, "No country with given ISO code or name exists in our service", "")
+		return nil, structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_COUNTRY_NOT_FOUND, "country", "No country with given ISO code or name exists in our service", "")
 	}
 
 	return countriesInDB, nil
@@ -107,7 +122,7 @@ func GetRenewablesHistoryParameters(w http.ResponseWriter, r *http.Request) (beg
 		// Try to convert string to int
 		beginYear, err = strconv.Atoi(begin)
 		if err != nil && begin != "" {
-			return -1, -1, false, false, structs.NewError(nil, http.StatusForbidden, "Malformed URL, invalid begin parameter set", "")
+			return -1, -1, false, false, structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "begin", "Malformed URL, invalid begin parameter set", "")
 		}
 	}
 
@@ -122,13 +137,14 @@ func GetRenewablesHistoryParameters(w http.ResponseWriter, r *http.Request) (beg
 		// Try to convert string to int
 		endYear, err = strconv.Atoi(end)
 		if err != nil && end != "" {
-			return -1, -1, false, false, structs.NewError(nil, http.StatusForbidden, "Malformed URL, invalid begin parameter set", "")
+			return -1, -1, false, false, structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "end", "Malformed URL, invalid end parameter set", "")
 		}
 	}
 
 	// If years set are outside of database scope
-	if (beginYear < constants.OLDEST_YEAR_DB && beginYear != -1) || (endYear > constants.LATEST_YEAR_DB && endYear != -1) {
-		return -1, -1, false, false, structs.NewError(nil, http.StatusUnprocessableEntity, "Malformed URL, begin and end years have to be between "+strconv.Itoa(constants.OLDEST_YEAR_DB)+" and "+strconv.Itoa(constants.LATEST_YEAR_DB), "")
+	err = CheckYearRange(beginYear, endYear)
+	if err != nil {
+		return -1, -1, false, false, err
 	}
 
 	// Get sortByValue param
@@ -146,6 +162,27 @@ func GetRenewablesHistoryParameters(w http.ResponseWriter, r *http.Request) (beg
 	return beginYear, endYear, sortByValue, getMean, nil
 }
 
+/*
+Check that begin and end years are within the years of the database
+
+	beginYear	- The first year requested, or -1 if not set
+	endYear		- The last year requested, or -1 if not set
+
+	return	- Error if any of the years are outside of the database scope
+*/
+func CheckYearRange(beginYear int, endYear int) error {
+	yearRangeMsg := "Malformed URL, begin and end years have to be between " + strconv.Itoa(constants.OLDEST_YEAR_DB) + " and " + strconv.Itoa(constants.LATEST_YEAR_DB)
+
+	if beginYear < constants.OLDEST_YEAR_DB && beginYear != -1 {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_YEAR_OUT_OF_RANGE, "begin", yearRangeMsg, "")
+	}
+	if endYear > constants.LATEST_YEAR_DB && endYear != -1 {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_YEAR_OUT_OF_RANGE, "end", yearRangeMsg, "")
+	}
+
+	return nil
+}
+
 /*
 Get country code or name from the requests url
 
@@ -162,7 +199,7 @@ func getCountryCodeOrNameFromRequest(w http.ResponseWriter, r *http.Request, pat
 
 	// Check if URL is correctly formatted
 	if len(args) != 6 && len(args) != 7 {
-		return "", structs.NewError(nil, http.StatusForbidden, "Malformed URL, Expecting format "+path+"{country?}", "")
+		return "", structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PATH, "country", "Malformed URL, Expecting format "+path+"{country?}", "")
 	}
 
 	// Return name of country / isoCode
@@ -184,7 +221,7 @@ func GetBoolParameterFromRequest(w http.ResponseWriter, r *http.Request, paramNa
 	// Try to convert string to int
 	paramBool, err := strconv.ParseBool(paramString)
 	if err != nil && paramString != "" {
-		return false, structs.NewError(nil, http.StatusForbidden, "Malformed URL, invalid "+paramName+" parameter set", "")
+		return false, structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, paramName, "Malformed URL, invalid "+paramName+" parameter set", "")
 	}
 
 	// Return neighbours bool
@@ -192,7 +229,7 @@ func GetBoolParameterFromRequest(w http.ResponseWriter, r *http.Request, paramNa
 }
 
 /*
-Get and decode webhook in json format into a webhook struct
+Get and decode webhook in json format into a webhook struct, and check that it is valid
 */
 func GetWebhookFromRequest(w http.ResponseWriter, r *http.Request) (structs.Webhook, error) {
 	// Decode JSON
@@ -201,19 +238,415 @@ func GetWebhookFromRequest(w http.ResponseWriter, r *http.Request) (structs.Webh
 	if err := decoder.Decode(&webhook); err != nil {
 		// Error for error in decoding
 		log.Println(err.Error())
-		return webhook, structs.NewError(err, http.StatusForbidden, "Invalid request body for registration of webhook", "There was an error when decoding webhook from json.")
+		return webhook, structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_INVALID_BODY, "", "Invalid request body for registration of webhook", "There was an error when decoding webhook from json.")
+	}
+
+	return webhook, CheckWebhook(webhook)
+}
+
+/*
+Get and decode a partial update of a webhook in json format
+
+	r	- Request with the partial update as body
+
+	return	- Map of the fields given, and their json values
+*/
+func GetWebhookPatchFromRequest(r *http.Request) (map[string]json.RawMessage, error) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return nil, structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_INVALID_BODY, "", "Invalid request body for update of webhook", "There was an error when decoding webhook patch from json.")
+	}
+
+	return patch, nil
+}
+
+/*
+Get the challenge posted back to verify a webhook, which was sent to a target that can not echo it
+
+	r	- Request to the verify endpoint, with {"challenge": "<challenge>"} as body, or no body to send the challenge again
+
+	return	- The challenge, or empty if the request has no body
+*/
+func GetVerificationChallengeFromRequest(r *http.Request) (string, error) {
+	var verification structs.WebhookVerification
+	err := json.NewDecoder(r.Body).Decode(&verification)
+	if errors.Is(err, io.EOF) {
+		return "", nil
+	}
+	if err != nil {
+		return "", structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_INVALID_BODY, "", "Invalid request body for verification of webhook", "There was an error when decoding verification challenge from json.")
+	}
+	if verification.Challenge == "" {
+		return "", structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MISSING_FIELD, "challenge", "Invalid request body for verification of webhook, challenge is missing", "")
+	}
+
+	return verification.Challenge, nil
+}
+
+/*
+Apply a partial update to a webhook, and check that the result is valid.
+Fields left out are kept, and country, countries, region, year, yearFrom or yearTo set to null removes that limit on the webhook.
+Trigger set to null makes it fired by calls, format set to null makes it native, channel set to null posts it to its url,
+and url, threshold, change, window, cooldown, schedule, expiresAt, template, headers, auth or includeData set to null removes it. Only webhooks of the file channel have no url.
+Headers and auth are replaced as a whole, and kept when left out.
+
+	webhook	- The webhook as it is now
+	patch	- Map of the fields to update, and their json values
+
+	return	- The updated webhook
+*/
+func ApplyWebhookPatch(webhook structs.Webhook, patch map[string]json.RawMessage) (structs.Webhook, error) {
+	// Fields which can be updated, and the field they are decoded into
+	fields := map[string]interface{}{
+		"url":         &webhook.Url,
+		"country":     &webhook.Country,
+		"calls":       &webhook.Calls,
+		"year":        &webhook.Year,
+		"countries":   &webhook.Countries,
+		"region":      &webhook.Region,
+		"yearFrom":    &webhook.YearFrom,
+		"yearTo":      &webhook.YearTo,
+		"trigger":     &webhook.Trigger,
+		"threshold":   &webhook.Threshold,
+		"change":      &webhook.Change,
+		"expiresAt":   &webhook.ExpiresAt,
+		"format":      &webhook.Format,
+		"template":    &webhook.Template,
+		"window":      &webhook.Window,
+		"cooldown":    &webhook.Cooldown,
+		"schedule":    &webhook.Schedule,
+		"channel":     &webhook.Channel,
+		"headers":     &webhook.Headers,
+		"auth":        &webhook.Auth,
+		"includeData": &webhook.IncludeData,
+	}
+
+	for name, target := range fields {
+		value, ok := patch[name]
+		if !ok {
+			continue
+		}
+
+		// Null clears the field, which is only valid for the optional fields
+		if string(value) == "null" {
+			switch name {
+			case "country":
+				webhook.Country = ""
+			case "year":
+				webhook.Year = 0
+			case "countries":
+				webhook.Countries = nil
+			case "region":
+				webhook.Region = ""
+			case "yearFrom":
+				webhook.YearFrom = 0
+			case "yearTo":
+				webhook.YearTo = 0
+			case "trigger":
+				webhook.Trigger = ""
+			case "threshold":
+				webhook.Threshold = nil
+			case "change":
+				webhook.Change = nil
+			case "expiresAt":
+				webhook.ExpiresAt = nil
+			case "format":
+				webhook.Format = ""
+			case "template":
+				webhook.Template = ""
+			case "window":
+				webhook.Window = ""
+			case "cooldown":
+				webhook.Cooldown = ""
+			case "schedule":
+				webhook.Schedule = ""
+			case "channel":
+				webhook.Channel = ""
+			case "url":
+				webhook.Url = ""
+			case "headers":
+				webhook.Headers = nil
+			case "auth":
+				webhook.Auth = nil
+			case "includeData":
+				webhook.IncludeData = false
+			default:
+				return webhook, structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, name, "Invalid request body for update of webhook, "+name+" can not be null", "")
+			}
+			continue
+		}
+
+		// Headers and auth are replaced as a whole, rather than merged with the ones stored
+		switch name {
+		case "headers":
+			webhook.Headers = nil
+		case "auth":
+			webhook.Auth = nil
+		}
+
+		if err := json.Unmarshal(value, target); err != nil {
+			return webhook, structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_INVALID_BODY, name, "Invalid request body for update of webhook, invalid "+name+" given", "")
+		}
+	}
+
+	return webhook, CheckWebhook(webhook)
+}
+
+/*
+Get the version required by the If-Match header of a request
+
+	r	- Request
+
+	return	- The version in the ETag given, or -1 if no header or * was given, and error if the ETag can never match
+*/
+func GetIfMatchVersionFromRequest(r *http.Request) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return -1, nil
+	}
+
+	// Weak ETags are never used for updates
+	version, err := strconv.ParseInt(strings.Trim(ifMatch, "\""), 10, 64)
+	if err != nil || !strings.HasPrefix(ifMatch, "\"") || version < 0 {
+		return -1, structs.NewCodedError(err, http.StatusPreconditionFailed, constants.ERR_PRECONDITION_FAILED, "If-Match", "The If-Match header does not match the current ETag of the resource", "")
+	}
+
+	return version, nil
+}
+
+/*
+Check the outbound headers and credentials of a webhook, which are only sent with deliveries of the http channel
+
+	webhook	- Webhook to check
+
+	return	- Error with status 422 describing the first invalid header or credential, or nil if they are valid
+*/
+func checkWebhookCredentials(webhook structs.Webhook) error {
+	if len(webhook.Headers) == 0 && webhook.Auth == nil {
+		return nil
+	}
+
+	param := "headers"
+	if len(webhook.Headers) == 0 {
+		param = "auth"
+	}
+	invalid := func(msg string) error {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, param, "Invalid request body for registration of webhook, "+msg, "")
+	}
+
+	if webhook.Channel != "" && webhook.Channel != constants.WEBHOOK_CHANNEL_HTTP {
+		return invalid("only webhooks of the " + constants.WEBHOOK_CHANNEL_HTTP + " channel have headers and auth")
+	}
+	// Credentials are only stored encrypted
+	if !div.EncryptionEnabled() {
+		return invalid("headers and auth are not enabled on this service")
+	}
+
+	if len(webhook.Headers) > constants.MAX_WEBHOOK_HEADERS {
+		return invalid("at most " + strconv.Itoa(constants.MAX_WEBHOOK_HEADERS) + " headers can be given")
+	}
+	given := map[string]bool{}
+	for name, value := range webhook.Headers {
+		if !isHeaderName(name) {
+			return invalid(name + " is not a valid header name")
+		}
+		if given[http.CanonicalHeaderKey(name)] {
+			return invalid("header " + name + " is given more than once")
+		}
+		given[http.CanonicalHeaderKey(name)] = true
+		if isReservedHeader(name) {
+			return invalid("header " + name + " is set by the service")
+		}
+		if len(name)+len(value) > constants.MAX_WEBHOOK_HEADER_SIZE || strings.ContainsAny(value, "\r\n\x00") {
+			return invalid("header " + name + " must be at most " + strconv.Itoa(constants.MAX_WEBHOOK_HEADER_SIZE) + " characters on one line")
+		}
+		// Values are shown redacted, so they must be given again rather than sent back as shown
+		if value == constants.REDACTED_VALUE {
+			return invalid("value of header " + name + " is redacted, and must be given again")
+		}
+	}
+
+	if webhook.Auth == nil {
+		return nil
+	}
+	param = "auth"
+	switch webhook.Auth.Type {
+	case constants.WEBHOOK_AUTH_BASIC:
+		if webhook.Auth.Username == "" || strings.Contains(webhook.Auth.Username, ":") || webhook.Auth.Token != "" {
+			return invalid("basic auth must have a username without colons, and a password")
+		}
+	case constants.WEBHOOK_AUTH_BEARER:
+		if webhook.Auth.Token == "" || webhook.Auth.Username != "" || webhook.Auth.Password != "" {
+			return invalid("bearer auth must have a token, and no username or password")
+		}
+	default:
+		return invalid("auth type must be " + constants.WEBHOOK_AUTH_BASIC + " or " + constants.WEBHOOK_AUTH_BEARER)
+	}
+	credentials := webhook.Auth.Username + webhook.Auth.Password + webhook.Auth.Token
+	if len(credentials) > constants.MAX_WEBHOOK_HEADER_SIZE || strings.ContainsAny(credentials, "\r\n\x00") {
+		return invalid("auth must be at most " + strconv.Itoa(constants.MAX_WEBHOOK_HEADER_SIZE) + " characters on one line")
+	}
+
+	return nil
+}
+
+/*
+Checks if a header name only has the characters allowed in tokens of http
+*/
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Checks if a header is set by the service or its http client, so it can not be an outbound header of a webhook.
+Authorization is given as auth instead, so the credentials are not shown.
+*/
+func isReservedHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Content-Type", "Content-Length", "Host", "Connection", "Keep-Alive", "Transfer-Encoding", "Te", "Trailer", "Upgrade":
+		return true
+	}
+	return strings.HasPrefix(http.CanonicalHeaderKey(name), "X-Energy-")
+}
+
+/*
+Check that a rate webhook has a window within the limits, and a cooldown of at least a second
+
+	webhook	- Rate webhook to check
+
+	return	- Error with status 422 if the window or cooldown is invalid
+*/
+func checkRateWindow(webhook structs.Webhook) error {
+	window, err := time.ParseDuration(webhook.Window)
+	if webhook.Window == "" {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, "window", "Invalid request body for registration of webhook, rate webhooks must have a window such as 1h", "")
+	}
+	if err != nil || window < constants.MIN_WEBHOOK_RATE_WINDOW || window > constants.MAX_WEBHOOK_RATE_WINDOW {
+		return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "window", "Invalid request body for registration of webhook, window must be a duration from "+constants.MIN_WEBHOOK_RATE_WINDOW.String()+" to "+constants.MAX_WEBHOOK_RATE_WINDOW.String(), "")
+	}
+
+	if webhook.Cooldown == "" {
+		return nil
+	}
+	if cooldown, err := time.ParseDuration(webhook.Cooldown); err != nil || cooldown < constants.MIN_WEBHOOK_RATE_COOLDOWN {
+		return structs.NewCodedError(err, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "cooldown", "Invalid request body for registration of webhook, cooldown must be a duration of at least "+constants.MIN_WEBHOOK_RATE_COOLDOWN.String()+", such as 30m", "")
+	}
+
+	return nil
+}
+
+/*
+Check that a webhook has all required fields, and a country which exists in the database
+
+	webhook	- Webhook to check
+
+	return	- Error describing the first invalid field, or nil if the webhook is valid
+*/
+func CheckWebhook(webhook structs.Webhook) error {
+	// Webhooks of the file channel are written to the file sink of the service, and have no url
+	if webhook.Url == "" && webhook.Channel != constants.WEBHOOK_CHANNEL_FILE {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, "url", "Invalid request body for registration of webhook, webhook URL and Calls must have a value", "There was an error when decoding webhook from json.")
+	}
+	if err := gateway.CheckWebhookTarget(webhook.Channel, webhook.Url); err != nil {
+		return err
+	}
+	if err := checkWebhookCredentials(webhook); err != nil {
+		return err
+	}
+
+	switch webhook.Trigger {
+	case "", constants.WEBHOOK_TRIGGER_CALLS:
+		if webhook.Calls <= 0 {
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, "calls", "Invalid request body for registration of webhook, webhook URL and Calls must have a value", "There was an error when decoding webhook from json.")
+		}
+	case constants.WEBHOOK_TRIGGER_THRESHOLD:
+		if webhook.Threshold == nil && webhook.Change == nil {
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, "threshold", "Invalid request body for registration of webhook, threshold webhooks must have a threshold or change", "")
+		}
+		if webhook.Threshold != nil && (*webhook.Threshold < 0 || *webhook.Threshold > 100) {
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "threshold", "Invalid request body for registration of webhook, threshold must be a percentage between 0 and 100", "")
+		}
+		if webhook.Change != nil && *webhook.Change <= 0 {
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "change", "Invalid request body for registration of webhook, change must be a positive number of points", "")
+		}
+	case constants.WEBHOOK_TRIGGER_DATASET:
+		// Dataset webhooks are fired by every import which changes the countries and years they apply to
+	case constants.WEBHOOK_TRIGGER_RATE:
+		if webhook.Calls <= 0 {
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, "calls", "Invalid request body for registration of webhook, rate webhooks must have the calls within the window they fire above", "")
+		}
+		if err := checkRateWindow(webhook); err != nil {
+			return err
+		}
+	case constants.WEBHOOK_TRIGGER_DIGEST:
+		switch webhook.Schedule {
+		case constants.WEBHOOK_SCHEDULE_HOURLY, constants.WEBHOOK_SCHEDULE_DAILY, constants.WEBHOOK_SCHEDULE_WEEKLY:
+		case "":
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_MISSING_FIELD, "schedule", "Invalid request body for registration of webhook, digest webhooks must have a schedule", "")
+		default:
+			return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "schedule", "Invalid request body for registration of webhook, schedule must be "+constants.WEBHOOK_SCHEDULE_HOURLY+", "+constants.WEBHOOK_SCHEDULE_DAILY+" or "+constants.WEBHOOK_SCHEDULE_WEEKLY, "")
+		}
+	default:
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "trigger", "Invalid request body for registration of webhook, trigger must be "+constants.WEBHOOK_TRIGGER_CALLS+", "+constants.WEBHOOK_TRIGGER_RATE+", "+constants.WEBHOOK_TRIGGER_DIGEST+", "+constants.WEBHOOK_TRIGGER_THRESHOLD+" or "+constants.WEBHOOK_TRIGGER_DATASET, "")
+	}
+	if webhook.Trigger != constants.WEBHOOK_TRIGGER_DIGEST && webhook.Schedule != "" {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "schedule", "Invalid request body for registration of webhook, only digest webhooks have a schedule", "")
+	}
+	if webhook.Trigger != constants.WEBHOOK_TRIGGER_RATE && (webhook.Window != "" || webhook.Cooldown != "") {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "window", "Invalid request body for registration of webhook, only rate webhooks have a window and cooldown", "")
+	}
+	// Other webhooks are sent the values or changes which fired them
+	if webhook.IncludeData && webhook.Trigger != "" && webhook.Trigger != constants.WEBHOOK_TRIGGER_CALLS && webhook.Trigger != constants.WEBHOOK_TRIGGER_RATE {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "includeData", "Invalid request body for registration of webhook, only calls and rate webhooks include data", "")
 	}
 
-	if webhook.Url == "" || webhook.Calls <= 0 {
-		return webhook, structs.NewError(nil, http.StatusUnprocessableEntity, "Invalid request body for registration of webhook, webhook URL and Calls must have a value", "There was an error when decoding webhook from json.")
+	// A webhook applies to one year, or a range of years
+	if webhook.YearFrom < 0 || webhook.YearTo < 0 || (webhook.YearFrom > 0 && webhook.YearTo > 0 && webhook.YearFrom > webhook.YearTo) {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "yearFrom", "Invalid request body for registration of webhook, yearFrom must be before yearTo", "")
+	}
+	if webhook.Year != 0 && (webhook.YearFrom != 0 || webhook.YearTo != 0) {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "year", "Invalid request body for registration of webhook, year can not be combined with yearFrom or yearTo", "")
+	}
+	if webhook.Trigger == constants.WEBHOOK_TRIGGER_THRESHOLD && (webhook.YearFrom != 0 || webhook.YearTo != 0) {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "yearFrom", "Invalid request body for registration of webhook, threshold webhooks apply to one year or the latest year", "")
+	}
+
+	// Payloads must be rendered in a format which exists, and templates must render for the trigger
+	if err := gateway.CheckPayloadFormat(webhook.Format, webhook.Template, webhook.Trigger); err != nil {
+		return err
+	}
+
+	// A webhook which is registered or updated must be fired for some time
+	if webhook.ExpiresAt != nil && !webhook.ExpiresAt.After(time.Now()) {
+		return structs.NewCodedError(nil, http.StatusUnprocessableEntity, constants.ERR_INVALID_FIELD, "expiresAt", "Invalid request body for registration of webhook, expiresAt must be in the future", "")
 	}
 
 	// Dont allow registration of webhook for country which does not exist in database
-	if !db.DocumentInCollection(webhook.Country, constants.RENEWABLES_COLLECTION) && webhook.Country != "" {
-		return webhook, structs.NewError(nil, http.StatusNotFound, "Invalid country code for registration of webhook", "User entered a country code not in the database")
+	if webhook.Country != "" && !db.DocumentInCollection(webhook.Country, constants.RENEWABLES_COLLECTION) {
+		return structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_COUNTRY_NOT_FOUND, "country", "Invalid country code for registration of webhook", "User entered a country code not in the database")
+	}
+	for _, country := range webhook.Countries {
+		if country == "" || !db.DocumentInCollection(country, constants.RENEWABLES_COLLECTION) {
+			return structs.NewCodedError(nil, http.StatusNotFound, constants.ERR_COUNTRY_NOT_FOUND, "countries", "Invalid country code "+country+" for registration of webhook", "User entered a country code not in the database")
+		}
+	}
+
+	// Dont allow registration of webhook for region which does not exist in restcountries
+	if webhook.Region != "" {
+		if _, err := gateway.GetIsoCodesByRegion(webhook.Region, constants.COUNTRIES_API_URL); err != nil {
+			return err
+		}
 	}
 
-	return webhook, nil
+	return nil
 }
 
 /*
@@ -230,7 +663,7 @@ func GetWebhookIDFromRequest(w http.ResponseWriter, r *http.Request) (string, er
 
 	// Check if URL is correctly formatted
 	if len(args) != 5 && len(args) != 6 {
-		return "", structs.NewError(nil, http.StatusForbidden, "Malformed URL, Expecting format "+constants.NOTIFICATION_PATH+"{webhookID}", "")
+		return "", structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PATH, "id", "Malformed URL, Expecting format "+constants.NOTIFICATION_PATH+"{webhookID}", "")
 	}
 
 	// Return webhookID
@@ -252,7 +685,7 @@ func GetWebhookIDOrNothingFromRequest(w http.ResponseWriter, r *http.Request) (s
 
 	// Check if URL is correctly formatted
 	if len(args) != 4 && len(args) != 5 && len(args) != 6 {
-		return "", structs.NewError(nil, http.StatusForbidden, "Malformed URL, Expecting format "+constants.NOTIFICATION_PATH+"{webhookID?}", "")
+		return "", structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PATH, "id", "Malformed URL, Expecting format "+constants.NOTIFICATION_PATH+"{webhookID?}", "")
 	}
 
 	// If no webhookID was specified
@@ -264,3 +697,114 @@ func GetWebhookIDOrNothingFromRequest(w http.ResponseWriter, r *http.Request) (s
 	return args[4], nil
 
 }
+
+/*
+Get webhookID from the url of a request for an action on a webhook, such as rotating its secret
+
+	r		- Request
+	action	- Path after the webhookID, such as WEBHOOK_SECRET_PATH
+
+	return 	- webhookID, and if the url is on the format {NOTIFICATION_PATH}{webhookID}/{action}
+*/
+func GetWebhookIDForActionFromRequest(r *http.Request, action string) (string, bool) {
+	// Split path into args
+	args := strings.Split(r.URL.Path, "/")
+
+	// Check if URL is for the action, with or without trailing slash
+	if (len(args) != 6 && len(args) != 7) || args[5] != action || (len(args) == 7 && args[6] != "") {
+		return "", false
+	}
+
+	return args[4], true
+}
+
+/*
+Get limit and cursor parameters for paginated lists
+
+	r	- Request
+
+	return	- Limit, which is the default if not given, cursor or empty, and error if the limit is invalid
+*/
+func GetPaginationParameters(r *http.Request) (int, string, error) {
+	limit := constants.DEFAULT_PAGE_LIMIT
+
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > constants.MAX_PAGE_LIMIT {
+			return 0, "", structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "limit", "Malformed URL, limit must be a number between 1 and "+strconv.Itoa(constants.MAX_PAGE_LIMIT), "")
+		}
+	}
+
+	return limit, r.URL.Query().Get("cursor"), nil
+}
+
+/*
+Get the filters, sort order and page of a listing of webhooks from the query of the request
+
+	r	- Request
+
+	return	- The query, sorted by creation time if no sort is given, or error with status 400 if a parameter is invalid
+*/
+func GetWebhookQueryFromRequest(r *http.Request) (structs.WebhookQuery, error) {
+	query := structs.WebhookQuery{
+		Country: strings.ToUpper(r.URL.Query().Get("country")),
+		Url:     r.URL.Query().Get("url"),
+		State:   r.URL.Query().Get("state"),
+		Sort:    r.URL.Query().Get("sort"),
+	}
+
+	var err error
+	query.Limit, query.Cursor, err = GetPaginationParameters(r)
+	if err != nil {
+		return query, err
+	}
+
+	if yearParam := r.URL.Query().Get("year"); yearParam != "" {
+		query.Year, err = strconv.Atoi(yearParam)
+		if err != nil || query.Year < 1 {
+			return query, structs.NewCodedError(err, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "year", "Malformed URL, year must be a positive number", "")
+		}
+	}
+
+	switch query.State {
+	case "", constants.WEBHOOK_STATUS_ACTIVE, constants.WEBHOOK_STATUS_PENDING, constants.WEBHOOK_STATUS_DISABLED, constants.WEBHOOK_STATUS_EXPIRED:
+	default:
+		return query, structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "state", "Malformed URL, state must be "+constants.WEBHOOK_STATUS_ACTIVE+", "+constants.WEBHOOK_STATUS_PENDING+", "+constants.WEBHOOK_STATUS_DISABLED+" or "+constants.WEBHOOK_STATUS_EXPIRED, "")
+	}
+
+	// Oldest first if no sort is given, so webhooks registered while paging do not move the pages
+	if query.Sort == "" {
+		query.Sort = constants.WEBHOOK_SORT_CREATED
+	}
+	switch strings.TrimPrefix(query.Sort, constants.WEBHOOK_SORT_DESCENDING_PREFIX) {
+	case constants.WEBHOOK_SORT_CREATED, constants.WEBHOOK_SORT_INVOCATIONS:
+	default:
+		return query, structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PARAMETER, "sort", "Malformed URL, sort must be "+constants.WEBHOOK_SORT_CREATED+" or "+constants.WEBHOOK_SORT_INVOCATIONS+", prefixed by "+constants.WEBHOOK_SORT_DESCENDING_PREFIX+" for descending order", "")
+	}
+
+	return query, nil
+}
+
+/*
+Get dead-letter ID and action from the requests url, on the format {DEADLETTERS_PATH}{deadLetterID?}/{action?}
+
+	r		- Request
+
+	return 	- Dead-letter ID or empty, action or empty, and error if the url has too many parts
+*/
+func GetDeadLetterIDAndActionFromRequest(r *http.Request) (string, string, error) {
+	// Split path into args, ignoring a trailing slash
+	args := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+
+	switch len(args) {
+	case 4:
+		return "", "", nil
+	case 5:
+		return args[4], "", nil
+	case 6:
+		return args[4], args[5], nil
+	default:
+		return "", "", structs.NewCodedError(nil, http.StatusBadRequest, constants.ERR_MALFORMED_PATH, "id", "Malformed URL, Expecting format "+constants.DEADLETTERS_PATH+"{deadLetterID?}", "")
+	}
+}
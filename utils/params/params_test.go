@@ -0,0 +1,240 @@
+package params
+
+import (
+	"assignment2/utils/constants"
+	"assignment2/utils/div"
+	"assignment2/utils/structs"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Tests applying partial updates to a webhook
+*/
+func TestApplyWebhookPatch(t *testing.T) {
+	webhook := structs.Webhook{WebhookId: "TEST", Url: "https://example.com", Calls: 5, Year: 2000}
+
+	decode := func(body string) map[string]json.RawMessage {
+		patch, err := GetWebhookPatchFromRequest(httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body)))
+		assert.Nil(t, err, "Patch should be decoded")
+		return patch
+	}
+
+	// Fields left out are kept
+	updated, err := ApplyWebhookPatch(webhook, decode(`{"calls": 10}`))
+	assert.Nil(t, err, "Patch should be valid")
+	assert.Equal(t, structs.Webhook{WebhookId: "TEST", Url: "https://example.com", Calls: 10, Year: 2000}, updated, "Only calls should be updated")
+
+	// Null clears optional fields
+	updated, err = ApplyWebhookPatch(webhook, decode(`{"year": null, "url": "https://example.org"}`))
+	assert.Nil(t, err, "Patch should be valid")
+	assert.Equal(t, 0, updated.Year, "Year should be cleared")
+	assert.Equal(t, "https://example.org", updated.Url, "Url should be updated")
+
+	// Required fields can not be cleared or made invalid
+	_, err = ApplyWebhookPatch(webhook, decode(`{"url": null}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, err.(structs.WrappedError).StatusCode, "Url should not be cleared")
+	_, err = ApplyWebhookPatch(webhook, decode(`{"calls": 0}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, err.(structs.WrappedError).StatusCode, "Calls should be positive")
+	_, err = ApplyWebhookPatch(webhook, decode(`{"calls": "ten"}`))
+	assert.Equal(t, http.StatusBadRequest, err.(structs.WrappedError).StatusCode, "Calls should be a number")
+
+	// Bodies which are not json objects are rejected
+	_, err = GetWebhookPatchFromRequest(httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`[1]`)))
+	assert.Equal(t, http.StatusBadRequest, err.(structs.WrappedError).StatusCode, "Patch should be an object")
+}
+
+/*
+Tests getting the challenge posted back to verify a webhook
+*/
+func TestGetVerificationChallengeFromRequest(t *testing.T) {
+	tests := []struct {
+		body      string
+		challenge string
+		status    int
+	}{
+		{"", "", 0},
+		{`{"challenge": "CHALLENGE"}`, "CHALLENGE", 0},
+		{`{"challenge": ""}`, "", http.StatusBadRequest},
+		{`{}`, "", http.StatusBadRequest},
+		{`CHALLENGE`, "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		challenge, err := GetVerificationChallengeFromRequest(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body)))
+		assert.Equal(t, test.challenge, challenge, "Wrong challenge for body "+test.body)
+		if test.status == 0 {
+			assert.Nil(t, err, "Body should be valid: "+test.body)
+		} else {
+			assert.Equal(t, test.status, err.(structs.WrappedError).StatusCode, "Wrong status for body "+test.body)
+		}
+	}
+}
+
+/*
+Tests getting the version from the If-Match header
+*/
+func TestGetIfMatchVersionFromRequest(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		version int64
+		valid   bool
+	}{
+		{"", -1, true},
+		{"*", -1, true},
+		{`"3"`, 3, true},
+		{`W/"3"`, -1, false},
+		{`"abc"`, -1, false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if test.ifMatch != "" {
+			r.Header.Set("If-Match", test.ifMatch)
+		}
+
+		version, err := GetIfMatchVersionFromRequest(r)
+		assert.Equal(t, test.valid, err == nil, "Wrong validity for If-Match "+test.ifMatch)
+		if test.valid {
+			assert.Equal(t, test.version, version, "Wrong version for If-Match "+test.ifMatch)
+		} else {
+			assert.Equal(t, http.StatusPreconditionFailed, err.(structs.WrappedError).StatusCode, "Wrong status for If-Match "+test.ifMatch)
+		}
+	}
+}
+
+/*
+Tests the fields required by each trigger of a webhook, and the years it applies to
+*/
+func TestCheckWebhook(t *testing.T) {
+	threshold, change, invalid := 50.0, 5.0, 150.0
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		webhook structs.Webhook
+		status  int
+	}{
+		{"calls", structs.Webhook{Url: "https://example.com", Calls: 5}, 0},
+		{"calls without calls", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_CALLS}, http.StatusUnprocessableEntity},
+		{"threshold", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_THRESHOLD, Threshold: &threshold}, 0},
+		{"change", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_THRESHOLD, Change: &change}, 0},
+		{"threshold without limits", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_THRESHOLD}, http.StatusUnprocessableEntity},
+		{"threshold above 100", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_THRESHOLD, Threshold: &invalid}, http.StatusUnprocessableEntity},
+		{"dataset", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_DATASET, YearFrom: 2000}, 0},
+		{"rate", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "1h", Cooldown: "30m"}, 0},
+		{"rate without window", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100}, http.StatusUnprocessableEntity},
+		{"rate with short window", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "10s"}, http.StatusUnprocessableEntity},
+		{"rate with negative cooldown", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "1h", Cooldown: "-1m"}, http.StatusUnprocessableEntity},
+		{"rate with no cooldown", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "1h", Cooldown: "0s"}, http.StatusUnprocessableEntity},
+		{"rate with sub-second cooldown", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "1h", Cooldown: "500ms"}, http.StatusUnprocessableEntity},
+		{"rate with cooldown of a second", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "1h", Cooldown: "1s"}, 0},
+		{"digest", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_DIGEST, Schedule: constants.WEBHOOK_SCHEDULE_DAILY}, 0},
+		{"digest without schedule", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_DIGEST}, http.StatusUnprocessableEntity},
+		{"digest with unknown schedule", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_DIGEST, Schedule: "monthly"}, http.StatusUnprocessableEntity},
+		{"schedule without digest", structs.Webhook{Url: "https://example.com", Calls: 5, Schedule: constants.WEBHOOK_SCHEDULE_DAILY}, http.StatusUnprocessableEntity},
+		{"window without rate", structs.Webhook{Url: "https://example.com", Calls: 5, Window: "1h"}, http.StatusUnprocessableEntity},
+		{"unknown channel", structs.Webhook{Url: "https://example.com", Calls: 5, Channel: "pigeon"}, http.StatusUnprocessableEntity},
+		{"file channel not enabled", structs.Webhook{Calls: 5, Channel: constants.WEBHOOK_CHANNEL_FILE}, http.StatusUnprocessableEntity},
+		{"email to http url", structs.Webhook{Url: "https://example.com", Calls: 5, Channel: constants.WEBHOOK_CHANNEL_SMTP}, http.StatusUnprocessableEntity},
+		{"unknown trigger", structs.Webhook{Url: "https://example.com", Trigger: "sometimes", Calls: 5}, http.StatusUnprocessableEntity},
+		{"range of years", structs.Webhook{Url: "https://example.com", Calls: 5, YearFrom: 2000, YearTo: 2010}, 0},
+		{"reversed range of years", structs.Webhook{Url: "https://example.com", Calls: 5, YearFrom: 2010, YearTo: 2000}, http.StatusUnprocessableEntity},
+		{"year and range of years", structs.Webhook{Url: "https://example.com", Calls: 5, Year: 2005, YearFrom: 2000}, http.StatusUnprocessableEntity},
+		{"expiry", structs.Webhook{Url: "https://example.com", Calls: 5, ExpiresAt: &future}, 0},
+		{"slack format", structs.Webhook{Url: "https://example.com", Calls: 5, Format: constants.WEBHOOK_FORMAT_SLACK}, 0},
+		{"unknown format", structs.Webhook{Url: "https://example.com", Calls: 5, Format: "xml"}, http.StatusUnprocessableEntity},
+		{"calls with data", structs.Webhook{Url: "https://example.com", Calls: 5, IncludeData: true}, 0},
+		{"rate with data", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_RATE, Calls: 100, Window: "1h", IncludeData: true}, 0},
+		{"threshold with data", structs.Webhook{Url: "https://example.com", Trigger: constants.WEBHOOK_TRIGGER_THRESHOLD, Threshold: &threshold, IncludeData: true}, http.StatusUnprocessableEntity},
+		{"expiry in the past", structs.Webhook{Url: "https://example.com", Calls: 5, ExpiresAt: &past}, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		err := CheckWebhook(test.webhook)
+		if test.status == 0 {
+			assert.Nil(t, err, "Webhook should be valid: "+test.name)
+		} else {
+			assert.Equal(t, test.status, err.(structs.WrappedError).StatusCode, "Webhook should be invalid: "+test.name)
+		}
+	}
+}
+
+/*
+Tests that outbound headers and auth are only accepted for http webhooks when they can be encrypted, and that they are checked
+*/
+func TestCheckWebhookCredentials(t *testing.T) {
+	webhook := structs.Webhook{Url: "https://example.com", Calls: 5, Headers: map[string]string{"X-Api-Key": "KEY"}}
+	err := CheckWebhook(webhook)
+	assert.Equal(t, http.StatusUnprocessableEntity, err.(structs.WrappedError).StatusCode, "Headers should not be accepted without an encryption key")
+
+	assert.Nil(t, div.SetEncryptionKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="), "Key should be set")
+	t.Cleanup(func() { div.SetEncryptionKey("") })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		auth    *structs.WebhookAuth
+		channel string
+		valid   bool
+	}{
+		{"headers", map[string]string{"X-Api-Key": "KEY", "x-tenant": "energy"}, nil, "", true},
+		{"basic auth", nil, &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BASIC, Username: "user", Password: "pass"}, "", true},
+		{"bearer auth", map[string]string{"X-Api-Key": "KEY"}, &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER, Token: "TOKEN"}, constants.WEBHOOK_CHANNEL_HTTP, true},
+		{"header given twice", map[string]string{"X-Api-Key": "KEY", "x-api-key": "KEY"}, nil, "", false},
+		{"invalid header name", map[string]string{"X Api Key": "KEY"}, nil, "", false},
+		{"authorization header", map[string]string{"authorization": "Bearer TOKEN"}, nil, "", false},
+		{"header of the service", map[string]string{"X-Energy-Delivery": "ID"}, nil, "", false},
+		{"header on several lines", map[string]string{"X-Api-Key": "KEY\r\nHost: example.org"}, nil, "", false},
+		{"redacted header", map[string]string{"X-Api-Key": constants.REDACTED_VALUE}, nil, "", false},
+		{"basic auth without username", nil, &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BASIC, Password: "pass"}, "", false},
+		{"basic auth with colon", nil, &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BASIC, Username: "user:name", Password: "pass"}, "", false},
+		{"bearer auth without token", nil, &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER}, "", false},
+		{"unknown auth", nil, &structs.WebhookAuth{Type: "digest", Token: "TOKEN"}, "", false},
+	}
+	for _, test := range tests {
+		err := CheckWebhook(structs.Webhook{Url: "https://example.com", Calls: 5, Channel: test.channel, Headers: test.headers, Auth: test.auth})
+		assert.Equal(t, test.valid, err == nil, "Wrong validity for "+test.name)
+	}
+
+	tooMany := map[string]string{}
+	for i := 0; i <= constants.MAX_WEBHOOK_HEADERS; i++ {
+		tooMany["X-Header-"+strings.Repeat("a", i+1)] = "value"
+	}
+	assert.NotNil(t, CheckWebhook(structs.Webhook{Url: "https://example.com", Calls: 5, Headers: tooMany}), "Too many headers should be invalid")
+
+	// Headers and auth are replaced as a whole when patched
+	webhook.Auth = &structs.WebhookAuth{Type: constants.WEBHOOK_AUTH_BEARER, Token: "TOKEN"}
+	updated, err := ApplyWebhookPatch(webhook, map[string]json.RawMessage{"headers": json.RawMessage(`{"X-Tenant": "energy"}`)})
+	assert.Nil(t, err, "Patch should be valid")
+	assert.Equal(t, map[string]string{"X-Tenant": "energy"}, updated.Headers, "Headers should be replaced")
+	assert.Equal(t, webhook.Auth, updated.Auth, "Auth should be kept")
+
+	updated, err = ApplyWebhookPatch(webhook, map[string]json.RawMessage{"auth": json.RawMessage("null")})
+	assert.Nil(t, err, "Patch should be valid")
+	assert.Nil(t, updated.Auth, "Auth should be cleared")
+}
+
+/*
+Tests getting the filters, sort order and page of a listing from the query of the request
+*/
+func TestGetWebhookQueryFromRequest(t *testing.T) {
+	query, err := GetWebhookQueryFromRequest(httptest.NewRequest(http.MethodGet, "/?country=nor&year=2020&url=hooks&state=active&sort=-invocations&limit=5", nil))
+	assert.Nil(t, err, "Query should be valid")
+	assert.Equal(t, structs.WebhookQuery{Country: "NOR", Year: 2020, Url: "hooks", State: "active", Sort: "-invocations", Limit: 5}, query, "Wrong query")
+
+	query, err = GetWebhookQueryFromRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Nil(t, err, "Empty query should be valid")
+	assert.Equal(t, constants.WEBHOOK_SORT_CREATED, query.Sort, "Webhooks should be sorted by creation time by default")
+	assert.Equal(t, constants.DEFAULT_PAGE_LIMIT, query.Limit, "Wrong default limit")
+
+	for _, invalid := range []string{"year=twenty", "year=-1", "state=sleeping", "sort=url", "sort=--createdAt", "limit=0"} {
+		_, err = GetWebhookQueryFromRequest(httptest.NewRequest(http.MethodGet, "/?"+invalid, nil))
+		assert.Equal(t, http.StatusBadRequest, err.(structs.WrappedError).StatusCode, "Query should be invalid: "+invalid)
+	}
+}
@@ -2,9 +2,11 @@ package structs
 
 import (
 	"assignment2/utils/constants"
+	"encoding/json"
 	"net/http"
 	"sort"
 	"strconv"
+	"time"
 )
 
 /*
@@ -116,7 +118,61 @@ func CreateMeanCountryOutputFromData(data map[string]interface{}, isoCode string
 }
 
 /*
-Create a webhook struct given a map of data and webhook ID as string
+Creates a slice of v2 renewables datapoints from countryOutput structs.
+CountryOutputs without a year are mean values, and are marked as aggregated over the year range given.
+
+	output		- List of countryOutput structs as created for the v1 API
+	startYear	- The first year of the year range the output was created from
+	endYear		- The last year of the year range the output was created from
+
+	return		- List of RenewableDatapoint structs with integer years and explicit aggregation fields
+*/
+func CreateDatapointsFromCountryOutput(output []CountryOutput, startYear int, endYear int) ([]RenewableDatapoint, error) {
+	datapoints := make([]RenewableDatapoint, 0, len(output))
+
+	for _, countryOutput := range output {
+		datapoint := RenewableDatapoint{
+			Name:       countryOutput.Name,
+			IsoCode:    countryOutput.IsoCode,
+			Percentage: countryOutput.Percentage,
+		}
+
+		// If no year is set, the percentage is a mean value over the year range
+		if countryOutput.Year == "" {
+			datapoint.Aggregation = constants.AGGREGATION_MEAN
+			datapoint.YearFrom = startYear
+			datapoint.YearTo = endYear
+			datapoints = append(datapoints, datapoint)
+			continue
+		}
+
+		// Try to convert year to an int
+		year, err := strconv.Atoi(countryOutput.Year)
+		if err != nil {
+			return nil, NewError(err, http.StatusInternalServerError, constants.DEFAULT500, "Error when creating v2 datapoint, could not convert year to int")
+		}
+
+		datapoint.Year = &year
+		datapoint.Aggregation = constants.AGGREGATION_NONE
+		datapoint.YearFrom = year
+		datapoint.YearTo = year
+
+		datapoints = append(datapoints, datapoint)
+	}
+
+	return datapoints, nil
+}
+
+/*
+Create a webhook struct given a map of data and webhook ID as string.
+Only the url, country, year and calls are stored for every webhook. Other fields are not stored for webhooks registered before
+the fields were added, and are left empty: those webhooks are active, fired by calls, posted to their url in the native format,
+have no owner, and apply to no list of countries, region or range of years.
+
+	data		- Map of webhook data, as stored in firestore
+	webhookID	- ID of the webhook
+
+	return	- Webhook struct, where secrets, header values and credentials are left out
 */
 func CreateWebhookFromData(data map[string]interface{}, webhookID string) Webhook {
 	// Create webhook struct from data
@@ -132,9 +188,168 @@ func CreateWebhookFromData(data map[string]interface{}, webhookID string) Webhoo
 		webhook.Year = int(data["year"].(int64))
 	}
 
+	// Include countries, region and range of years if specified
+	if countries, ok := data["countries"].([]interface{}); ok {
+		for _, country := range countries {
+			webhook.Countries = append(webhook.Countries, country.(string))
+		}
+	}
+	if region, ok := data["region"].(string); ok {
+		webhook.Region = region
+	}
+	if yearFrom, ok := data["year_from"].(int64); ok && yearFrom != -1 {
+		webhook.YearFrom = int(yearFrom)
+	}
+	if yearTo, ok := data["year_to"].(int64); ok && yearTo != -1 {
+		webhook.YearTo = int(yearTo)
+	}
+
+	// Include trigger if specified
+	if trigger, ok := data["trigger"].(string); ok {
+		webhook.Trigger = trigger
+	}
+
+	// Include threshold and change if specified
+	if threshold, ok := data["threshold"].(float64); ok {
+		webhook.Threshold = &threshold
+	}
+	if change, ok := data["change"].(float64); ok {
+		webhook.Change = &change
+	}
+
+	// Include window and cooldown of rate webhooks, stored in seconds
+	if window, ok := data["window_seconds"].(int64); ok {
+		webhook.Window = (time.Duration(window) * time.Second).String()
+	}
+	if cooldown, ok := data["cooldown_seconds"].(int64); ok {
+		webhook.Cooldown = (time.Duration(cooldown) * time.Second).String()
+	}
+
+	// Include schedule of digest webhooks
+	if schedule, ok := data["schedule"].(string); ok {
+		webhook.Schedule = schedule
+	}
+
+	// Include channel and if data is included in deliveries
+	if channel, ok := data["channel"].(string); ok {
+		webhook.Channel = channel
+	}
+	if includeData, ok := data["include_data"].(bool); ok {
+		webhook.IncludeData = includeData
+	}
+
+	// Header values and credentials are never sent in responses, so only the names of headers and the type of auth are included
+	if headers, ok := data["headers"].([]interface{}); ok && len(headers) > 0 {
+		webhook.Headers = map[string]string{}
+		for _, header := range headers {
+			if name, ok := header.(map[string]interface{})["name"].(string); ok {
+				webhook.Headers[name] = constants.REDACTED_VALUE
+			}
+		}
+	}
+	if authType, ok := data["auth_type"].(string); ok && authType != "" {
+		webhook.Auth = &WebhookAuth{Type: authType}
+	}
+
+	// Include invocations, and the time the webhook was registered
+	if invocations, ok := data["invocations"].(int64); ok {
+		webhook.Invocations = int(invocations)
+	}
+	if createdAt, ok := data["created_at"].(time.Time); ok {
+		webhook.CreatedAt = &createdAt
+	}
+
+	// Include status of the verification of the url
+	if status, ok := data["status"].(string); ok {
+		webhook.Status = status
+	}
+
+	// Include payload format and template if specified
+	if format, ok := data["format"].(string); ok {
+		webhook.Format = format
+	}
+	if template, ok := data["template"].(string); ok {
+		webhook.Template = template
+	}
+
+	// Include expiry if specified, and the failed deliveries in a row
+	if expiresAt, ok := data["expires_at"].(time.Time); ok {
+		webhook.ExpiresAt = &expiresAt
+	}
+	if failures, ok := data["consecutive_failures"].(int64); ok {
+		webhook.Failures = int(failures)
+	}
+
+	// Include the API key owning the webhook
+	if owner, ok := data["owner"].(string); ok {
+		webhook.Owner = owner
+	}
+
+	// Webhooks which have never been updated have no version
+	if version, ok := data["version"].(int64); ok {
+		webhook.Version = version
+	}
+
 	return webhook
 }
 
+/*
+Creates a dead-letter struct from the data of a failed delivery, as stored in firestore
+
+	data			- Map of delivery data
+	deadLetterID	- ID of the dead-letter
+
+	return	- Dead-letter struct
+*/
+func CreateDeadLetterFromData(data map[string]interface{}, deadLetterID string) DeadLetter {
+	deadLetter := DeadLetter{
+		DeadLetterId: deadLetterID,
+		WebhookId:    data["webhook_id"].(string),
+		Url:          data["url"].(string),
+		Country:      data["country"].(string),
+		Calls:        int(data["invocations"].(int64)),
+		Attempts:     int(data["attempts"].(int64)),
+		LastStatus:   int(data["last_status"].(int64)),
+		LastError:    data["last_error"].(string),
+		CreatedAt:    data["created_at"].(time.Time),
+		FailedAt:     data["failed_at"].(time.Time),
+	}
+
+	// Include year if specified
+	if data["year"].(int64) != -1 {
+		deadLetter.Year = int(data["year"].(int64))
+	}
+
+	return deadLetter
+}
+
+/*
+Creates a delivery attempt struct from the data of an attempt, as stored in firestore
+
+	data		- Map of attempt data
+	attemptID	- ID of the attempt
+
+	return	- Delivery attempt struct
+*/
+func CreateDeliveryAttemptFromData(data map[string]interface{}, attemptID string) DeliveryAttempt {
+	attempt := DeliveryAttempt{
+		AttemptId:  attemptID,
+		DeliveryId: data["delivery_id"].(string),
+		Attempt:    int(data["attempt"].(int64)),
+		Timestamp:  data["timestamp"].(time.Time),
+		StatusCode: int(data["status_code"].(int64)),
+		LatencyMs:  data["latency_ms"].(int64),
+		Error:      data["error"].(string),
+	}
+
+	// Include payload if it was created
+	if payload := data["payload"].(string); payload != "" {
+		attempt.Payload = json.RawMessage(payload)
+	}
+
+	return attempt
+}
+
 /*
 Calculate mean value of list of numbers
 
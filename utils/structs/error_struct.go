@@ -1,5 +1,10 @@
 package structs
 
+import (
+	"net/http"
+	"strings"
+)
+
 /*
 * Struct for wrapping errors for standardized error handling.
 *
@@ -7,21 +12,52 @@ package structs
 * StatusCode: Status code to show user
 * UsrMessage: Error message to show user.
 * DevMessage: Error message to display in logs.
+* Code: Stable, machine-readable error code to show user.
+* Param: Name of the parameter which caused the error, if any.
  */
 type WrappedError struct {
 	OrigErr    error
 	StatusCode int
 	UsrMessage string
 	DevMessage string
+	Code       string
+	Param      string
+}
+
+/*
+* Struct for RFC 7807 problem details, sent as application/problem+json on errors.
+ */
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	Param     string `json:"param,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
 }
 
-// Function for creating a new error message.
+// Function for creating a new error message. The error code is derived from the status code.
 func NewError(origErr error, statusCode int, userMsg, devMsg string) error {
 	return WrappedError{
 		OrigErr:    origErr,
 		StatusCode: statusCode,
 		UsrMessage: userMsg,
 		DevMessage: devMsg,
+		Code:       DefaultErrorCode(statusCode),
+	}
+}
+
+// Function for creating a new error message with a specific error code, and the parameter which caused it.
+func NewCodedError(origErr error, statusCode int, code, param, userMsg, devMsg string) error {
+	return WrappedError{
+		OrigErr:    origErr,
+		StatusCode: statusCode,
+		UsrMessage: userMsg,
+		DevMessage: devMsg,
+		Code:       code,
+		Param:      param,
 	}
 }
 
@@ -32,3 +68,12 @@ func (err WrappedError) Error() string {
 	}
 	return ""
 }
+
+// Returns the error code derived from a status code, such as "not_found" for 404
+func DefaultErrorCode(statusCode int) string {
+	statusText := http.StatusText(statusCode)
+	if statusText == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(statusText), " ", "_")
+}
@@ -1,5 +1,12 @@
 package structs
 
+import (
+	"assignment2/utils/dispatcher"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
 /*
 Struct for encoding json response for RENEWABLES_CURRENT and RENEWABLES_HISTORY endpoints.
  */
@@ -23,20 +30,322 @@ type Country struct {
 Struct for encoding JSON response for deleting and viewing a webhook/all webhooks in Notification endpoint.
  */
 type Webhook struct {
-	WebhookId string `json:"webhook_id"`
-	Url       string `json:"url,omitempty"`
-	Country   string `json:"country,omitempty"`
-	Calls     int    `json:"calls,omitempty"`
-	Year      int    `json:"year,omitempty"`
+	WebhookId   string            `json:"webhook_id"`
+	Url         string            `json:"url,omitempty"`
+	Country     string            `json:"country,omitempty"`
+	Calls       int               `json:"calls,omitempty"`
+	Year        int               `json:"year,omitempty"`
+	Countries   []string          `json:"countries,omitempty"`           // ISO codes of countries the webhook applies to, in addition to country
+	Region      string            `json:"region,omitempty"`              // Region or subregion in the restcountries API the webhook applies to
+	YearFrom    int               `json:"yearFrom,omitempty"`            // First year of the range the webhook applies to
+	YearTo      int               `json:"yearTo,omitempty"`              // Last year of the range the webhook applies to
+	Trigger     string            `json:"trigger,omitempty"`             // WEBHOOK_TRIGGER_CALLS if not given
+	Threshold   *float64          `json:"threshold,omitempty"`           // Percentage which fires threshold webhooks when crossed
+	Change      *float64          `json:"change,omitempty"`              // Points which fire threshold webhooks when a value changes by more
+	Window      string            `json:"window,omitempty"`              // Duration such as 1h, which rate webhooks count invocations within
+	Cooldown    string            `json:"cooldown,omitempty"`            // Duration after a rate webhook fires before it can fire again, the window if not given
+	Schedule    string            `json:"schedule,omitempty"`            // Hourly, daily or weekly, which digest webhooks are sent a digest
+	Channel     string            `json:"channel,omitempty"`             // Channel deliveries are sent through, WEBHOOK_CHANNEL_HTTP if not given
+	Headers     map[string]string `json:"headers,omitempty"`             // Outbound headers of http deliveries. Values are stored encrypted, and shown as REDACTED_VALUE.
+	Auth        *WebhookAuth      `json:"auth,omitempty"`                // Credentials of http deliveries, stored encrypted and shown with only their type
+	Secret      string            `json:"secret,omitempty"`              // Only sent when the webhook is registered
+	Status      string            `json:"status,omitempty"`              // WEBHOOK_STATUS_PENDING until the url has echoed the verification challenge, and disabled or expired when no longer fired
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`           // Time the webhook stops being fired
+	Format      string            `json:"format,omitempty"`              // Payload format of deliveries, WEBHOOK_FORMAT_NATIVE if not given
+	Template    string            `json:"template,omitempty"`            // Go text/template rendering the payload, for the WEBHOOK_FORMAT_TEMPLATE format
+	IncludeData bool              `json:"includeData,omitempty"`         // Deliveries of calls and rate webhooks include the renewables of the countries and years of the webhook
+	Data        []CountryOutput   `json:"data,omitempty"`                // Set in the payload of webhooks with includeData, with the renewables when the delivery was sent
+	Failures    int               `json:"consecutiveFailures,omitempty"` // Failed deliveries in a row, which disable the webhook when they reach the limit
+	Invocations int               `json:"invocations,omitempty"`         // Invocations counted for the webhook since it was registered
+	CreatedAt   *time.Time        `json:"createdAt,omitempty"`           // Time the webhook was registered, not set for webhooks registered before it was stored
+	Test        bool              `json:"test,omitempty"`                // Set in the payload of test deliveries
+	Version     int64             `json:"-"`                             // Incremented on each update, and sent as the ETag
+	Owner       string            `json:"-"`                             // Hash of the API key which registered the webhook
+}
+
+/*
+Struct for the filters, sort order and page of a listing of webhooks
+ */
+type WebhookQuery struct {
+	Country string // ISO code the webhook is registered for, as country or in countries
+	Year    int    // Year the webhook applies to, as year or within its range of years
+	Url     string // Text the url of the webhook contains, ignoring case
+	State   string // Status of the webhook, such as active or expired
+	Sort    string // WEBHOOK_SORT_CREATED or WEBHOOK_SORT_INVOCATIONS, prefixed by WEBHOOK_SORT_DESCENDING_PREFIX if descending
+	Limit   int    // Max amount of webhooks in the page
+	Cursor  string // Cursor of the previous page, or empty for the first page
+}
+
+/*
+Struct for a webhook delivery rendered in the payload format of the webhook, ready to be sent by the notifier of its channel.
+ */
+type Delivery struct {
+	WebhookId   string
+	DeliveryId  string
+	Timestamp   time.Time
+	Summary     string // One line describing the notification, such as the subject of emails
+	ContentType string
+	Payload     []byte
+	Signature   string      // Signature header of the payload, empty if the webhook has no secret
+	Test        bool        // Set for test deliveries
+	Headers     http.Header // Outbound headers and credentials of the webhook, decrypted
+}
+
+/*
+Struct for encoding a delivery appended to the file sink, one json object per line.
+ */
+type DeliveryRecord struct {
+	Timestamp   time.Time       `json:"timestamp"`
+	WebhookId   string          `json:"webhook_id"`
+	DeliveryId  string          `json:"delivery_id"`
+	Test        bool            `json:"test,omitempty"`
+	ContentType string          `json:"content_type"`
+	Signature   string          `json:"signature,omitempty"`
+	Payload     json.RawMessage `json:"payload"` // Payloads which are not json are stored as a json string
+}
+
+/*
+Struct for decoding the credentials sent with deliveries to a webhook, and encoding their type.
+ */
+type WebhookAuth struct {
+	Type     string `json:"type"`               // WEBHOOK_AUTH_BASIC or WEBHOOK_AUTH_BEARER
+	Username string `json:"username,omitempty"` // Username of basic auth
+	Password string `json:"password,omitempty"` // Password of basic auth
+	Token    string `json:"token,omitempty"`    // Token of bearer auth
+}
+
+/*
+Struct for encoding JSON body of the challenge sent to verify webhook urls, and decoding the echo.
+ */
+type WebhookVerification struct {
+	Type      string `json:"type,omitempty"`
+	WebhookId string `json:"webhook_id,omitempty"`
+	Challenge string `json:"challenge"`
+}
+
+/*
+Struct for encoding JSON body of deliveries to threshold webhooks, sent after a dataset import.
+ */
+type ThresholdNotification struct {
+	WebhookId string   `json:"webhook_id"`
+	Trigger   string   `json:"trigger"`
+	Country   string   `json:"country"`
+	IsoCode   string   `json:"isoCode"`
+	Year      int      `json:"year"`
+	OldValue  float64  `json:"old_value"`
+	NewValue  float64  `json:"new_value"`
+	Threshold *float64 `json:"threshold,omitempty"`
+	Change    *float64 `json:"change,omitempty"`
+	Test      bool     `json:"test,omitempty"` // Set in the payload of test deliveries
+}
+
+/*
+Struct for encoding JSON body of deliveries to rate webhooks, sent when the invocations within the window exceed calls.
+ */
+type RateNotification struct {
+	WebhookId string          `json:"webhook_id"`
+	Trigger   string          `json:"trigger"`
+	Country   string          `json:"country,omitempty"`
+	Calls     int             `json:"calls"`  // Invocations within the window when the webhook fired
+	Window    string          `json:"window"` // Length of the window
+	Year      int             `json:"year,omitempty"`
+	Test      bool            `json:"test,omitempty"` // Set in the payload of test deliveries
+	Data      []CountryOutput `json:"data,omitempty"` // Set in the payload of webhooks with includeData
+}
+
+/*
+Struct for encoding JSON body of deliveries to digest webhooks, sent on their schedule.
+ */
+type DigestNotification struct {
+	WebhookId    string             `json:"webhook_id"`
+	Trigger      string             `json:"trigger"`
+	Schedule     string             `json:"schedule"`
+	From         *time.Time         `json:"from,omitempty"` // Time of the last digest, or of the registration for the first digest
+	To           time.Time          `json:"to"`
+	Invocations  []DigestInvocation `json:"invocations"`    // Requests of each country and range of years, by most requested
+	TopCountries []DigestCountry    `json:"topCountries"`   // Most requested countries
+	Changes      []DatasetChange    `json:"changes"`        // Countries and years added or changed by dataset imports
+	Test         bool               `json:"test,omitempty"` // Set in the payload of test deliveries
+}
+
+/*
+Struct for encoding the requests of a country and range of years in a digest.
+ */
+type DigestInvocation struct {
+	IsoCode  string `json:"isoCode"` // ALL for requests of all countries
+	YearFrom int    `json:"yearFrom"`
+	YearTo   int    `json:"yearTo"`
+	Calls    int    `json:"calls"`
+}
+
+/*
+Struct for encoding the requests of a country in a digest.
+ */
+type DigestCountry struct {
+	IsoCode string `json:"isoCode"`
+	Calls   int    `json:"calls"`
+}
+
+/*
+Struct for encoding JSON body of deliveries to dataset webhooks, sent after a dataset import.
+ */
+type DatasetUpdateNotification struct {
+	WebhookId string          `json:"webhook_id"`
+	Trigger   string          `json:"trigger"`
+	Changes   []DatasetChange `json:"changes"`
+	Test      bool            `json:"test,omitempty"` // Set in the payload of test deliveries
+}
+
+/*
+Struct for encoding the years of a country added or changed by a dataset import.
+ */
+type DatasetChange struct {
+	IsoCode      string `json:"isoCode"`
+	Country      string `json:"country"`
+	YearsAdded   []int  `json:"yearsAdded,omitempty"`
+	YearsChanged []int  `json:"yearsChanged,omitempty"`
+}
+
+/*
+Struct for a notification to a webhook, before it is rendered in the payload format of the webhook.
+Templates are executed with this struct, so its field names are part of the API.
+ */
+type WebhookNotification struct {
+	WebhookId  string
+	DeliveryId string
+	Trigger    string
+	Timestamp  time.Time
+	Test       bool        // If the delivery is a test, sent by the test endpoint
+	Data       interface{} // Native payload, Webhook for webhooks fired by calls, RateNotification for rate webhooks, DigestNotification for digest webhooks, ThresholdNotification for threshold webhooks and DatasetUpdateNotification for dataset webhooks
+}
+
+/*
+Struct for encoding a CloudEvents 1.0 event in structured json.
+ */
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	Id              string      `json:"id"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+/*
+Struct for encoding a Slack or Mattermost message. Mattermost shows the text, and Slack the blocks.
+ */
+type SlackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks,omitempty"`
+}
+
+/*
+Struct for encoding a block of a Slack message, and the text of a block.
+ */
+type SlackBlock struct {
+	Type string     `json:"type"`
+	Text *SlackText `json:"text,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+/*
+Struct for encoding JSON response for rotating the signing secret of a webhook.
+ */
+type WebhookSecret struct {
+	WebhookId             string     `json:"webhook_id"`
+	Secret                string     `json:"secret"`
+	PreviousSecretExpires *time.Time `json:"previous_secret_expires,omitempty"` // Not set if the webhook had no secret before
+}
+
+/*
+Struct for encoding JSON response for sending a test delivery to a webhook.
+ */
+type WebhookTest struct {
+	WebhookId  string `json:"webhook_id"`
+	DeliveryId string `json:"delivery_id"`
+	Delivered  bool   `json:"delivered"`                  // If the webhook url responded with a 2xx status
+	StatusCode int    `json:"status_code,omitempty"`      // Not set if the webhook url never responded
+	LatencyMs  int64  `json:"latency_ms"`                 // Time until the webhook url responded
+	Response   string `json:"response_excerpt,omitempty"` // Start of the body the webhook url responded with
+	Error      string `json:"error,omitempty"`
 }
 
 /*
 Struct for status endpoint response
 */
 type Status struct {
-	CountriesApi   string  `json:"countries_api"`
-	NotificationDb string  `json:"notification_db"`
-	Webhooks       int     `json:"webhooks"`
-	Version        string  `json:"version"`
-	Uptime         float64 `json:"uptime"`
+	CountriesApi   string            `json:"countries_api"`
+	NotificationDb string            `json:"notification_db"`
+	Webhooks       int               `json:"webhooks"`
+	Version        string            `json:"version"`
+	Uptime         float64           `json:"uptime"`
+	Dispatcher     *dispatcher.Stats `json:"dispatcher,omitempty"` // Metrics of the dispatcher of invocations and deliveries
+}
+
+/*
+Struct for renewables data points in the v2 API. Years are integers, and aggregated values are explicitly marked.
+*/
+type RenewableDatapoint struct {
+	Name        string  `json:"name"`
+	IsoCode     string  `json:"isoCode"`
+	Year        *int    `json:"year"` // null if the value is aggregated over a year range
+	Percentage  float64 `json:"percentage"`
+	Aggregation string  `json:"aggregation"` // AGGREGATION_NONE or AGGREGATION_MEAN
+	YearFrom    int     `json:"yearFrom"`
+	YearTo      int     `json:"yearTo"`
+}
+
+/*
+Struct for the response envelope used by every endpoint in the v2 API.
+*/
+type Envelope struct {
+	Data  interface{}       `json:"data"`
+	Meta  Meta              `json:"meta"`
+	Links map[string]string `json:"links"`
+}
+
+/*
+Struct for the meta field of the v2 response envelope, echoing the interpreted query.
+*/
+type Meta struct {
+	Version string                 `json:"version"`
+	Count   int                    `json:"count"`
+	Query   map[string]interface{} `json:"query"`
+}
+
+/*
+Struct for encoding JSON response for webhook deliveries which failed too many times.
+ */
+type DeadLetter struct {
+	DeadLetterId string    `json:"deadletter_id"`
+	WebhookId    string    `json:"webhook_id"`
+	Url          string    `json:"url"`
+	Country      string    `json:"country"`
+	Calls        int       `json:"calls"`
+	Year         int       `json:"year,omitempty"`
+	Attempts     int       `json:"attempts"`
+	LastStatus   int       `json:"last_status,omitempty"` // Not set if the webhook url never responded
+	LastError    string    `json:"last_error"`
+	CreatedAt    time.Time `json:"created_at"`
+	FailedAt     time.Time `json:"failed_at"`
+}
+
+/*
+Struct for encoding JSON response for one attempt of a webhook delivery.
+ */
+type DeliveryAttempt struct {
+	AttemptId  string          `json:"attempt_id"`
+	DeliveryId string          `json:"delivery_id"`
+	Attempt    int             `json:"attempt"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Payload    json.RawMessage `json:"payload,omitempty"`     // Not set if the payload could not be created
+	StatusCode int             `json:"status_code,omitempty"` // Not set if the webhook url never responded
+	LatencyMs  int64           `json:"latency_ms"`
+	Error      string          `json:"error,omitempty"`
+	Response   string          `json:"response_excerpt,omitempty"` // Start of the body the webhook url responded with
 }
@@ -184,6 +184,15 @@ func TestCreateWebhookFromData(t *testing.T) {
 			"calls":   int64(15),
 			"year":    int64(1977),
 		},
+		{
+			"url":              "https://example.com/webhook2",
+			"country":          "NOR",
+			"calls":            int64(5),
+			"year":             int64(-1),
+			"headers":          []interface{}{map[string]interface{}{"name": "X-Api-Key", "value": "enc:v1:AAAA"}},
+			"auth_type":        "bearer",
+			"auth_credentials": "enc:v1:BBBB",
+		},
 	}
 
 	// Set webhookID to be used in the test
@@ -204,6 +213,14 @@ func TestCreateWebhookFromData(t *testing.T) {
 			Calls:     15,
 			Year:      1977,
 		},
+		{
+			WebhookId: webhookID,
+			Url:       "https://example.com/webhook2",
+			Country:   "NOR",
+			Calls:     5,
+			Headers:   map[string]string{"X-Api-Key": "***"},
+			Auth:      &structs.WebhookAuth{Type: "bearer"},
+		},
 	}
 
 	// Iterate over the test data
@@ -212,7 +229,7 @@ func TestCreateWebhookFromData(t *testing.T) {
 		got := structs.CreateWebhookFromData(val, webhookID)
 
 		// Compare the result with the expected value
-		if got != want[i] {
+		if !reflect.DeepEqual(got, want[i]) {
 			// If the result is not as expected, log an error with details about the inputs and outputs
 			t.Errorf("CreateWebhookFromData(%v, %s) = %v; want %v", data, webhookID, got, want)
 		}
@@ -333,3 +350,51 @@ func TestWrappedError_Error(t *testing.T) {
 		t.Errorf("Error() returned '%s', expected '%s'", err.Error(), errMsg)
 	}
 }
+
+/*
+Unit test for CreateDatapointsFromCountryOutput() in create_structs file
+*/
+func TestCreateDatapointsFromCountryOutput(t *testing.T) {
+	input := []structs.CountryOutput{
+		{Name: "Norway", IsoCode: "NOR", Year: "2020", Percentage: 69},
+		{Name: "Sweden", IsoCode: "SWE", Percentage: 50},
+	}
+
+	datapoints, err := structs.CreateDatapointsFromCountryOutput(input, 1990, 2021)
+	assert.Nil(t, err, "CreateDatapointsFromCountryOutput() returned an error")
+	assert.Equal(t, 2, len(datapoints), "Wrong amount of datapoints returned")
+
+	// Yearly value should have an integer year
+	assert.Equal(t, 2020, *datapoints[0].Year, "Year should be converted to int")
+	assert.Equal(t, "none", datapoints[0].Aggregation, "Yearly value should not be aggregated")
+	assert.Equal(t, 2020, datapoints[0].YearFrom, "YearFrom should equal year")
+	assert.Equal(t, 2020, datapoints[0].YearTo, "YearTo should equal year")
+
+	// Mean value should have no year, and the year range it was calculated from
+	assert.Nil(t, datapoints[1].Year, "Mean value should not have a year")
+	assert.Equal(t, "mean", datapoints[1].Aggregation, "Mean value should be aggregated")
+	assert.Equal(t, 1990, datapoints[1].YearFrom, "YearFrom should equal start year")
+	assert.Equal(t, 2021, datapoints[1].YearTo, "YearTo should equal end year")
+
+	// Invalid year should give an error
+	_, err = structs.CreateDatapointsFromCountryOutput([]structs.CountryOutput{{Year: "abc"}}, 1990, 2021)
+	assert.NotNil(t, err, "Invalid year should return an error")
+}
+
+/*
+Unit test for NewCodedError() and DefaultErrorCode() in error_struct file
+*/
+func TestNewCodedError(t *testing.T) {
+	err := structs.NewCodedError(nil, http.StatusBadRequest, "malformed_parameter", "begin", "Bad request", "")
+
+	e, ok := err.(structs.WrappedError)
+	assert.True(t, ok, "NewCodedError() did not return a WrappedError")
+	assert.Equal(t, "malformed_parameter", e.Code, "Wrong error code")
+	assert.Equal(t, "begin", e.Param, "Wrong parameter")
+
+	// Errors created with NewError get the code from the status code
+	e = structs.NewError(nil, http.StatusNotFound, "Not found", "").(structs.WrappedError)
+	assert.Equal(t, "not_found", e.Code, "Wrong default error code")
+	assert.Equal(t, "method_not_allowed", structs.DefaultErrorCode(http.StatusMethodNotAllowed), "Wrong default error code")
+	assert.Equal(t, "error", structs.DefaultErrorCode(999), "Unknown status codes should give generic error code")
+}